@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"sync"
+	"time"
 
 	bolt "github.com/coreos/bbolt"
 )
@@ -16,16 +18,43 @@ import (
 ////////////////////////////////////////////////////////////////////////////////
 
 const (
-	bucketName = "Aliases"
+	bucketName      = "Aliases"
+	wakesBucketName = "Wakes"
+	seenBucketName  = "Seen"
 )
 
 ////////////////////////////////////////////////////////////////////////////////
 
 // MacIface holds a MAC Address to wake up, along with an optionally specified
-// default interface to use when typically waking up said interface.
+// default interface to use when typically waking up said interface, any tags
+// used to group and filter aliases, optionally the machine's address and
+// prefix (e.g. 192.168.1.20/24), which its directed broadcast is computed
+// from, optionally its host name, which is resolved whenever it is woken, and
+// the seconds during which repeat wakes of it are skipped.
 type MacIface struct {
-	Mac   string
-	Iface string
+	Mac      string
+	Iface    string
+	Tags     []string
+	IP       string
+	Host     string
+	Cooldown int
+}
+
+// HasTags returns true if the entry carries all of `tags`.
+func (mi MacIface) HasTags(tags ...string) bool {
+	for _, want := range tags {
+		found := false
+		for _, tag := range mi.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 // DecodeToMacIface takes a byte buffer and converts decodes it using the gob
@@ -37,41 +66,72 @@ func DecodeToMacIface(buf *bytes.Buffer) (MacIface, error) {
 	return entry, err
 }
 
-// EncodeFromMacIface takes a MAC and an Iface and encodes a gob with a MacIface
-// entry.
-func EncodeFromMacIface(mac, iface string) (*bytes.Buffer, error) {
+// EncodeFromMacIface takes a MAC, an Iface and optional tags and encodes a gob
+// with a MacIface entry.
+func EncodeFromMacIface(mac, iface string, tags ...string) (*bytes.Buffer, error) {
+	return encodeMacIface(MacIface{Mac: mac, Iface: iface, Tags: tags})
+}
+
+// encodeMacIface encodes a gob with the MacIface `entry`.
+func encodeMacIface(entry MacIface) (*bytes.Buffer, error) {
 	buf := bytes.NewBuffer(nil)
-	entry := MacIface{mac, iface}
 	err := gob.NewEncoder(buf).Encode(entry)
 	return buf, err
 }
 
+// Sighting records when a MAC address was last observed on the network.
+// `First` is when tracking of the MAC began and `Last` is when it was last
+// seen, which is the zero time if it has not been seen since.
+type Sighting struct {
+	First time.Time
+	Last  time.Time
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 // Aliases holds a pointer to a mutex which will be acquired and released as
-// transactions are carried out on the `db`.
+// transactions are carried out on the `db`, the `path` of the db (and of the
+// snapshot copy opened instead, if any), the read-only aliases of an ethers
+// file, if one was loaded, and the IPAM unknown targets are looked up in.
 type Aliases struct {
-	mtx *sync.Mutex
-	db  *bolt.DB
+	mtx        *sync.Mutex
+	db         *bolt.DB
+	path       string
+	snapshot   string
+	ethers     map[string]MacIface
+	ethersPath string
+	ipam       *ipamSource
 }
 
 // LoadAliases fetches a boltDb entity at a given `dbpath`. The db just contains
 // a default bucket called `Aliases` which is where the alias entries are
-// stored.
+// stored. If another process has the db open for longer than `--db-timeout`,
+// a *dbLockedError is returned.
 func LoadAliases(dbpath string) (*Aliases, error) {
 	err := os.MkdirAll(path.Dir(dbpath), os.ModePerm)
 	if os.IsNotExist(err) {
 		return nil, err
 	}
 
-	db, err := bolt.Open(dbpath, 0660, nil)
+	timeout := cliFlags.DBTimeout
+	db, err := bolt.Open(dbpath, 0660, &bolt.Options{Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, &dbLockedError{path: dbpath, waited: timeout}
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, lerr := tx.CreateBucketIfNotExists([]byte(bucketName)); lerr != nil {
-			return lerr
+		for _, name := range []string{
+			bucketName, wakesBucketName, seenBucketName, tokensBucketName,
+			historyBucketName, createdBucketName, modifiedBucketName,
+			tombstonesBucketName, scansBucketName, groupsBucketName,
+			oobBucketName, queueBucketName, ipamBucketName,
+		} {
+			if _, lerr := tx.CreateBucketIfNotExists([]byte(name)); lerr != nil {
+				return lerr
+			}
 		}
 		return nil
 	}); err != nil {
@@ -79,44 +139,61 @@ func LoadAliases(dbpath string) (*Aliases, error) {
 	}
 
 	return &Aliases{
-		mtx: &sync.Mutex{},
-		db:  db,
+		mtx:  &sync.Mutex{},
+		db:   db,
+		path: dbpath,
 	}, nil
 }
 
-// Add updates an alias entry or adds a new alias entry. If the alias already
-// exists it is just overwritten.
-func (a *Aliases) Add(alias, mac, iface string) error {
+// Batch runs `fn` in a single transaction, so that either all of the changes
+// it makes are written or, if it (or the commit) fails, none are. The store is
+// locked while it runs, so `fn` must only use the Batch it is given.
+func (a *Aliases) Batch(fn func(*Batch) error) error {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
-	// Create a buffer to store the encoded MAC, interface pair.
-	buf, err := EncodeFromMacIface(mac, iface)
-	if err != nil {
-		return err
-	}
-
-	// We don't have to worry about the key existing, as we will update it
-	// provided it exists.
 	return a.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		return bucket.Put([]byte(alias), buf.Bytes())
+		return fn(&Batch{tx})
+	})
+}
+
+// Add updates an alias entry or adds a new alias entry. If the alias already
+// exists it is just overwritten.
+func (a *Aliases) Add(alias, mac, iface string, tags ...string) error {
+	return a.Put(alias, MacIface{Mac: mac, Iface: iface, Tags: tags})
+}
+
+// Put is Add with every field of the entry.
+func (a *Aliases) Put(alias string, mi MacIface) error {
+	return a.Batch(func(b *Batch) error {
+		return b.Put(alias, mi)
 	})
 }
 
 // Del removes an alias from the store based on the alias string.
 func (a *Aliases) Del(alias string) error {
-	a.mtx.Lock()
-	defer a.mtx.Unlock()
-
-	return a.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		return bucket.Delete([]byte(alias))
+	if a.ReadOnly(alias) {
+		return fmt.Errorf("alias (%s) is read from %s, remove it there", alias, a.ethersPath)
+	}
+	return a.Batch(func(b *Batch) error {
+		return b.Del(alias)
 	})
 }
 
-// Get retrieves a MacIface from the store based on an alias string.
+// Get retrieves a MacIface from the store based on an alias string, falling
+// back to the ethers file.
 func (a *Aliases) Get(alias string) (MacIface, error) {
+	entry, err := a.getDB(alias)
+	if err != nil {
+		if mi, ok := a.ethers[alias]; ok {
+			return mi, nil
+		}
+	}
+	return entry, err
+}
+
+// getDB retrieves a MacIface from the db alone.
+func (a *Aliases) getDB(alias string) (MacIface, error) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
@@ -136,31 +213,221 @@ func (a *Aliases) Get(alias string) (MacIface, error) {
 	return entry, err
 }
 
-// List returns a map containing all alias MacIface pairs.
-func (a *Aliases) List() (map[string]MacIface, error) {
+// Alias is a named MacIface, along with when it was created and last
+// modified. Either time is zero if it is unknown, e.g. for aliases added
+// before they were recorded.
+type Alias struct {
+	Name string
+	MacIface
+	Created  time.Time
+	Modified time.Time
+}
+
+// Each calls `fn` for every alias in name order, including those of the
+// ethers file which the db does not override, stopping at the first error
+// `fn` returns. The store is locked while it runs, so `fn` must not call
+// other methods of `a`.
+func (a *Aliases) Each(fn func(Alias) error) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if len(a.ethers) == 0 {
+		return a.db.View(func(tx *bolt.Tx) error {
+			return (&Batch{tx}).Each(fn)
+		})
+	}
+
+	var list []Alias
+	if err := a.db.View(func(tx *bolt.Tx) error {
+		return (&Batch{tx}).Each(func(alias Alias) error {
+			list = append(list, alias)
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	stored := len(list)
+	for name, mi := range a.ethers {
+		idx := sort.Search(stored, func(i int) bool { return list[i].Name >= name })
+		if idx == stored || list[idx].Name != name {
+			list = append(list, Alias{Name: name, MacIface: mi})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	for _, alias := range list {
+		if err := fn(alias); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns all aliases sorted by name.
+func (a *Aliases) List() ([]Alias, error) {
+	list := []Alias{}
+	err := a.Each(func(alias Alias) error {
+		list = append(list, alias)
+		return nil
+	})
+	return list, err
+}
+
+// LastWake returns the time a magic packet was last sent to `key` (an alias
+// name, or the MAC of a machine woken without one), or the zero time if it
+// has never been woken.
+func (a *Aliases) LastWake(key string) (time.Time, error) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
-	aliasMap := make(map[string]MacIface, 1)
+	var t time.Time
 	err := a.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		cursor := bucket.Cursor()
-		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			if entry, err := DecodeToMacIface(bytes.NewBuffer(v)); err == nil {
-				aliasMap[string(k)] = entry
-			} else {
+		bucket := tx.Bucket([]byte(wakesBucketName))
+		value := bucket.Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		return t.UnmarshalBinary(value)
+	})
+	return t, err
+}
+
+// SetLastWake records that a magic packet was sent to `key` at time `t`.
+func (a *Aliases) SetLastWake(key string, t time.Time) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	value, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(wakesBucketName))
+		return bucket.Put([]byte(key), value)
+	})
+}
+
+// Track updates the sighting record for `mac` at time `now`, starting one if
+// the MAC was not being tracked yet, and returns the updated record. If `seen`
+// is true the MAC was observed on the network at `now`.
+func (a *Aliases) Track(mac string, seen bool, now time.Time) (Sighting, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var sighting Sighting
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(seenBucketName))
+		if value := bucket.Get([]byte(mac)); value != nil {
+			if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&sighting); err != nil {
 				return err
 			}
+		} else {
+			sighting.First = now
 		}
-		return nil
+		if seen {
+			sighting.Last = now
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if err := gob.NewEncoder(buf).Encode(sighting); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(mac), buf.Bytes())
 	})
-	return aliasMap, err
+	return sighting, err
 }
 
-// Close closes the alias store.
+// Close closes the alias store, and removes the snapshot it was read from, if
+// any.
 func (a *Aliases) Close() error {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
-	return a.db.Close()
+	err := a.db.Close()
+	if a.snapshot != "" {
+		os.Remove(a.snapshot)
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Batch is a transaction on the alias store, see Aliases.Batch.
+type Batch struct {
+	tx *bolt.Tx
+}
+
+// Add updates an alias entry or adds a new alias entry in the batch.
+func (b *Batch) Add(alias, mac, iface string, tags ...string) error {
+	return b.Put(alias, MacIface{Mac: mac, Iface: iface, Tags: tags})
+}
+
+// Put is Add with every field of the entry.
+func (b *Batch) Put(alias string, mi MacIface) error {
+	if err := checkPlaintextSpec(alias, mi.Mac); err != nil {
+		return err
+	}
+
+	// Create a buffer to store the encoded MAC, interface pair.
+	buf, err := encodeMacIface(mi)
+	if err != nil {
+		return err
+	}
+
+	// We don't have to worry about the key existing, as we will update it
+	// provided it exists.
+	bucket := b.tx.Bucket([]byte(bucketName))
+	if err := bucket.Put([]byte(alias), buf.Bytes()); err != nil {
+		return err
+	}
+	now := time.Now()
+	return stampModified(b.tx, alias, now, now)
+}
+
+// Del removes an alias in the batch, if it exists.
+func (b *Batch) Del(alias string) error {
+	bucket := b.tx.Bucket([]byte(bucketName))
+	if bucket.Get([]byte(alias)) == nil {
+		return nil
+	}
+	if err := bucket.Delete([]byte(alias)); err != nil {
+		return err
+	}
+	if err := b.tx.Bucket([]byte(oobBucketName)).Delete([]byte(alias)); err != nil {
+		return err
+	}
+	return stampDeleted(b.tx, alias, time.Now())
+}
+
+// Get retrieves an alias as the batch sees it, i.e. including its changes.
+func (b *Batch) Get(alias string) (MacIface, error) {
+	value := b.tx.Bucket([]byte(bucketName)).Get([]byte(alias))
+	if value == nil {
+		return MacIface{}, fmt.Errorf("alias (%s) not found in db", alias)
+	}
+	return DecodeToMacIface(bytes.NewBuffer(value))
+}
+
+// Each calls `fn` for every alias as the batch sees it, in name order,
+// stopping at the first error `fn` returns.
+func (b *Batch) Each(fn func(Alias) error) error {
+	cursor := b.tx.Bucket([]byte(bucketName)).Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		entry, err := DecodeToMacIface(bytes.NewBuffer(v))
+		if err != nil {
+			return err
+		}
+		created, err := getTime(b.tx, createdBucketName, string(k))
+		if err != nil {
+			return err
+		}
+		modified, err := getTime(b.tx, modifiedBucketName, string(k))
+		if err != nil {
+			return err
+		}
+		if err := fn(Alias{string(k), entry, created, modified}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
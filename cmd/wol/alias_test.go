@@ -5,10 +5,12 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"os"
 	"regexp"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -25,8 +27,8 @@ var reStripFnPreamble = regexp.MustCompile(`^.*\.(.*)$`)
 // Validate the DecodeToMacIface function.
 func TestDecodeToMacIface(t *testing.T) {
 	var TestCases = []MacIface{
-		{"00:00:00:00:00:00", ""},
-		{"00:00:00:00:00:AA", "eth1"},
+		{"00:00:00:00:00:00", "", nil, "", "", 0},
+		{"00:00:00:00:00:AA", "eth1", []string{"lab"}, "192.168.1.20/24", "nas.example.com", 30},
 	}
 
 	for _, entry := range TestCases {
@@ -39,25 +41,51 @@ func TestDecodeToMacIface(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Equal(t, entry.Mac, result.Mac)
 		assert.Equal(t, entry.Iface, result.Iface)
+		assert.Equal(t, entry.Tags, result.Tags)
+		assert.Equal(t, entry.IP, result.IP)
 	}
 }
 
+// Entries written before tags existed decode with no tags.
+func TestDecodeToMacIfaceWithoutTags(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(buf).Encode(struct{ Mac, Iface string }{"00:00:00:00:00:AA", "eth0"})
+	assert.Nil(t, err)
+
+	result, err := DecodeToMacIface(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "00:00:00:00:00:AA", result.Mac)
+	assert.Equal(t, "eth0", result.Iface)
+	assert.Nil(t, result.Tags)
+}
+
+// Validate the MacIface HasTags function.
+func TestMacIfaceHasTags(t *testing.T) {
+	mi := MacIface{Mac: "00:00:00:00:00:AA", Tags: []string{"lab", "rack1"}}
+	assert.True(t, mi.HasTags())
+	assert.True(t, mi.HasTags("lab"))
+	assert.True(t, mi.HasTags("rack1", "lab"))
+	assert.False(t, mi.HasTags("lab", "rack2"))
+	assert.False(t, MacIface{}.HasTags("lab"))
+}
+
 // Validate the EncodeFromMacIface function.
 func TestEncodeFromMacIface(t *testing.T) {
 	var TestCases = []MacIface{
-		{"00:00:00:00:00:00", "eth0"},
-		{"00:00:00:00:00:AA", ""},
+		{"00:00:00:00:00:00", "eth0", nil, "", "", 0},
+		{"00:00:00:00:00:AA", "", []string{"lab", "rack1"}, "", "", 0},
 	}
 
 	for _, entry := range TestCases {
 		// First encode the MacIface to a bunch of bytes.
-		buf, err := EncodeFromMacIface(entry.Mac, entry.Iface)
+		buf, err := EncodeFromMacIface(entry.Mac, entry.Iface, entry.Tags...)
 		assert.Nil(t, err)
 
 		result, err := DecodeToMacIface(buf)
 		assert.Nil(t, err)
 		assert.Equal(t, entry.Mac, result.Mac)
 		assert.Equal(t, entry.Iface, result.Iface)
+		assert.Equal(t, entry.Tags, result.Tags)
 	}
 }
 
@@ -95,6 +123,16 @@ func (suite *AliasDBTests) TearDownTest() {
 	assert.Nil(suite.T(), err)
 }
 
+// findAlias returns the MacIface of alias `name` in `list`.
+func findAlias(list []Alias, name string) MacIface {
+	for _, alias := range list {
+		if alias.Name == name {
+			return alias.MacIface
+		}
+	}
+	return MacIface{}
+}
+
 // Validates the Aliases `Add` function.
 func (suite *AliasDBTests) TestAddAlias() {
 	var TestCases = []struct {
@@ -118,10 +156,11 @@ func (suite *AliasDBTests) TestAddAlias() {
 		assert.Nil(suite.T(), err)
 		assert.Equal(suite.T(), entryCount, len(list))
 
-		// Check to ensure that the current map contains the key we
+		// Check to ensure that the current list contains the alias we
 		// just added to the db.
-		assert.Equal(suite.T(), entry.mac, list[entry.alias].Mac)
-		assert.Equal(suite.T(), entry.iface, list[entry.alias].Iface)
+		mi := findAlias(list, entry.alias)
+		assert.Equal(suite.T(), entry.mac, mi.Mac)
+		assert.Equal(suite.T(), entry.iface, mi.Iface)
 	}
 }
 
@@ -133,22 +172,22 @@ func (suite *AliasDBTests) TestAddDuplicateAlias() {
 	// Validate the first entry exists.
 	list, err := suite.aliases.List()
 	assert.Nil(suite.T(), err)
-	assert.Equal(suite.T(), "00:11:22:33:44:55", list["test01"].Mac)
-	assert.Equal(suite.T(), "eth0", list["test01"].Iface)
+	assert.Equal(suite.T(), "00:11:22:33:44:55", findAlias(list, "test01").Mac)
+	assert.Equal(suite.T(), "eth0", findAlias(list, "test01").Iface)
 
 	err = suite.aliases.Add("test01", "00:11:22:33:44:66", "")
 	assert.Nil(suite.T(), err)
 
 	list, err = suite.aliases.List()
 	assert.Nil(suite.T(), err)
-	assert.Equal(suite.T(), "00:11:22:33:44:66", list["test01"].Mac)
-	assert.Equal(suite.T(), "", list["test01"].Iface)
+	assert.Equal(suite.T(), "00:11:22:33:44:66", findAlias(list, "test01").Mac)
+	assert.Equal(suite.T(), "", findAlias(list, "test01").Iface)
 }
 
 // Adding a duplicate entry should overwrite the original one.
 func (suite *AliasDBTests) TestDeleteAlias() {
 	var err error
-	var list map[string]MacIface
+	var list []Alias
 
 	err = suite.aliases.Add("test01", "00:11:22:33:44:55", "eth0")
 	assert.Nil(suite.T(), err)
@@ -201,6 +240,182 @@ func (suite *AliasDBTests) TestGetAlias() {
 	assert.NotNil(suite.T(), err)
 }
 
+// Aliases are listed, and iterated over, in name order.
+func (suite *AliasDBTests) TestListOrder() {
+	for _, name := range []string{"tv", "nas", "pc"} {
+		assert.Nil(suite.T(), suite.aliases.Add(name, "00:11:22:33:44:55", ""))
+	}
+
+	list, err := suite.aliases.List()
+	assert.Nil(suite.T(), err)
+	var names []string
+	for _, alias := range list {
+		names = append(names, alias.Name)
+	}
+	assert.Equal(suite.T(), []string{"nas", "pc", "tv"}, names)
+
+	// Each stops at the first error.
+	stop := errors.New("stop")
+	names = nil
+	err = suite.aliases.Each(func(alias Alias) error {
+		names = append(names, alias.Name)
+		if len(names) == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(suite.T(), stop, err)
+	assert.Equal(suite.T(), []string{"nas", "pc"}, names)
+}
+
+// Overwriting an alias updates its modification time but not its creation
+// time, and deleting it forgets both.
+func (suite *AliasDBTests) TestTimestamps() {
+	assert.Nil(suite.T(), suite.aliases.Add("nas", "00:11:22:33:44:55", ""))
+	list, err := suite.aliases.List()
+	assert.Nil(suite.T(), err)
+	created := list[0].Created
+	assert.False(suite.T(), created.IsZero())
+	assert.True(suite.T(), created.Equal(list[0].Modified))
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(suite.T(), suite.aliases.Add("nas", "00:11:22:33:44:66", ""))
+	list, err = suite.aliases.List()
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), created.Equal(list[0].Created))
+	assert.True(suite.T(), list[0].Modified.After(created))
+
+	assert.Nil(suite.T(), suite.aliases.Del("nas"))
+	assert.Nil(suite.T(), suite.aliases.Add("nas", "00:11:22:33:44:66", ""))
+	list, err = suite.aliases.List()
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), list[0].Created.After(created))
+}
+
+// A batch which fails writes nothing, and one which succeeds writes all of
+// its changes.
+func (suite *AliasDBTests) TestBatch() {
+	assert.Nil(suite.T(), suite.aliases.Add("nas", "00:11:22:33:44:55", ""))
+
+	boom := errors.New("boom")
+	err := suite.aliases.Batch(func(b *Batch) error {
+		assert.Nil(suite.T(), b.Add("tv", "00:11:22:33:44:66", ""))
+		assert.Nil(suite.T(), b.Del("nas"))
+		_, err := b.Get("nas")
+		assert.NotNil(suite.T(), err)
+		return boom
+	})
+	assert.Equal(suite.T(), boom, err)
+	list, err := suite.aliases.List()
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(list))
+	assert.Equal(suite.T(), "nas", list[0].Name)
+
+	err = suite.aliases.Batch(func(b *Batch) error {
+		if err := b.Add("tv", "00:11:22:33:44:66", ""); err != nil {
+			return err
+		}
+		return b.Del("nas")
+	})
+	assert.Nil(suite.T(), err)
+	list, err = suite.aliases.List()
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(list))
+	assert.Equal(suite.T(), "tv", list[0].Name)
+}
+
+// Validates the Aliases `LastWake` and `SetLastWake` functions.
+func (suite *AliasDBTests) TestLastWake() {
+	mac := "00:11:22:33:44:55"
+
+	// A MAC which has never been woken has a zero last wake time.
+	last, err := suite.aliases.LastWake(mac)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), last.IsZero())
+
+	now := time.Now()
+	err = suite.aliases.SetLastWake(mac, now)
+	assert.Nil(suite.T(), err)
+
+	last, err = suite.aliases.LastWake(mac)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), now.Equal(last))
+
+	// Other MACs are unaffected.
+	last, err = suite.aliases.LastWake("00:11:22:33:44:66")
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), last.IsZero())
+}
+
+// Validates the Aliases `Track` function.
+func (suite *AliasDBTests) TestTrack() {
+	mac := "00:11:22:33:44:55"
+	t0 := time.Now()
+
+	// Tracking starts unseen.
+	s, err := suite.aliases.Track(mac, false, t0)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), t0.Equal(s.First))
+	assert.True(suite.T(), s.Last.IsZero())
+
+	// A sighting keeps the first time and updates the last.
+	t1 := t0.Add(time.Hour)
+	s, err = suite.aliases.Track(mac, true, t1)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), t0.Equal(s.First))
+	assert.True(suite.T(), t1.Equal(s.Last))
+
+	// Not seeing the MAC later on does not reset the last sighting.
+	s, err = suite.aliases.Track(mac, false, t1.Add(time.Hour))
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), t0.Equal(s.First))
+	assert.True(suite.T(), t1.Equal(s.Last))
+}
+
+// Validates storing, listing and deleting tokens.
+func (suite *AliasDBTests) TestTokens() {
+	tokens, err := suite.aliases.Tokens()
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, len(tokens))
+
+	tok := Token{Hash: hashTokenSecret("secret"), Aliases: []string{"nas"}}
+	assert.Nil(suite.T(), suite.aliases.AddToken("kids", tok))
+
+	tokens, err = suite.aliases.Tokens()
+	assert.Nil(suite.T(), err)
+	tok.name = "kids"
+	assert.Equal(suite.T(), map[string]Token{"kids": tok}, tokens)
+
+	assert.Nil(suite.T(), suite.aliases.DelToken("kids"))
+	tokens, err = suite.aliases.Tokens()
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 0, len(tokens))
+}
+
+// Validates recording and querying the wake history.
+func (suite *AliasDBTests) TestHistory() {
+	t0 := time.Now()
+	for i, target := range []string{"nas", "tv", "nas"} {
+		err := suite.aliases.AddHistory(HistoryEntry{Time: t0.Add(time.Duration(i) * time.Minute), Target: target})
+		assert.Nil(suite.T(), err)
+	}
+	// Entries at the same time are all kept.
+	assert.Nil(suite.T(), suite.aliases.AddHistory(HistoryEntry{Time: t0, Target: "tv"}))
+
+	entries, err := suite.aliases.History(time.Time{}, "")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 4, len(entries))
+
+	entries, err = suite.aliases.History(time.Time{}, "nas")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, len(entries))
+
+	entries, err = suite.aliases.History(t0.Add(30*time.Second), "")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 2, len(entries))
+	assert.Equal(suite.T(), "tv", entries[0].Target)
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 // Group up all the test suites we wish to run and dispatch them here.
@@ -0,0 +1,210 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// tokenContextKey is the request context key of the authenticated *Token.
+type tokenContextKey struct{}
+
+// bearerSecret returns the token secret of a request, taken from either the
+// `Authorization: Bearer` header or (for EventSource clients, which cannot
+// set headers) the `token` query parameter.
+func bearerSecret(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.URL.Query().Get("token")
+}
+
+// certNames returns the names a client certificate identifies its holder
+// by: its common name, DNS names and email addresses.
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	return append(names, cert.EmailAddresses...)
+}
+
+// certToken returns the token the verified client certificate of `state`
+// authenticates as, if any. Should several tokens list one of its names, the
+// first by token name wins.
+func certToken(state *tls.ConnectionState, tokens map[string]Token) *Token {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	names := certNames(state.VerifiedChains[0][0])
+	tokenNames := make([]string, 0, len(tokens))
+	for name := range tokens {
+		tokenNames = append(tokenNames, name)
+	}
+	sort.Strings(tokenNames)
+	for _, tokenName := range tokenNames {
+		tok := tokens[tokenName]
+		for _, want := range tok.Certs {
+			for _, name := range names {
+				if name == want {
+					return &tok
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// authed wraps `h` so that it requires a valid token, if any tokens have
+// been defined or OIDC login is configured. The token is made available to `h` through requestToken.
+func (s *server) authed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens, err := s.aliases.Tokens()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Without any tokens the API is open, as it was before tokens,
+		// unless users are to log in.
+		if len(tokens) == 0 && s.oidc == nil {
+			h(w, r)
+			return
+		}
+
+		// Clients with a verified certificate mapped to a token need no
+		// secret.
+		if tok := certToken(r.TLS, tokens); tok != nil {
+			h(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, tok)))
+			return
+		}
+
+		// Dashboard users logged in through OIDC act as the token mapped to
+		// one of their groups. As browsers send cookies with requests from
+		// other sites, those may only read.
+		if s.oidc != nil {
+			if sess, ok := s.oidc.session(r); ok {
+				tok := sessionToken(sess, tokens)
+				if tok == nil {
+					writeError(w, http.StatusForbidden, fmt.Errorf("%s is in no group a token is mapped to", sess.User))
+					return
+				}
+				if r.Method != "GET" && r.Method != "HEAD" && !sameOrigin(r) {
+					writeError(w, http.StatusForbidden, errors.New("cross-origin request"))
+					return
+				}
+				ctx := context.WithValue(r.Context(), tokenContextKey{}, tok)
+				h(w, r.WithContext(context.WithValue(ctx, userContextKey{}, sess.User)))
+				return
+			}
+		}
+
+		// Signed requests carry no secret, but an HMAC of the request.
+		if strings.HasPrefix(r.Header.Get("Authorization"), signatureScheme) {
+			tok, err := s.verifySignature(r, tokens)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+			h(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, tok)))
+			return
+		}
+
+		hash := hashTokenSecret(bearerSecret(r))
+		for _, tok := range tokens {
+			if subtle.ConstantTimeCompare(hash, tok.Hash) == 1 {
+				tok := tok
+				h(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, &tok)))
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="go-wol"`)
+		if s.oidc != nil {
+			w.Header().Set("X-Login", "/oidc/login")
+		}
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+	}
+}
+
+// requestToken returns the token a request was authenticated with, or nil
+// if the API is unauthenticated.
+func requestToken(r *http.Request) *Token {
+	tok, _ := r.Context().Value(tokenContextKey{}).(*Token)
+	return tok
+}
+
+// sameOrigin returns true if the request comes from a page served by the
+// server itself, or from no page at all.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// apiClient describes the client of a request in the wake history, by its
+// token name (and OIDC user, if any) and address.
+func apiClient(r *http.Request) string {
+	if p, _ := r.Context().Value(peerContextKey{}).(*peer); p != nil {
+		return "local:" + p.name
+	}
+	if user, _ := r.Context().Value(userContextKey{}).(string); user != "" {
+		return "api:" + requestToken(r).name + "/" + user + "@" + clientIP(r)
+	}
+	if tok := requestToken(r); tok != nil {
+		return "api:" + tok.name + "@" + clientIP(r)
+	}
+	return "api:" + clientIP(r)
+}
+
+// allowed returns true if the request may access the alias `name`.
+func allowed(r *http.Request, name string, mi MacIface) bool {
+	tok := requestToken(r)
+	return tok == nil || tok.Allows(name, mi)
+}
+
+// visibleAliases returns the aliases the request may access.
+func (s *server) visibleAliases(r *http.Request) ([]Alias, error) {
+	list, err := s.aliases.List()
+	if err != nil {
+		return nil, err
+	}
+	visible := list[:0]
+	for _, alias := range list {
+		if allowed(r, alias.Name, alias.MacIface) {
+			visible = append(visible, alias)
+		}
+	}
+	return visible, nil
+}
+
+// mayWake returns true if the request may wake `target`. Restricted tokens
+// may only wake the aliases they allow, and not raw MAC addresses. Neither
+// may tenants, which share the gateway with others, unless their token
+// allows MACs.
+func (s *server) mayWake(r *http.Request, target string) bool {
+	tok := requestToken(r)
+	mi, err := s.aliases.Get(target)
+	if err != nil && s.tenant != "" && (tok == nil || !tok.MACs) {
+		return false
+	}
+	if tok == nil || tok.Unrestricted() {
+		return true
+	}
+	return err == nil && tok.Allows(target, mi)
+}
@@ -0,0 +1,80 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"sort"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// bootSamples is how many of a machine's latest verified wakes its
+	// usual boot time is learnt from.
+	bootSamples = 10
+
+	// defaultVerifyTimeout is how long to wait for a machine to come up
+	// when its boot time is not known yet.
+	defaultVerifyTimeout = 60 * time.Second
+
+	// minVerifyTimeout is the least a learnt verify timeout is.
+	minVerifyTimeout = 30 * time.Second
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// usualBootTimes returns how long each target of the history `entries`
+// (oldest first) usually takes to come up after a wake: the median of its
+// latest bootSamples successful verifications. Targets which were never
+// verified are left out.
+func usualBootTimes(entries []HistoryEntry) map[string]time.Duration {
+	samples := map[string][]time.Duration{}
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		e := entries[idx]
+		if e.Verify == "" || e.Error != "" || e.UpAfter <= 0 || len(samples[e.Target]) == bootSamples {
+			continue
+		}
+		samples[e.Target] = append(samples[e.Target], e.UpAfter)
+	}
+
+	usual := make(map[string]time.Duration, len(samples))
+	for target, ups := range samples {
+		sort.Slice(ups, func(i, j int) bool { return ups[i] < ups[j] })
+		usual[target] = ups[len(ups)/2]
+	}
+	return usual
+}
+
+// UsualBootTime returns how long `target` usually takes to come up after a
+// wake, and false if it is not known.
+func (a *Aliases) UsualBootTime(target string) (time.Duration, bool) {
+	entries, err := a.History(time.Time{}, target)
+	if err != nil {
+		return 0, false
+	}
+	usual, ok := usualBootTimes(entries)[target]
+	return usual, ok
+}
+
+// verifyTimeout returns how long to wait for a machine which usually comes
+// up after `usual` (or 0 if that is not known): twice that, so that a slow
+// boot is not taken for a failed wake, but at least minVerifyTimeout.
+func verifyTimeout(usual time.Duration) time.Duration {
+	if usual <= 0 {
+		return defaultVerifyTimeout
+	}
+	timeout := (2 * usual).Round(time.Second)
+	if timeout < minVerifyTimeout {
+		return minVerifyTimeout
+	}
+	return timeout
+}
+
+// aboutDuration formats `d` as "~45s" or "~2m10s", to the second.
+func aboutDuration(d time.Duration) string {
+	if d < time.Second {
+		d = time.Second
+	}
+	return "~" + d.Round(time.Second).String()
+}
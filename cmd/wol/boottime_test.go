@@ -0,0 +1,61 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestUsualBootTimes(t *testing.T) {
+	verified := func(target string, up time.Duration) HistoryEntry {
+		return HistoryEntry{Target: target, Verify: "ssh", UpAfter: up}
+	}
+	entries := []HistoryEntry{
+		verified("nas", 300*time.Second), // older than the last bootSamples
+		{Target: "nas"},
+		{Target: "nas", Verify: "ssh", Error: "timed out"},
+		{Target: "tv"},
+	}
+	for i := 0; i < bootSamples; i++ {
+		entries = append(entries, verified("nas", time.Duration(40+i)*time.Second))
+	}
+	entries = append(entries, verified("pc", 20*time.Second), verified("pc", 90*time.Second))
+
+	assert.Equal(t, map[string]time.Duration{
+		"nas": 45 * time.Second,
+		"pc":  90 * time.Second,
+	}, usualBootTimes(entries))
+}
+
+func TestUsualBootTime(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestUsualBootTime.db")
+	defer cleanup()
+
+	_, ok := aliases.UsualBootTime("nas")
+	assert.False(t, ok)
+
+	now := time.Now()
+	for idx, up := range []time.Duration{40 * time.Second, 50 * time.Second, 45 * time.Second} {
+		assert.Nil(t, aliases.AddHistory(HistoryEntry{
+			Time: now.Add(time.Duration(idx) * time.Minute), Target: "nas", Verify: "ssh", UpAfter: up,
+		}))
+	}
+	usual, ok := aliases.UsualBootTime("nas")
+	assert.True(t, ok)
+	assert.Equal(t, 45*time.Second, usual)
+}
+
+func TestVerifyTimeout(t *testing.T) {
+	assert.Equal(t, defaultVerifyTimeout, verifyTimeout(0))
+	assert.Equal(t, minVerifyTimeout, verifyTimeout(10*time.Second))
+	assert.Equal(t, 91*time.Second, verifyTimeout(45500*time.Millisecond))
+
+	assert.Equal(t, "~45s", aboutDuration(45400*time.Millisecond))
+	assert.Equal(t, "~2m10s", aboutDuration(130*time.Second))
+	assert.Equal(t, "~1s", aboutDuration(0))
+}
@@ -0,0 +1,99 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	limitedBroadcast = "255.255.255.255"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// directedBroadcast returns the broadcast address of the subnet of the alias
+// address `cidr` (e.g. 192.168.1.255 for 192.168.1.20/24). Only IPv4 subnets
+// with room for hosts and a broadcast address (up to /30) have one.
+func directedBroadcast(cidr string) (net.IP, error) {
+	ip, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q, expected e.g. 192.168.1.20/24", cidr)
+	}
+	base := subnet.IP.To4()
+	if ip.To4() == nil || base == nil {
+		return nil, fmt.Errorf("%s is not an IPv4 address, which have no broadcast address", cidr)
+	}
+	if ones, _ := subnet.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("the subnet of %s has no broadcast address", cidr)
+	}
+
+	mask := net.IP(subnet.Mask).To4()
+	bcast := make(net.IP, net.IPv4len)
+	for i := range bcast {
+		bcast[i] = base[i] | ^mask[i]
+	}
+	return bcast, nil
+}
+
+// checkAliasIP checks the alias address `cidr`, which is an address and the
+// prefix of its subnet. Addresses whose subnet has no broadcast address (such
+// as a /32) are still kept, to reach the machine at, but are woken with the
+// limited broadcast.
+func checkAliasIP(cidr string) error {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return fmt.Errorf("invalid address %q, expected e.g. 192.168.1.20/24", cidr)
+	}
+	return nil
+}
+
+// hostCIDR returns `ip` with the prefix of the (most specific) subnet of the
+// interfaces in `snap` it is in, or as a single address if it is in none (or
+// `snap` is nil), for storing it as an alias address.
+func hostCIDR(snap *ifaceSnapshot, ip net.IP) string {
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	ones := -1
+	if snap != nil {
+		for _, addrs := range snap.addrs {
+			for _, addr := range addrs {
+				if ipnet, ok := addr.(*net.IPNet); ok && ipnet.Contains(ip) {
+					if n, b := ipnet.Mask.Size(); b == bits && n > ones {
+						ones = n
+					}
+				}
+			}
+		}
+	}
+	if ones < 0 {
+		ones = bits
+	}
+	return fmt.Sprintf("%s/%d", ip, ones)
+}
+
+// resolveHost looks up the current address of the alias host name `host`,
+// preferring IPv4, so that machines whose address comes from DHCP (and is
+// published through dynamic DNS) are found where they are now.
+func resolveHost(ctx context.Context, host string) (net.IP, error) {
+	ctx, cancel := withTimeout(ctx, opSend)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s has no addresses", host)
+	}
+	return addrs[0].IP, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestDirectedBroadcast(t *testing.T) {
+	for cidr, expected := range map[string]string{
+		"192.168.1.20/24": "192.168.1.255",
+		"10.1.2.3/8":      "10.255.255.255",
+		"172.16.5.4/20":   "172.16.15.255",
+		"192.168.1.9/30":  "192.168.1.11",
+	} {
+		ip, err := directedBroadcast(cidr)
+		assert.Nil(t, err, cidr)
+		assert.Equal(t, expected, ip.String(), cidr)
+	}
+
+	for _, cidr := range []string{"192.168.1.20", "10.0.0.1/31", "10.0.0.1/32", "fe80::1/64", "nope/24"} {
+		_, err := directedBroadcast(cidr)
+		assert.NotNil(t, err, cidr)
+	}
+}
+
+func TestCheckAliasIP(t *testing.T) {
+	for _, cidr := range []string{"192.168.1.20/24", "10.0.0.1/32", "fe80::1/64"} {
+		assert.Nil(t, checkAliasIP(cidr), cidr)
+	}
+	for _, cidr := range []string{"192.168.1.20", "nope/24", ""} {
+		assert.NotNil(t, checkAliasIP(cidr), cidr)
+	}
+}
+
+func TestHostCIDR(t *testing.T) {
+	addrs := func(cidrs ...string) []net.Addr {
+		var list []net.Addr
+		for _, cidr := range cidrs {
+			ip, ipnet, _ := net.ParseCIDR(cidr)
+			list = append(list, &net.IPNet{IP: ip, Mask: ipnet.Mask})
+		}
+		return list
+	}
+	snap := &ifaceSnapshot{addrs: map[string][]net.Addr{
+		"eth0": addrs("192.168.1.2/24", "fd00::2/64"),
+		"eth1": addrs("10.0.0.2/8"),
+		"eth2": addrs("10.1.0.2/16"),
+	}}
+
+	assert.Equal(t, "192.168.1.20/24", hostCIDR(snap, net.ParseIP("192.168.1.20")))
+	assert.Equal(t, "10.1.2.3/16", hostCIDR(snap, net.ParseIP("10.1.2.3")))
+	assert.Equal(t, "10.2.0.1/8", hostCIDR(snap, net.ParseIP("10.2.0.1")))
+	assert.Equal(t, "fd00::20/64", hostCIDR(snap, net.ParseIP("fd00::20")))
+	assert.Equal(t, "172.16.0.1/32", hostCIDR(snap, net.ParseIP("172.16.0.1")))
+	assert.Equal(t, "172.16.0.1/32", hostCIDR(nil, net.ParseIP("172.16.0.1")))
+	assert.Equal(t, "2001:db8::1/128", hostCIDR(snap, net.ParseIP("2001:db8::1")))
+}
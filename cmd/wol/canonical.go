@@ -0,0 +1,202 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// canonicalHeader is the first line of a canonical export, which versions the
+// format.
+const canonicalHeader = `{"format":"go-wol-aliases","version":1}`
+
+// canonicalTrailer holds the checksum which ends a canonical export.
+type canonicalTrailer struct {
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// canonicalExport returns the aliases in the canonical export format: a
+// header line, then one JSON line per alias in the order of `list` (which
+// Aliases.List sorts by name), with the MAC address normalized and tags
+// sorted, then a line with the SHA-256 of everything above it. The output for
+// a given set of aliases is always the same, which keeps diffs of exports
+// kept in git minimal.
+func canonicalExport(list []Alias) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(canonicalHeader + "\n")
+	for _, alias := range list {
+		mac, ok := canonicalMAC(alias.Mac)
+		if !ok {
+			return nil, fmt.Errorf("alias %s has an invalid mac address %s", alias.Name, alias.Mac)
+		}
+		tags := append([]string(nil), alias.Tags...)
+		sort.Strings(tags)
+
+		line, err := json.Marshal(aliasEntry{Name: alias.Name, Mac: mac, Iface: alias.Iface, Tags: tags, IP: alias.IP, Host: alias.Host, Cooldown: alias.Cooldown})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	trailer, err := json.Marshal(canonicalTrailer{SHA256: hex.EncodeToString(sum[:])})
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(trailer)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// parseCanonical parses a canonical export, checking its checksum.
+func parseCanonical(data []byte) (map[string]MacIface, error) {
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) < 2 || string(lines[0]) != canonicalHeader {
+		return nil, errors.New("not a canonical go-wol alias export")
+	}
+
+	// Find the checksum, which covers every line before it.
+	offset, sumIdx := 0, -1
+	var sumOffset int
+	var trailer canonicalTrailer
+	for idx, line := range lines {
+		if idx > 0 && json.Unmarshal(line, &trailer) == nil && trailer.SHA256 != "" {
+			sumIdx, sumOffset = idx, offset
+			break
+		}
+		offset += len(line) + 1
+	}
+	if sumIdx < 0 {
+		return nil, errors.New("the export has no checksum")
+	}
+	sum := sha256.Sum256(data[:sumOffset])
+	if hex.EncodeToString(sum[:]) != trailer.SHA256 {
+		return nil, errors.New("checksum mismatch, the export was modified")
+	}
+
+	if sumIdx+1 < len(lines) {
+		return nil, errors.New("unexpected data after the checksum")
+	}
+
+	mp := map[string]MacIface{}
+	for _, line := range lines[1:sumIdx] {
+		var e aliasEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, err
+		}
+		if e.Name == "" {
+			return nil, errors.New("the export has an alias without a name")
+		}
+		if _, ok := canonicalMAC(e.Mac); !ok {
+			return nil, fmt.Errorf("alias %s has an invalid mac address %s", e.Name, e.Mac)
+		}
+		if e.IP != "" {
+			if err := checkAliasIP(e.IP); err != nil {
+				return nil, fmt.Errorf("alias %s: %v", e.Name, err)
+			}
+		}
+		mp[e.Name] = MacIface{Mac: e.Mac, Iface: e.Iface, Tags: e.Tags, IP: e.IP, Host: e.Host, Cooldown: e.Cooldown}
+	}
+	return mp, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// verifyImport checks that the export `data`, read from `file`, is signed by
+// the `--verify-key`, with the minisign signature in `--signature` (or else
+// next to the file).
+func verifyImport(file string, data []byte) error {
+	key, err := loadMinisignKey(cliFlags.VerifyKey)
+	if err != nil {
+		return err
+	}
+	sigFile := cliFlags.Signature
+	if sigFile == "" {
+		sigFile = file + ".minisig"
+	}
+	sig, err := ioutil.ReadFile(sigFile)
+	if err != nil {
+		return fmt.Errorf("the export is not signed: %v", err)
+	}
+	comment, err := key.verify(data, string(sig))
+	if err != nil {
+		return fmt.Errorf("%s: %v", sigFile, err)
+	}
+	progressf("Good signature, trusted comment: %s\n", comment)
+	return nil
+}
+
+// Run the import command.
+func importCmd(args []string, aliases *Aliases) error {
+	if cliFlags.Router != "" {
+		return importRouter(cliFlags.Router, cliFlags.URL, cliFlags.Token, aliases)
+	}
+	if len(args) != 1 {
+		return errors.New("import command requires the <file> of a canonical export (or nmap scan)")
+	}
+
+	if cliFlags.Nmap {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return importNmap(f, aliases)
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if cliFlags.VerifyKey != "" {
+		if err := verifyImport(args[0], data); err != nil {
+			return err
+		}
+	}
+
+	// Everything is validated before any alias is written.
+	mp, err := parseCanonical(data)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(mp))
+	for name := range mp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err = aliases.Batch(func(b *Batch) error {
+		for _, name := range names {
+			mi := mp[name]
+			if err := b.Put(name, mi); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	statusf("Imported %d alias(es) from %s\n", len(names), args[0])
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+var testCanonicalAliases = []Alias{
+	{Name: "nas", MacIface: MacIface{Mac: "00:11:22:33:44:55", Iface: "eth0"}},
+	{Name: "tv", MacIface: MacIface{Mac: "00-11-22-33-44-6f", Tags: []string{"media", "kids"}}},
+}
+
+func TestCanonicalExport(t *testing.T) {
+	bs, err := canonicalExport(testCanonicalAliases)
+	assert.Nil(t, err)
+	assert.Equal(t, canonicalHeader+"\n"+
+		`{"name":"nas","mac":"00:11:22:33:44:55","iface":"eth0"}`+"\n"+
+		`{"name":"tv","mac":"00:11:22:33:44:6f","tags":["kids","media"]}`+"\n"+
+		`{"sha256":"`, string(bs[:bytes.LastIndex(bs, []byte(`{"sha256":"`))+11]))
+
+	// The export is deterministic.
+	again, err := canonicalExport(testCanonicalAliases)
+	assert.Nil(t, err)
+	assert.Equal(t, bs, again)
+
+	mp, err := parseCanonical(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(mp))
+	assert.Equal(t, MacIface{Mac: "00:11:22:33:44:6f", Tags: []string{"kids", "media"}}, mp["tv"])
+
+	_, err = canonicalExport([]Alias{{Name: "bad", MacIface: MacIface{Mac: "nope"}}})
+	assert.NotNil(t, err)
+}
+
+func TestParseCanonicalNegative(t *testing.T) {
+	bs, err := canonicalExport(testCanonicalAliases)
+	assert.Nil(t, err)
+
+	for _, data := range [][]byte{
+		nil,
+		[]byte("nas 00:11:22:33:44:55\n"),
+		bytes.Replace(bs, []byte("eth0"), []byte("eth1"), 1),
+		bs[:bytes.LastIndex(bs, []byte(`{"sha256"`))],
+		append(append([]byte(nil), bs...), []byte("{}\n")...),
+		append(append([]byte(nil), bs...), []byte(`{"signature":"AAAA"}`+"\n")...),
+	} {
+		_, err := parseCanonical(data)
+		assert.NotNil(t, err)
+	}
+}
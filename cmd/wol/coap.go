@@ -0,0 +1,518 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	wol "github.com/sabhiram/go-wol"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// A minimal CoAP (RFC 7252) server which exposes a single `wake` resource so
+// that constrained devices can trigger wakes with one UDP datagram:
+//
+//     POST coap://gateway/wake/<alias or mac>
+//     POST coap://gateway/wake          (with the alias or mac as payload)
+//
+// Only what is needed for that is implemented: piggybacked responses to CON
+// requests, NON responses to NON requests, duplicate detection, and
+// /.well-known/core discovery. CoAP over UDP has no authentication and its
+// senders can be spoofed, so the server listens on loopback unless told
+// otherwise, only answers `--coap-allow` clients if given, and only wakes
+// aliases (and, with `--coap-allow-macs`, bare MAC addresses).
+
+const (
+	coapVersion = 1
+
+	coapTypeCON = 0
+	coapTypeNON = 1
+	coapTypeACK = 2
+	coapTypeRST = 3
+
+	coapCodeGET              = 0x01
+	coapCodePOST             = 0x02
+	coapCodeValid            = 0x43 // 2.03
+	coapCodeChanged          = 0x44 // 2.04
+	coapCodeContent          = 0x45 // 2.05
+	coapCodeBadRequest       = 0x80 // 4.00
+	coapCodeForbidden        = 0x83 // 4.03
+	coapCodeNotFound         = 0x84 // 4.04
+	coapCodeMethodNotAllowed = 0x85 // 4.05
+	coapCodeInternalError    = 0xA0 // 5.00
+
+	coapOptionURIPath       = 11
+	coapOptionContentFormat = 12
+	coapOptionMaxAge        = 14
+
+	defaultCoAPListen = "127.0.0.1:5683"
+
+	// coapMaxInFlight bounds the wake requests handled at once.
+	coapMaxInFlight = 16
+
+	coapFormatText     = 0
+	coapFormatLinkFmt  = 40
+	coapPayloadMarker  = 0xFF
+	coapMaxMessageSize = 1152
+
+	// coapExchangeLifetime is how long a message ID is remembered for, after
+	// which a sender may reuse it (EXCHANGE_LIFETIME, RFC 7252 §4.8.2).
+	coapExchangeLifetime = 247 * time.Second
+
+	// coapDedupMax bounds the number of remembered exchanges.
+	coapDedupMax = 4096
+)
+
+var errCoAPMalformed = errors.New("malformed coap message")
+
+////////////////////////////////////////////////////////////////////////////////
+
+// coapOption is a single (number, value) option from a CoAP message.
+type coapOption struct {
+	number uint16
+	value  []byte
+}
+
+// coapMessage is a decoded CoAP message.
+type coapMessage struct {
+	msgType   byte
+	code      byte
+	messageID uint16
+	token     []byte
+	options   []coapOption
+	payload   []byte
+}
+
+// path returns the Uri-Path segments of the message.
+func (m *coapMessage) path() []string {
+	var segs []string
+	for _, o := range m.options {
+		if o.number == coapOptionURIPath {
+			segs = append(segs, string(o.value))
+		}
+	}
+	return segs
+}
+
+// parseCoAP decodes a CoAP message from `bs`.
+func parseCoAP(bs []byte) (*coapMessage, error) {
+	if len(bs) < 4 || bs[0]>>6 != coapVersion {
+		return nil, errCoAPMalformed
+	}
+
+	tkl := int(bs[0] & 0x0F)
+	if tkl > 8 || len(bs) < 4+tkl {
+		return nil, errCoAPMalformed
+	}
+
+	m := &coapMessage{
+		msgType:   (bs[0] >> 4) & 0x03,
+		code:      bs[1],
+		messageID: uint16(bs[2])<<8 | uint16(bs[3]),
+		token:     bs[4 : 4+tkl],
+	}
+
+	number := 0
+	rest := bs[4+tkl:]
+	for len(rest) > 0 {
+		if rest[0] == coapPayloadMarker {
+			if len(rest) == 1 {
+				return nil, errCoAPMalformed
+			}
+			m.payload = rest[1:]
+			break
+		}
+
+		delta, length := int(rest[0]>>4), int(rest[0]&0x0F)
+		rest = rest[1:]
+
+		var err error
+		if delta, rest, err = coapExtended(delta, rest); err != nil {
+			return nil, err
+		}
+		if length, rest, err = coapExtended(length, rest); err != nil {
+			return nil, err
+		}
+		if len(rest) < length {
+			return nil, errCoAPMalformed
+		}
+
+		number += delta
+		m.options = append(m.options, coapOption{uint16(number), rest[:length]})
+		rest = rest[length:]
+	}
+	return m, nil
+}
+
+// coapExtended decodes an option delta or length nibble, consuming any
+// extended bytes from `rest`.
+func coapExtended(v int, rest []byte) (int, []byte, error) {
+	switch {
+	case v < 13:
+		return v, rest, nil
+	case v == 13 && len(rest) >= 1:
+		return int(rest[0]) + 13, rest[1:], nil
+	case v == 14 && len(rest) >= 2:
+		return (int(rest[0])<<8 | int(rest[1])) + 269, rest[2:], nil
+	}
+	return 0, nil, errCoAPMalformed
+}
+
+// coapNibble encodes an option delta or length as a nibble and any extended
+// bytes which follow the option header.
+func coapNibble(v int) (byte, []byte) {
+	switch {
+	case v < 13:
+		return byte(v), nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	}
+	v -= 269
+	return 14, []byte{byte(v >> 8), byte(v)}
+}
+
+// marshal encodes the message. Options must be sorted by number.
+func (m *coapMessage) marshal() []byte {
+	bs := []byte{
+		coapVersion<<6 | m.msgType<<4 | byte(len(m.token)),
+		m.code,
+		byte(m.messageID >> 8),
+		byte(m.messageID),
+	}
+	bs = append(bs, m.token...)
+
+	prev := uint16(0)
+	for _, o := range m.options {
+		delta, dext := coapNibble(int(o.number - prev))
+		length, lext := coapNibble(len(o.value))
+		bs = append(bs, delta<<4|length)
+		bs = append(bs, dext...)
+		bs = append(bs, lext...)
+		bs = append(bs, o.value...)
+		prev = o.number
+	}
+
+	if len(m.payload) > 0 {
+		bs = append(bs, coapPayloadMarker)
+		bs = append(bs, m.payload...)
+	}
+	return bs
+}
+
+// coapResponse builds the response to `req` with the given code, content
+// format and payload.
+func coapResponse(req *coapMessage, code byte, format byte, payload string) *coapMessage {
+	resp := &coapMessage{
+		msgType:   coapTypeACK,
+		code:      code,
+		messageID: req.messageID,
+		token:     req.token,
+		payload:   []byte(payload),
+	}
+	if req.msgType == coapTypeNON {
+		// NON requests get NON responses; the message ID only needs to differ
+		// from the request's.
+		resp.msgType = coapTypeNON
+		resp.messageID = req.messageID + 1
+	}
+
+	// Content-Format 0 is encoded as a zero length option value.
+	var value []byte
+	if format != coapFormatText {
+		value = []byte{format}
+	}
+	resp.options = []coapOption{{coapOptionContentFormat, value}}
+	return resp
+}
+
+// coapUint encodes an unsigned option value in as few bytes as possible.
+func coapUint(v uint32) []byte {
+	var bs []byte
+	for ; v > 0; v >>= 8 {
+		bs = append([]byte{byte(v)}, bs...)
+	}
+	return bs
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// coapExchange identifies a message by its sender and message ID.
+type coapExchange struct {
+	from string
+	id   uint16
+}
+
+// coapSeen is the response sent to an exchange, and when.
+type coapSeen struct {
+	at   time.Time
+	resp []byte // nil if no response was sent
+}
+
+// coapDedup remembers recent exchanges, so that a retransmitted request is
+// answered with the response to the original instead of being handled (and
+// waking the machine) again (RFC 7252 §4.5).
+type coapDedup struct {
+	mtx  sync.Mutex
+	seen map[coapExchange]coapSeen
+}
+
+// newCoAPDedup returns an empty duplicate cache.
+func newCoAPDedup() *coapDedup {
+	return &coapDedup{seen: map[coapExchange]coapSeen{}}
+}
+
+// lookup returns the response to the message `id` from `from` if it is a
+// duplicate of one seen at `now`.
+func (d *coapDedup) lookup(from string, id uint16, now time.Time) ([]byte, bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	seen, ok := d.seen[coapExchange{from, id}]
+	if !ok || now.Sub(seen.at) >= coapExchangeLifetime {
+		return nil, false
+	}
+	return seen.resp, true
+}
+
+// remember records the response sent to the message `id` from `from`, which
+// is nil while it is being handled (or if none is sent).
+func (d *coapDedup) remember(from string, id uint16, resp []byte, now time.Time) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	key := coapExchange{from, id}
+	if _, ok := d.seen[key]; ok {
+		d.seen[key] = coapSeen{now, resp}
+		return
+	}
+	if len(d.seen) >= coapDedupMax {
+		for k, seen := range d.seen {
+			if now.Sub(seen.at) >= coapExchangeLifetime {
+				delete(d.seen, k)
+			}
+		}
+	}
+	if len(d.seen) < coapDedupMax {
+		d.seen[key] = coapSeen{now, resp}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// errCoAPTarget is returned for a target CoAP clients may not wake.
+var errCoAPTarget = errors.New("not an alias")
+
+// checkCoAPTarget returns errCoAPTarget unless `target` is an alias or, with
+// `--coap-allow-macs`, a bare MAC address. Target specs could send packets
+// anywhere, so they are never accepted.
+func checkCoAPTarget(target string, aliases *Aliases) error {
+	if _, err := aliases.Get(target); err == nil {
+		return nil
+	}
+	if _, err := wol.ParseMAC(target); err == nil && cliFlags.CoAPAllowMACs {
+		return nil
+	}
+	return errCoAPTarget
+}
+
+// coapAllowed returns true if `ip` is one of the `--coap-allow` clients, or
+// any client if none are given.
+func coapAllowed(ip net.IP, allowed []*net.IPNet) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, n := range allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// coapHandler handles a single CoAP request, using `wake` to send a magic
+// packet to an alias or MAC, which returns the time left in the cooldown if
+// the wake was coalesced with an earlier one. It returns nil if no response
+// should be sent.
+func coapHandler(req *coapMessage, wake func(string) (string, time.Duration, error)) *coapMessage {
+	// Only requests are handled; ACK / RST messages and empty pings are
+	// answered with a reset (or not at all).
+	if req.msgType == coapTypeACK || req.msgType == coapTypeRST {
+		return nil
+	}
+	if req.code == 0 {
+		return &coapMessage{msgType: coapTypeRST, messageID: req.messageID}
+	}
+
+	path := strings.Join(req.path(), "/")
+	switch {
+	case path == ".well-known/core":
+		if req.code != coapCodeGET {
+			return coapResponse(req, coapCodeMethodNotAllowed, coapFormatText, "")
+		}
+		return coapResponse(req, coapCodeContent, coapFormatLinkFmt, `</wake>;rt="wake";ct=0`)
+
+	case path == "wake" || strings.HasPrefix(path, "wake/"):
+		if req.code != coapCodePOST {
+			return coapResponse(req, coapCodeMethodNotAllowed, coapFormatText, "")
+		}
+
+		target := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(path, "wake"), "/"))
+		if target == "" {
+			target = strings.TrimSpace(string(req.payload))
+		}
+		if target == "" {
+			return coapResponse(req, coapCodeBadRequest, coapFormatText, "no mac address or alias specified")
+		}
+
+		// Errors are logged rather than told to a sender, which may not be
+		// who it claims to be.
+		mac, wait, err := wake(target)
+		if err == errCoAPTarget {
+			return coapResponse(req, coapCodeForbidden, coapFormatText, "not an alias")
+		}
+		if err != nil {
+			return coapResponse(req, coapCodeInternalError, coapFormatText, "wake failed")
+		}
+		if wait > 0 {
+			// Nothing was sent: the earlier wake still stands, and Max-Age
+			// says when another would be.
+			resp := coapResponse(req, coapCodeValid, coapFormatText, mac)
+			resp.options = append(resp.options, coapOption{coapOptionMaxAge, coapUint(uint32(wait.Round(time.Second) / time.Second))})
+			return resp
+		}
+		return coapResponse(req, coapCodeChanged, coapFormatText, mac)
+	}
+	return coapResponse(req, coapCodeNotFound, coapFormatText, "")
+}
+
+// Run the coap command.
+func coapCmd(args []string, aliases *Aliases) error {
+	laddr, err := net.ResolveUDPAddr("udp", listenAddr(defaultCoAPListen))
+	if err != nil {
+		return err
+	}
+	allowed, err := parseAddrNets("--coap-allow", cliFlags.CoAPAllow)
+	if err != nil {
+		return err
+	}
+	if len(allowed) == 0 && (laddr.IP == nil || !laddr.IP.IsLoopback()) {
+		log.Printf("WARNING: coap accepts wakes from anyone who can reach %s, give --coap-allow to restrict them\n", laddr)
+	}
+
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	startHealthServer()
+	stop := shutdownSignal()
+	go pruneHistory(aliases, stop)
+	watchInterfaces(stop, nil)
+	unblockOnStop(conn, stop)
+	log.Printf("Serving CoAP wake requests on %s\n", conn.LocalAddr())
+	done, err := daemonReady()
+	if err != nil {
+		return err
+	}
+	defer done()
+	dedup := newCoAPDedup()
+	inFlight := make(chan struct{}, coapMaxInFlight)
+	buf := make([]byte, coapMaxMessageSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if stopping(stop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !coapAllowed(from.IP, allowed) {
+			continue
+		}
+
+		req, err := parseCoAP(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		// Retransmissions get the original response, and wake nothing.
+		// Requests are remembered before they are handled, so that those
+		// retransmitted meanwhile are dropped.
+		now := time.Now()
+		if req.msgType == coapTypeCON || req.msgType == coapTypeNON {
+			if bs, ok := dedup.lookup(from.String(), req.messageID, now); ok {
+				log.Printf("Dropped duplicate message %d from %s\n", req.messageID, from)
+				if bs != nil {
+					conn.WriteToUDP(bs, from)
+				}
+				continue
+			}
+			dedup.remember(from.String(), req.messageID, nil, now)
+		}
+
+		// Wakes are handled concurrently, so that a slow one (e.g. of an
+		// alias with a host name to resolve) does not hold up the others.
+		inFlight <- struct{}{}
+		go func(req *coapMessage, from *net.UDPAddr, now time.Time) {
+			defer func() { <-inFlight }()
+			coapServe(conn, req, from, now, dedup, aliases)
+		}(req, from, now)
+	}
+}
+
+// coapServe handles the CoAP request `req` from `from`, received at `now`,
+// and sends its response.
+func coapServe(conn *net.UDPConn, req *coapMessage, from *net.UDPAddr, now time.Time, dedup *coapDedup, aliases *Aliases) {
+	var failure error
+	wake := func(target string) (string, time.Duration, error) {
+		if err := checkCoAPTarget(target, aliases); err != nil {
+			failure = err
+			return "", 0, err
+		}
+		ctx, sp := startSpan(context.Background(), "coap wake")
+		sp.set("wol.target", redactSpec(target))
+		wt, err := resolveWakeTarget(ctx, target, fromClient, aliases)
+		if err != nil {
+			sp.finish(err)
+			failure = err
+			return "", 0, err
+		}
+		wait, err := wt.wake(ctx, aliases, "coap:"+from.IP.String())
+		sp.finish(err)
+		failure = err
+		return wt.mac, wait, err
+	}
+
+	resp := coapHandler(req, wake)
+	if req.msgType == coapTypeCON || req.msgType == coapTypeNON {
+		var bs []byte
+		if resp != nil {
+			bs = resp.marshal()
+		}
+		dedup.remember(from.String(), req.messageID, bs, now)
+	}
+	if resp == nil {
+		return
+	}
+	switch resp.code {
+	case coapCodeChanged:
+		log.Printf("Woke %s for %s\n", resp.payload, from)
+	case coapCodeValid:
+		log.Printf("Coalesced the wake of %s for %s with a recent one\n", resp.payload, from)
+	case coapCodeForbidden, coapCodeInternalError:
+		log.Printf("Failed wake request from %s: %v\n", from, failure)
+	}
+	if _, err := conn.WriteToUDP(resp.marshal(), from); err != nil {
+		log.Printf("Failed to respond to %s: %v\n", from, err)
+	}
+}
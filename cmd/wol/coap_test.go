@@ -0,0 +1,202 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Builds a request with the given type, code, path and payload.
+func newCoAPRequest(msgType, code byte, payload string, path ...string) []byte {
+	req := &coapMessage{
+		msgType:   msgType,
+		code:      code,
+		messageID: 0x1234,
+		token:     []byte{0xCA, 0xFE},
+		payload:   []byte(payload),
+	}
+	for _, seg := range path {
+		req.options = append(req.options, coapOption{coapOptionURIPath, []byte(seg)})
+	}
+	return req.marshal()
+}
+
+func TestParseCoAP(t *testing.T) {
+	bs := newCoAPRequest(coapTypeCON, coapCodePOST, "skynet", "wake")
+	m, err := parseCoAP(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(coapTypeCON), m.msgType)
+	assert.Equal(t, byte(coapCodePOST), m.code)
+	assert.Equal(t, uint16(0x1234), m.messageID)
+	assert.Equal(t, []byte{0xCA, 0xFE}, m.token)
+	assert.Equal(t, []string{"wake"}, m.path())
+	assert.Equal(t, "skynet", string(m.payload))
+
+	// Extended option lengths (13+ bytes) decode correctly.
+	long := "a-rather-long-alias-name"
+	m, err = parseCoAP(newCoAPRequest(coapTypeNON, coapCodePOST, "", "wake", long))
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"wake", long}, m.path())
+	}
+}
+
+func TestParseCoAPNegative(t *testing.T) {
+	for _, bs := range [][]byte{
+		nil,
+		{0x40, 0x02},
+		{0x80, 0x02, 0x00, 0x01},             // version 2
+		{0x49, 0x02, 0x00, 0x01},             // token length 9
+		{0x40, 0x02, 0x00, 0x01, 0xFF},       // marker without payload
+		{0x40, 0x02, 0x00, 0x01, 0xB4, 'w'},  // truncated option
+		{0x40, 0x02, 0x00, 0x01, 0xD0},       // missing extended delta
+		{0x40, 0x02, 0x00, 0x01, 0xF0, 0x00}, // reserved nibble
+	} {
+		_, err := parseCoAP(bs)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestCoAPHandler(t *testing.T) {
+	var woken []string
+	wake := func(target string) (string, time.Duration, error) {
+		if target == "broken" {
+			return "", 0, errors.New("boom")
+		}
+		if target == "10:20:30:40:50:60@192.0.2.1" {
+			return "", 0, errCoAPTarget
+		}
+		if target == "recent" {
+			return "00:11:22:33:44:56", 90 * time.Second, nil
+		}
+		woken = append(woken, target)
+		return "00:11:22:33:44:55", 0, nil
+	}
+
+	for _, tc := range []struct {
+		req  []byte
+		code byte
+	}{
+		{newCoAPRequest(coapTypeCON, coapCodePOST, "", "wake", "skynet"), coapCodeChanged},
+		{newCoAPRequest(coapTypeCON, coapCodePOST, "skynet\n", "wake"), coapCodeChanged},
+		{newCoAPRequest(coapTypeCON, coapCodePOST, "", "wake"), coapCodeBadRequest},
+		{newCoAPRequest(coapTypeCON, coapCodeGET, "", "wake"), coapCodeMethodNotAllowed},
+		{newCoAPRequest(coapTypeCON, coapCodePOST, "broken", "wake"), coapCodeInternalError},
+		{newCoAPRequest(coapTypeCON, coapCodePOST, "10:20:30:40:50:60@192.0.2.1", "wake"), coapCodeForbidden},
+		{newCoAPRequest(coapTypeCON, coapCodePOST, "recent", "wake"), coapCodeValid},
+		{newCoAPRequest(coapTypeCON, coapCodePOST, "", "sleep"), coapCodeNotFound},
+		{newCoAPRequest(coapTypeCON, coapCodeGET, "", ".well-known", "core"), coapCodeContent},
+	} {
+		req, err := parseCoAP(tc.req)
+		assert.Nil(t, err)
+
+		resp := coapHandler(req, wake)
+		assert.Equal(t, tc.code, resp.code)
+		assert.NotContains(t, string(resp.payload), "boom")
+		assert.Equal(t, byte(coapTypeACK), resp.msgType)
+		assert.Equal(t, req.messageID, resp.messageID)
+		assert.Equal(t, req.token, resp.token)
+
+		// Responses must round trip through the parser.
+		_, err = parseCoAP(resp.marshal())
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []string{"skynet", "skynet"}, woken)
+}
+
+func TestCoAPHandlerMessageTypes(t *testing.T) {
+	wake := func(string) (string, time.Duration, error) { return "", 0, nil }
+
+	// NON requests get NON responses with a fresh message ID.
+	req, _ := parseCoAP(newCoAPRequest(coapTypeNON, coapCodePOST, "x", "wake"))
+	resp := coapHandler(req, wake)
+	assert.Equal(t, byte(coapTypeNON), resp.msgType)
+	assert.NotEqual(t, req.messageID, resp.messageID)
+
+	// Empty CON messages (pings) get a reset.
+	req, _ = parseCoAP(newCoAPRequest(coapTypeCON, 0, ""))
+	resp = coapHandler(req, wake)
+	assert.Equal(t, byte(coapTypeRST), resp.msgType)
+
+	// ACKs are ignored.
+	req, _ = parseCoAP(newCoAPRequest(coapTypeACK, 0, ""))
+	assert.Nil(t, coapHandler(req, wake))
+}
+
+// Coalesced wakes say how long until another would be sent.
+func TestCoAPHandlerCoalesced(t *testing.T) {
+	wake := func(string) (string, time.Duration, error) { return "00:11:22:33:44:55", 300 * time.Second, nil }
+
+	req, _ := parseCoAP(newCoAPRequest(coapTypeCON, coapCodePOST, "x", "wake"))
+	resp, err := parseCoAP(coapHandler(req, wake).marshal())
+	assert.Nil(t, err)
+	assert.Equal(t, byte(coapCodeValid), resp.code)
+	assert.Equal(t, []coapOption{{coapOptionContentFormat, []byte{}}, {coapOptionMaxAge, []byte{0x01, 0x2C}}}, resp.options)
+}
+
+// Retransmissions are recognised by sender and message ID for the exchange
+// lifetime.
+func TestCoAPDedup(t *testing.T) {
+	now := time.Now()
+	d := newCoAPDedup()
+
+	_, ok := d.lookup("10.0.0.1:5683", 1, now)
+	assert.False(t, ok)
+	d.remember("10.0.0.1:5683", 1, []byte{1}, now)
+	d.remember("10.0.0.1:5683", 2, nil, now)
+
+	resp, ok := d.lookup("10.0.0.1:5683", 1, now.Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1}, resp)
+	resp, ok = d.lookup("10.0.0.1:5683", 2, now.Add(time.Second))
+	assert.True(t, ok)
+	assert.Nil(t, resp)
+
+	_, ok = d.lookup("10.0.0.2:5683", 1, now)
+	assert.False(t, ok)
+	_, ok = d.lookup("10.0.0.1:5683", 1, now.Add(coapExchangeLifetime))
+	assert.False(t, ok)
+}
+
+// Only aliases, and MACs if allowed, may be woken over CoAP.
+func TestCheckCoAPTarget(t *testing.T) {
+	defer func(allow bool) { cliFlags.CoAPAllowMACs = allow }(cliFlags.CoAPAllowMACs)
+	aliases, cleanup := openTestAliases(t, "./TestCheckCoAPTarget.db")
+	defer cleanup()
+	assert.Nil(t, aliases.Add("nas", "00:11:22:aa:bb:cc", ""))
+
+	cliFlags.CoAPAllowMACs = false
+	assert.Nil(t, checkCoAPTarget("nas", aliases))
+	assert.Equal(t, errCoAPTarget, checkCoAPTarget("00:11:22:aa:bb:cc", aliases))
+	assert.Equal(t, errCoAPTarget, checkCoAPTarget("printer", aliases))
+
+	cliFlags.CoAPAllowMACs = true
+	assert.Nil(t, checkCoAPTarget("00:11:22:aa:bb:cc", aliases))
+	for _, target := range []string{
+		"00:11:22:aa:bb:cc@192.0.2.1",
+		"00:11:22:aa:bb:cc?pw=00:00:00:00:00:01",
+		"nas@192.0.2.1",
+	} {
+		assert.Equal(t, errCoAPTarget, checkCoAPTarget(target, aliases), target)
+	}
+}
+
+func TestCoAPAllowed(t *testing.T) {
+	assert.True(t, coapAllowed(net.ParseIP("192.0.2.7"), nil))
+
+	allowed, err := parseAddrNets("--coap-allow", []string{"192.0.2.0/28", "2001:db8::1"})
+	assert.Nil(t, err)
+	assert.True(t, coapAllowed(net.ParseIP("192.0.2.7"), allowed))
+	assert.True(t, coapAllowed(net.ParseIP("2001:db8::1"), allowed))
+	assert.False(t, coapAllowed(net.ParseIP("192.0.2.70"), allowed))
+	assert.False(t, coapAllowed(net.ParseIP("2001:db8::2"), allowed))
+
+	_, err = parseAddrNets("--coap-allow", []string{"192.0.2"})
+	assert.NotNil(t, err)
+}
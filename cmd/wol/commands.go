@@ -0,0 +1,104 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+type cmdFnType func([]string, *Aliases) error
+
+// usageExample is an example invocation shown in the usage: the message key
+// of its caption, followed by the arguments of one or more invocations.
+type usageExample struct {
+	caption string
+	args    []string
+}
+
+// command is an entry of the command registry, which both dispatches
+// commands and generates the usage. Each command is described by the
+// `cmd.<name>` message, and `flags` points to the structs (in cliFlags) of
+// the options it takes besides the global ones, if any.
+type command struct {
+	run      cmdFnType
+	flags    []interface{}
+	examples []usageExample
+}
+
+// cmdMap is the command registry. `help` has no run function, as it is
+// handled before the alias db is opened.
+var cmdMap = map[string]command{
+	"alias": {aliasCmd, []interface{}{&cliFlags.aliasFlags, &cliFlags.tagFlags, &cliFlags.plaintextFlags}, []usageExample{
+		{`usage.alias`, []string{`<alias> <mac address> <optional interface> [--ip <address/prefix>] [--tag <tag> ...]`}},
+	}},
+	"coap": {coapCmd, []interface{}{&cliFlags.coapFlags, &cliFlags.listenFlags, &cliFlags.sendFlags, &cliFlags.wakerFlags, &cliFlags.retentionFlags, &cliFlags.daemonFlags, &cliFlags.healthFlags}, []usageExample{
+		{`usage.coap`, []string{`[--coap-allow <address/CIDR> ...] [--coap-allow-macs]`}},
+	}},
+	"export": {exportCmd, []interface{}{&cliFlags.exportFlags}, []usageExample{
+		{`usage.export-ansible`, []string{`--ansible-inventory`}},
+		{`usage.export-canonical`, []string{`--canonical > aliases.jsonl`}},
+	}},
+	"gpio": {gpioCmd, []interface{}{&cliFlags.gpioFlags, &cliFlags.sendFlags, &cliFlags.wakerFlags, &cliFlags.retentionFlags, &cliFlags.daemonFlags, &cliFlags.healthFlags}, []usageExample{
+		{`usage.gpio`, []string{`<line>=<alias> [<line>=<alias> ...]`}},
+	}},
+	"group": {groupCmd, nil, []usageExample{
+		{`usage.group`, []string{`add <name> <alias | group> [<alias | group> ...]`, `list | show <name> | remove <name>`}},
+	}},
+	"help": {nil, nil, []usageExample{
+		{`usage.help`, []string{`<optional command>`}},
+	}},
+	"history": {historyCmd, []interface{}{&cliFlags.historyFlags, &cliFlags.sinceFlags, &cliFlags.retentionFlags, &cliFlags.apiFlags}, []usageExample{
+		{`usage.history`, []string{`<optional alias> [--since <time>] [--format <format>]`}},
+		{`usage.history-prune`, []string{`prune [--history-max-age <days>] [--history-max-entries <n>]`}},
+	}},
+	"import": {importCmd, []interface{}{&cliFlags.importFlags, &cliFlags.tagFlags, &cliFlags.plaintextFlags, &cliFlags.apiFlags}, []usageExample{
+		{`usage.import`, []string{`aliases.jsonl [--verify-key <minisign.pub>] [--signature <aliases.jsonl.minisig>]`}},
+		{`usage.import-nmap`, []string{`--nmap scan.xml [--tag <tag> ...]`}},
+		{`usage.import-router`, []string{`--router <fritzbox | openwrt | unifi> --url <url> --token <token>`}},
+	}},
+	"list": {listCmd, []interface{}{&cliFlags.listFlags, &cliFlags.apiFlags}, []usageExample{
+		{`usage.list`, []string{`[--long]`}},
+	}},
+	"oob": {oobCmd, []interface{}{&cliFlags.oobFlags, &cliFlags.plaintextFlags}, []usageExample{
+		{`usage.oob`, []string{`set <alias> <amt | ipmi | redfish> <address> --oob-user <user> [--secret <env:NAME | keyring:name | prompt>] [--insecure] [--default]`, `list | on <alias> | status <alias> | remove <alias>`}},
+		{`usage.oob-vm`, []string{`set <alias> libvirt <uri> [--vm <domain>]`, `set <alias> proxmox <url> --oob-user <user@realm!tokenid> --secret <env:NAME | keyring:name> [--vm <vmid>]`}},
+	}},
+	"power": {powerCmd, []interface{}{&cliFlags.shutdownFlags}, []usageExample{
+		{`usage.power`, []string{`on <alias>`, `off <alias> [--hard] [--ssh <[user@]host[:port]>]`, `status <alias>`}},
+	}},
+	"probe": {probeCmd, []interface{}{&cliFlags.probeFlags}, []usageExample{
+		{`usage.probe`, []string{`<mac address | alias> [--ssh <user@host>]`}},
+	}},
+	"prune": {pruneCmd, []interface{}{&cliFlags.pruneFlags}, []usageExample{
+		{`usage.prune`, []string{``}},
+	}},
+	"relay": {relayCmd, []interface{}{&cliFlags.relayFlags, &cliFlags.listenFlags, &cliFlags.sendFlags, &cliFlags.daemonFlags, &cliFlags.healthFlags}, []usageExample{
+		{`usage.relay`, []string{``}},
+	}},
+	"remove": {removeCmd, nil, []usageExample{
+		{`usage.remove`, []string{`<alias>`}},
+	}},
+	"scan": {scanCmd, []interface{}{&cliFlags.scanFlags}, []usageExample{
+		{`usage.scan`, []string{`<subnet> [--concurrency <n>] [--scan-timeout <duration>] [--exclude <ip|cidr>,...] [--diff]`}},
+		{`usage.scan-passive`, []string{`<subnet> --passive [--duration <s>] [--exclude <ip|cidr>,...] [--diff]`}},
+	}},
+	"secret": {secretCmd, nil, []usageExample{
+		{`usage.secret`, []string{`set <name>`, `remove <name>`}},
+	}},
+	"serve": {serveCmd, []interface{}{&cliFlags.serveFlags, &cliFlags.listenFlags, &cliFlags.sendFlags, &cliFlags.wakerFlags, &cliFlags.retentionFlags, &cliFlags.daemonFlags}, []usageExample{
+		{`usage.serve`, []string{``, `--tls-cert <pem> --tls-key <pem> [--client-ca <pem>]`, `--oidc-issuer <url> --oidc-client-id <id> --oidc-client-secret <secret> --oidc-redirect-url <url>`}},
+	}},
+	"stats": {statsCmd, []interface{}{&cliFlags.sinceFlags}, []usageExample{
+		{`usage.stats`, []string{`<optional alias> [--since <time>]`}},
+	}},
+	"shutdown": {shutdownCmd, []interface{}{&cliFlags.shutdownFlags}, []usageExample{
+		{`usage.shutdown`, []string{`<alias> [--hard] [--ssh <[user@]host[:port]>]`}},
+	}},
+	"sync": {syncCmd, []interface{}{&cliFlags.syncFlags, &cliFlags.plaintextFlags, &cliFlags.apiFlags}, []usageExample{
+		{`usage.sync`, []string{`--peer <url> [--token <token>]`}},
+	}},
+	"token": {tokenCmd, []interface{}{&cliFlags.tokenFlags, &cliFlags.tagFlags}, []usageExample{
+		{`usage.token`, []string{`add <name> [--allow <alias> ...] [--tag <tag> ...] [--signing] [--cert <name> ...] [--oidc-group <group> ...]`, `list | remove <name>`}},
+	}},
+	"wake": {wakeCmd, []interface{}{&cliFlags.wakeFlags, &cliFlags.sendFlags, &cliFlags.wakerFlags, &cliFlags.apiFlags}, []usageExample{
+		{`usage.wake`, []string{`<mac address | alias | group> <optional interface>`}},
+		{`usage.wake-via`, []string{`<mac address | alias> --via <user@gateway>`}},
+		{`usage.wake-oob`, []string{`<alias> --verify <method> --oob`, `<alias> --method <amt | ipmi | redfish | libvirt | proxmox>`}},
+	}},
+}
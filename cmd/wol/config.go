@@ -0,0 +1,206 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	configPath = "/.config/go-wol/config"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// configEntry is a single `key = value` line of the config file.
+type configEntry struct {
+	key, value string
+	line       int
+}
+
+// configFile holds the entries of the config file by section. Entries before
+// the first section (the "" section) apply to every profile, and each
+// `[name]` section is a profile.
+type configFile map[string][]configEntry
+
+// parseConfig parses an INI style config file. Blank lines and lines starting
+// with `#` or `;` are ignored.
+func parseConfig(r io.Reader) (configFile, error) {
+	cfg := configFile{}
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("config line %d: empty profile name", n)
+			}
+			if _, ok := cfg[section]; !ok {
+				cfg[section] = nil
+			}
+
+		default:
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("config line %d: expected key = value", n)
+			}
+			key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			if uq, err := strconv.Unquote(value); err == nil {
+				value = uq
+			}
+			cfg[section] = append(cfg[section], configEntry{key, value, n})
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// configArgs converts the defaults and the entries of `profile` into command
+// line arguments for the options in `opts` (keyed by long name). Options
+// whose environment variable is set are skipped, so that the environment
+// takes precedence over the config file, as are options which map to nil
+// (those of commands other than the one being run).
+func configArgs(cfg configFile, profile string, opts map[string]*flags.Option) ([]string, error) {
+	entries := cfg[""]
+	if profile != "" {
+		section, ok := cfg[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in the config file", profile)
+		}
+		entries = append(entries, section...)
+	}
+
+	var args []string
+	for _, e := range entries {
+		opt, ok := opts[e.key]
+		if !ok || e.key == "config" || e.key == "profile" {
+			return nil, fmt.Errorf("config line %d: unknown option %q", e.line, e.key)
+		}
+		if opt == nil {
+			// An option of another command.
+			continue
+		}
+		if opt.EnvDefaultKey != "" && os.Getenv(opt.EnvDefaultKey) != "" {
+			continue
+		}
+
+		// Boolean options take no argument, so they are only passed on if
+		// they are true.
+		if reflect.TypeOf(opt.Value()).Kind() == reflect.Bool {
+			on, err := strconv.ParseBool(e.value)
+			if err != nil {
+				return nil, fmt.Errorf("config line %d: invalid boolean %q", e.line, e.value)
+			}
+			if on {
+				args = append(args, "--"+e.key)
+			}
+			continue
+		}
+
+		value := e.value
+		if strings.HasPrefix(value, "~/") {
+			if usr, err := user.Current(); err == nil {
+				value = path.Join(usr.HomeDir, value[2:])
+			}
+		}
+		args = append(args, "--"+e.key+"="+value)
+	}
+	return args, nil
+}
+
+// longOptions returns the global options of `parser` by their long name.
+func longOptions(parser *flags.Parser) map[string]*flags.Option {
+	opts := map[string]*flags.Option{}
+	for _, group := range parser.Groups() {
+		for _, opt := range group.Options() {
+			opts[opt.LongName] = opt
+		}
+	}
+	return opts
+}
+
+// configOptions returns the options a config file may set for the command
+// being run: the global options and those of the active command. The options
+// of other commands map to nil, so that they are known but skipped.
+func configOptions(parser *cliParser) map[string]*flags.Option {
+	opts := longOptions(parser.Parser)
+	for _, cmd := range parser.Commands() {
+		for _, group := range cmd.Groups() {
+			for _, opt := range group.Options() {
+				if cmd == parser.Active {
+					opts[opt.LongName] = opt
+				} else if _, ok := opts[opt.LongName]; !ok {
+					opts[opt.LongName] = nil
+				}
+			}
+		}
+	}
+	return opts
+}
+
+// loadConfig reads the config file (`--config`, or ~/.config/go-wol/config)
+// and re-parses the command line `cmdline` with the defaults of the selected
+// `--profile` in front of it (right after the command, as they may include
+// its options), so that options given on the command line still win. It
+// returns the positional arguments, which are `args` if there is no config.
+func loadConfig(parser *cliParser, cmdline, args []string) ([]string, error) {
+	explicit := cliFlags.Config != "" || cliFlags.Profile != ""
+
+	p := cliFlags.Config
+	if p == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		p = path.Join(usr.HomeDir, configPath)
+	}
+
+	fd, err := os.Open(p)
+	if os.IsNotExist(err) && !explicit {
+		return args, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	cfg, err := parseConfig(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+
+	extra, err := configArgs(cfg, cliFlags.Profile, configOptions(parser))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	if len(extra) == 0 {
+		return args, nil
+	}
+
+	// Options which may be repeated would otherwise keep the values of the
+	// first parse.
+	v := reflect.ValueOf(&cliFlags).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	if idx := commandIndex(parser, cmdline); idx >= 0 {
+		extra = append([]string{cmdline[idx]}, extra...)
+		extra = append(extra, cmdline[:idx]...)
+		cmdline = cmdline[idx+1:]
+	}
+	return parser.ParseArgs(append(extra, cmdline...))
+}
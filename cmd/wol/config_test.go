@@ -0,0 +1,84 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const testConfig = `
+# Defaults for every profile.
+port = 7
+
+[home]
+bcast = 192.168.1.255
+
+; Quoted values are unquoted.
+[work]
+bcast = "10.0.0.255"
+interface = eth1
+force = true
+network-probe = false
+`
+
+// Returns the options of a parser over a struct like cliFlags.
+func testConfigOptions() map[string]*flags.Option {
+	var opts struct {
+		BroadcastIP        string `long:"bcast" env:"WOL_TEST_BCAST"`
+		BroadcastInterface string `long:"interface"`
+		UDPPort            string `long:"port"`
+		Force              bool   `long:"force"`
+		NetworkProbe       bool   `long:"network-probe"`
+	}
+	return longOptions(flags.NewParser(&opts, flags.None))
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(testConfig))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(cfg))
+	assert.Equal(t, []configEntry{{"port", "7", 3}}, cfg[""])
+	assert.Equal(t, []configEntry{{"bcast", "192.168.1.255", 6}}, cfg["home"])
+	assert.Equal(t, "10.0.0.255", cfg["work"][0].value)
+
+	for _, bad := range []string{"[]\n", "port\n"} {
+		_, err := parseConfig(strings.NewReader(bad))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestConfigArgs(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(testConfig))
+	assert.Nil(t, err)
+	opts := testConfigOptions()
+
+	args, err := configArgs(cfg, "", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--port=7"}, args)
+
+	args, err = configArgs(cfg, "work", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--port=7", "--bcast=10.0.0.255", "--interface=eth1", "--force"}, args)
+
+	// The environment takes precedence over the config file.
+	os.Setenv("WOL_TEST_BCAST", "10.0.1.255")
+	defer os.Unsetenv("WOL_TEST_BCAST")
+	args, err = configArgs(cfg, "home", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--port=7"}, args)
+
+	_, err = configArgs(cfg, "lab", opts)
+	assert.NotNil(t, err)
+
+	cfg, err = parseConfig(strings.NewReader("color = blue\n"))
+	assert.Nil(t, err)
+	_, err = configArgs(cfg, "", opts)
+	assert.NotNil(t, err)
+}
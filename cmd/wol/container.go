@@ -0,0 +1,196 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// How long the network probe waits for another host to answer.
+	networkProbeTimeout = time.Second
+
+	// networkProbePrefix starts the datagrams of the network probe, which a
+	// `wol relay` on another host sends back to their sender.
+	networkProbePrefix = "go-wol-probe-"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// cgroupIsContainer returns true if a `/proc/<pid>/cgroup` listing belongs
+// to a process running under a container runtime.
+func cgroupIsContainer(r io.Reader) bool {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, marker := range []string{"docker", "kubepods", "containerd", "libpod"} {
+			if strings.Contains(line, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inContainer returns true if we appear to be running inside a container.
+func inContainer() bool {
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return true
+	}
+	f, err := os.Open("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return cgroupIsContainer(f)
+}
+
+// isVirtualPair returns true if the linux interface `name` is one end of a
+// veth style pair, i.e. its link points at a different interface index. This
+// is how bridge networked containers are attached to the host.
+func isVirtualPair(sysfs, name string) bool {
+	read := func(file string) string {
+		bs, err := ioutil.ReadFile(filepath.Join(sysfs, name, file))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(bs))
+	}
+	ifindex, iflink := read("ifindex"), read("iflink")
+	return ifindex != "" && iflink != "" && ifindex != iflink
+}
+
+// bridgeNetworked returns true if every non-loopback interface which is up
+// is a veth style pair, which is the case for containers on a bridge network
+// (but not for `--network host`).
+func bridgeNetworked(sysfs string) bool {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false
+	}
+
+	found := false
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if !isVirtualPair(sysfs, iface.Name) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// warnIfBridged prints a warning to stderr if we are running in a container
+// attached to a bridge network, where broadcasts will not reach the LAN.
+func warnIfBridged() {
+	if !inContainer() || !bridgeNetworked("/sys/class/net") {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: running in a container on a bridge network. Broadcast magic\n"+
+		"WARNING: packets will not leave the container's network; run it with\n"+
+		"WARNING: `--network host` (or macvlan), or send to a directed broadcast.\n")
+}
+
+// isNetworkProbe returns true if `bs` is a datagram of the network probe.
+func isNetworkProbe(bs []byte) bool {
+	return strings.HasPrefix(string(bs), networkProbePrefix)
+}
+
+// probeBroadcast checks that broadcasts to `udpAddr` sent from `localAddr`
+// reach the network, by broadcasting a probe datagram and waiting for another
+// host (a `wol relay`) to send it back. Copies looped back to this host prove
+// nothing, so datagrams from our own addresses are ignored. It returns the
+// address of the host which answered.
+func probeBroadcast(localAddr, udpAddr *net.UDPAddr) (net.Addr, error) {
+	lip := net.IPv4zero
+	if localAddr != nil {
+		lip = localAddr.IP
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: lip})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	token := []byte(fmt.Sprintf("%s%d", networkProbePrefix, time.Now().UnixNano()))
+	if _, err := conn.WriteToUDP(token, udpAddr); err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(networkProbeTimeout)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 64)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, fmt.Errorf("no other host answered a broadcast to %s (is a `wol relay` listening on the network?): %v", udpAddr, err)
+		}
+		if string(buf[:n]) == string(token) && !isLocalIP(from.IP) {
+			return from, nil
+		}
+	}
+}
+
+// runNetworkProbe verifies at startup that broadcasts can be sent with the
+// configured interface and broadcast address.
+func runNetworkProbe() error {
+	var localAddr *net.UDPAddr
+	var err error
+	if cliFlags.BroadcastInterface != "" {
+		localAddr, err = ipFromInterface(cliFlags.BroadcastInterface)
+		if err != nil {
+			return err
+		}
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("%s:%s", cliFlags.BroadcastIP, cliFlags.UDPPort))
+	if err != nil {
+		return err
+	}
+
+	from, err := probeBroadcast(localAddr, udpAddr)
+	if err != nil {
+		return fmt.Errorf("network probe failed: %v", err)
+	}
+	progressf("Network probe: broadcasts to %s reached %s\n", udpAddr.IP, from)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// healthHandler answers container HEALTHCHECKs for long running modes.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "ok\n")
+}
+
+// startHealthServer serves `/healthz` on `--health-listen`, if set.
+func startHealthServer() {
+	if cliFlags.HealthListen == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthHandler)
+	go func() {
+		log.Printf("Serving health checks on %s/healthz\n", cliFlags.HealthListen)
+		if err := http.ListenAndServe(cliFlags.HealthListen, mux); err != nil {
+			log.Printf("Health check server failed: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestCgroupIsContainer(t *testing.T) {
+	for _, tc := range []struct {
+		cgroup    string
+		container bool
+	}{
+		{"12:devices:/docker/0123456789abcdef\n", true},
+		{"0::/kubepods/besteffort/pod1234\n", true},
+		{"0::/system.slice/containerd.service\n", true},
+		{"0::/init.scope\n", false},
+		{"", false},
+	} {
+		assert.Equal(t, tc.container, cgroupIsContainer(strings.NewReader(tc.cgroup)))
+	}
+}
+
+func TestIsVirtualPair(t *testing.T) {
+	sysfs, err := ioutil.TempDir("", "go-wol-sysfs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(sysfs)
+
+	write := func(iface, index, link string) {
+		dir := filepath.Join(sysfs, iface)
+		assert.Nil(t, os.MkdirAll(dir, 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "ifindex"), []byte(index+"\n"), 0644))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "iflink"), []byte(link+"\n"), 0644))
+	}
+	write("eth0", "12", "13")
+	write("enp3s0", "2", "2")
+
+	assert.True(t, isVirtualPair(sysfs, "eth0"))
+	assert.False(t, isVirtualPair(sysfs, "enp3s0"))
+	assert.False(t, isVirtualPair(sysfs, "missing0"))
+}
+
+func TestHealthHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok\n", rec.Body.String())
+}
+
+// Answers from our own addresses do not count as the probe reaching the
+// network.
+func TestProbeBroadcastIgnoresLocal(t *testing.T) {
+	echo, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			assert.True(t, isNetworkProbe(buf[:n]))
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	_, err = probeBroadcast(nil, echo.LocalAddr().(*net.UDPAddr))
+	assert.NotNil(t, err)
+	assert.False(t, isNetworkProbe([]byte("hello")))
+}
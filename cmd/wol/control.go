@@ -0,0 +1,216 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The control API is the part of the HTTP API which `serve` offers on its
+// unix socket to the CLI and local automation. Rather than by tokens,
+// requests are authorized by the user of the process which connected: root,
+// the daemon's own user, and those allowed with `--socket-allow`.
+
+// peer is the local user at the other end of a control socket connection.
+type peer struct {
+	uid  int
+	gid  int
+	name string
+	err  error
+}
+
+// peerContextKey is the request context key of the *peer of a control API
+// request.
+type peerContextKey struct{}
+
+// peerAddr is the address of a control socket connection, which tells the
+// peers of the listener apart.
+type peerAddr string
+
+func (a peerAddr) Network() string { return "unix" }
+func (a peerAddr) String() string  { return string(a) }
+
+// peerConn is a control socket connection, which forgets its peer when it is
+// closed.
+type peerConn struct {
+	net.Conn
+	addr peerAddr
+	l    *peerListener
+}
+
+func (c *peerConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *peerConn) Close() error {
+	c.l.forget(c.addr)
+	return c.Conn.Close()
+}
+
+// peerListener accepts control socket connections and remembers who is at
+// the other end of each, by the address the connection reports.
+type peerListener struct {
+	net.Listener
+
+	mtx   sync.Mutex
+	next  int
+	peers map[peerAddr]*peer
+}
+
+// newPeerListener wraps the unix socket listener `l`.
+func newPeerListener(l net.Listener) *peerListener {
+	return &peerListener{Listener: l, peers: map[peerAddr]*peer{}}
+}
+
+// Accept waits for the next connection and looks up its peer.
+func (l *peerListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peer{}
+	p.uid, p.gid, p.err = peerCredentials(conn)
+	p.name = strconv.Itoa(p.uid)
+	if u, err := user.LookupId(p.name); err == nil {
+		p.name = u.Username
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.next++
+	addr := peerAddr("unix#" + strconv.Itoa(l.next))
+	l.peers[addr] = p
+	return &peerConn{Conn: conn, addr: addr, l: l}, nil
+}
+
+// peer returns the peer of the connection at `addr`.
+func (l *peerListener) peer(addr string) (*peer, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	p, ok := l.peers[peerAddr(addr)]
+	return p, ok
+}
+
+// forget drops the peer of the connection at `addr`.
+func (l *peerListener) forget(addr peerAddr) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	delete(l.peers, addr)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// allowedPeer returns true if the local user `p` may use the control API:
+// root, the daemon's user `self`, and the users and (as "@group") members of
+// the groups in `allow`.
+func allowedPeer(p *peer, self int, allow []string) bool {
+	if p.err != nil {
+		return false
+	}
+	if p.uid == 0 || p.uid == self {
+		return true
+	}
+
+	var groups []string
+	if u, err := user.LookupId(strconv.Itoa(p.uid)); err == nil {
+		groups, _ = u.GroupIds()
+	}
+	groups = append(groups, strconv.Itoa(p.gid))
+
+	for _, a := range allow {
+		if !strings.HasPrefix(a, "@") {
+			if a == p.name || a == strconv.Itoa(p.uid) {
+				return true
+			}
+			continue
+		}
+		gid := strings.TrimPrefix(a, "@")
+		if g, err := user.LookupGroup(gid); err == nil {
+			gid = g.Gid
+		}
+		for _, id := range groups {
+			if id == gid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleSnapshot serves `GET /snapshot?db=<path>`, a consistent copy of the
+// alias db for commands which find it in use. As the db holds tokens, only
+// root and the daemon's own user, who can read the db anyway, may get it.
+func (s *server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+	if p, _ := r.Context().Value(peerContextKey{}).(*peer); p == nil || p.uid != 0 && p.uid != os.Getuid() {
+		writeError(w, http.StatusForbidden, errors.New("only root and serve's user may read the alias db"))
+		return
+	}
+	if !samePath(r.URL.Query().Get("db"), s.aliases.path) {
+		writeError(w, http.StatusNotFound, errors.New("serve has another alias db open"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := s.aliases.WriteSnapshot(w); err != nil {
+		log.Printf("Failed to write a snapshot of the alias db: %v\n", err)
+	}
+}
+
+// samePath returns true if the paths `a` and `b` name the same file.
+func samePath(a, b string) bool {
+	aa, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	ab, err := filepath.Abs(b)
+	return err == nil && aa == ab
+}
+
+// controlHandler returns the handler of the control API served on the socket
+// `l`: aliases, wakes, history and status, without the dashboard or the
+// endpoints which exchange whole alias dbs.
+func (s *server) controlHandler(l *peerListener) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/aliases", s.handleAliases)
+	mux.HandleFunc("/wake/", s.handleWake)
+	mux.HandleFunc("/history", s.handleHistory)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/snapshot", s.handleSnapshot)
+
+	self := os.Getuid()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := l.peer(r.RemoteAddr)
+		if !ok || !allowedPeer(p, self, cliFlags.SocketAllow) {
+			if ok && p.err != nil {
+				log.Printf("Could not tell who connected to the control socket: %v\n", p.err)
+			}
+			name := "unknown user"
+			if ok {
+				name = p.name
+			}
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s may not use the control socket", name))
+			return
+		}
+
+		// Requests are logged, rate limited and recorded in the history as
+		// coming from the local user.
+		r.RemoteAddr = "local:" + p.name
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerContextKey{}, p)))
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestAllowedPeer(t *testing.T) {
+	const self = 1000
+	nobody := &peer{uid: 12345, gid: 12345, name: "12345"}
+
+	assert.True(t, allowedPeer(&peer{uid: 0, name: "root"}, self, nil))
+	assert.True(t, allowedPeer(&peer{uid: self, name: "wol"}, self, nil))
+	assert.False(t, allowedPeer(&peer{uid: 0, err: errors.New("no creds")}, self, nil))
+
+	assert.False(t, allowedPeer(nobody, self, nil))
+	assert.False(t, allowedPeer(nobody, self, []string{"alice", "@54321"}))
+	assert.True(t, allowedPeer(nobody, self, []string{"12345"}))
+	assert.True(t, allowedPeer(nobody, self, []string{"@12345"}))
+	assert.True(t, allowedPeer(&peer{uid: 12345, gid: 12345, name: "alice"}, self, []string{"alice"}))
+
+	if g, err := user.LookupGroupId("0"); err == nil {
+		assert.True(t, allowedPeer(&peer{uid: 12345, gid: 0, name: "12345"}, self, []string{"@" + g.Name}))
+	}
+}
+
+func TestControlHandler(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestControlHandler.db")
+	defer cleanup()
+	assert.Nil(t, aliases.Add("nas", "00:11:22:33:44:55", ""))
+	assert.Nil(t, aliases.AddToken("restricted", Token{Hash: []byte("x"), Aliases: []string{"tv"}}))
+
+	l := newPeerListener(nil)
+	l.peers["unix#1"] = &peer{uid: 0, name: "root"}
+	l.peers["unix#2"] = &peer{uid: 12345, gid: 12345, name: "mallory"}
+	h := newServer(aliases).controlHandler(l)
+
+	get := func(path, addr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = addr
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Tokens do not apply, who connected does.
+	w := get("/aliases", "unix#1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"nas"`)
+
+	w = get("/aliases", "unix#2")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "mallory may not use the control socket")
+	assert.Equal(t, http.StatusForbidden, get("/aliases", "unix#3").Code)
+
+	// Whole alias dbs are only exchanged over the network API.
+	assert.Equal(t, http.StatusNotFound, get("/sync", "unix#1").Code)
+	assert.Equal(t, http.StatusNotFound, get("/", "unix#1").Code)
+}
@@ -0,0 +1,260 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// daemonDialTimeout is how long a command waits for a daemon to answer on
+	// its socket before opening the alias db itself.
+	daemonDialTimeout = 200 * time.Millisecond
+
+	// socketURL is the base URL of requests sent over the socket, whose host
+	// does not matter.
+	socketURL = "http://wol"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// daemon is a client of the API of a `wol serve` on this machine, which the
+// commands it serves go through rather than opening the alias db, so that
+// they see the aliases as it does and wakes are sent with its privileges.
+type daemon struct {
+	url    string
+	client *http.Client
+}
+
+// socketPath returns the unix socket `serve` listens on and other commands
+// look for it at, or "" if there is none.
+func socketPath() string {
+	switch cliFlags.Socket {
+	case "":
+		return defaultSocketPath
+	case "-":
+		return ""
+	}
+	return cliFlags.Socket
+}
+
+// dialDaemon returns a client of the daemon listening on the unix socket at
+// `path`, or nil if none answers.
+func dialDaemon(path string) *daemon {
+	if path == "" {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, daemonDialTimeout)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &daemon{url: socketURL, client: &http.Client{Transport: transport}}
+}
+
+// listenSocket listens on the unix socket at `path` for commands on this
+// machine, replacing a socket left behind by a daemon which is gone. It
+// returns nil if there is no socket to listen on, which is the case without
+// `--socket` unless the default one can be created.
+func listenSocket(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if dialDaemon(path) != nil {
+		return nil, fmt.Errorf("another daemon is listening on %s", path)
+	}
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		if oe, ok := err.(*net.OpError); ok && cliFlags.Socket == "" &&
+			(os.IsPermission(oe.Err) || os.IsNotExist(oe.Err)) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// Where the peer's user is known, anyone may connect and is then
+	// authorized by it. On Windows, only the daemon's user may connect, and
+	// elsewhere the socket is not served.
+	if err := restrictSocket(path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// newDaemon returns a client of the daemon API at `apiURL`.
+func newDaemon(apiURL string) *daemon {
+	return &daemon{url: strings.TrimRight(apiURL, "/"), client: http.DefaultClient}
+}
+
+// do sends a `method` request for `path` (with its query) and decodes the
+// JSON response into `v`.
+func (d *daemon) do(method, path string, v interface{}) error {
+	ctx, cancel := withTimeout(context.Background(), opAPI)
+	defer cancel()
+
+	req, err := http.NewRequest(method, d.url+path, nil)
+	if err != nil {
+		return err
+	}
+	if cliFlags.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cliFlags.Token)
+	}
+
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("wol serve returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// wake asks the daemon to wake `target`.
+func (d *daemon) wake(target string) error {
+	if localWakeOptions() {
+		fmt.Fprintf(os.Stderr, "WARNING: --via, --verify, --verify-cmd, --method and --oob are not applied when waking through wol serve\n")
+	}
+
+	var result struct {
+		Mac       string `json:"mac"`
+		Coalesced bool   `json:"coalesced"`
+		Queued    bool   `json:"queued"`
+		Error     string `json:"error"`
+	}
+	if err := d.do("POST", "/wake/"+url.PathEscape(target), &result); err != nil {
+		return err
+	}
+	if result.Queued {
+		statusf("wol serve could not send the magic packet to %s yet (%s), and will retry\n", result.Mac, result.Error)
+		return nil
+	}
+	if result.Coalesced {
+		statusf("Skipped, %s was just woken by wol serve\n", result.Mac)
+		return nil
+	}
+	statusf("Magic packet sent successfully to %s by wol serve\n", result.Mac)
+	return nil
+}
+
+// list prints the aliases the daemon lists, as the list command does.
+func (d *daemon) list() error {
+	var list []Alias
+	for {
+		var page aliasPage
+		if err := d.do("GET", fmt.Sprintf("/aliases?offset=%d&limit=%d", len(list), maxPageLimit), &page); err != nil {
+			return err
+		}
+		for _, e := range page.Aliases {
+			alias := Alias{Name: e.Name, MacIface: MacIface{Mac: e.Mac, Iface: e.Iface, Tags: e.Tags, IP: e.IP, Host: e.Host, Cooldown: e.Cooldown}}
+			if e.Created != nil {
+				alias.Created = *e.Created
+			}
+			if e.Modified != nil {
+				alias.Modified = *e.Modified
+			}
+			list = append(list, alias)
+		}
+		if len(page.Aliases) == 0 || len(list) >= page.Total {
+			break
+		}
+	}
+	printAliases(list)
+	return nil
+}
+
+// history prints the history the daemon has, as the history command does
+// with `args`.
+func (d *daemon) history(args []string) error {
+	now := time.Now()
+	since, err := parseSince(cliFlags.Since, now)
+	if err != nil {
+		return err
+	}
+	if _, ok := historyFormats[cliFlags.Format]; !ok {
+		return fmt.Errorf("unknown history format %q", cliFlags.Format)
+	}
+
+	q := url.Values{}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339Nano))
+	}
+	if len(args) > 0 {
+		q.Set("alias", args[0])
+	}
+	var entries []HistoryEntry
+	if err := d.do("GET", "/history?"+q.Encode(), &entries); err != nil {
+		return err
+	}
+	if len(entries) == 0 && cliFlags.Format == "text" {
+		progressf("No wakes found\n")
+		return nil
+	}
+	return writeHistory(os.Stdout, cliFlags.Format, entries, now)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// localWakeOptions returns true if options were given which only a wake sent
+// by this process applies.
+func localWakeOptions() bool {
+	return cliFlags.Via != "" || cliFlags.Verify != "" || cliFlags.VerifyCmd != "" ||
+		cliFlags.Method != "" && cliFlags.Method != "wol" || cliFlags.OOB
+}
+
+// daemonCmd returns how the command line `args` is run through a daemon, or
+// nil if daemons do not serve that command.
+func daemonCmd(args []string) func(*daemon) error {
+	if target, ok := wakeArg(args); ok {
+		return func(d *daemon) error { return d.wake(target) }
+	}
+	switch cmdArgs := args[1:]; strings.ToLower(args[0]) {
+	case "list":
+		return (*daemon).list
+	case "history":
+		if len(cmdArgs) == 0 || strings.ToLower(cmdArgs[0]) != "prune" {
+			return func(d *daemon) error { return d.history(cmdArgs) }
+		}
+	}
+	return nil
+}
+
+// wakeArg returns the target the command line `args` wakes, if it is a wake
+// of one.
+func wakeArg(args []string) (string, bool) {
+	cmd := strings.ToLower(args[0])
+	if cmd == "wake" {
+		if len(args) < 2 {
+			return "", false
+		}
+		return args[1], true
+	}
+	if c, ok := cmdMap[cmd]; ok && c.run != nil {
+		return "", false
+	}
+	return args[0], true
+}
@@ -0,0 +1,135 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestDaemonWake(t *testing.T) {
+	defer func(token string) { cliFlags.Token = token }(cliFlags.Token)
+	cliFlags.Token = "secret"
+
+	aliases, cleanup := openTestAliases(t, "./TestDaemonWake.db")
+	defer cleanup()
+
+	var gotPath, gotAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		if r.URL.Path == "/wake/unknown" {
+			writeError(w, http.StatusBadRequest, os.ErrNotExist)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"target": "nas", "mac": "00:11:22:33:44:55"})
+	}))
+	defer ts.Close()
+
+	remove, err := advertiseDaemon(aliases, ts.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, ts.URL, daemonURL("./TestDaemonWake.db"))
+
+	d := newDaemon(daemonURL("./TestDaemonWake.db"))
+	assert.Nil(t, d.wake("nas"))
+	assert.Equal(t, "/wake/nas", gotPath)
+	assert.Equal(t, "Bearer secret", gotAuth)
+	assert.NotNil(t, d.wake("unknown"))
+
+	remove()
+	assert.Equal(t, "", daemonURL("./TestDaemonWake.db"))
+}
+
+func TestDaemonSocket(t *testing.T) {
+	if !peerCredsSupported && runtime.GOOS != "windows" {
+		t.Skip("no control socket on " + runtime.GOOS)
+	}
+	defer func(socket, format string) { cliFlags.Socket, cliFlags.Format = socket, format }(cliFlags.Socket, cliFlags.Format)
+	cliFlags.Format = "jsonl"
+	dir, err := ioutil.TempDir("", "wol-socket-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cliFlags.Socket = filepath.Join(dir, "wol.sock")
+
+	aliases, cleanup := openTestAliases(t, "./TestDaemonSocket.db")
+	defer cleanup()
+	assert.Nil(t, aliases.Add("nas", "00:11:22:33:44:55", ""))
+	assert.Nil(t, aliases.AddHistory(HistoryEntry{Time: time.Now(), Target: "nas", Mac: "00:11:22:33:44:55", By: "test"}))
+
+	assert.Nil(t, dialDaemon(socketPath()))
+
+	// A socket left behind by a daemon which is gone is replaced.
+	assert.Nil(t, ioutil.WriteFile(socketPath(), nil, 0600))
+	l, err := listenSocket(socketPath())
+	assert.Nil(t, err)
+	pl := newPeerListener(l)
+	srv := &http.Server{Handler: newServer(aliases).controlHandler(pl)}
+	go srv.Serve(pl)
+	defer srv.Close()
+
+	_, err = listenSocket(socketPath())
+	assert.NotNil(t, err)
+
+	d := dialDaemon(socketPath())
+	assert.NotNil(t, d)
+	var page aliasPage
+	assert.Nil(t, d.do("GET", "/aliases", &page))
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, "nas", page.Aliases[0].Name)
+	assert.Nil(t, d.list())
+
+	var entries []HistoryEntry
+	assert.Nil(t, d.do("GET", "/history?alias=nas", &entries))
+	assert.Len(t, entries, 1)
+	assert.Nil(t, d.history([]string{"nas"}))
+
+	// Commands which find the db in use read a snapshot the daemon writes.
+	var buf bytes.Buffer
+	assert.NotNil(t, d.snapshot("./other.db", &buf))
+	assert.Nil(t, d.snapshot("./TestDaemonSocket.db", &buf))
+	assert.NotEqual(t, 0, buf.Len())
+	snapshot, err := loadSnapshot("./TestDaemonSocket.db")
+	assert.Nil(t, err)
+	mi, err := snapshot.Get("nas")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:11:22:33:44:55", mi.Mac)
+	assert.Nil(t, snapshot.Close())
+
+	if peerCredsSupported {
+		fi, err := os.Stat(socketPath())
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0666), fi.Mode().Perm())
+	}
+}
+
+func TestDaemonCmd(t *testing.T) {
+	for _, tc := range []struct {
+		args   []string
+		target string
+		ok     bool
+		served bool
+	}{
+		{[]string{"NAS"}, "NAS", true, true},
+		{[]string{"wake", "nas", "--verify", "ssh"}, "nas", true, true},
+		{[]string{"wake"}, "", false, false},
+		{[]string{"list"}, "", false, true},
+		{[]string{"history", "nas"}, "", false, true},
+		{[]string{"history", "prune"}, "", false, false},
+		{[]string{"alias", "nas", "00:11:22:33:44:55"}, "", false, false},
+	} {
+		target, ok := wakeArg(tc.args)
+		assert.Equal(t, tc.target, target, "%v", tc.args)
+		assert.Equal(t, tc.ok, ok, "%v", tc.args)
+		assert.Equal(t, tc.served, daemonCmd(tc.args) != nil, "%v", tc.args)
+	}
+}
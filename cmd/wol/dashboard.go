@@ -0,0 +1,252 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net/http"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// aliasStatus is the JSON representation of an alias' network status.
+type aliasStatus struct {
+	Online   bool       `json:"online"`
+	IP       string     `json:"ip,omitempty"`
+	LastWake *time.Time `json:"last_wake,omitempty"`
+	UsualUp  float64    `json:"usual_up_after,omitempty"` // seconds
+}
+
+// handleStatus serves `GET /status`, a map of alias name to whether its MAC
+// is currently in the neighbor table, when it was last woken and how long it
+// usually takes to come up.
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+
+	list, err := s.visibleAliases(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// A missing neighbor table (e.g. no `arp` binary) just means that no
+	// alias is reported online.
+	table, _ := neighborTable()
+
+	// How long machines usually take to come up is learnt from the history.
+	var usual map[string]time.Duration
+	if entries, err := s.aliases.History(time.Time{}, ""); err == nil {
+		usual = usualBootTimes(entries)
+	}
+
+	statuses := make(map[string]aliasStatus, len(list))
+	for _, alias := range list {
+		var st aliasStatus
+		if mac, ok := canonicalMAC(alias.Mac); ok {
+			if ip, ok := table[mac]; ok {
+				st.Online, st.IP = true, ip.String()
+			}
+		}
+		if last, err := s.aliases.LastWake(alias.Name); err == nil && !last.IsZero() {
+			st.LastWake = &last
+		}
+		st.UsualUp = usual[alias.Name].Round(time.Second).Seconds()
+		statuses[alias.Name] = st
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleDashboard serves the web dashboard at `/`.
+func (s *server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, errNotFound(r))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// dashboardHTML is a single page UI built on the JSON API. It is kept free of
+// external assets so the daemon works on networks without internet access.
+// It lists the aliases (page by page, however many there are) and graphs the
+// wakes of the last two weeks from the history. Schedules are not managed
+// from it, as the daemon has no scheduler yet.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>go-wol</title>
+<style>
+  body { font-family: sans-serif; margin: 1em auto; max-width: 48em; padding: 0 1em; }
+  table { border-collapse: collapse; width: 100%; }
+  th, td { padding: .5em; text-align: left; border-bottom: 1px solid #ddd; }
+  .dot { display: inline-block; width: .7em; height: .7em; border-radius: 50%; background: #bbb; }
+  .online { background: #2a2; }
+  .tag { background: #eee; border-radius: .3em; padding: 0 .3em; margin-right: .2em; font-size: .85em; }
+  button { padding: .4em 1em; }
+  #msg { min-height: 1.5em; }
+  #graph { display: flex; align-items: flex-end; height: 6em; margin: 1em 0 .2em; }
+  #graph div { flex: 1; margin: 0 1px; display: flex; flex-direction: column-reverse; }
+  #graph span { display: block; }
+  .sent { background: #2a2; }
+  .failed { background: #c33; }
+  .skipped { background: #bbb; }
+  .legend { font-size: .85em; color: #666; }
+</style>
+</head>
+<body>
+<h1>go-wol</h1>
+<p id="msg"></p>
+<div id="graph"></div>
+<p class="legend">Wakes per day over the last two weeks: <span class="tag sent">sent</span> <span class="tag failed">failed</span> <span class="tag skipped">skipped</span></p>
+<table>
+  <thead><tr><th></th><th>Alias</th><th>MAC</th><th>Last woken</th><th></th></tr></thead>
+  <tbody id="aliases"></tbody>
+</table>
+<script>
+function text(tag, s, cls) {
+  var el = document.createElement(tag);
+  el.textContent = s;
+  if (cls) el.className = cls;
+  return el;
+}
+
+function msg(s) { document.getElementById('msg').textContent = s; }
+
+// Requests carry the token from local storage, and ask for one when the
+// server rejects them. Servers with a single sign-on log the user in instead.
+function api(path, opts) {
+  opts = opts || {};
+  opts.headers = {'Authorization': 'Bearer ' + (localStorage.getItem('token') || '')};
+  opts.credentials = 'same-origin';
+  return fetch(path, opts).then(function(r) {
+    if (r.status == 401) {
+      if (r.headers.get('X-Login')) {
+        location.href = r.headers.get('X-Login');
+        throw new Error('unauthorized');
+      }
+      var token = prompt('API token');
+      if (token) {
+        localStorage.setItem('token', token);
+        location.reload();
+      }
+      throw new Error('unauthorized');
+    }
+    return r.json();
+  });
+}
+
+function wake(name) {
+  msg('Waking ' + name + '...');
+  api('wake/' + encodeURIComponent(name), {method: 'POST'})
+    .then(function(j) {
+      if (j.queued) msg('Could not wake ' + name + ' yet, retrying: ' + j.error);
+      else if (j.error) msg('Failed to wake ' + name + ': ' + j.error);
+      else msg(j.coalesced ? name + ' was woken recently, skipped' : 'Sent magic packet to ' + name);
+      refresh();
+    });
+}
+
+// The listing is read a page at a time until every alias has been fetched.
+function allAliases(list) {
+  list = list || [];
+  return api('aliases?offset=' + list.length + '&limit=1000').then(function(page) {
+    list = list.concat(page.aliases);
+    if (page.aliases.length == 0 || list.length >= page.total) return list;
+    return allAliases(list);
+  });
+}
+
+var graphDays = 14;
+
+// graph draws a bar per day of the wakes in the history since 'since'.
+function graph(entries, since) {
+  var days = [];
+  for (var i = 0; i < graphDays; i++) days.push({sent: 0, failed: 0, skipped: 0});
+  entries.forEach(function(e) {
+    var t = new Date(e.time);
+    t.setHours(0, 0, 0, 0);
+    var day = Math.round((t - since) / 86400000);
+    if (day < 0 || day >= graphDays) return;
+    days[day][e.error ? 'failed' : e.coalesced ? 'skipped' : 'sent']++;
+  });
+  var max = Math.max.apply(null, days.map(function(d) { return d.sent + d.failed + d.skipped; })) || 1;
+  var el = document.getElementById('graph');
+  el.innerHTML = '';
+  days.forEach(function(d, i) {
+    var bar = document.createElement('div');
+    var date = new Date(since);
+    date.setDate(date.getDate() + i);
+    bar.title = date.toLocaleDateString() + ': ' + d.sent + ' sent, ' + d.failed + ' failed, ' + d.skipped + ' skipped';
+    ['sent', 'failed', 'skipped'].forEach(function(k) {
+      var seg = text('span', '', k);
+      seg.style.height = (6 * d[k] / max) + 'em';
+      bar.appendChild(seg);
+    });
+    el.appendChild(bar);
+  });
+}
+
+function refresh() {
+  var since = new Date();
+  since.setHours(0, 0, 0, 0);
+  since.setDate(since.getDate() - graphDays + 1);
+  Promise.all([
+    allAliases(),
+    api('status'),
+    api('history?since=' + encodeURIComponent(since.toISOString()))
+  ]).then(function(res) {
+    graph(res[2] || [], since);
+    var body = document.getElementById('aliases');
+    body.innerHTML = '';
+    res[0].forEach(function(a) {
+      var st = res[1][a.name] || {};
+      var tr = document.createElement('tr');
+
+      var dot = text('td', '');
+      dot.appendChild(text('span', '', 'dot' + (st.online ? ' online' : '')));
+      dot.title = st.online ? 'online at ' + st.ip : 'not seen';
+      tr.appendChild(dot);
+
+      var name = text('td', a.name + ' ');
+      (a.tags || []).forEach(function(t) { name.appendChild(text('span', t, 'tag')); });
+      tr.appendChild(name);
+
+      tr.appendChild(text('td', a.mac));
+      var last = text('td', st.last_wake ? new Date(st.last_wake).toLocaleString() : '');
+      if (st.usual_up_after) last.title = 'usually up in ~' + st.usual_up_after + 's';
+      tr.appendChild(last);
+
+      var btn = text('button', 'Wake');
+      btn.onclick = function() { wake(a.name); };
+      var cell = text('td', '');
+      cell.appendChild(btn);
+      tr.appendChild(cell);
+
+      body.appendChild(tr);
+    });
+  });
+}
+
+refresh();
+setInterval(refresh, 10000);
+
+// Refresh as soon as anyone wakes a machine, instead of waiting for the poll.
+if (window.EventSource) {
+  var token = encodeURIComponent(localStorage.getItem('token') || '');
+  new EventSource('events?token=' + token).addEventListener('wake', function(e) {
+    var j = JSON.parse(e.data);
+    msg(j.error ? 'Failed to wake ' + j.target + ': ' + j.error : j.target + ' was woken');
+    refresh();
+  });
+}
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,204 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// daemonFileSuffix is appended to the alias db path to name the file in which
+// `wol serve` leaves the URL of its API while it has the db open.
+const daemonFileSuffix = ".serve"
+
+// readOnlyCmds are the commands which only read the alias db, and so can use
+// a snapshot of it while a daemon has it open.
+var readOnlyCmds = map[string]bool{
+	"export":  true,
+	"history": true,
+	"list":    true,
+	"stats":   true,
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// dbLockedError is returned when another process (typically `wol serve`) has
+// had the alias db open for longer than `--db-timeout`.
+type dbLockedError struct {
+	path   string
+	waited time.Duration
+}
+
+func (e *dbLockedError) Error() string {
+	return fmt.Sprintf("alias db %s is in use by another wol process, such as wol serve (gave up after %s, see --db-timeout); "+
+		"stop it, or use --db to work on another db", e.path, e.waited)
+}
+
+// snapshotMetaSize covers the two meta pages at the start of a bolt db, for
+// page sizes up to 64KiB. Every commit rewrites one of them.
+const snapshotMetaSize = 2 << 16
+
+// snapshotAttempts is how often a copy of a db being written is tried.
+const snapshotAttempts = 5
+
+// loadSnapshot opens a read-only copy of the alias db at `dbpath`, for when
+// another process has the db itself open. The daemon on the socket writes it
+// from a read transaction if it has the db open, else the file is copied
+// while no commit happens. The copy is removed when the aliases are closed.
+func loadSnapshot(dbpath string) (*Aliases, error) {
+	dst, err := ioutil.TempFile("", "wol-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	if d := dialDaemon(socketPath()); d == nil || d.snapshot(dbpath, dst) != nil {
+		err = copyQuiescent(dbpath, dst)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return nil, err
+	}
+
+	db, err := bolt.Open(dst.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		os.Remove(dst.Name())
+		return nil, err
+	}
+	return &Aliases{
+		mtx:      &sync.Mutex{},
+		db:       db,
+		path:     dbpath,
+		snapshot: dst.Name(),
+	}, nil
+}
+
+// copyQuiescent copies the bolt db at `dbpath` to `dst`, retrying until its
+// meta pages are the same after the copy as before it. As bolt only writes
+// pages which the latest meta page does not refer to until it rewrites a meta
+// page, the copy is then consistent.
+func copyQuiescent(dbpath string, dst *os.File) error {
+	src, err := os.Open(dbpath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	readMeta := func() ([]byte, error) {
+		meta := make([]byte, snapshotMetaSize)
+		n, err := src.ReadAt(meta, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return meta[:n], nil
+	}
+	for i := 0; i < snapshotAttempts; i++ {
+		before, err := readMeta()
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := dst.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, io.NewSectionReader(src, 0, 1<<62)); err != nil {
+			return err
+		}
+		after, err := readMeta()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(before, after) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("%s kept changing while it was copied", dbpath)
+}
+
+// WriteSnapshot writes a consistent copy of the alias db to `w`.
+func (a *Aliases) WriteSnapshot(w io.Writer) error {
+	return a.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// snapshot writes a copy of the alias db at `dbpath`, which the daemon must
+// have open, to `w`.
+func (d *daemon) snapshot(dbpath string, w io.Writer) error {
+	ctx, cancel := withTimeout(context.Background(), opAPI)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", d.url+"/snapshot?db="+url.QueryEscape(dbpath), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("wol serve returned %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// daemonAPIURL returns the URL a client on this machine reaches an API
+// listening on `addr` at.
+func daemonAPIURL(addr string, tls bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port), nil
+}
+
+// advertiseDaemon leaves `apiURL`, where the API of the alias db is served,
+// next to the db, so that commands which find the db in use can go through
+// it. The returned function removes it again.
+func advertiseDaemon(aliases *Aliases, apiURL string) (func(), error) {
+	p := aliases.path + daemonFileSuffix
+	if err := ioutil.WriteFile(p, []byte(apiURL+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return func() { os.Remove(p) }, nil
+}
+
+// daemonURL returns the URL of the API of the `wol serve` which has the alias
+// db at `dbpath` open, or "" if it did not leave one.
+func daemonURL(dbpath string) string {
+	bs, err := ioutil.ReadFile(dbpath + daemonFileSuffix)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bs))
+}
@@ -0,0 +1,57 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestLoadAliasesLocked(t *testing.T) {
+	defer func(timeout time.Duration) { cliFlags.DBTimeout = timeout }(cliFlags.DBTimeout)
+	cliFlags.DBTimeout = 50 * time.Millisecond
+
+	aliases, cleanup := openTestAliases(t, "./TestLoadAliasesLocked.db")
+	defer cleanup()
+	assert.Nil(t, aliases.Add("nas", "00:11:22:33:44:55", ""))
+
+	_, err := LoadAliases("./TestLoadAliasesLocked.db")
+	assert.IsType(t, &dbLockedError{}, err)
+	assert.Contains(t, err.Error(), "gave up after 50ms")
+
+	snapshot, err := loadSnapshot("./TestLoadAliasesLocked.db")
+	assert.Nil(t, err)
+	mi, err := snapshot.Get("nas")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:11:22:33:44:55", mi.Mac)
+	assert.NotNil(t, snapshot.Add("tv", "00:11:22:33:44:66", ""))
+
+	assert.Nil(t, snapshot.Close())
+	_, err = os.Stat(snapshot.snapshot)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDaemonAPIURL(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		tls  bool
+		want string
+	}{
+		{"127.0.0.1:7788", false, "http://127.0.0.1:7788"},
+		{":7788", false, "http://127.0.0.1:7788"},
+		{"0.0.0.0:443", true, "https://127.0.0.1:443"},
+		{"[::1]:7788", false, "http://[::1]:7788"},
+		{"wol.lan:7788", false, "http://wol.lan:7788"},
+	} {
+		got, err := daemonAPIURL(tc.addr, tc.tls)
+		assert.Nil(t, err, tc.addr)
+		assert.Equal(t, tc.want, got, tc.addr)
+	}
+	_, err := daemonAPIURL("7788", false)
+	assert.NotNil(t, err)
+}
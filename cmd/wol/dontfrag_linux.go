@@ -0,0 +1,14 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"syscall"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// setDontFragment sets the DF bit on packets sent on `fd`.
+func setDontFragment(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// setDontFragment is only implemented on Linux.
+func setDontFragment(fd uintptr) error {
+	return errors.New("--dont-fragment is only supported on Linux")
+}
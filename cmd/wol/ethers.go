@@ -0,0 +1,78 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// parseEthers parses an ethers(5) file, lines of a MAC address and a host
+// name, into a map of host name -> alias. Comments start with `#`. Lines
+// which name an IP address rather than a host are skipped, as are hosts
+// listed twice after their first line, like the resolver does.
+func parseEthers(r io.Reader) (map[string]MacIface, error) {
+	entries := map[string]MacIface{}
+	sc := bufio.NewScanner(r)
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := sc.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a MAC address and a host name", lineno)
+		}
+		mac, ok := canonicalMAC(fields[0])
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid MAC address %q", lineno, fields[0])
+		}
+		name := fields[1]
+		if net.ParseIP(name) != nil {
+			continue
+		}
+		if _, dup := entries[name]; !dup {
+			entries[name] = MacIface{Mac: mac}
+		}
+	}
+	return entries, sc.Err()
+}
+
+// LoadEthers adds the hosts of the ethers(5) file at `path` as read-only
+// aliases. Aliases in the db take precedence over them.
+func (a *Aliases) LoadEthers(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := parseEthers(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.ethers, a.ethersPath = entries, path
+	return nil
+}
+
+// ReadOnly returns true if the alias `name` only comes from the ethers file,
+// so that it cannot be changed or removed through the db.
+func (a *Aliases) ReadOnly(name string) bool {
+	if _, ok := a.ethers[name]; !ok {
+		return false
+	}
+	_, err := a.getDB(name)
+	return err != nil
+}
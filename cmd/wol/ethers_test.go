@@ -0,0 +1,72 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseEthers(t *testing.T) {
+	entries, err := parseEthers(strings.NewReader(`
+# Lab machines
+8:0:20:1:2:3    sparc
+00:11:22:AA:BB:CC	nas   # the NAS
+00:11:22:aa:bb:cd nas
+00:11:22:aa:bb:ce 192.168.1.30
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]MacIface{
+		"sparc": {Mac: "08:00:20:01:02:03"},
+		"nas":   {Mac: "00:11:22:aa:bb:cc"},
+	}, entries)
+
+	_, err = parseEthers(strings.NewReader("00:11:22:aa:bb:cc\n"))
+	assert.NotNil(t, err)
+	_, err = parseEthers(strings.NewReader("nas 00:11:22:aa:bb:cc\n"))
+	assert.NotNil(t, err)
+}
+
+func TestAliasesEthers(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestAliasesEthers.db")
+	defer cleanup()
+
+	ethers := "./TestAliasesEthers.ethers"
+	assert.Nil(t, ioutil.WriteFile(ethers, []byte("00:00:00:00:00:01 nas\n00:00:00:00:00:03 tv\n"), 0644))
+	defer os.Remove(ethers)
+
+	assert.Nil(t, aliases.Add("tv", "00:00:00:00:00:33", ""))
+	assert.Nil(t, aliases.Add("box", "00:00:00:00:00:02", ""))
+	assert.Nil(t, aliases.LoadEthers(ethers))
+
+	mi, err := aliases.Get("nas")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:00:00:00:00:01", mi.Mac)
+	mi, err = aliases.Get("tv")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:00:00:00:00:33", mi.Mac)
+
+	list, err := aliases.List()
+	assert.Nil(t, err)
+	var names []string
+	for _, alias := range list {
+		names = append(names, alias.Name+" "+alias.Mac)
+	}
+	assert.Equal(t, []string{"box 00:00:00:00:00:02", "nas 00:00:00:00:00:01", "tv 00:00:00:00:00:33"}, names)
+
+	assert.True(t, aliases.ReadOnly("nas"))
+	assert.False(t, aliases.ReadOnly("tv"))
+	assert.NotNil(t, aliases.Del("nas"))
+	assert.Nil(t, aliases.Del("tv"))
+	mi, err = aliases.Get("tv")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:00:00:00:00:03", mi.Mac)
+
+	assert.NotNil(t, aliases.LoadEthers("./TestAliasesEthers.missing"))
+}
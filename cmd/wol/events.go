@@ -0,0 +1,145 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// Buffered events per subscriber; slow subscribers drop events rather
+	// than blocking wakes.
+	eventBufferSize = 32
+
+	// Interval between keep-alive comments on idle event streams.
+	eventKeepAlive = 15 * time.Second
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// event is a single entry in the live event stream.
+type event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Target    string    `json:"target,omitempty"`
+	Mac       string    `json:"mac,omitempty"`
+	Coalesced bool      `json:"coalesced,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// eventBroker fans published events out to all current subscribers.
+type eventBroker struct {
+	mtx         sync.Mutex
+	subscribers map[chan event]struct{}
+	done        chan struct{}
+}
+
+// newEventBroker returns a broker with no subscribers.
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: map[chan event]struct{}{},
+		done:        make(chan struct{}),
+	}
+}
+
+// close ends all event streams, so that the server can shut down without
+// waiting for their clients to disconnect.
+func (b *eventBroker) close() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+}
+
+// subscribe returns a channel which receives all events published until
+// unsubscribe is called with it.
+func (b *eventBroker) subscribe() chan event {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	ch := make(chan event, eventBufferSize)
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe stops delivery of events to `ch`.
+func (b *eventBroker) unsubscribe(ch chan event) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	delete(b.subscribers, ch)
+}
+
+// publish sends `e` to every subscriber, stamping it with the current time if
+// it has none. Subscribers whose buffer is full miss the event.
+func (b *eventBroker) publish(e event) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// serveEvents streams events for which `filter` returns true (or all events
+// if it is nil) to the client as Server-Sent Events until it disconnects or
+// the broker is closed.
+func (b *eventBroker) serveEvents(w http.ResponseWriter, r *http.Request, filter func(event) bool) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-ch:
+			if filter != nil && !filter(e) {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		case <-ticker.C:
+			fmt.Fprintf(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			return
+		}
+		flusher.Flush()
+	}
+}
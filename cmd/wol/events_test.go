@@ -0,0 +1,82 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestEventBroker(t *testing.T) {
+	b := newEventBroker()
+	a, c := b.subscribe(), b.subscribe()
+
+	b.publish(event{Type: "wake", Target: "nas"})
+	for _, ch := range []chan event{a, c} {
+		e := <-ch
+		assert.Equal(t, "wake", e.Type)
+		assert.Equal(t, "nas", e.Target)
+		assert.False(t, e.Time.IsZero())
+	}
+
+	// Unsubscribed channels get nothing more.
+	b.unsubscribe(a)
+	b.publish(event{Type: "wake", Target: "media"})
+	assert.Equal(t, "media", (<-c).Target)
+	assert.Equal(t, 0, len(a))
+
+	// A full subscriber does not block publishing.
+	for i := 0; i < eventBufferSize+5; i++ {
+		b.publish(event{Type: "wake"})
+	}
+	assert.Equal(t, eventBufferSize, len(c))
+}
+
+func TestServeEvents(t *testing.T) {
+	b := newEventBroker()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.serveEvents(w, r, func(e event) bool { return e.Target != "secret" })
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Wait for the stream to subscribe before publishing.
+	for i := 0; i < 100; i++ {
+		b.mtx.Lock()
+		n := len(b.subscribers)
+		b.mtx.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.publish(event{Type: "wake", Target: "secret"})
+	b.publish(event{Type: "wake", Target: "nas"})
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "event: wake\n", line)
+	line, err = reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(line, "data: {"))
+	assert.Contains(t, line, `"target":"nas"`)
+
+	// Closing the broker ends the stream.
+	b.close()
+	_, err = ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+}
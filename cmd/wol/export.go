@@ -0,0 +1,76 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ansibleHost holds the host variables emitted for a single alias in an
+// Ansible inventory.
+type ansibleHost struct {
+	Address string `json:"ansible_host,omitempty"`
+	Mac     string `json:"wol_mac"`
+	Iface   string `json:"wol_interface,omitempty"`
+	IP      string `json:"wol_ip,omitempty"`
+}
+
+// ansibleChild is a group nested under `all`, which only lists its hosts.
+type ansibleChild struct {
+	Hosts map[string]struct{} `json:"hosts"`
+}
+
+// ansibleGroup mirrors the layout of a group in an Ansible YAML / JSON
+// inventory file.
+type ansibleGroup struct {
+	Hosts    map[string]ansibleHost  `json:"hosts"`
+	Children map[string]ansibleChild `json:"children,omitempty"`
+}
+
+// ansibleGroupName converts a tag into a valid Ansible group name, which may
+// only contain letters, digits and underscores.
+func ansibleGroupName(tag string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, tag)
+}
+
+// ansibleInventory builds an Ansible inventory from the sorted `aliases`.
+// Each alias becomes a host in the `all` group with its MAC address, preferred
+// interface and address exposed as host vars (its host name, or else its
+// address, is what Ansible connects to), and each tag becomes a child group
+// of its aliases. The output is JSON, which the Ansible `yaml` inventory
+// plugin accepts directly (`ansible -i inventory.json`).
+func ansibleInventory(aliases []Alias) ([]byte, error) {
+	all := ansibleGroup{
+		Hosts: make(map[string]ansibleHost, len(aliases)),
+	}
+	for _, alias := range aliases {
+		host := ansibleHost{Address: alias.Host, Mac: alias.Mac, Iface: alias.Iface, IP: alias.IP}
+		if host.Address == "" && alias.IP != "" {
+			host.Address = strings.SplitN(alias.IP, "/", 2)[0]
+		}
+		all.Hosts[alias.Name] = host
+
+		for _, tag := range alias.Tags {
+			if all.Children == nil {
+				all.Children = map[string]ansibleChild{}
+			}
+			name := ansibleGroupName(tag)
+			if _, ok := all.Children[name]; !ok {
+				all.Children[name] = ansibleChild{map[string]struct{}{}}
+			}
+			all.Children[name].Hosts[alias.Name] = struct{}{}
+		}
+	}
+
+	// encoding/json sorts map keys, so the inventory is stable across runs.
+	return json.MarshalIndent(map[string]ansibleGroup{"all": all}, "", "  ")
+}
@@ -0,0 +1,74 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Validate the ansibleInventory function.
+func TestAnsibleInventory(t *testing.T) {
+	bs, err := ansibleInventory([]Alias{
+		{Name: "one", MacIface: MacIface{Mac: "00:00:00:00:00:00", Iface: "eth0"}},
+		{Name: "two", MacIface: MacIface{Mac: "00:00:00:00:00:AA", IP: "192.168.1.20/24"}},
+		{Name: "three", MacIface: MacIface{Mac: "00:00:00:00:00:BB", IP: "192.168.1.21/24", Host: "three.lan"}},
+	})
+	assert.Nil(t, err)
+
+	var inv map[string]map[string]map[string]map[string]string
+	err = json.Unmarshal(bs, &inv)
+	assert.Nil(t, err)
+
+	hosts := inv["all"]["hosts"]
+	assert.Equal(t, 3, len(hosts))
+	assert.Equal(t, "00:00:00:00:00:00", hosts["one"]["wol_mac"])
+	assert.Equal(t, "eth0", hosts["one"]["wol_interface"])
+	_, ok := hosts["one"]["ansible_host"]
+	assert.False(t, ok)
+	assert.Equal(t, "00:00:00:00:00:AA", hosts["two"]["wol_mac"])
+	_, ok = hosts["two"]["wol_interface"]
+	assert.False(t, ok)
+
+	// The address round trips, and is what Ansible connects to unless the
+	// alias has a host name.
+	assert.Equal(t, "192.168.1.20/24", hosts["two"]["wol_ip"])
+	assert.Equal(t, "192.168.1.20", hosts["two"]["ansible_host"])
+	assert.Equal(t, "three.lan", hosts["three"]["ansible_host"])
+}
+
+// An empty alias db still yields a valid (empty) inventory.
+func TestAnsibleInventoryEmpty(t *testing.T) {
+	bs, err := ansibleInventory([]Alias{})
+	assert.Nil(t, err)
+	assert.Contains(t, string(bs), `"all"`)
+}
+
+// Tags become child groups of `all`.
+func TestAnsibleInventoryGroups(t *testing.T) {
+	bs, err := ansibleInventory([]Alias{
+		{Name: "one", MacIface: MacIface{Mac: "00:00:00:00:00:00", Tags: []string{"lab", "rack-1"}}},
+		{Name: "two", MacIface: MacIface{Mac: "00:00:00:00:00:AA", Tags: []string{"lab"}}},
+	})
+	assert.Nil(t, err)
+
+	var inv struct {
+		All struct {
+			Children map[string]struct {
+				Hosts map[string]struct{} `json:"hosts"`
+			} `json:"children"`
+		} `json:"all"`
+	}
+	err = json.Unmarshal(bs, &inv)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, len(inv.All.Children))
+	assert.Equal(t, 2, len(inv.All.Children["lab"].Hosts))
+	assert.Equal(t, 1, len(inv.All.Children["rack_1"].Hosts))
+	_, ok := inv.All.Children["rack_1"].Hosts["one"]
+	assert.True(t, ok)
+}
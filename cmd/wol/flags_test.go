@@ -0,0 +1,124 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestCommandIndex(t *testing.T) {
+	parser := newParser()
+	assert.Equal(t, 0, commandIndex(parser, []string{"list"}))
+	assert.Equal(t, 2, commandIndex(parser, []string{"-i", "eth0", "list"}))
+	assert.Equal(t, 1, commandIndex(parser, []string{"--port=7", "list"}))
+	assert.Equal(t, 1, commandIndex(parser, []string{"--network-probe", "list"}))
+	assert.Equal(t, -1, commandIndex(parser, []string{"--db", "x"}))
+	assert.Equal(t, -1, commandIndex(parser, []string{"--", "list"}))
+
+	assert.Equal(t, []string{"-p", "7", "wake", "nas", "--force"},
+		implicitWake(parser, []string{"-p", "7", "nas", "--force"}))
+	assert.Equal(t, []string{"scan"}, implicitWake(parser, []string{"scan"}))
+}
+
+func TestParseCommandOptions(t *testing.T) {
+	saved := cliFlags
+	defer func() { cliFlags = saved }()
+
+	// Global options go before or after the command, its own after it.
+	parser := newParser()
+	args, err := parser.ParseArgs(implicitWake(parser, []string{"-p", "7", "nas", "--via", "gw", "-i", "eth1"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "wake", parser.Active.Name)
+	assert.Equal(t, []string{"nas"}, args)
+	assert.Equal(t, "7", cliFlags.UDPPort)
+	assert.Equal(t, "eth1", cliFlags.BroadcastInterface)
+	assert.Equal(t, "gw", cliFlags.Via)
+	assert.Equal(t, 128, cliFlags.Concurrency)
+
+	// Options of other commands are rejected...
+	parser = newParser()
+	parser.Options = 0
+	_, err = parser.ParseArgs([]string{"list", "--via", "gw"})
+	assert.NotNil(t, err)
+
+	// ...and skipped in the config file.
+	opts := configOptions(parser)
+	assert.NotNil(t, opts["port"])
+	_, ok := opts["via"]
+	assert.True(t, ok)
+	assert.Nil(t, opts["via"])
+}
+
+func TestParseSharedOptions(t *testing.T) {
+	saved := cliFlags
+	defer func() { cliFlags = saved }()
+
+	// Options several commands take keep the values given to the one run...
+	parser := newParser()
+	_, err := parser.ParseArgs([]string{"relay", "--listen", ":7", "--ttl", "3"})
+	assert.Nil(t, err)
+	assert.Equal(t, ":7", cliFlags.Listen)
+	assert.Equal(t, 3, cliFlags.TTL)
+	_, err = parser.ParseArgs([]string{"power", "off", "nas", "--hard"})
+	assert.Nil(t, err)
+	assert.True(t, cliFlags.Hard)
+	assert.NotNil(t, configOptions(parser)["hard"])
+
+	// ... are rejected by the others...
+	parser.Options = 0
+	_, err = parser.ParseArgs([]string{"list", "--listen", ":7"})
+	assert.NotNil(t, err)
+
+	// ... and otherwise take their defaults, or their variables.
+	_, err = parser.ParseArgs([]string{"list"})
+	assert.Nil(t, err)
+	assert.Equal(t, "", cliFlags.Listen)
+	assert.Equal(t, "udp", cliFlags.Transport)
+	assert.Equal(t, 1, cliFlags.MulticastTTL)
+
+	os.Setenv("WOL_TRANSPORT", "tcp")
+	defer os.Unsetenv("WOL_TRANSPORT")
+	_, err = parser.ParseArgs([]string{"serve", "--transport", "raw"})
+	assert.Nil(t, err)
+	assert.Equal(t, "raw", cliFlags.Transport)
+	_, err = parser.ParseArgs([]string{"wake", "nas"})
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp", cliFlags.Transport)
+}
+
+func TestOptionEnvVars(t *testing.T) {
+	// Every option but --help and --version has a variable named after it.
+	groups := reflect.TypeOf(cliFlags)
+	for i := 0; i < groups.NumField(); i++ {
+		group := groups.Field(i).Type
+		if group.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < group.NumField(); j++ {
+			tag := group.Field(j).Tag
+			long := tag.Get("long")
+			if long == "help" || long == "version" {
+				assert.Equal(t, "", tag.Get("env"), long)
+				continue
+			}
+			assert.Equal(t, "WOL_"+strings.ToUpper(strings.Replace(long, "-", "_", -1)), tag.Get("env"), long)
+			if group.Field(j).Type.Kind() == reflect.Slice {
+				assert.Equal(t, ",", tag.Get("env-delim"), long)
+			}
+		}
+	}
+
+	vars := optionEnvVars()
+	assert.Equal(t, "token", vars["WOL_TOKEN"])
+	for _, env := range (verifyCmdData{}).env() {
+		_, ok := vars[strings.TrimSuffix(env, "=")]
+		assert.False(t, ok, env)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	fritzboxHostsAction = "urn:dslforum-org:service:Hosts:1#X_AVM-DE_GetHostListPath"
+
+	fritzboxHostsRequest = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:X_AVM-DE_GetHostListPath xmlns:u="urn:dslforum-org:service:Hosts:1"/></s:Body>
+</s:Envelope>`
+)
+
+var (
+	// Matches the parameters of a WWW-Authenticate challenge.
+	reChallengeParam = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`)
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// md5Hex returns the hex encoded MD5 sum of `parts` joined with colons.
+func md5Hex(parts ...string) string {
+	sum := md5.Sum([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(sum[:])
+}
+
+// digestAuthorization answers the HTTP digest `challenge` (RFC 2617, with
+// MD5 and qop=auth if offered) for a request of `method` to `uri`.
+func digestAuthorization(challenge, user, password, method, uri, cnonce string) (string, error) {
+	if !strings.HasPrefix(challenge, "Digest ") {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	params := map[string]string{}
+	for _, m := range reChallengeParam.FindAllStringSubmatch(challenge[len("Digest "):], -1) {
+		params[strings.ToLower(m[1])] = m[2] + m[3]
+	}
+	if alg := params["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %s", alg)
+	}
+
+	ha1 := md5Hex(user, params["realm"], password)
+	ha2 := md5Hex(method, uri)
+	auth := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		user, params["realm"], params["nonce"], uri)
+	if params["qop"] != "" {
+		ok := false
+		for _, qop := range strings.Split(params["qop"], ",") {
+			ok = ok || strings.TrimSpace(qop) == "auth"
+		}
+		if !ok {
+			return "", fmt.Errorf("unsupported digest qop %s", params["qop"])
+		}
+		response := md5Hex(ha1, params["nonce"], "00000001", cnonce, "auth", ha2)
+		auth += fmt.Sprintf(`, qop=auth, nc=00000001, cnonce="%s", response="%s"`, cnonce, response)
+	} else {
+		auth += fmt.Sprintf(`, response="%s"`, md5Hex(ha1, params["nonce"], ha2))
+	}
+	if opaque, ok := params["opaque"]; ok {
+		auth += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return auth + ", algorithm=MD5", nil
+}
+
+// digestDo sends the request built by `newRequest`, answering the HTTP
+// digest authentication challenge it is met with by sending it again, and
+// returns the response to that.
+func digestDo(client *http.Client, newRequest func() (*http.Request, error), user, password string) (*http.Response, error) {
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, fmt.Errorf("%s returned %s (expected an authentication challenge)", req.URL.Host, resp.Status)
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	auth, err := digestAuthorization(resp.Header.Get("WWW-Authenticate"), user, password,
+		req.Method, req.URL.RequestURI(), hex.EncodeToString(nonce))
+	if err != nil {
+		return nil, err
+	}
+	if req, err = newRequest(); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", auth)
+	return client.Do(req)
+}
+
+// fritzboxSOAP calls the Hosts service action which returns the path of the
+// host list, answering the digest authentication challenge TR-064 requires.
+func fritzboxSOAP(client *http.Client, baseURL, user, password string) ([]byte, error) {
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", baseURL+"/upnp/control/hosts", bytes.NewReader([]byte(fritzboxHostsRequest)))
+		if err == nil {
+			req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+			req.Header.Set("SOAPAction", fritzboxHostsAction)
+		}
+		return req, err
+	}
+	resp, err := digestDo(client, newRequest, user, password)
+	if err != nil {
+		return nil, err
+	}
+	return readRouterResponse(resp)
+}
+
+// fritzboxClients lists the hosts known to a FRITZ!Box through TR-064, where
+// `baseURL` is e.g. `http://fritz.box:49000` and `token` is `user:password`.
+func fritzboxClients(client *http.Client, baseURL, token string) ([]inventoryHost, error) {
+	parts := strings.SplitN(token, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("the FRITZ!Box router requires --token <user>:<password>")
+	}
+
+	body, err := fritzboxSOAP(client, baseURL, parts[0], parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var envelope struct {
+		Path string `xml:"Body>X_AVM-DE_GetHostListPathResponse>NewX_AVM-DE_HostListPath"`
+	}
+	if err := xml.Unmarshal(body, &envelope); err != nil || envelope.Path == "" {
+		return nil, errors.New("invalid FRITZ!Box response: missing host list path")
+	}
+
+	// The path carries a session id, so the list needs no authentication.
+	req, err := http.NewRequest("GET", baseURL+envelope.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = routerRequest(client, req); err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []struct {
+			IP   string `xml:"IPAddress"`
+			MAC  string `xml:"MACAddress"`
+			Name string `xml:"HostName"`
+		} `xml:"Item"`
+	}
+	if err := xml.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("invalid FRITZ!Box host list: %v", err)
+	}
+
+	var hosts []inventoryHost
+	for _, item := range list.Items {
+		if mac, ok := canonicalMAC(item.MAC); ok {
+			hosts = append(hosts, inventoryHost{Name: item.Name, IP: item.IP, MAC: mac})
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,34 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func FuzzParseCoAP(f *testing.F) {
+	f.Add(newCoAPRequest(coapTypeCON, coapCodePOST, "skynet", "wake"))
+	f.Add(newCoAPRequest(coapTypeNON, coapCodePOST, "", "wake", "a-rather-long-alias-name"))
+	f.Add([]byte{0x40, 0x01, 0x00, 0x01, 0xFF})
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		req, err := parseCoAP(bs)
+		if err != nil {
+			return
+		}
+
+		// Whatever parses is answered, and only wakes trimmed targets.
+		coapHandler(req, func(target string) (string, time.Duration, error) {
+			if target == "" || strings.TrimSpace(target) != target {
+				t.Fatalf("woke %q", target)
+			}
+			return "00:11:22:33:44:55", 0, nil
+		})
+	})
+}
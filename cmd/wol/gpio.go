@@ -0,0 +1,178 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// gpioButtons maps a GPIO line offset to the alias (or MAC) it wakes.
+type gpioButtons map[uint32]string
+
+// parseGPIOButtons parses `<line>=<alias>` arguments into a gpioButtons map.
+func parseGPIOButtons(args []string) (gpioButtons, error) {
+	if len(args) == 0 {
+		return nil, errors.New("gpio command requires at least one <line>=<alias> mapping")
+	}
+
+	buttons := gpioButtons{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid gpio mapping %q (expected <line>=<alias>)", arg)
+		}
+		line, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gpio line %q: %v", parts[0], err)
+		}
+		if _, ok := buttons[uint32(line)]; ok {
+			return nil, fmt.Errorf("gpio line %d is mapped more than once", line)
+		}
+		buttons[uint32(line)] = parts[1]
+	}
+	return buttons, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// gpioDebouncer drops button events which arrive within `window` of the last
+// accepted event on the same line, since mechanical switches bounce.
+type gpioDebouncer struct {
+	mtx    sync.Mutex
+	window time.Duration
+	last   map[uint32]time.Time
+}
+
+// newGPIODebouncer returns a debouncer with the given window.
+func newGPIODebouncer(window time.Duration) *gpioDebouncer {
+	return &gpioDebouncer{
+		window: window,
+		last:   map[uint32]time.Time{},
+	}
+}
+
+// accept returns true if an event on `line` at `now` is a new press.
+func (d *gpioDebouncer) accept(line uint32, now time.Time) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if last, ok := d.last[line]; ok && now.Sub(last) < d.window {
+		return false
+	}
+	d.last[line] = now
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// gpioLED is an optional status LED.
+type gpioLED interface {
+	set(on bool) error
+}
+
+// gpioFeedback lights the LED while a wake is in progress, and blinks it
+// three times if the wake failed.
+func gpioFeedback(led gpioLED, err error) {
+	if led == nil {
+		return
+	}
+	if err == nil {
+		led.set(false)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		led.set(false)
+		time.Sleep(150 * time.Millisecond)
+		led.set(true)
+		time.Sleep(150 * time.Millisecond)
+	}
+	led.set(false)
+}
+
+// Run the gpio command.
+func gpioCmd(args []string, aliases *Aliases) error {
+	buttons, err := parseGPIOButtons(args)
+	if err != nil {
+		return err
+	}
+	if cliFlags.GPIODebounce < 0 {
+		return fmt.Errorf("invalid gpio debounce %d", cliFlags.GPIODebounce)
+	}
+
+	chip, err := openGPIOChip(cliFlags.GPIOChip)
+	if err != nil {
+		return err
+	}
+	defer chip.close()
+
+	var led gpioLED
+	if cliFlags.GPIOLed >= 0 {
+		if led, err = chip.led(uint32(cliFlags.GPIOLed)); err != nil {
+			return err
+		}
+	}
+
+	presses, err := chip.watch(buttons)
+	if err != nil {
+		return err
+	}
+
+	startHealthServer()
+	stop := shutdownSignal()
+	go pruneHistory(aliases, stop)
+	watchInterfaces(stop, nil)
+	log.Printf("Watching %d button(s) on %s\n", len(buttons), cliFlags.GPIOChip)
+	done, err := daemonReady()
+	if err != nil {
+		return err
+	}
+	defer done()
+	debouncer := newGPIODebouncer(time.Duration(cliFlags.GPIODebounce) * time.Millisecond)
+	for {
+		var line uint32
+		var ok bool
+		select {
+		case line, ok = <-presses:
+		case <-stop:
+			return nil
+		}
+		if !ok {
+			return errors.New("gpio event stream closed")
+		}
+		if !debouncer.accept(line, time.Now()) {
+			continue
+		}
+
+		target := buttons[line]
+		if led != nil {
+			led.set(true)
+		}
+
+		var wait time.Duration
+		ctx, sp := startSpan(context.Background(), "gpio wake")
+		sp.set("wol.target", target)
+		wt, err := resolveWakeTarget(ctx, target, fromLocal, aliases)
+		if err == nil {
+			wait, err = wt.wake(ctx, aliases, fmt.Sprintf("gpio:%d", line))
+		}
+		sp.finish(err)
+		switch {
+		case err != nil:
+			log.Printf("Button on line %d failed to wake %s: %v\n", line, target, err)
+		case wait > 0:
+			log.Printf("Button on line %d coalesced with a recent wake of %s\n", line, target)
+		default:
+			log.Printf("Button on line %d woke %s (%s)\n", line, target, wt.mac)
+		}
+		gpioFeedback(led, err)
+	}
+}
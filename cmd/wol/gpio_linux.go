@@ -0,0 +1,155 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Definitions from the GPIO character device uAPI (linux/gpio.h, v1). The
+// ioctl numbers use the generic _IOWR encoding shared by x86 and arm, which
+// covers the Raspberry Pi and other SBCs this mode targets.
+const (
+	gpioHandleRequestInput      = 1 << 0
+	gpioHandleRequestOutput     = 1 << 1
+	gpioHandleRequestBiasPullUp = 1 << 5
+
+	gpioEventRequestFallingEdge = 1 << 1
+
+	gpioGetLineHandleIoctl       = 0xC16CB403 // _IOWR(0xB4, 0x03, gpiohandle_request)
+	gpioGetLineEventIoctl        = 0xC030B404 // _IOWR(0xB4, 0x04, gpioevent_request)
+	gpioHandleSetLineValuesIoctl = 0xC040B409 // _IOWR(0xB4, 0x09, gpiohandle_data)
+
+	gpioConsumer = "go-wol"
+)
+
+type gpioHandleRequest struct {
+	LineOffsets   [64]uint32
+	Flags         uint32
+	DefaultValues [64]uint8
+	ConsumerLabel [32]byte
+	Lines         uint32
+	Fd            int32
+}
+
+type gpioEventRequest struct {
+	LineOffset    uint32
+	HandleFlags   uint32
+	EventFlags    uint32
+	ConsumerLabel [32]byte
+	Fd            int32
+}
+
+type gpioHandleData struct {
+	Values [64]uint8
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// gpioChip is an open /dev/gpiochipN device.
+type gpioChip struct {
+	f     *os.File
+	files []*os.File
+}
+
+// openGPIOChip opens the GPIO character device at `path`.
+func openGPIOChip(path string) (*gpioChip, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &gpioChip{f: f}, nil
+}
+
+// ioctl issues `req` against `fd` with `arg` as the argument.
+func gpioIoctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// watch requests falling edge events for each button line and returns a
+// channel of line offsets, one per edge seen.
+func (c *gpioChip) watch(buttons gpioButtons) (<-chan uint32, error) {
+	presses := make(chan uint32)
+	for line := range buttons {
+		req := gpioEventRequest{
+			LineOffset:  line,
+			HandleFlags: gpioHandleRequestInput | gpioHandleRequestBiasPullUp,
+			EventFlags:  gpioEventRequestFallingEdge,
+		}
+		copy(req.ConsumerLabel[:], gpioConsumer)
+
+		// Bias flags need Linux 5.5+, fall back to relying on an external
+		// pull-up resistor on older kernels.
+		err := gpioIoctl(c.f.Fd(), gpioGetLineEventIoctl, unsafe.Pointer(&req))
+		if err == syscall.EINVAL {
+			req.HandleFlags = gpioHandleRequestInput
+			err = gpioIoctl(c.f.Fd(), gpioGetLineEventIoctl, unsafe.Pointer(&req))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		f := os.NewFile(uintptr(req.Fd), gpioConsumer)
+		c.files = append(c.files, f)
+		go func(line uint32, f *os.File) {
+			// Each gpioevent_data is a u64 timestamp and a u32 id (padded).
+			buf := make([]byte, 16)
+			for {
+				if _, err := f.Read(buf); err != nil {
+					log.Printf("Stopped watching gpio line %d: %v\n", line, err)
+					return
+				}
+				presses <- line
+			}
+		}(line, f)
+	}
+	return presses, nil
+}
+
+// gpioLine is a single output line used to drive an LED.
+type gpioLine struct {
+	f *os.File
+}
+
+// led requests `line` as an output, initially off.
+func (c *gpioChip) led(line uint32) (gpioLED, error) {
+	req := gpioHandleRequest{
+		Flags: gpioHandleRequestOutput,
+		Lines: 1,
+	}
+	req.LineOffsets[0] = line
+	copy(req.ConsumerLabel[:], gpioConsumer)
+
+	if err := gpioIoctl(c.f.Fd(), gpioGetLineHandleIoctl, unsafe.Pointer(&req)); err != nil {
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(req.Fd), gpioConsumer)
+	c.files = append(c.files, f)
+	return &gpioLine{f}, nil
+}
+
+// set drives the LED on or off.
+func (l *gpioLine) set(on bool) error {
+	var data gpioHandleData
+	if on {
+		data.Values[0] = 1
+	}
+	return gpioIoctl(l.f.Fd(), gpioHandleSetLineValuesIoctl, unsafe.Pointer(&data))
+}
+
+// close releases all requested lines and the chip.
+func (c *gpioChip) close() error {
+	for _, f := range c.files {
+		f.Close()
+	}
+	return c.f.Close()
+}
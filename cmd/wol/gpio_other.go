@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// gpioChip is only implemented on linux.
+type gpioChip struct{}
+
+// openGPIOChip always fails on platforms without the GPIO character device.
+func openGPIOChip(path string) (*gpioChip, error) {
+	return nil, errors.New("gpio mode is only supported on linux")
+}
+
+func (c *gpioChip) watch(buttons gpioButtons) (<-chan uint32, error) {
+	return nil, errors.New("gpio mode is only supported on linux")
+}
+
+func (c *gpioChip) led(line uint32) (gpioLED, error) {
+	return nil, errors.New("gpio mode is only supported on linux")
+}
+
+func (c *gpioChip) close() error {
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseGPIOButtons(t *testing.T) {
+	buttons, err := parseGPIOButtons([]string{"17=nas", "27=00:11:22:33:44:55"})
+	assert.Nil(t, err)
+	assert.Equal(t, gpioButtons{17: "nas", 27: "00:11:22:33:44:55"}, buttons)
+}
+
+func TestParseGPIOButtonsNegative(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"17"},
+		{"17="},
+		{"x=nas"},
+		{"-1=nas"},
+		{"17=nas", "17=media"},
+	} {
+		_, err := parseGPIOButtons(args)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestGPIODebouncer(t *testing.T) {
+	now := time.Now()
+	d := newGPIODebouncer(250 * time.Millisecond)
+
+	assert.True(t, d.accept(17, now))
+	assert.False(t, d.accept(17, now.Add(10*time.Millisecond)))
+	assert.True(t, d.accept(27, now.Add(10*time.Millisecond)))
+	assert.True(t, d.accept(17, now.Add(300*time.Millisecond)))
+}
@@ -0,0 +1,369 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	groupsBucketName = "Groups"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Groups holds named groups of aliases, stored alongside the aliases. A
+// member of a group is the name of an alias or of another group, so groups
+// can be nested; a member which names both is taken to be the group.
+type Groups struct {
+	a *Aliases
+}
+
+// Groups returns the groups of the alias store.
+func (a *Aliases) Groups() Groups {
+	return Groups{a}
+}
+
+// getMembers returns the members of group `name`, and whether it exists.
+func getMembers(tx *bolt.Tx, name string) ([]string, bool, error) {
+	value := tx.Bucket([]byte(groupsBucketName)).Get([]byte(name))
+	if value == nil {
+		return nil, false, nil
+	}
+	var members []string
+	err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&members)
+	return members, true, err
+}
+
+// resolve appends the aliases `name` expands to to `out`, skipping those in
+// `seen`. `path` holds the groups being expanded, to detect cycles.
+func resolve(tx *bolt.Tx, name string, path []string, seen map[string]bool, out *[]string) error {
+	members, ok, err := getMembers(tx, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if !seen[name] {
+			seen[name] = true
+			*out = append(*out, name)
+		}
+		return nil
+	}
+
+	for idx, group := range path {
+		if group == name {
+			cycle := append(path[idx:], name)
+			return fmt.Errorf("group %s contains itself (%s)", name, strings.Join(cycle, " -> "))
+		}
+	}
+	path = append(path, name)
+	for _, member := range members {
+		if err := resolve(tx, member, path, seen, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Add stores (or replaces) group `name` with `members`. It fails if the group
+// would then contain itself, directly or through other groups.
+func (g Groups) Add(name string, members ...string) error {
+	g.a.mtx.Lock()
+	defer g.a.mtx.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(members); err != nil {
+		return err
+	}
+	return g.a.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(groupsBucketName)).Put([]byte(name), buf.Bytes()); err != nil {
+			return err
+		}
+		// Failing rolls the group back.
+		var out []string
+		return resolve(tx, name, nil, map[string]bool{}, &out)
+	})
+}
+
+// Del removes group `name`. Groups which contain it are left as they are,
+// and treat it as an alias name from then on.
+func (g Groups) Del(name string) error {
+	g.a.mtx.Lock()
+	defer g.a.mtx.Unlock()
+
+	return g.a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(groupsBucketName)).Delete([]byte(name))
+	})
+}
+
+// List returns the names of all groups, sorted.
+func (g Groups) List() ([]string, error) {
+	g.a.mtx.Lock()
+	defer g.a.mtx.Unlock()
+
+	names := []string{}
+	err := g.a.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(groupsBucketName)).ForEach(func(k, v []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	return names, err
+}
+
+// Members returns the members of group `name` as they were added.
+func (g Groups) Members(name string) ([]string, error) {
+	g.a.mtx.Lock()
+	defer g.a.mtx.Unlock()
+
+	var members []string
+	err := g.a.db.View(func(tx *bolt.Tx) error {
+		var ok bool
+		var err error
+		if members, ok, err = getMembers(tx, name); err == nil && !ok {
+			err = fmt.Errorf("group (%s) not found in db", name)
+		}
+		return err
+	})
+	return members, err
+}
+
+// Resolve returns the names of the aliases `name` expands to: the members of
+// the group `name`, with nested groups expanded and each alias listed once,
+// in the order first reached. A name which is not a group resolves to itself.
+func (g Groups) Resolve(name string) ([]string, error) {
+	g.a.mtx.Lock()
+	defer g.a.mtx.Unlock()
+
+	out := []string{}
+	err := g.a.db.View(func(tx *bolt.Tx) error {
+		return resolve(tx, name, nil, map[string]bool{}, &out)
+	})
+	return out, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Run the group command.
+func groupCmd(args []string, aliases *Aliases) error {
+	if len(args) == 0 {
+		return errors.New("group command requires one of: add <name> <member> ..., list, show <name>, remove <name>")
+	}
+
+	groups := aliases.Groups()
+	switch sub, args := strings.ToLower(args[0]), args[1:]; sub {
+	case "add":
+		if len(args) < 2 {
+			return errors.New("group add requires a <name> and at least one <member>")
+		}
+		return groups.Add(args[0], args[1:]...)
+
+	case "list":
+		names, err := groups.List()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			progressf("No groups found! Add one with \"wol group add <name> <member> ...\"\n")
+			return nil
+		}
+		for _, name := range names {
+			members, err := groups.Members(name)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("    %s - %s\n", name, strings.Join(members, ", "))
+		}
+		return nil
+
+	case "show":
+		if len(args) != 1 {
+			return errors.New("group show requires a <name>")
+		}
+		if _, err := groups.Members(args[0]); err != nil {
+			return err
+		}
+		names, err := groups.Resolve(args[0])
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			mac := "(no such alias)"
+			if mi, err := aliases.Get(name); err == nil {
+				mac = mi.Mac
+			}
+			fmt.Printf("    %s - %s\n", name, mac)
+		}
+		return nil
+
+	case "remove":
+		if len(args) != 1 {
+			return errors.New("group remove requires a <name>")
+		}
+		return groups.Del(args[0])
+	}
+	return fmt.Errorf("unknown group command %q", args[0])
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// groupTargets resolves the aliases group `name` expands to into wake
+// targets, once per MAC address even if several aliases (e.g. through nested
+// groups) share it. Aliases which fail to resolve are printed and counted.
+// Aliases woken through their management controller are returned apart.
+func groupTargets(ctx context.Context, name string, aliases *Aliases) ([]*wakeTarget, []string, int, error) {
+	names, err := aliases.Groups().Resolve(name)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var targets []*wakeTarget
+	var oobAliases []string
+	seen := map[string]string{}
+	failed := 0
+	for _, alias := range names {
+		if _, ok := defaultOOB(alias, aliases); ok && cliFlags.Method == "" {
+			oobAliases = append(oobAliases, alias)
+			continue
+		}
+		wt, err := resolveWakeTarget(ctx, alias, fromLocal, aliases)
+		if err != nil {
+			errorf("Failed to wake %s: %v\n", alias, err)
+			failed++
+			continue
+		}
+		mac := wt.hwAddr.String()
+		if other, ok := seen[mac]; ok {
+			progressf("Skipped %s, it has the same MAC address as %s\n", alias, other)
+			continue
+		}
+		seen[mac] = alias
+		targets = append(targets, wt)
+	}
+	return targets, oobAliases, failed, nil
+}
+
+// broadcastDomain identifies the network segment the packet of `wt` floods:
+// the gateway it is sent --via, or the local and broadcast addresses it is
+// sent from and to.
+func (wt *wakeTarget) broadcastDomain() string {
+	if wt.via != "" {
+		return "via " + wt.via
+	}
+	local := ""
+	if wt.localAddr != nil {
+		local = wt.localAddr.IP.String()
+	}
+	return local + " -> " + wt.udpAddr.IP.String()
+}
+
+// byBroadcastDomain splits `targets` by broadcast domain, keeping the order
+// of the domains and of the targets in each.
+func byBroadcastDomain(targets []*wakeTarget) [][]*wakeTarget {
+	var domains [][]*wakeTarget
+	index := map[string]int{}
+	for _, wt := range targets {
+		key := wt.broadcastDomain()
+		idx, ok := index[key]
+		if !ok {
+			idx = len(domains)
+			index[key] = idx
+			domains = append(domains, nil)
+		}
+		domains[idx] = append(domains[idx], wt)
+	}
+	return domains
+}
+
+// wakeGroup wakes every machine in group `name`. Machines in the same
+// broadcast domain are woken one at a time, `--group-pace` apart, so that no
+// segment is flooded, while the domains are woken in parallel. A failed wake
+// does not stop the others; failures are counted in the returned error.
+func wakeGroup(name string, aliases *Aliases) error {
+	if cliFlags.Verify != "" || cliFlags.VerifyCmd != "" {
+		return fmt.Errorf("--verify and --verify-cmd apply to a single machine, not to group %s", name)
+	}
+
+	ctx, sp := startSpan(context.Background(), "wake group")
+	sp.set("wol.group", name)
+	defer sp.finish(nil)
+
+	targets, oobAliases, failed, err := groupTargets(ctx, name, aliases)
+	if err != nil {
+		return err
+	}
+	total := len(targets) + len(oobAliases) + failed
+
+	domains := byBroadcastDomain(targets)
+	progressf("Attempting to wake %d machine(s) in group %s (%d broadcast domain(s))\n", total, name, len(domains))
+	p := newProgress("wake "+name, total)
+	p.add(failed)
+
+	pace := time.Duration(cliFlags.GroupPace) * time.Millisecond
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	for _, domain := range domains {
+		wg.Add(1)
+		go func(domain []*wakeTarget) {
+			defer wg.Done()
+			for idx, wt := range domain {
+				if idx > 0 {
+					time.Sleep(pace)
+				}
+				wait, err := wt.wake(ctx, aliases, cliUser())
+
+				mtx.Lock()
+				switch {
+				case err != nil:
+					errorf("Failed to wake %s: %v\n", wt.target, err)
+					failed++
+				case wait > 0:
+					statusf("Skipped %s, it was woken less than %s ago\n", wt.target, wt.cooldown)
+				default:
+					statusf("Magic packet sent to %s (%s via %s)\n", wt.target, wt.mac, wt.bcastAddr)
+				}
+				mtx.Unlock()
+				p.add(1)
+			}
+		}(domain)
+	}
+
+	// Machines woken through their management controller are powered on
+	// meanwhile, as they share no broadcast domain to pace.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, alias := range oobAliases {
+			oob, _ := defaultOOB(alias, aliases)
+			err := powerOnOOB(alias, oob, aliases)
+
+			mtx.Lock()
+			if err != nil {
+				errorf("Failed to wake %s: %v\n", alias, err)
+				failed++
+			}
+			mtx.Unlock()
+			p.add(1)
+		}
+	}()
+	wg.Wait()
+	p.finish()
+
+	if failed > 0 {
+		return fmt.Errorf("failed to wake %d of the %d machine(s) in group %s", failed, total, name)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestGroups(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestGroups.db")
+	defer cleanup()
+	groups := aliases.Groups()
+
+	assert.Nil(t, groups.Add("rack1", "nas", "backup"))
+	assert.Nil(t, groups.Add("rack2", "build", "nas"))
+	assert.Nil(t, groups.Add("lab", "rack1", "rack2", "printer"))
+
+	names, err := groups.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"lab", "rack1", "rack2"}, names)
+
+	members, err := groups.Members("lab")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rack1", "rack2", "printer"}, members)
+	_, err = groups.Members("nas")
+	assert.NotNil(t, err)
+
+	// Nested groups are expanded and each alias listed once.
+	resolved, err := groups.Resolve("lab")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nas", "backup", "build", "printer"}, resolved)
+	resolved, err = groups.Resolve("nas")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nas"}, resolved)
+
+	// Cycles are refused, and the group left as it was.
+	err = groups.Add("rack1", "nas", "lab")
+	assert.EqualError(t, err, "group rack1 contains itself (rack1 -> lab -> rack1)")
+	err = groups.Add("self", "self")
+	assert.NotNil(t, err)
+	members, err = groups.Members("rack1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nas", "backup"}, members)
+	_, err = groups.Members("self")
+	assert.NotNil(t, err)
+
+	// A removed group becomes a (missing) alias name in its parents.
+	assert.Nil(t, groups.Del("rack2"))
+	resolved, err = groups.Resolve("lab")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nas", "backup", "rack2", "printer"}, resolved)
+}
+
+func TestGroupTargets(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestGroupTargets.db")
+	defer cleanup()
+
+	assert.Nil(t, aliases.Add("nas", "00:11:22:AA:BB:CC@10.0.0.255:7", ""))
+	assert.Nil(t, aliases.Add("nas-eth1", "00-11-22-aa-bb-cc@10.0.1.255:7", ""))
+	assert.Nil(t, aliases.Add("tv", "00:11:22:AA:BB:DD@10.0.0.255:7", ""))
+	assert.Nil(t, aliases.Add("r640", "00:11:22:AA:BB:EE", ""))
+	assert.Nil(t, aliases.SetOOB("r640", OOB{Kind: "redfish", Address: "idrac.lan", Default: true}))
+	assert.Nil(t, aliases.Groups().Add("rack1", "nas", "ghost", "r640"))
+	assert.Nil(t, aliases.Groups().Add("lab", "rack1", "nas-eth1", "tv", "nas"))
+
+	// Each MAC address is woken once, and missing aliases are counted.
+	// Aliases woken through their management controller are kept apart.
+	targets, oobAliases, failed, err := groupTargets(context.Background(), "lab", aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, []string{"r640"}, oobAliases)
+	assert.Equal(t, 2, len(targets))
+	assert.Equal(t, "00:11:22:aa:bb:cc", targets[0].hwAddr.String())
+	assert.Equal(t, "10.0.0.255:7", targets[0].udpAddr.String())
+	assert.Equal(t, "00:11:22:aa:bb:dd", targets[1].hwAddr.String())
+}
+
+func TestByBroadcastDomain(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestByBroadcastDomain.db")
+	defer cleanup()
+
+	var targets []*wakeTarget
+	for _, spec := range []string{
+		"00:11:22:33:44:01@10.0.0.255:9",
+		"00:11:22:33:44:02@10.0.1.255:9",
+		"00:11:22:33:44:03@10.0.0.255:7",
+		"00:11:22:33:44:04@10.0.1.255:9",
+	} {
+		wt, err := resolveWakeTarget(context.Background(), spec, fromLocal, aliases)
+		assert.Nil(t, err)
+		targets = append(targets, wt)
+	}
+	via := *targets[0]
+	via.via = "gw"
+
+	// Ports do not matter, gateways do.
+	domains := byBroadcastDomain(append(targets, &via))
+	assert.Equal(t, 3, len(domains))
+	assert.Equal(t, []*wakeTarget{targets[0], targets[2]}, domains[0])
+	assert.Equal(t, []*wakeTarget{targets[1], targets[3]}, domains[1])
+	assert.Equal(t, []*wakeTarget{&via}, domains[2])
+}
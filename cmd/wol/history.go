@@ -0,0 +1,387 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	historyBucketName = "History"
+
+	// historyPruneInterval is how often daemons apply the history retention.
+	historyPruneInterval = time.Hour
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// HistoryEntry records a single wake attempt: what was woken, by whom and how
+// it went. Verifying a wake is recorded as a separate entry with `Verify` set
+// to the method used and, if the host came up, `UpAfter` set to how long it
+// took. A machine powered on through its management controller instead has
+// `OOB` set to the kind of controller.
+type HistoryEntry struct {
+	Time      time.Time     `json:"time"`
+	Target    string        `json:"target"`
+	Mac       string        `json:"mac"`
+	By        string        `json:"by"`
+	Coalesced bool          `json:"coalesced,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Verify    string        `json:"verify,omitempty"`
+	UpAfter   time.Duration `json:"up_after,omitempty"`
+	OOB       string        `json:"oob,omitempty"`
+}
+
+// historyKey returns the db key of an entry at `t`. Keys sort by time, so
+// ranges can be read with a cursor.
+func historyKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// AddHistory appends `e` to the wake history.
+func (a *Aliases) AddHistory(e HistoryEntry) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(e); err != nil {
+		return err
+	}
+	return a.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(historyBucketName))
+
+		// Entries recorded within the same nanosecond are stored one
+		// nanosecond apart rather than overwriting each other.
+		key := historyKey(e.Time)
+		for bucket.Get(key) != nil {
+			binary.BigEndian.PutUint64(key, binary.BigEndian.Uint64(key)+1)
+		}
+		return bucket.Put(key, buf.Bytes())
+	})
+}
+
+// History returns the wake history since `since`, oldest first. If `target`
+// is not empty, only wakes of that alias or MAC address are returned.
+func (a *Aliases) History(since time.Time, target string) ([]HistoryEntry, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var entries []HistoryEntry
+	err := a.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(historyBucketName)).Cursor()
+		k, v := c.First()
+		if !since.IsZero() {
+			k, v = c.Seek(historyKey(since))
+		}
+		for ; k != nil; k, v = c.Next() {
+			var e HistoryEntry
+			if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&e); err != nil {
+				return err
+			}
+			if target == "" || e.Target == target || e.Mac == target {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// PruneHistory removes the history entries recorded before `before` (unless
+// it is zero) and all but the newest `keep` entries (unless it is 0), and
+// returns how many it removed.
+func (a *Aliases) PruneHistory(before time.Time, keep int) (int, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	removed := 0
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(historyBucketName))
+		excess := 0
+		if n := bucket.Stats().KeyN; keep > 0 && n > keep {
+			excess = n - keep
+		}
+
+		// Keys are collected first, as deleting moves the cursor.
+		var keys [][]byte
+		cutoff := historyKey(before)
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if len(keys) >= excess && (before.IsZero() || bytes.Compare(k, cutoff) >= 0) {
+				break
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	return removed, err
+}
+
+// historyRetention returns the cutoff and entry limit of the history set by
+// `--history-max-age` and `--history-max-entries`, and whether either is.
+func historyRetention() (time.Time, int, bool, error) {
+	if cliFlags.HistoryMaxAge < 0 {
+		return time.Time{}, 0, false, fmt.Errorf("invalid history max age %d", cliFlags.HistoryMaxAge)
+	}
+	if cliFlags.HistoryMaxEntries < 0 {
+		return time.Time{}, 0, false, fmt.Errorf("invalid history max entries %d", cliFlags.HistoryMaxEntries)
+	}
+	var before time.Time
+	if cliFlags.HistoryMaxAge > 0 {
+		before = time.Now().Add(-time.Duration(cliFlags.HistoryMaxAge) * 24 * time.Hour)
+	}
+	return before, cliFlags.HistoryMaxEntries, cliFlags.HistoryMaxAge > 0 || cliFlags.HistoryMaxEntries > 0, nil
+}
+
+// pruneHistory applies the history retention, if any, every
+// historyPruneInterval until `stop` is closed. Daemons run it so that the
+// alias db does not grow without bound.
+func pruneHistory(aliases *Aliases, stop <-chan struct{}) {
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		before, keep, ok, err := historyRetention()
+		if err != nil {
+			log.Printf("Not pruning the history: %v\n", err)
+			return
+		}
+		if !ok {
+			return
+		}
+		if removed, err := aliases.PruneHistory(before, keep); err != nil {
+			log.Printf("Failed to prune the history: %v\n", err)
+		} else if removed > 0 {
+			log.Printf("Pruned %d history entries\n", removed)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// historyFormats maps the supported export formats to their content types.
+var historyFormats = map[string]string{
+	"text":  "text/plain",
+	"json":  "application/json",
+	"jsonl": "application/x-ndjson",
+	"csv":   "text/csv",
+}
+
+// writeHistory writes `entries` to `w` in the given format. The text format
+// is for people, and shows times in local time and relative to `now`.
+func writeHistory(w io.Writer, format string, entries []HistoryEntry, now time.Time) error {
+	switch format {
+	case "text":
+		for _, e := range entries {
+			what, result := "", "ok"
+			if e.Verify != "" {
+				what, result = "verify of ", "up after "+e.UpAfter.Round(time.Second).String()
+			}
+			if e.OOB != "" {
+				what = "power on (" + e.OOB + ") of "
+			}
+			if e.Error != "" {
+				result = "failed: " + e.Error
+			} else if e.Coalesced {
+				result = "coalesced"
+			}
+			fmt.Fprintf(w, "    %s  %s%s (%s) by %s, %s\n",
+				formatTime(e.Time, now), what, e.Target, e.Mac, e.By, result)
+		}
+		return nil
+
+	case "json":
+		if entries == nil {
+			entries = []HistoryEntry{}
+		}
+		return json.NewEncoder(w).Encode(entries)
+
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "target", "mac", "by", "coalesced", "error", "verify", "up_after", "oob"})
+		for _, e := range entries {
+			upAfter := ""
+			if e.UpAfter > 0 {
+				upAfter = strconv.FormatFloat(e.UpAfter.Seconds(), 'f', -1, 64)
+			}
+			cw.Write([]string{
+				e.Time.Format(time.RFC3339Nano), e.Target, e.Mac, e.By,
+				strconv.FormatBool(e.Coalesced), e.Error, e.Verify, upAfter, e.OOB,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown history format %q", format)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// recordWake adds a wake attempt to the history and, if `--audit-syslog` is
+// set, forwards it to syslog, and tells the `--notify` services about it.
+// Failures are logged rather than returned, as the wake itself has already
+// happened.
+func recordWake(aliases *Aliases, e HistoryEntry) {
+	if err := aliases.AddHistory(e); err != nil {
+		log.Printf("Failed to record wake of %s in the history: %v\n", e.Target, err)
+	}
+	if cliFlags.AuditSyslog {
+		if err := auditSyslog(e); err != nil {
+			log.Printf("Failed to forward wake of %s to syslog: %v\n", e.Target, err)
+		}
+	}
+	if notifier != nil {
+		notifier.notify(e)
+	}
+}
+
+// auditSyslog forwards a history entry to the local syslog daemon as JSON.
+func auditSyslog(e HistoryEntry) error {
+	w, err := newSyslogWriter("wol")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// handleHistory serves `GET /history`, the wake history filtered by `alias`
+// and `since` and formatted as `format` (json, jsonl or csv).
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+
+	q := r.URL.Query()
+	since, err := parseSince(q.Get("since"), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := historyFormats[format]
+	if !ok || format == "text" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown history format %q", format))
+		return
+	}
+
+	entries, err := s.aliases.History(since, q.Get("alias"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Restricted tokens only see the history of the targets they may wake.
+	visible := entries[:0]
+	for _, e := range entries {
+		if s.mayWake(r, e.Target) {
+			visible = append(visible, e)
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	writeHistory(w, format, visible, time.Now())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Run the history command.
+func historyCmd(args []string, aliases *Aliases) error {
+	if len(args) > 0 && strings.ToLower(args[0]) == "prune" {
+		return historyPruneCmd(args[1:], aliases)
+	}
+
+	since, err := parseSince(cliFlags.Since, time.Now())
+	if err != nil {
+		return err
+	}
+	if _, ok := historyFormats[cliFlags.Format]; !ok {
+		return fmt.Errorf("unknown history format %q", cliFlags.Format)
+	}
+
+	target := ""
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	entries, err := aliases.History(since, target)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 && cliFlags.Format == "text" {
+		progressf("No wakes found\n")
+		return nil
+	}
+	return writeHistory(os.Stdout, cliFlags.Format, entries, time.Now())
+}
+
+// historyPruneCmd applies the history retention once.
+func historyPruneCmd(args []string, aliases *Aliases) error {
+	if len(args) != 0 {
+		return errors.New("history prune takes no arguments")
+	}
+	before, keep, ok, err := historyRetention()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("history prune requires --history-max-age or --history-max-entries")
+	}
+	removed, err := aliases.PruneHistory(before, keep)
+	if err != nil {
+		return err
+	}
+	statusf("Pruned %d history entries\n", removed)
+	return nil
+}
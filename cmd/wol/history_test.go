@@ -0,0 +1,176 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestWriteHistory(t *testing.T) {
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.FixedZone("CET", 3600)
+
+	when := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := when.Add(3*time.Hour + 20*time.Minute)
+	entries := []HistoryEntry{
+		{when, "nas", "00:11:22:33:44:55", "cli:root", false, "", "", 0, ""},
+		{when, "tv", "00:11:22:33:44:66", "api:10.0.0.2", false, "no route, to host", "", 0, ""},
+		{when, "nas", "00:11:22:33:44:55", "cli:root", false, "", "ssh", 1500 * time.Millisecond, ""},
+		{when, "nas", "00:11:22:33:44:55", "cli:root", false, "", "", 0, "redfish"},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	assert.Nil(t, writeHistory(buf, "csv", entries, now))
+	assert.Equal(t, "time,target,mac,by,coalesced,error,verify,up_after,oob\n"+
+		"2018-01-02T03:04:05Z,nas,00:11:22:33:44:55,cli:root,false,,,,\n"+
+		"2018-01-02T03:04:05Z,tv,00:11:22:33:44:66,api:10.0.0.2,false,\"no route, to host\",,,\n"+
+		"2018-01-02T03:04:05Z,nas,00:11:22:33:44:55,cli:root,false,,ssh,1.5,\n"+
+		"2018-01-02T03:04:05Z,nas,00:11:22:33:44:55,cli:root,false,,,,redfish\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, writeHistory(buf, "jsonl", entries, now))
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Equal(t, 4, len(lines))
+	var e HistoryEntry
+	assert.Nil(t, json.Unmarshal(lines[1], &e))
+	assert.Equal(t, entries[1], e)
+	var v HistoryEntry
+	assert.Nil(t, json.Unmarshal(lines[2], &v))
+	assert.Equal(t, entries[2], v)
+
+	buf.Reset()
+	assert.Nil(t, writeHistory(buf, "json", nil, now))
+	assert.Equal(t, "[]\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, writeHistory(buf, "text", entries[:1], now))
+	assert.Equal(t, "    2018-01-02 04:04:05 CET (3h ago)  nas (00:11:22:33:44:55) by cli:root, ok\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, writeHistory(buf, "text", entries[2:3], now))
+	assert.Equal(t, "    2018-01-02 04:04:05 CET (3h ago)  verify of nas (00:11:22:33:44:55) by cli:root, up after 2s\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, writeHistory(buf, "text", entries[3:], now))
+	assert.Equal(t, "    2018-01-02 04:04:05 CET (3h ago)  power on (redfish) of nas (00:11:22:33:44:55) by cli:root, ok\n", buf.String())
+
+	assert.NotNil(t, writeHistory(buf, "xml", entries, now))
+}
+
+func TestParseSince(t *testing.T) {
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.FixedZone("CET", 3600)
+	now := time.Date(2018, 1, 2, 15, 4, 5, 0, time.Local)
+
+	since, err := parseSince("", now)
+	assert.Nil(t, err)
+	assert.True(t, since.IsZero())
+
+	for _, tc := range []struct {
+		since    string
+		expected time.Time
+	}{
+		{"2018-01-02T03:04:05Z", time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2018-01-01", time.Date(2018, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"2018-01-01 12:30", time.Date(2018, 1, 1, 12, 30, 0, 0, time.Local)},
+		{"today", time.Date(2018, 1, 2, 0, 0, 0, 0, time.Local)},
+		{"Yesterday", time.Date(2018, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"3h", now.Add(-3 * time.Hour)},
+		{"1h30m", now.Add(-90 * time.Minute)},
+		{"90 min ago", now.Add(-90 * time.Minute)},
+		{"2 days ago", now.Add(-48 * time.Hour)},
+		{"2d", now.Add(-48 * time.Hour)},
+		{"last week", now.Add(-7 * 24 * time.Hour)},
+	} {
+		since, err := parseSince(tc.since, now)
+		assert.Nil(t, err, tc.since)
+		assert.True(t, since.Equal(tc.expected), tc.since)
+	}
+
+	for _, since := range []string{"tomorrow", "3 fortnights", "ago", "-3h", "2018-13-01"} {
+		_, err := parseSince(since, now)
+		assert.NotNil(t, err, since)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		ago      time.Duration
+		expected string
+	}{
+		{0, "just now"},
+		{42 * time.Second, "42s ago"},
+		{5*time.Minute + 59*time.Second, "5m ago"},
+		{3*time.Hour + 20*time.Minute, "3h ago"},
+		{50 * time.Hour, "2d ago"},
+		{-3 * time.Hour, "in 3h"},
+	} {
+		assert.Equal(t, tc.expected, relativeTime(now.Add(-tc.ago), now))
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestPruneHistory.db")
+	defer cleanup()
+
+	now := time.Now()
+	for days := 5; days >= 0; days-- {
+		assert.Nil(t, aliases.AddHistory(HistoryEntry{
+			Time:   now.Add(-time.Duration(days) * 24 * time.Hour),
+			Target: fmt.Sprintf("day%d", days),
+		}))
+	}
+
+	// Entries older than the cutoff go first...
+	removed, err := aliases.PruneHistory(now.Add(-3*24*time.Hour-time.Minute), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+
+	// ... then the oldest beyond the limit.
+	removed, err = aliases.PruneHistory(time.Time{}, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+
+	entries, err := aliases.History(time.Time{}, "")
+	assert.Nil(t, err)
+	var targets []string
+	for _, e := range entries {
+		targets = append(targets, e.Target)
+	}
+	assert.Equal(t, []string{"day2", "day1", "day0"}, targets)
+
+	removed, err = aliases.PruneHistory(time.Time{}, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, removed)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	defer func(age, entries int) {
+		cliFlags.HistoryMaxAge, cliFlags.HistoryMaxEntries = age, entries
+	}(cliFlags.HistoryMaxAge, cliFlags.HistoryMaxEntries)
+
+	cliFlags.HistoryMaxAge, cliFlags.HistoryMaxEntries = 0, 0
+	_, _, ok, err := historyRetention()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	cliFlags.HistoryMaxAge, cliFlags.HistoryMaxEntries = 30, 1000
+	before, keep, ok, err := historyRetention()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1000, keep)
+	assert.WithinDuration(t, time.Now().Add(-30*24*time.Hour), before, time.Minute)
+
+	cliFlags.HistoryMaxAge = -1
+	_, _, _, err = historyRetention()
+	assert.EqualError(t, err, "invalid history max age -1")
+}
@@ -0,0 +1,176 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// virshCommand is the libvirt client VMs are started and stopped with.
+var virshCommand = "virsh"
+
+// libvirtWakeVerbs are the virsh commands which bring back a libvirt domain
+// that is not running but not shut off either, by its state.
+var libvirtWakeVerbs = map[string]string{
+	"paused":      "resume",
+	"pmsuspended": "dompmwakeup",
+}
+
+// hypervisorKinds are the kinds of "management controller" which run the
+// machine as a VM. As VMs cannot receive magic packets, their aliases are
+// always woken through the hypervisor.
+var hypervisorKinds = map[string]bool{
+	"libvirt": true,
+	"proxmox": true,
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// virsh runs virsh against the libvirt URI of `oob` and returns its output.
+func virsh(ctx context.Context, oob OOB, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, virshCommand, append([]string{"-c", oob.Address}, args...)...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// libvirtPower starts or stops the libvirt domain of `oob`. Powering on a
+// paused or suspended domain resumes it, as it cannot be started.
+func libvirtPower(ctx context.Context, client *http.Client, oob OOB, action string) error {
+	verb, ok := map[string]string{
+		powerOn:      "start",
+		powerOff:     "shutdown",
+		powerOffHard: "destroy",
+	}[action]
+	if !ok {
+		return fmt.Errorf("libvirt cannot power %s", action)
+	}
+	if action == powerOn {
+		state, err := virsh(ctx, oob, "domstate", oob.VM)
+		if err != nil {
+			return err
+		}
+		if wake, ok := libvirtWakeVerbs[state]; ok {
+			verb = wake
+		}
+	}
+	_, err := virsh(ctx, oob, verb, oob.VM)
+	return err
+}
+
+// libvirtStatus returns the power state of the libvirt domain of `oob`. A
+// paused or suspended domain is off, as it does not run until it is woken.
+func libvirtStatus(ctx context.Context, client *http.Client, oob OOB) (string, error) {
+	state, err := virsh(ctx, oob, "domstate", oob.VM)
+	if err != nil {
+		return "", err
+	}
+	switch state {
+	case "running", "idle", "in shutdown":
+		return powerOn, nil
+	case "shut off", "crashed", "paused", "pmsuspended":
+		return powerOff, nil
+	}
+	return "", fmt.Errorf("libvirt reports an unknown state %q", state)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// proxmoxGuest is a VM (or container) of a Proxmox VE cluster.
+type proxmoxGuest struct {
+	VMID   int    `json:"vmid"`
+	Name   string `json:"name"`
+	Node   string `json:"node"`
+	Type   string `json:"type"` // qemu or lxc
+	Status string `json:"status"`
+}
+
+// proxmoxRequest sends a request to the Proxmox VE API of `oob`, which logs
+// in with the API token User (user@realm!tokenid) and its secret Password.
+func proxmoxRequest(ctx context.Context, client *http.Client, oob OOB, method, path string) ([]byte, error) {
+	req, err := http.NewRequest(method, oob.baseURL("https", "8006")+"/api2/json"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if oob.User != "" {
+		req.Header.Set("Authorization", "PVEAPIToken="+oob.User+"="+oob.Password)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return readOOBResponse(resp)
+}
+
+// proxmoxFind returns the guest of the cluster `oob` whose VMID or name is
+// the VM of `oob`.
+func proxmoxFind(ctx context.Context, client *http.Client, oob OOB) (proxmoxGuest, error) {
+	body, err := proxmoxRequest(ctx, client, oob, "GET", "/cluster/resources?type=vm")
+	if err != nil {
+		return proxmoxGuest{}, err
+	}
+	var resources struct {
+		Data []proxmoxGuest `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resources); err != nil {
+		return proxmoxGuest{}, fmt.Errorf("invalid Proxmox response: %v", err)
+	}
+	for _, guest := range resources.Data {
+		if strconv.Itoa(guest.VMID) == oob.VM || guest.Name == oob.VM {
+			return guest, nil
+		}
+	}
+	return proxmoxGuest{}, fmt.Errorf("the Proxmox cluster has no VM %s", oob.VM)
+}
+
+// proxmoxPower starts or stops the Proxmox VE guest of `oob`, on whichever
+// node of the cluster it lives.
+func proxmoxPower(ctx context.Context, client *http.Client, oob OOB, action string) error {
+	verb, ok := map[string]string{
+		powerOn:      "start",
+		powerOff:     "shutdown",
+		powerOffHard: "stop",
+	}[action]
+	if !ok {
+		return fmt.Errorf("proxmox cannot power %s", action)
+	}
+
+	guest, err := proxmoxFind(ctx, client, oob)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/nodes/%s/%s/%d/status/%s", url.PathEscape(guest.Node), guest.Type, guest.VMID, verb)
+	_, err = proxmoxRequest(ctx, client, oob, "POST", path)
+	return err
+}
+
+// proxmoxStatus returns the power state of the Proxmox VE guest of `oob`.
+func proxmoxStatus(ctx context.Context, client *http.Client, oob OOB) (string, error) {
+	guest, err := proxmoxFind(ctx, client, oob)
+	if err != nil {
+		return "", err
+	}
+	switch guest.Status {
+	case "running":
+		return powerOn, nil
+	case "stopped":
+		return powerOff, nil
+	}
+	return "", fmt.Errorf("proxmox reports an unknown status %q", guest.Status)
+}
@@ -0,0 +1,114 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestProxmoxPower(t *testing.T) {
+	var posted string
+	status := "stopped"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "PVEAPIToken=root@pam!wol=1234-abcd" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api2/json/cluster/resources":
+			w.Write([]byte(`{"data": [
+				{"vmid": 100, "name": "router", "node": "pve1", "type": "qemu", "status": "running"},
+				{"vmid": 101, "name": "build", "node": "pve2", "type": "lxc", "status": "` + status + `"}
+			]}`))
+		case r.Method == "POST":
+			posted = r.URL.Path
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	oob := OOB{Kind: "proxmox", Address: server.URL, User: "root@pam!wol", Password: "1234-abcd", VM: "build"}
+	assert.Nil(t, proxmoxPower(context.Background(), server.Client(), oob, powerOn))
+	assert.Equal(t, "/api2/json/nodes/pve2/lxc/101/status/start", posted)
+	oob.VM = "100"
+	assert.Nil(t, proxmoxPower(context.Background(), server.Client(), oob, powerOffHard))
+	assert.Equal(t, "/api2/json/nodes/pve1/qemu/100/status/stop", posted)
+
+	state, err := proxmoxStatus(context.Background(), server.Client(), oob)
+	assert.Nil(t, err)
+	assert.Equal(t, "on", state)
+	oob.VM = "build"
+	state, err = proxmoxStatus(context.Background(), server.Client(), oob)
+	assert.Nil(t, err)
+	assert.Equal(t, "off", state)
+
+	oob.VM = "ghost"
+	err = proxmoxPower(context.Background(), server.Client(), oob, powerOn)
+	assert.EqualError(t, err, "the Proxmox cluster has no VM ghost")
+}
+
+func TestLibvirtPower(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake virsh is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "TestLibvirtPower")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// The fake virsh logs its arguments, and knows the domain "build",
+	// whose state is read from a file.
+	log := filepath.Join(dir, "log")
+	domstate := filepath.Join(dir, "domstate")
+	assert.Nil(t, ioutil.WriteFile(domstate, []byte("shut off\n"), 0644))
+	script := `#!/bin/sh
+echo "$@" >> ` + log + `
+case "$4" in
+build) [ "$3" = domstate ] && cat ` + domstate + `; exit 0 ;;
+*) echo "error: failed to get domain '$4'"; exit 1 ;;
+esac
+`
+	defer func(cmd string) { virshCommand = cmd }(virshCommand)
+	virshCommand = filepath.Join(dir, "virsh")
+	assert.Nil(t, ioutil.WriteFile(virshCommand, []byte(script), 0755))
+
+	oob := OOB{Kind: "libvirt", Address: "qemu:///system", VM: "build"}
+	assert.Nil(t, libvirtPower(context.Background(), nil, oob, powerOn))
+	assert.Nil(t, libvirtPower(context.Background(), nil, oob, powerOff))
+	state, err := libvirtStatus(context.Background(), nil, oob)
+	assert.Nil(t, err)
+	assert.Equal(t, "off", state)
+	logged, err := ioutil.ReadFile(log)
+	assert.Nil(t, err)
+	assert.Equal(t, "-c qemu:///system domstate build\n-c qemu:///system start build\n"+
+		"-c qemu:///system shutdown build\n-c qemu:///system domstate build\n", string(logged))
+
+	// Paused and suspended domains are off, and are resumed rather than
+	// started.
+	for state, verb := range map[string]string{"paused": "resume", "pmsuspended": "dompmwakeup"} {
+		assert.Nil(t, ioutil.WriteFile(domstate, []byte(state+"\n"), 0644))
+		assert.Nil(t, os.Remove(log))
+		status, err := libvirtStatus(context.Background(), nil, oob)
+		assert.Nil(t, err)
+		assert.Equal(t, powerOff, status, state)
+		assert.Nil(t, libvirtPower(context.Background(), nil, oob, powerOn))
+		logged, err := ioutil.ReadFile(log)
+		assert.Nil(t, err)
+		assert.Contains(t, string(logged), "-c qemu:///system "+verb+" build\n", state)
+	}
+
+	oob.VM = "ghost"
+	err = libvirtPower(context.Background(), nil, oob, powerOn)
+	assert.EqualError(t, err, "exit status 1: error: failed to get domain 'ghost'")
+}
@@ -0,0 +1,158 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// maxIdempotencyKey is the longest Idempotency-Key accepted.
+const maxIdempotencyKey = 255
+
+// Clients which retry `POST /wake/<target>` (after a timeout, say) send the
+// same Idempotency-Key header with each attempt. The first request with a key
+// wakes the target, and those repeating it within `--idempotency-window`
+// get its response rather than sending the packet again. Keys are scoped to
+// the token, or without tokens to the client, which sent them.
+
+// idempotentResult is the response to the first request with a key, which
+// is being made until `done` is closed.
+type idempotentResult struct {
+	target  string
+	expires time.Time
+	done    chan struct{}
+
+	status      int // 0 if the response is not to be replayed
+	contentType string
+	body        []byte
+}
+
+// idempotencyCache remembers the responses to requests with a key.
+type idempotencyCache struct {
+	sync.Mutex
+	results map[string]*idempotentResult
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{results: map[string]*idempotentResult{}}
+}
+
+// begin returns the result of the request with `key`, and true if this is
+// the first request with it, which must finish the result. Repeated requests
+// wait for the first to finish, and fail if they are not for the same
+// `target`.
+func (c *idempotencyCache) begin(key, target string, now time.Time, window time.Duration) (*idempotentResult, bool, error) {
+	for {
+		c.Lock()
+		for k, res := range c.results {
+			if now.After(res.expires) {
+				delete(c.results, k)
+			}
+		}
+		res, ok := c.results[key]
+		if !ok {
+			res = &idempotentResult{target: target, expires: now.Add(window), done: make(chan struct{})}
+			c.results[key] = res
+			c.Unlock()
+			return res, true, nil
+		}
+		c.Unlock()
+
+		if res.target != target {
+			return nil, false, fmt.Errorf("Idempotency-Key was used to wake %s", res.target)
+		}
+		<-res.done
+		if res.status != 0 {
+			return res, false, nil
+		}
+		// The first request was not remembered, so this one takes its place.
+	}
+}
+
+// finish records the response the first request with `key` got. Responses
+// which say to retry (rate limits and server errors) are not remembered, so
+// that a retry is made anew.
+func (c *idempotencyCache) finish(key string, res *idempotentResult, rec *responseRecorder) {
+	c.Lock()
+	defer c.Unlock()
+
+	if rec.status == 0 || rec.status == http.StatusTooManyRequests || rec.status >= 500 {
+		delete(c.results, key)
+	} else {
+		res.status = rec.status
+		res.contentType = rec.Header().Get("Content-Type")
+		res.body = rec.body.Bytes()
+	}
+	close(res.done)
+}
+
+// replay writes the remembered response to `w`.
+func (res *idempotentResult) replay(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", res.contentType)
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(res.status)
+	w.Write(res.body)
+}
+
+// responseRecorder passes a response through, and keeps a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotencyScope returns who the Idempotency-Key of `r` belongs to.
+func idempotencyScope(r *http.Request) string {
+	if tok := requestToken(r); tok != nil {
+		return "token:" + tok.name
+	}
+	return apiClient(r)
+}
+
+// idempotent handles the Idempotency-Key of a request to wake `target`. If
+// it repeats an earlier request, the earlier response is written and false
+// returned. Otherwise the returned writer, which must be used for the
+// response, remembers it once the returned function is called.
+func (s *server) idempotent(w http.ResponseWriter, r *http.Request, target string) (http.ResponseWriter, func(), bool) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" || cliFlags.IdempotencyWindow <= 0 {
+		return w, func() {}, true
+	}
+	if len(key) > maxIdempotencyKey {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Idempotency-Key is longer than %d characters", maxIdempotencyKey))
+		return nil, nil, false
+	}
+
+	key = idempotencyScope(r) + "\x00" + key
+	window := time.Duration(cliFlags.IdempotencyWindow) * time.Second
+	res, first, err := s.idempotency.begin(key, target, time.Now(), window)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return nil, nil, false
+	}
+	if !first {
+		res.replay(w)
+		return nil, nil, false
+	}
+	rec := &responseRecorder{ResponseWriter: w}
+	return rec, func() { s.idempotency.finish(key, res, rec) }, true
+}
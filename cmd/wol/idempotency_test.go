@@ -0,0 +1,103 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestIdempotencyCache(t *testing.T) {
+	c := newIdempotencyCache()
+	now := time.Now()
+
+	res, first, err := c.begin("k", "nas", now, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, first)
+
+	// A repeat waits for the first request to finish.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		again, first, err := c.begin("k", "nas", now, time.Minute)
+		assert.Nil(t, err)
+		assert.False(t, first)
+		assert.Equal(t, http.StatusOK, again.status)
+	}()
+	rec := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+	writeJSON(rec, http.StatusOK, map[string]string{"mac": "00:11:22:33:44:55"})
+	c.finish("k", res, rec)
+	wg.Wait()
+
+	_, _, err = c.begin("k", "tv", now, time.Minute)
+	assert.NotNil(t, err)
+
+	// Keys are forgotten after the window...
+	_, first, err = c.begin("k", "tv", now.Add(2*time.Minute), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, first)
+
+	// ... and responses which ask to retry are not remembered.
+	res, _, _ = c.begin("limited", "nas", now, time.Minute)
+	rec = &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+	writeJSON(rec, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+	c.finish("limited", res, rec)
+	_, first, err = c.begin("limited", "nas", now, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, first)
+}
+
+func TestIdempotentWake(t *testing.T) {
+	saved := cliFlags
+	defer func() { cliFlags = saved }()
+	cliFlags.IdempotencyWindow = 60
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	cliFlags.BroadcastIP, cliFlags.UDPPort = "127.0.0.1", strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+
+	aliases, cleanup := openTestAliases(t, "./TestIdempotentWake.db")
+	defer cleanup()
+	assert.Nil(t, aliases.Add("nas", "00:11:22:33:44:55", ""))
+	assert.Nil(t, aliases.Add("tv", "00:11:22:33:44:66", ""))
+	s := newServer(aliases)
+
+	wake := func(target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/wake/"+target, nil)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := wake("nas", "retry-1")
+	assert.Equal(t, http.StatusOK, first.Code)
+	again := wake("nas", "retry-1")
+	assert.Equal(t, http.StatusOK, again.Code)
+	assert.Equal(t, first.Body.String(), again.Body.String())
+	assert.Equal(t, "true", again.Header().Get("Idempotent-Replayed"))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, wake("tv", "retry-1").Code)
+	assert.Equal(t, http.StatusBadRequest, wake("nas", strings.Repeat("k", 256)).Code)
+	assert.Equal(t, http.StatusOK, wake("nas", "retry-2").Code)
+	assert.Equal(t, http.StatusOK, wake("nas", "").Code)
+
+	// Only the requests which were not repeats woke the machine.
+	history, err := aliases.History(time.Time{}, "nas")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+}
@@ -0,0 +1,128 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// How long an enumeration of the interfaces is reused for. Daemons pick
+	// up added or removed interfaces (and addresses) after this long.
+	ifaceCacheTTL = 5 * time.Second
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ifaceSnapshot is the network interfaces and their addresses at one point
+// in time.
+type ifaceSnapshot struct {
+	ifaces []net.Interface
+	addrs  map[string][]net.Addr // by interface name
+}
+
+// loadInterfaces enumerates the network interfaces.
+func loadInterfaces() (*ifaceSnapshot, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &ifaceSnapshot{ifaces, map[string][]net.Addr{}}
+	for _, iface := range ifaces {
+		// Interfaces which vanish while enumerating simply have no addresses.
+		snap.addrs[iface.Name], _ = iface.Addrs()
+	}
+	return snap, nil
+}
+
+// byName returns the interface called `name`, if there is one.
+func (s *ifaceSnapshot) byName(name string) (*net.Interface, bool) {
+	for idx := range s.ifaces {
+		if s.ifaces[idx].Name == name {
+			return &s.ifaces[idx], true
+		}
+	}
+	return nil, false
+}
+
+// onLink returns the name of the interface with an address on the subnet of
+// `ip`, or "" if there is none.
+func (s *ifaceSnapshot) onLink(ip net.IP) string {
+	for _, iface := range s.ifaces {
+		for _, addr := range s.addrs[iface.Name] {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.Contains(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+// hasIP returns true if one of the interfaces has the address `ip`.
+func (s *ifaceSnapshot) hasIP(ip net.IP) bool {
+	for _, addrs := range s.addrs {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ifaceCache reuses an enumeration of the interfaces for `ttl`, so that
+// sending many packets (from a daemon, or to many hosts) does not enumerate
+// them for each packet.
+type ifaceCache struct {
+	mtx   sync.Mutex
+	ttl   time.Duration
+	load  func() (*ifaceSnapshot, error)
+	snap  *ifaceSnapshot
+	taken time.Time
+}
+
+// interfaces is the cache used for all lookups.
+var interfaces = &ifaceCache{ttl: ifaceCacheTTL, load: loadInterfaces}
+
+// get returns the cached snapshot, enumerating the interfaces again if it is
+// missing or older than the TTL.
+func (c *ifaceCache) get() (*ifaceSnapshot, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	if c.snap == nil || now.Sub(c.taken) >= c.ttl {
+		snap, err := c.load()
+		if err != nil {
+			return nil, err
+		}
+		c.snap, c.taken = snap, now
+	}
+	return c.snap, nil
+}
+
+// isLocalIP returns true if `ip` is an address of this host, e.g. the source
+// of a broadcast which looped back to it.
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	snap, err := interfaces.get()
+	return err == nil && snap.hasIP(ip)
+}
+
+// invalidate drops the cached snapshot, e.g. after a send failed because an
+// interface or address went away.
+func (c *ifaceCache) invalidate() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.snap = nil
+}
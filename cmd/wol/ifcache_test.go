@@ -0,0 +1,77 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestIfaceCache(t *testing.T) {
+	loads := 0
+	cache := &ifaceCache{ttl: time.Hour, load: func() (*ifaceSnapshot, error) {
+		loads++
+		return &ifaceSnapshot{[]net.Interface{{Name: "eth0"}}, nil}, nil
+	}}
+
+	for i := 0; i < 3; i++ {
+		snap, err := cache.get()
+		assert.Nil(t, err)
+		_, ok := snap.byName("eth0")
+		assert.True(t, ok)
+		_, ok = snap.byName("eth1")
+		assert.False(t, ok)
+	}
+	assert.Equal(t, 1, loads)
+
+	cache.invalidate()
+	cache.get()
+	assert.Equal(t, 2, loads)
+
+	cache.ttl = 0
+	cache.get()
+	assert.Equal(t, 3, loads)
+}
+
+// Compares looking up the interface a packet leaves from (as is done for
+// every packet sent) with and without the cache.
+func BenchmarkInterfaceByIP(b *testing.B) {
+	ip := net.IPv4(127, 0, 0, 1)
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			interfaces.invalidate()
+			interfaceByIP(ip)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			interfaceByIP(ip)
+		}
+	})
+}
+
+func TestIfaceSnapshotOnLink(t *testing.T) {
+	ipnet := func(cidr string) net.Addr {
+		ip, n, _ := net.ParseCIDR(cidr)
+		return &net.IPNet{IP: ip, Mask: n.Mask}
+	}
+	snap := &ifaceSnapshot{
+		ifaces: []net.Interface{{Name: "lo"}, {Name: "eth0"}, {Name: "eth1"}},
+		addrs: map[string][]net.Addr{
+			"lo":   {ipnet("::1/128"), ipnet("127.0.0.1/8")},
+			"eth0": {ipnet("192.168.1.2/24"), ipnet("2001:db8:1::2/64")},
+			"eth1": {ipnet("2001:db8:2::2/64")},
+		},
+	}
+
+	assert.Equal(t, "eth1", snap.onLink(net.ParseIP("2001:db8:2::99")))
+	assert.Equal(t, "eth0", snap.onLink(net.ParseIP("2001:db8:1::99")))
+	assert.Equal(t, "eth0", snap.onLink(net.ParseIP("192.168.1.99")))
+	assert.Equal(t, "", snap.onLink(net.ParseIP("2001:db8:3::1")))
+	assert.Equal(t, "", snap.onLink(net.ParseIP("::1")))
+}
@@ -0,0 +1,148 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// defaultRouteProbe is an address (TEST-NET-2) which is only reachable
+	// through the default route. Dialing it over UDP sends nothing.
+	defaultRouteProbe = "198.51.100.1:9"
+)
+
+// virtualIfacePrefixes are the name prefixes of common virtual interfaces:
+// container and VM bridges, veth pairs, tunnels and VPNs.
+var virtualIfacePrefixes = []string{
+	"lo", "docker", "br-", "veth", "virbr", "vnet", "vmnet", "vboxnet", "cni",
+	"flannel", "cali", "tun", "tap", "utun", "wg", "tailscale", "zt", "ppp",
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ifaceCandidate is an interface a limited broadcast could leave from, with
+// what speaks for it.
+type ifaceCandidate struct {
+	name         string
+	physical     bool
+	broadcast    bool
+	defaultRoute bool
+}
+
+// reasons describes why the candidate was picked.
+func (c ifaceCandidate) reasons() string {
+	reasons := []string{"has IPv4"}
+	if c.physical {
+		reasons = append([]string{"physical"}, reasons...)
+	}
+	if c.broadcast {
+		reasons = append(reasons, "has broadcast")
+	}
+	if c.defaultRoute {
+		reasons = append(reasons, "default route")
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// better returns true if `c` ranks above `o`: physical interfaces first, then
+// those which can broadcast, then the one of the default route.
+func (c ifaceCandidate) better(o ifaceCandidate) bool {
+	if c.physical != o.physical {
+		return c.physical
+	}
+	if c.broadcast != o.broadcast {
+		return c.broadcast
+	}
+	return c.defaultRoute && !o.defaultRoute
+}
+
+// rankInterfaces returns the interfaces of `snap` which are up and have an
+// IPv4 address, best first. `physical` tells physical interfaces apart and
+// `defaultRoute` names the interface of the default route, if known.
+func rankInterfaces(snap *ifaceSnapshot, physical func(net.Interface) bool, defaultRoute string) []ifaceCandidate {
+	var candidates []ifaceCandidate
+	for _, iface := range snap.ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		hasIPv4 := false
+		for _, addr := range snap.addrs[iface.Name] {
+			if ip, ok := addr.(*net.IPNet); ok && ip.IP.To4() != nil && !ip.IP.IsLinkLocalUnicast() {
+				hasIPv4 = true
+			}
+		}
+		if !hasIPv4 {
+			continue
+		}
+		candidates = append(candidates, ifaceCandidate{
+			name:         iface.Name,
+			physical:     physical(iface),
+			broadcast:    iface.Flags&net.FlagBroadcast != 0,
+			defaultRoute: iface.Name == defaultRoute,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].better(candidates[j])
+	})
+	return candidates
+}
+
+// isPhysicalInterface returns true if `iface` is backed by hardware. Linux
+// tells through sysfs; elsewhere interfaces with an Ethernet address and
+// without a well known virtual name are taken to be physical. VLAN
+// sub-interfaces are as physical as their trunk.
+func isPhysicalInterface(iface net.Interface) bool {
+	if vlan, ok := vlanOf(iface.Name); ok {
+		parent, err := net.InterfaceByName(vlan.parent)
+		return err == nil && isPhysicalInterface(*parent)
+	}
+	if _, err := os.Stat(sysClassNet); err == nil {
+		_, err := os.Stat(filepath.Join(sysClassNet, iface.Name, "device"))
+		return err == nil
+	}
+	return len(iface.HardwareAddr) == 6 && !isVirtualInterfaceName(iface.Name)
+}
+
+// isVirtualInterfaceName returns true if `name` is that of a common virtual
+// interface.
+func isVirtualInterfaceName(name string) bool {
+	for _, prefix := range virtualIfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultRouteInterface returns the name of the interface of the IPv4
+// default route, or "" if there is none.
+func defaultRouteInterface() string {
+	conn, err := net.Dial("udp4", defaultRouteProbe)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	return interfaceByIP(conn.LocalAddr().(*net.UDPAddr).IP)
+}
+
+// pickInterface returns the interface limited broadcasts are sent from when
+// none was given, rather than leaving it to the OS, which may pick a bridge
+// or a VPN.
+func pickInterface() (ifaceCandidate, bool) {
+	snap, err := interfaces.get()
+	if err != nil {
+		return ifaceCandidate{}, false
+	}
+	candidates := rankInterfaces(snap, isPhysicalInterface, defaultRouteInterface())
+	if len(candidates) == 0 {
+		return ifaceCandidate{}, false
+	}
+	return candidates[0], true
+}
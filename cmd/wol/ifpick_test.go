@@ -0,0 +1,67 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestRankInterfaces(t *testing.T) {
+	up, bcast := net.FlagUp|net.FlagMulticast, net.FlagUp|net.FlagBroadcast|net.FlagMulticast
+	ipv4 := func(cidr string) []net.Addr {
+		ip, ipnet, _ := net.ParseCIDR(cidr)
+		return []net.Addr{&net.IPNet{IP: ip, Mask: ipnet.Mask}}
+	}
+	snap := &ifaceSnapshot{
+		ifaces: []net.Interface{
+			{Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+			{Name: "docker0", Flags: bcast},
+			{Name: "wg0", Flags: up},
+			{Name: "wlan0", Flags: bcast},
+			{Name: "eth0", Flags: bcast},
+			{Name: "eth1", Flags: net.FlagBroadcast},
+			{Name: "eth2", Flags: bcast},
+		},
+		addrs: map[string][]net.Addr{
+			"lo":      ipv4("127.0.0.1/8"),
+			"docker0": ipv4("172.17.0.1/16"),
+			"wg0":     ipv4("10.8.0.2/24"),
+			"wlan0":   ipv4("192.168.1.20/24"),
+			"eth0":    ipv4("192.168.2.20/24"),
+			"eth1":    ipv4("192.168.3.20/24"),
+			"eth2":    ipv4("fd00::2/64"),
+		},
+	}
+	physical := func(iface net.Interface) bool {
+		return !isVirtualInterfaceName(iface.Name)
+	}
+
+	// The VPN has the default route, but physical interfaces come first.
+	candidates := rankInterfaces(snap, physical, "wg0")
+	names := []string{}
+	for _, c := range candidates {
+		names = append(names, c.name)
+	}
+	assert.Equal(t, []string{"wlan0", "eth0", "docker0", "wg0"}, names)
+	assert.Equal(t, "physical, has IPv4, has broadcast", candidates[0].reasons())
+	assert.Equal(t, "has IPv4, default route", candidates[3].reasons())
+
+	// Among physical interfaces, the one of the default route wins.
+	candidates = rankInterfaces(snap, physical, "eth0")
+	assert.Equal(t, "eth0", candidates[0].name)
+	assert.Equal(t, "physical, has IPv4, has broadcast, default route", candidates[0].reasons())
+}
+
+func TestIsVirtualInterfaceName(t *testing.T) {
+	for _, name := range []string{"docker0", "veth1a2b3c", "br-0123456789ab", "tun0", "tap1", "utun3", "wg0", "lo"} {
+		assert.True(t, isVirtualInterfaceName(name), name)
+	}
+	for _, name := range []string{"eth0", "enp3s0", "wlan0", "en0", "Ethernet"} {
+		assert.False(t, isVirtualInterfaceName(name), name)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// ifaceSettleDelay is how long to let a burst of interface events (a
+	// link coming up, then getting its addresses) settle before acting.
+	ifaceSettleDelay = 250 * time.Millisecond
+
+	// ifacePollInterval is how often interfaces are compared where changes
+	// are not announced.
+	ifacePollInterval = ifaceCacheTTL
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// watchInterfaces invalidates the interface cache, and calls `changed` if it
+// is not nil, whenever an interface goes up or down or its addresses change,
+// until `stop` is closed. Daemons thus pick up renegotiated links right away.
+// Changes are announced over netlink on Linux, and polled for elsewhere.
+func watchInterfaces(stop <-chan struct{}, changed func()) {
+	events, err := ifaceEvents(stop)
+	if err != nil {
+		events = pollInterfaces(stop, loadInterfaces, ifacePollInterval)
+	}
+
+	go func() {
+		for range events {
+			time.Sleep(ifaceSettleDelay)
+			select {
+			case <-events:
+			default:
+			}
+
+			interfaces.invalidate()
+			log.Printf("Network interfaces changed\n")
+			if changed != nil {
+				changed()
+			}
+		}
+	}()
+}
+
+// pollInterfaces returns a channel which receives a value whenever the
+// interfaces `load` returns change, checked every `interval`, and is closed
+// once `stop` is.
+func pollInterfaces(stop <-chan struct{}, load func() (*ifaceSnapshot, error), interval time.Duration) <-chan struct{} {
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := ""
+		if snap, err := load(); err == nil {
+			last = snap.signature()
+		}
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			snap, err := load()
+			if err != nil {
+				continue
+			}
+			if sig := snap.signature(); sig != last {
+				last = sig
+				notify(events)
+			}
+		}
+	}()
+	return events
+}
+
+// notify sends to `events` unless a notification is pending already.
+func notify(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
+
+// signature sums up the interfaces of the snapshot, their state and their
+// addresses, so that snapshots can be compared.
+func (s *ifaceSnapshot) signature() string {
+	var sb strings.Builder
+	for _, iface := range s.ifaces {
+		fmt.Fprintf(&sb, "%s %d %v", iface.Name, iface.Index, iface.Flags&net.FlagUp != 0)
+		for _, addr := range s.addrs[iface.Name] {
+			sb.WriteString(" " + addr.String())
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,77 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"syscall"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// rtnetlink multicast groups of link and address changes, which package
+// syscall does not define.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ifaceEvents returns a channel which receives a value whenever the kernel
+// announces that a link or an address was added, removed or changed, and is
+// closed once `stop` is.
+func ifaceEvents(stop <-chan struct{}) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// Reads time out now and then so that `stop` is noticed.
+	tv := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+		buf := make([]byte, 1<<16)
+		for !stopping(stop) {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			switch err {
+			case nil:
+			case syscall.EAGAIN, syscall.EINTR:
+				continue
+			case syscall.ENOBUFS:
+				// Events were dropped, so whatever they were, look again.
+				notify(events)
+				continue
+			default:
+				return
+			}
+
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				switch m.Header.Type {
+				case syscall.RTM_NEWLINK, syscall.RTM_DELLINK, syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+					notify(events)
+				}
+			}
+		}
+	}()
+	return events, nil
+}
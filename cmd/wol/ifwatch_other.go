@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ifaceEvents is only implemented on linux, elsewhere interfaces are polled.
+func ifaceEvents(stop <-chan struct{}) (<-chan struct{}, error) {
+	return nil, errors.New("interface events are only supported on linux")
+}
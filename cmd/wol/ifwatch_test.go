@@ -0,0 +1,64 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestPollInterfaces(t *testing.T) {
+	var mtx sync.Mutex
+	snap := &ifaceSnapshot{
+		ifaces: []net.Interface{{Name: "eth0", Index: 2, Flags: net.FlagUp}},
+		addrs:  map[string][]net.Addr{"eth0": {&net.IPNet{IP: net.IPv4(192, 168, 1, 5), Mask: net.CIDRMask(24, 32)}}},
+	}
+	load := func() (*ifaceSnapshot, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return snap, nil
+	}
+
+	stop := make(chan struct{})
+	events := pollInterfaces(stop, load, 10*time.Millisecond)
+	select {
+	case <-events:
+		t.Fatal("unchanged interfaces reported a change")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The link renegotiated and got another address.
+	mtx.Lock()
+	snap = &ifaceSnapshot{
+		ifaces: snap.ifaces,
+		addrs:  map[string][]net.Addr{"eth0": {&net.IPNet{IP: net.IPv4(192, 168, 1, 6), Mask: net.CIDRMask(24, 32)}}},
+	}
+	mtx.Unlock()
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("changed interfaces were not reported")
+	}
+
+	close(stop)
+	for range events {
+	}
+}
+
+func TestIfaceEvents(t *testing.T) {
+	stop := make(chan struct{})
+	events, err := ifaceEvents(stop)
+	if err != nil {
+		t.Skipf("no interface events here: %v", err)
+	}
+	close(stop)
+	for range events {
+	}
+	assert.True(t, stopping(stop))
+}
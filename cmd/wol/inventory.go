@@ -0,0 +1,107 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// inventoryHost is a host (with its MAC address) found by another tool, such
+// as nmap or a router, which can be imported as an alias.
+type inventoryHost struct {
+	Name   string // host name, "" if unknown
+	IP     string
+	MAC    string // canonical
+	Vendor string
+}
+
+// alias returns the alias name of the host: the first label of its host
+// name, with anything but letters, digits, '-' and '_' replaced, as aliases
+// are typed by hand. Hosts without a name are named after their IP address.
+func (h inventoryHost) alias() string {
+	name := strings.TrimSpace(strings.SplitN(h.Name, ".", 2)[0])
+	if name == "" {
+		return h.IP
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// vendorTag turns a MAC vendor (`Super Micro Computer`) into a tag
+// (`vendor:super-micro-computer`).
+func vendorTag(vendor string) string {
+	return "vendor:" + strings.Join(strings.Fields(strings.ToLower(vendor)), "-")
+}
+
+// importHosts adds an alias for each host whose MAC address has none yet,
+// tagged with its vendor and `--tag`s, with its address (and the prefix of
+// the local subnet it is in) so it can be woken with a directed broadcast. Names which are already taken are
+// skipped. The aliases are added in one batch, so a failed import adds none.
+func importHosts(hosts []inventoryHost, aliases *Aliases) error {
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].alias() < hosts[j].alias() })
+
+	// Addresses are stored with the prefix of their local subnet.
+	snap, _ := interfaces.get()
+
+	var report []string
+	imported := 0
+	err := aliases.Batch(func(b *Batch) error {
+		known, taken := map[string]bool{}, map[string]bool{}
+		if err := b.Each(func(alias Alias) error {
+			if mac, ok := canonicalMAC(alias.Mac); ok {
+				known[mac] = true
+			}
+			taken[alias.Name] = true
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, host := range hosts {
+			name := host.alias()
+			if known[host.MAC] || name == "" {
+				continue
+			}
+			if taken[name] {
+				report = append(report, fmt.Sprintf("Skipped %s (%s), the alias %s is taken", host.IP, host.MAC, name))
+				continue
+			}
+
+			tags := append([]string{}, cliFlags.Tags...)
+			if host.Vendor != "" {
+				tags = append(tags, vendorTag(host.Vendor))
+			}
+			mi := MacIface{Mac: host.MAC, Tags: tags}
+			if ip := net.ParseIP(host.IP); ip != nil {
+				mi.IP = hostCIDR(snap, ip)
+			}
+			if err := b.Put(name, mi); err != nil {
+				return err
+			}
+			taken[name] = true
+			known[host.MAC] = true
+			imported++
+			report = append(report, fmt.Sprintf("Imported %s (%s, %s)", name, host.IP, host.MAC))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, line := range report {
+		statusf("%s\n", line)
+	}
+	statusf("Imported %d alias(es) from %d host(s)\n", imported, len(hosts))
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestInventoryHostAlias(t *testing.T) {
+	for _, tc := range []struct {
+		name, ip, expected string
+	}{
+		{"nas.lan", "192.168.1.2", "nas"},
+		{"Galaxy S21", "192.168.1.3", "Galaxy-S21"},
+		{"", "192.168.1.4", "192.168.1.4"},
+		{" ", "192.168.1.5", "192.168.1.5"},
+	} {
+		assert.Equal(t, tc.expected, inventoryHost{Name: tc.name, IP: tc.ip}.alias())
+	}
+}
+
+func TestVendorTag(t *testing.T) {
+	assert.Equal(t, "vendor:super-micro-computer", vendorTag("Super Micro  Computer"))
+}
@@ -0,0 +1,391 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ipamBucketName is the bucket hosts looked up in the IPAM are cached in.
+const ipamBucketName = "IPAM"
+
+// With `--ipam`, targets which are neither aliases nor MAC addresses are
+// looked up by host name in an IPAM (or directory), which stays the source
+// of truth for them: its answers are cached in the alias db for `--ipam-cache` seconds,
+// and only used beyond that while the IPAM cannot be reached.
+
+// ipamBackends look up the host `name` in the IPAM at `u`, and return false
+// if it does not know it.
+var ipamBackends = map[string]func(ctx context.Context, client *http.Client, u *url.URL, name string) (ipamHost, bool, error){
+	"ldap":    ldapLookup,
+	"ldaps":   ldapLookup,
+	"netbox":  netboxLookup,
+	"phpipam": phpipamLookup,
+}
+
+// reIPAMName matches the host names which are looked up in the IPAM.
+var reIPAMName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// errIPAMNotFound is returned by ipamGet for objects the IPAM does not have.
+var errIPAMNotFound = errors.New("not found")
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ipamHost is what an IPAM knows about a host.
+type ipamHost struct {
+	MAC  string // canonical
+	IP   string // with the prefix length of its subnet, "" if unknown
+	VLAN int    // 0 if unknown
+	Host string // DNS name to find its current address by, "" if unknown
+}
+
+// ipamEntry is a cached IPAM answer.
+type ipamEntry struct {
+	Host    ipamHost
+	Fetched time.Time
+}
+
+// ipamSource is the IPAM `--ipam` names.
+type ipamSource struct {
+	url    *url.URL
+	client *http.Client
+	ttl    time.Duration
+}
+
+// parseIPAM parses an `--ipam` URL, which may also be given as a secret
+// reference (env:<NAME> or keyring:<name>).
+func parseIPAM(spec string) (*url.URL, error) {
+	if isSecretRef(spec) {
+		secret, err := resolveSecret(spec, "IPAM URL: ")
+		if err != nil {
+			return nil, err
+		}
+		spec = secret
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid --ipam URL %q", redactURL(spec))
+	}
+	if _, ok := ipamBackends[u.Scheme]; !ok {
+		return nil, fmt.Errorf("unknown --ipam %q (expected %s)", u.Scheme, ipamBackendNames())
+	}
+	return u, nil
+}
+
+// ipamBackendNames returns the names of the IPAM backends, sorted.
+func ipamBackendNames() string {
+	var names []string
+	for name := range ipamBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// UseIPAM looks up unknown targets in the IPAM at `spec`.
+func (a *Aliases) UseIPAM(spec string) error {
+	u, err := parseIPAM(spec)
+	if err != nil {
+		return err
+	}
+	if cliFlags.IPAMCache < 0 {
+		return fmt.Errorf("invalid --ipam-cache %d", cliFlags.IPAMCache)
+	}
+	a.ipam = &ipamSource{
+		url:    u,
+		client: &http.Client{},
+		ttl:    time.Duration(cliFlags.IPAMCache) * time.Second,
+	}
+	return nil
+}
+
+// lookupIPAM returns the alias the IPAM has for the host `name`, if any.
+func (a *Aliases) lookupIPAM(ctx context.Context, name string) (MacIface, bool) {
+	if a.ipam == nil || !reIPAMName.MatchString(name) {
+		return MacIface{}, false
+	}
+	if _, ok := canonicalMAC(name); ok {
+		return MacIface{}, false
+	}
+
+	cached, haveCached := a.cachedIPAM(name)
+	if haveCached && time.Since(cached.Fetched) < a.ipam.ttl {
+		return ipamAlias(cached.Host), true
+	}
+
+	ctx, cancel := withTimeout(ctx, opAPI)
+	defer cancel()
+	scheme := a.ipam.url.Scheme
+	host, ok, err := ipamBackends[scheme](ctx, a.ipam.client, a.ipam.url, name)
+	switch {
+	case err != nil && haveCached:
+		fmt.Fprintf(os.Stderr, "WARNING: looking up %s in %s failed (%v), using its answer from %s ago\n",
+			name, scheme, err, time.Since(cached.Fetched).Round(time.Second))
+		return ipamAlias(cached.Host), true
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "WARNING: looking up %s in %s failed: %v\n", name, scheme, err)
+		return MacIface{}, false
+	case !ok:
+		if haveCached {
+			a.uncacheIPAM(name)
+		}
+		return MacIface{}, false
+	}
+
+	progressf("Found %s in %s: %s\n", name, scheme, host.MAC)
+	a.cacheIPAM(name, ipamEntry{Host: host, Fetched: time.Now()})
+	return ipamAlias(host), true
+}
+
+// ipamAlias returns the alias of the IPAM host `h`, sent from the interface
+// of this machine on its VLAN, if there is one.
+func ipamAlias(h ipamHost) MacIface {
+	mi := MacIface{Mac: h.MAC, IP: h.IP, Host: h.Host}
+	if h.VLAN != 0 {
+		mi.Iface, _ = vlanInterface(h.VLAN)
+	}
+	return mi
+}
+
+// cachedIPAM returns the cached IPAM answer for the host `name`.
+func (a *Aliases) cachedIPAM(name string) (ipamEntry, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var e ipamEntry
+	found := false
+	a.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ipamBucketName))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(name)); v != nil {
+			found = gob.NewDecoder(bytes.NewBuffer(v)).Decode(&e) == nil
+		}
+		return nil
+	})
+	return e, found
+}
+
+// cacheIPAM caches the IPAM answer `e` for the host `name`. Failures only
+// cost a lookup next time, so they are ignored.
+func (a *Aliases) cacheIPAM(name string, e ipamEntry) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(e); err != nil {
+		return
+	}
+	a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(ipamBucketName)).Put([]byte(name), buf.Bytes())
+	})
+}
+
+// uncacheIPAM forgets the host `name`, which the IPAM no longer knows.
+func (a *Aliases) uncacheIPAM(name string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(ipamBucketName)).Delete([]byte(name))
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ipamGet fetches `path` of the IPAM at `u` with the `header` which
+// authenticates, and decodes its JSON into `v`.
+func ipamGet(ctx context.Context, client *http.Client, u *url.URL, path string, header http.Header, v interface{}) error {
+	req, err := http.NewRequest("GET", serviceURL(u, path), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errIPAMNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(v)
+}
+
+// netboxLookup looks the device, or failing that the virtual machine, `name`
+// up in the NetBox at `netbox://<API token>@host[/path]`. Its MAC address is
+// that of its first enabled interface which has one, other than management
+// interfaces, and its VLAN the untagged one of that interface.
+func netboxLookup(ctx context.Context, client *http.Client, u *url.URL, name string) (ipamHost, bool, error) {
+	if u.User == nil {
+		return ipamHost{}, false, errors.New("netbox needs netbox://<API token>@<host>")
+	}
+	header := http.Header{"Authorization": {"Token " + u.User.Username()}}
+	base := strings.TrimRight(u.Path, "/")
+
+	for _, kind := range []struct{ objects, interfaces, filter string }{
+		{"/api/dcim/devices/", "/api/dcim/interfaces/", "device_id"},
+		{"/api/virtualization/virtual-machines/", "/api/virtualization/interfaces/", "virtual_machine_id"},
+	} {
+		var objects struct {
+			Results []struct {
+				ID        int `json:"id"`
+				PrimaryIP *struct {
+					Address string `json:"address"`
+				} `json:"primary_ip4"`
+			} `json:"results"`
+		}
+		if err := ipamGet(ctx, client, u, base+kind.objects+"?name__ie="+url.QueryEscape(name), header, &objects); err != nil {
+			return ipamHost{}, false, err
+		}
+		if len(objects.Results) == 0 {
+			continue
+		}
+		obj := objects.Results[0]
+
+		var ifaces struct {
+			Results []struct {
+				Enabled    bool   `json:"enabled"`
+				MgmtOnly   bool   `json:"mgmt_only"`
+				MacAddress string `json:"mac_address"`
+				PrimaryMac *struct {
+					MacAddress string `json:"mac_address"`
+				} `json:"primary_mac_address"` // NetBox 4.2 and later
+				UntaggedVLAN *struct {
+					VID int `json:"vid"`
+				} `json:"untagged_vlan"`
+			} `json:"results"`
+		}
+		path := fmt.Sprintf("%s%s?%s=%d&limit=1000", base, kind.interfaces, kind.filter, obj.ID)
+		if err := ipamGet(ctx, client, u, path, header, &ifaces); err != nil {
+			return ipamHost{}, false, err
+		}
+		for _, iface := range ifaces.Results {
+			mac := iface.MacAddress
+			if iface.PrimaryMac != nil {
+				mac = iface.PrimaryMac.MacAddress
+			}
+			canonical, ok := canonicalMAC(mac)
+			if !ok || !iface.Enabled || iface.MgmtOnly {
+				continue
+			}
+			h := ipamHost{MAC: canonical}
+			if obj.PrimaryIP != nil {
+				h.IP = obj.PrimaryIP.Address
+			}
+			if iface.UntaggedVLAN != nil {
+				h.VLAN = iface.UntaggedVLAN.VID
+			}
+			return h, true, nil
+		}
+		return ipamHost{}, false, fmt.Errorf("%s has no interface with a MAC address in netbox", name)
+	}
+	return ipamHost{}, false, nil
+}
+
+// phpipamResponse is the envelope of phpIPAM API responses, which give most
+// numbers as strings.
+type phpipamResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// phpipamGet fetches `path` of the phpIPAM API and decodes its data into `v`.
+func phpipamGet(ctx context.Context, client *http.Client, u *url.URL, path string, v interface{}) error {
+	password, _ := u.User.Password()
+	var resp phpipamResponse
+	err := ipamGet(ctx, client, u, "/api/"+u.User.Username()+path, http.Header{"Token": {password}}, &resp)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return errIPAMNotFound
+	}
+	return json.Unmarshal(resp.Data, v)
+}
+
+// phpipamLookup looks the address with the host name `name` up in the
+// phpIPAM at `phpipam://<app id>:<app code>@host`, whose API app uses the
+// app code token security. Its prefix length and VLAN are those of its
+// subnet.
+func phpipamLookup(ctx context.Context, client *http.Client, u *url.URL, name string) (ipamHost, bool, error) {
+	hasCode := false
+	if u.User != nil {
+		_, hasCode = u.User.Password()
+	}
+	if !hasCode {
+		return ipamHost{}, false, errors.New("phpipam needs phpipam://<app id>:<app code>@<host>")
+	}
+
+	var addrs []struct {
+		IP       string `json:"ip"`
+		MAC      string `json:"mac"`
+		SubnetID string `json:"subnetId"`
+	}
+	err := phpipamGet(ctx, client, u, "/addresses/search_hostname/"+url.PathEscape(name)+"/", &addrs)
+	if err == errIPAMNotFound {
+		return ipamHost{}, false, nil
+	}
+	if err != nil {
+		return ipamHost{}, false, err
+	}
+	for _, addr := range addrs {
+		mac, ok := canonicalMAC(addr.MAC)
+		if !ok {
+			continue
+		}
+		h := ipamHost{MAC: mac}
+		if addr.SubnetID == "" || addr.SubnetID == "0" {
+			return h, true, nil
+		}
+
+		var subnet struct {
+			Mask   string `json:"mask"`
+			VlanID string `json:"vlanId"`
+		}
+		if err := phpipamGet(ctx, client, u, "/subnets/"+addr.SubnetID+"/", &subnet); err != nil {
+			return ipamHost{}, false, err
+		}
+		if net.ParseIP(addr.IP) != nil && subnet.Mask != "" {
+			h.IP = addr.IP + "/" + subnet.Mask
+		}
+		if subnet.VlanID != "" && subnet.VlanID != "0" {
+			var vlan struct {
+				Number string `json:"number"`
+			}
+			if err := phpipamGet(ctx, client, u, "/vlan/"+subnet.VlanID+"/", &vlan); err != nil {
+				return ipamHost{}, false, err
+			}
+			h.VLAN, _ = strconv.Atoi(vlan.Number)
+		}
+		return h, true, nil
+	}
+	return ipamHost{}, false, fmt.Errorf("%s has no MAC address in phpipam", name)
+}
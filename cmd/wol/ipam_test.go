@@ -0,0 +1,161 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseIPAM(t *testing.T) {
+	u, err := parseIPAM("netbox://0123abcd@netbox.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "netbox", u.Scheme)
+
+	for _, spec := range []string{"", "netbox", "infoblox://wapi@ib.example.com", "phpipam:///api"} {
+		_, err := parseIPAM(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestNetboxLookup(t *testing.T) {
+	var gotAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		switch r.URL.Path + "?" + r.URL.RawQuery {
+		case "/api/dcim/devices/?name__ie=nas":
+			w.Write([]byte(`{"results": [{"id": 7, "primary_ip4": {"address": "10.0.20.5/24"}}]}`))
+		case "/api/dcim/interfaces/?device_id=7&limit=1000":
+			w.Write([]byte(`{"results": [
+				{"enabled": true, "mgmt_only": true, "mac_address": "00:11:22:33:44:00"},
+				{"enabled": true, "mac_address": null},
+				{"enabled": true, "primary_mac_address": {"mac_address": "00:11:22:33:44:55"}, "untagged_vlan": {"vid": 20}}
+			]}`))
+		case "/api/virtualization/virtual-machines/?name__ie=build":
+			w.Write([]byte(`{"results": [{"id": 3, "primary_ip4": null}]}`))
+		case "/api/virtualization/interfaces/?virtual_machine_id=3&limit=1000":
+			w.Write([]byte(`{"results": [{"enabled": true, "mac_address": "52:54:00:aa:bb:cc"}]}`))
+		default:
+			w.Write([]byte(`{"results": []}`))
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse("netbox://0123abcd@" + ts.Listener.Addr().String() + "?scheme=http")
+
+	h, ok, err := netboxLookup(context.Background(), ts.Client(), u, "nas")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ipamHost{MAC: "00:11:22:33:44:55", IP: "10.0.20.5/24", VLAN: 20}, h)
+	assert.Equal(t, "Token 0123abcd", gotAuth)
+
+	h, ok, err = netboxLookup(context.Background(), ts.Client(), u, "build")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ipamHost{MAC: "52:54:00:aa:bb:cc"}, h)
+
+	_, ok, err = netboxLookup(context.Background(), ts.Client(), u, "printer")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestPhpipamLookup(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Token") != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/wol/addresses/search_hostname/nas/":
+			w.Write([]byte(`{"code": 200, "success": true, "data": [{"ip": "10.0.20.5", "mac": "00:11:22:33:44:55", "subnetId": "4"}]}`))
+		case "/api/wol/subnets/4/":
+			w.Write([]byte(`{"code": 200, "success": true, "data": {"subnet": "10.0.20.0", "mask": "24", "vlanId": "2"}}`))
+		case "/api/wol/vlan/2/":
+			w.Write([]byte(`{"code": 200, "success": true, "data": {"number": "20"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code": 404, "success": false, "message": "Address not found"}`))
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse("phpipam://wol:s3cret@" + ts.Listener.Addr().String() + "?scheme=http")
+
+	h, ok, err := phpipamLookup(context.Background(), ts.Client(), u, "nas")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ipamHost{MAC: "00:11:22:33:44:55", IP: "10.0.20.5/24", VLAN: 20}, h)
+
+	_, ok, err = phpipamLookup(context.Background(), ts.Client(), u, "printer")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	u.User = url.UserPassword("wol", "wrong")
+	_, _, err = phpipamLookup(context.Background(), ts.Client(), u, "nas")
+	assert.NotNil(t, err)
+}
+
+func TestLookupIPAM(t *testing.T) {
+	defer func(cache int) { cliFlags.IPAMCache = cache }(cliFlags.IPAMCache)
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path + "?" + r.URL.RawQuery {
+		case "/api/dcim/devices/?name__ie=nas":
+			w.Write([]byte(`{"results": [{"id": 7}]}`))
+		case "/api/dcim/interfaces/?device_id=7&limit=1000":
+			w.Write([]byte(`{"results": [{"enabled": true, "mac_address": "00:11:22:33:44:55"}]}`))
+		default:
+			w.Write([]byte(`{"results": []}`))
+		}
+	}))
+
+	aliases, cleanup := openTestAliases(t, "./TestLookupIPAM.db")
+	defer cleanup()
+	assert.Nil(t, aliases.Add("tv", "00:11:22:33:44:66", ""))
+	cliFlags.IPAMCache = 60
+	assert.Nil(t, aliases.UseIPAM("netbox://0123abcd@"+ts.Listener.Addr().String()+"?scheme=http"))
+
+	// Aliases, MAC addresses and target specs are not looked up.
+	for _, target := range []string{"00:11:22:33:44:77", "00:11:22:33:44:77@10.0.0.255"} {
+		_, ok := aliases.lookupIPAM(context.Background(), target)
+		assert.False(t, ok, target)
+	}
+	wt, err := resolveWakeTarget(context.Background(), "tv", fromLocal, aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, "00:11:22:33:44:66", wt.mac)
+	assert.Equal(t, 0, requests)
+
+	wt, err = resolveWakeTarget(context.Background(), "nas", fromLocal, aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, "00:11:22:33:44:55", wt.mac)
+	assert.Equal(t, 2, requests)
+
+	// Answers are cached...
+	_, ok := aliases.lookupIPAM(context.Background(), "nas")
+	assert.True(t, ok)
+	assert.Equal(t, 2, requests)
+	_, ok = aliases.lookupIPAM(context.Background(), "printer")
+	assert.False(t, ok)
+	assert.Equal(t, 4, requests)
+
+	// ... and used beyond their time while the IPAM is down.
+	ts.Close()
+	aliases.ipam.ttl = 0
+	mi, ok := aliases.lookupIPAM(context.Background(), "nas")
+	assert.True(t, ok)
+	assert.Equal(t, "00:11:22:33:44:55", mi.Mac)
+	_, ok = aliases.lookupIPAM(context.Background(), "printer")
+	assert.False(t, ok)
+
+	e, ok := aliases.cachedIPAM("nas")
+	assert.True(t, ok)
+	assert.True(t, time.Since(e.Fetched) < time.Minute)
+}
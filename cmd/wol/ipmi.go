@@ -0,0 +1,430 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	ipmiPort = "623"
+
+	// How often, and how far apart, requests are sent before the BMC is
+	// given up on. IPMI runs over UDP, so requests may get lost.
+	ipmiAttempts      = 3
+	ipmiRetryInterval = 2 * time.Second
+
+	// RMCP+ payload types, and the flags of encrypted and authenticated
+	// payloads.
+	ipmiPayloadIPMI        = 0x00
+	ipmiPayloadOpenSession = 0x10
+	ipmiPayloadOpenReply   = 0x11
+	ipmiPayloadRAKP1       = 0x12
+	ipmiPayloadRAKP2       = 0x13
+	ipmiPayloadRAKP3       = 0x14
+	ipmiPayloadRAKP4       = 0x15
+	ipmiEncrypted          = 0x80
+	ipmiAuthenticated      = 0x40
+
+	// Sessions request the administrator privilege, looking the user up by
+	// name only.
+	ipmiPrivAdmin = 0x04
+	ipmiRole      = 0x10 | ipmiPrivAdmin
+
+	// Addresses of the BMC and of remote console software.
+	ipmiBMCAddr  = 0x20
+	ipmiSWIDAddr = 0x81
+
+	ipmiNetFnChassis        = 0x00
+	ipmiNetFnApp            = 0x06
+	ipmiCmdChassisStatus    = 0x01
+	ipmiCmdChassisControl   = 0x02
+	ipmiCmdSetSessionPriv   = 0x3b
+	ipmiCmdCloseSession     = 0x3c
+	ipmiChassisPowerDown    = 0x00
+	ipmiChassisPowerUp      = 0x01
+	ipmiChassisSoftShutdown = 0x05
+)
+
+var (
+	// The RMCP header of IPMI messages: version 6, no acknowledgement,
+	// class IPMI.
+	rmcpHeader = []byte{0x06, 0x00, 0xff, 0x07}
+
+	// ipmiAlgorithms proposes cipher suite 3: RAKP-HMAC-SHA1 authentication,
+	// HMAC-SHA1-96 integrity and AES-CBC-128 confidentiality.
+	ipmiAlgorithms = []byte{
+		0x00, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00,
+		0x02, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00,
+	}
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// le32 returns `v` in little endian byte order, which IPMI uses.
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+// hmacSHA1 returns the HMAC-SHA1 of the concatenated `parts` under `key`.
+func hmacSHA1(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
+
+// ipmiChecksum returns the two's complement checksum of `data`.
+func ipmiChecksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+// ipmiMessage builds the IPMI request `cmd` of `netFn` from the remote
+// console to the BMC.
+func ipmiMessage(netFn, cmd, rqSeq byte, data []byte) []byte {
+	msg := []byte{ipmiBMCAddr, netFn << 2}
+	msg = append(msg, ipmiChecksum(msg))
+	body := append([]byte{ipmiSWIDAddr, rqSeq << 2, cmd}, data...)
+	msg = append(msg, body...)
+	return append(msg, ipmiChecksum(body))
+}
+
+// ipmiEncrypt encrypts `data` with AES-CBC-128 under `key`, prefixed with
+// its random IV and padded as IPMI requires.
+func ipmiEncrypt(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padLen := (aes.BlockSize - (len(data)+1)%aes.BlockSize) % aes.BlockSize
+	plain := append([]byte{}, data...)
+	for idx := 1; idx <= padLen; idx++ {
+		plain = append(plain, byte(idx))
+	}
+	plain = append(plain, byte(padLen))
+
+	out := make([]byte, aes.BlockSize+len(plain))
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], plain)
+	return out, nil
+}
+
+// ipmiDecrypt reverses ipmiEncrypt.
+func ipmiDecrypt(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted IPMI payload")
+	}
+	plain := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, data[aes.BlockSize:])
+	padLen := int(plain[len(plain)-1])
+	if padLen >= len(plain) {
+		return nil, errors.New("invalid encrypted IPMI payload")
+	}
+	return plain[:len(plain)-1-padLen], nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ipmiSession is an RMCP+ (IPMI v2.0 over LAN) session with a BMC.
+type ipmiSession struct {
+	conn      net.Conn
+	consoleID uint32 // our session ID
+	bmcID     uint32 // the BMC's session ID
+	seq       uint32
+	rqSeq     byte
+	k1, k2    []byte // integrity and confidentiality keys, once open
+}
+
+// packet frames `payload` of `payloadType`, encrypted and authenticated once
+// the session is open.
+func (s *ipmiSession) packet(payloadType byte, payload []byte) ([]byte, error) {
+	sessionID, seq := uint32(0), uint32(0)
+	if s.k1 != nil {
+		var err error
+		if payload, err = ipmiEncrypt(s.k2[:16], payload); err != nil {
+			return nil, err
+		}
+		payloadType |= ipmiEncrypted | ipmiAuthenticated
+		sessionID, seq = s.bmcID, s.seq
+	}
+
+	pkt := []byte{0x06, payloadType}
+	pkt = append(pkt, le32(sessionID)...)
+	pkt = append(pkt, le32(seq)...)
+	pkt = append(pkt, byte(len(payload)), byte(len(payload)>>8))
+	pkt = append(pkt, payload...)
+	if s.k1 != nil {
+		pad := (4 - (len(pkt)+2)%4) % 4
+		pkt = append(pkt, bytes.Repeat([]byte{0xff}, pad)...)
+		pkt = append(pkt, byte(pad), 0x07)
+		pkt = append(pkt, hmacSHA1(s.k1, pkt)[:12]...)
+	}
+	return append(append([]byte{}, rmcpHeader...), pkt...), nil
+}
+
+// parse returns the payload type and (decrypted) payload of `pkt`.
+func (s *ipmiSession) parse(pkt []byte) (byte, []byte, error) {
+	if len(pkt) < 16 || !bytes.Equal(pkt[:4], rmcpHeader) || pkt[4] != 0x06 {
+		return 0, nil, errors.New("not an RMCP+ packet")
+	}
+	payloadType := pkt[5]
+	end := 16 + int(binary.LittleEndian.Uint16(pkt[14:16]))
+	if end > len(pkt) {
+		return 0, nil, errors.New("truncated RMCP+ packet")
+	}
+	payload := pkt[16:end]
+
+	if payloadType&ipmiAuthenticated != 0 {
+		if s.k1 == nil || len(pkt) < end+14 {
+			return 0, nil, errors.New("unexpected authenticated packet")
+		}
+		code := pkt[len(pkt)-12:]
+		if !hmac.Equal(hmacSHA1(s.k1, pkt[4:len(pkt)-12])[:12], code) {
+			return 0, nil, errors.New("invalid RMCP+ auth code")
+		}
+	}
+	if payloadType&ipmiEncrypted != 0 {
+		if s.k2 == nil {
+			return 0, nil, errors.New("unexpected encrypted packet")
+		}
+		var err error
+		if payload, err = ipmiDecrypt(s.k2[:16], payload); err != nil {
+			return 0, nil, err
+		}
+	}
+	return payloadType &^ (ipmiEncrypted | ipmiAuthenticated), payload, nil
+}
+
+// exchange sends `payload` and returns the payload of the first reply of
+// type `want` which `match` accepts, resending the request if none arrives.
+func (s *ipmiSession) exchange(ctx context.Context, payloadType byte, payload []byte, want byte, match func([]byte) bool) ([]byte, error) {
+	pkt, err := s.packet(payloadType, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1024)
+	for attempt := 0; attempt < ipmiAttempts; attempt++ {
+		if _, err := s.conn.Write(pkt); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(ipmiRetryInterval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := s.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := s.conn.Read(buf)
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			// Anything else, such as late replies to earlier attempts, is
+			// skipped.
+			typ, reply, err := s.parse(buf[:n])
+			if err == nil && typ == want && match(reply) {
+				return reply, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errors.New("the BMC did not answer")
+}
+
+// open opens the session, logging in as `user`.
+func (s *ipmiSession) open(ctx context.Context, user, password string) error {
+	if len(user) > 16 {
+		return errors.New("IPMI user names are at most 16 characters")
+	}
+	if len(password) > 20 {
+		return errors.New("IPMI passwords are at most 20 characters")
+	}
+	ourID := func(reply []byte) bool {
+		return len(reply) >= 8 && binary.LittleEndian.Uint32(reply[4:8]) == s.consoleID
+	}
+
+	req := append([]byte{0x00, ipmiPrivAdmin, 0x00, 0x00}, le32(s.consoleID)...)
+	reply, err := s.exchange(ctx, ipmiPayloadOpenSession, append(req, ipmiAlgorithms...), ipmiPayloadOpenReply, ourID)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("the BMC refused to open a session (status 0x%02x)", reply[1])
+	}
+	if len(reply) < 12 {
+		return errors.New("invalid RMCP+ open session response")
+	}
+	s.bmcID = binary.LittleEndian.Uint32(reply[8:12])
+
+	// RAKP: both sides prove that they know the password, and derive the
+	// session keys from it and the exchanged random numbers.
+	rm := make([]byte, 16)
+	if _, err := rand.Read(rm); err != nil {
+		return err
+	}
+	kuid := []byte(password)
+	name := append([]byte{ipmiRole, byte(len(user))}, user...)
+	rakp1 := append([]byte{0x00, 0x00, 0x00, 0x00}, le32(s.bmcID)...)
+	rakp1 = append(append(rakp1, rm...), ipmiRole, 0x00, 0x00, byte(len(user)))
+	reply, err = s.exchange(ctx, ipmiPayloadRAKP1, append(rakp1, user...), ipmiPayloadRAKP2, ourID)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("the BMC refused the login of %q (status 0x%02x)", user, reply[1])
+	}
+	if len(reply) < 60 {
+		return errors.New("invalid RAKP message 2")
+	}
+	rc, guid := reply[8:24], reply[24:40]
+	expected := hmacSHA1(kuid, le32(s.consoleID), le32(s.bmcID), rm, rc, guid, name)
+	if !hmac.Equal(expected, reply[40:60]) {
+		return fmt.Errorf("wrong IPMI password for %q", user)
+	}
+	sik := hmacSHA1(kuid, rm, rc, name)
+
+	rakp3 := append([]byte{0x00, 0x00, 0x00, 0x00}, le32(s.bmcID)...)
+	rakp3 = append(rakp3, hmacSHA1(kuid, rc, le32(s.consoleID), name)...)
+	reply, err = s.exchange(ctx, ipmiPayloadRAKP3, rakp3, ipmiPayloadRAKP4, ourID)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("the BMC refused the login of %q (status 0x%02x)", user, reply[1])
+	}
+	if len(reply) < 20 || !hmac.Equal(hmacSHA1(sik, rm, le32(s.bmcID), guid)[:12], reply[8:20]) {
+		return errors.New("the BMC failed to authenticate itself")
+	}
+	s.k1 = hmacSHA1(sik, bytes.Repeat([]byte{0x01}, 20))
+	s.k2 = hmacSHA1(sik, bytes.Repeat([]byte{0x02}, 20))
+
+	// Sessions start out with the user privilege.
+	_, err = s.command(ctx, ipmiNetFnApp, ipmiCmdSetSessionPriv, ipmiPrivAdmin)
+	return err
+}
+
+// command sends the IPMI request `cmd` of `netFn` and returns the data of
+// its (successful) response.
+func (s *ipmiSession) command(ctx context.Context, netFn, cmd byte, data ...byte) ([]byte, error) {
+	s.seq++
+	s.rqSeq = (s.rqSeq + 1) & 0x3f
+	rqSeq := s.rqSeq
+	reply, err := s.exchange(ctx, ipmiPayloadIPMI, ipmiMessage(netFn, cmd, rqSeq, data), ipmiPayloadIPMI, func(reply []byte) bool {
+		return len(reply) >= 8 && reply[1]>>2 == netFn|1 && reply[4]>>2 == rqSeq && reply[5] == cmd
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reply[6] != 0 {
+		return nil, fmt.Errorf("the BMC refused command 0x%02x (completion code 0x%02x)", cmd, reply[6])
+	}
+	return reply[7 : len(reply)-1], nil
+}
+
+// Close closes the session, if open, and the connection.
+func (s *ipmiSession) Close(ctx context.Context) error {
+	if s.k1 != nil {
+		s.command(ctx, ipmiNetFnApp, ipmiCmdCloseSession, le32(s.bmcID)...)
+	}
+	return s.conn.Close()
+}
+
+// dialIPMI opens an RMCP+ session with the BMC at `address` (port 623 unless
+// given) as `user`.
+func dialIPMI(ctx context.Context, address, user, password string) (*ipmiSession, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, ipmiPort)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := &ipmiSession{conn: conn, consoleID: binary.LittleEndian.Uint32(id) | 1}
+	if err := s.open(ctx, user, password); err != nil {
+		s.Close(ctx)
+		return nil, err
+	}
+	return s, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ipmiPower powers the machine of the BMC `oob` on or off.
+func ipmiPower(ctx context.Context, client *http.Client, oob OOB, action string) error {
+	control, ok := map[string]byte{
+		powerOn:      ipmiChassisPowerUp,
+		powerOff:     ipmiChassisSoftShutdown,
+		powerOffHard: ipmiChassisPowerDown,
+	}[action]
+	if !ok {
+		return fmt.Errorf("ipmi cannot power %s", action)
+	}
+
+	s, err := dialIPMI(ctx, oob.Address, oob.User, oob.Password)
+	if err != nil {
+		return err
+	}
+	defer s.Close(ctx)
+	_, err = s.command(ctx, ipmiNetFnChassis, ipmiCmdChassisControl, control)
+	return err
+}
+
+// ipmiStatus returns whether the machine of the BMC `oob` is on or off.
+func ipmiStatus(ctx context.Context, client *http.Client, oob OOB) (string, error) {
+	s, err := dialIPMI(ctx, oob.Address, oob.User, oob.Password)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close(ctx)
+	status, err := s.command(ctx, ipmiNetFnChassis, ipmiCmdChassisStatus)
+	if err != nil {
+		return "", err
+	}
+	if len(status) == 0 {
+		return "", errors.New("invalid chassis status from the BMC")
+	}
+	if status[0]&0x01 != 0 {
+		return powerOn, nil
+	}
+	return powerOff, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// fakeBMC answers RMCP+ sessions of `user` with `password`, and records the
+// IPMI commands it is sent.
+type fakeBMC struct {
+	conn     *net.UDPConn
+	user     string
+	password string
+
+	mtx      sync.Mutex
+	commands [][]byte // netFn, cmd and data of each command
+}
+
+func newFakeBMC(t *testing.T, user, password string) *fakeBMC {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	bmc := &fakeBMC{conn: conn, user: user, password: password}
+	go bmc.serve()
+	return bmc
+}
+
+func (b *fakeBMC) serve() {
+	const bmcID = 0x0a0b0c0d
+	guid := bytes.Repeat([]byte{0x42}, 16)
+	rc := bytes.Repeat([]byte{0x17}, 16)
+	kuid := []byte(b.password)
+	var session ipmiSession // frames replies, with the console's session ID
+	var consoleID uint32
+	var rm, name []byte
+	var opened *ipmiSession // the session once RAKP 4 is sent
+
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		typ, p, err := session.parse(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		var replyType byte
+		var reply []byte
+		switch typ {
+		case ipmiPayloadOpenSession:
+			session = ipmiSession{}
+			consoleID = binary.LittleEndian.Uint32(p[4:8])
+			replyType = ipmiPayloadOpenReply
+			reply = append([]byte{p[0], 0x00, ipmiPrivAdmin, 0x00}, le32(consoleID)...)
+			reply = append(append(reply, le32(bmcID)...), p[8:]...)
+
+		case ipmiPayloadRAKP1:
+			rm = append([]byte{}, p[8:24]...)
+			name = append([]byte{p[24], p[27]}, p[28:28+int(p[27])]...)
+			replyType = ipmiPayloadRAKP2
+			reply = append([]byte{p[0], 0x00, 0x00, 0x00}, le32(consoleID)...)
+			if string(name[2:]) != b.user {
+				reply[1] = 0x0d // unauthorized name
+				break
+			}
+			reply = append(append(reply, rc...), guid...)
+			reply = append(reply, hmacSHA1(kuid, le32(consoleID), le32(bmcID), rm, rc, guid, name)...)
+
+		case ipmiPayloadRAKP3:
+			replyType = ipmiPayloadRAKP4
+			reply = append([]byte{p[0], 0x00, 0x00, 0x00}, le32(consoleID)...)
+			if !bytes.Equal(p[8:28], hmacSHA1(kuid, rc, le32(consoleID), name)) {
+				reply[1] = 0x0f // invalid integrity check value
+				break
+			}
+			sik := hmacSHA1(kuid, rm, rc, name)
+			reply = append(reply, hmacSHA1(sik, rm, le32(bmcID), guid)[:12]...)
+			opened = &ipmiSession{
+				bmcID: consoleID,
+				k1:    hmacSHA1(sik, bytes.Repeat([]byte{0x01}, 20)),
+				k2:    hmacSHA1(sik, bytes.Repeat([]byte{0x02}, 20)),
+			}
+
+		case ipmiPayloadIPMI:
+			netFn, rqSeq, cmd := p[1]>>2, p[4]>>2, p[5]
+			b.mtx.Lock()
+			b.commands = append(b.commands, append([]byte{netFn, cmd}, p[6:len(p)-1]...))
+			b.mtx.Unlock()
+			replyType = ipmiPayloadIPMI
+			reply = []byte{ipmiSWIDAddr, (netFn | 1) << 2}
+			reply = append(reply, ipmiChecksum(reply))
+			body := []byte{ipmiBMCAddr, rqSeq << 2, cmd, 0x00}
+			if netFn == ipmiNetFnChassis && cmd == ipmiCmdChassisStatus {
+				body = append(body, 0x01, 0x00, 0x00) // powered on
+			}
+			reply = append(append(reply, body...), ipmiChecksum(body))
+		}
+
+		pkt, _ := session.packet(replyType, reply)
+		b.conn.WriteToUDP(pkt, from)
+		if opened != nil {
+			session, opened = *opened, nil
+		}
+	}
+}
+
+func TestIPMIEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{0x02}, 16)
+	for size := 0; size < 40; size++ {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+		encrypted, err := ipmiEncrypt(key, data)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(encrypted)%16)
+		decrypted, err := ipmiDecrypt(key, encrypted)
+		assert.Nil(t, err)
+		assert.Equal(t, data, decrypted)
+	}
+	_, err := ipmiDecrypt(key, make([]byte, 20))
+	assert.NotNil(t, err)
+}
+
+func TestIPMIPower(t *testing.T) {
+	bmc := newFakeBMC(t, "ADMIN", "s3cret")
+	defer bmc.conn.Close()
+	oob := OOB{Kind: "ipmi", Address: bmc.conn.LocalAddr().String(), User: "ADMIN", Password: "s3cret"}
+
+	assert.Nil(t, ipmiPower(context.Background(), nil, oob, powerOn))
+	assert.Nil(t, ipmiPower(context.Background(), nil, oob, powerOff))
+	bmc.mtx.Lock()
+	assert.Equal(t, [][]byte{
+		{ipmiNetFnApp, ipmiCmdSetSessionPriv, ipmiPrivAdmin},
+		{ipmiNetFnChassis, ipmiCmdChassisControl, ipmiChassisPowerUp},
+		{ipmiNetFnApp, ipmiCmdCloseSession, 0x0d, 0x0c, 0x0b, 0x0a},
+		{ipmiNetFnApp, ipmiCmdSetSessionPriv, ipmiPrivAdmin},
+		{ipmiNetFnChassis, ipmiCmdChassisControl, ipmiChassisSoftShutdown},
+		{ipmiNetFnApp, ipmiCmdCloseSession, 0x0d, 0x0c, 0x0b, 0x0a},
+	}, bmc.commands)
+	bmc.mtx.Unlock()
+
+	state, err := ipmiStatus(context.Background(), nil, oob)
+	assert.Nil(t, err)
+	assert.Equal(t, "on", state)
+
+	oob.Password = "wrong"
+	err = ipmiPower(context.Background(), nil, oob, powerOn)
+	assert.EqualError(t, err, `wrong IPMI password for "ADMIN"`)
+
+	oob.User = "root"
+	err = ipmiPower(context.Background(), nil, oob, powerOn)
+	assert.EqualError(t, err, `the BMC refused the login of "root" (status 0x0d)`)
+}
@@ -0,0 +1,73 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// dscpClasses maps the names of the standard DSCP classes to their values.
+var dscpClasses = map[string]int{
+	"default": 0, "ef": 46,
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14, "af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30, "af41": 34, "af42": 36, "af43": 38,
+}
+
+// parseDSCP parses a DSCP given as a number or a class name ("" is 0).
+func parseDSCP(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if dscp, ok := dscpClasses[strings.ToLower(s)]; ok {
+		return dscp, nil
+	}
+	dscp, err := strconv.Atoi(s)
+	if err != nil || dscp < 0 || dscp > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q (expected 0-63 or a class such as ef or af41)", s)
+	}
+	return dscp, nil
+}
+
+// setupIPOptions applies `--ttl`, `--dont-fragment` and `--dscp` to `conn`,
+// which some router-assisted directed broadcast setups, and networks which
+// classify management traffic, require. Without them the OS defaults are
+// kept.
+func setupIPOptions(conn *net.UDPConn) error {
+	ttl := cliFlags.TTL
+	if ttl < 0 || ttl > 255 {
+		return fmt.Errorf("invalid TTL %d (expected 1-255)", ttl)
+	}
+	dscp, err := parseDSCP(cliFlags.DSCP)
+	if err != nil {
+		return err
+	}
+	ipv6 := false
+	if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		ipv6 = local.IP.To4() == nil
+	}
+
+	return controlConn(conn, func(fd uintptr) error {
+		if ttl > 0 {
+			if err := setTTL(fd, ttl); err != nil {
+				return err
+			}
+		}
+		// The DSCP is the upper six bits of the TOS (or traffic class)
+		// byte, below which the ECN bits are left clear.
+		if dscp > 0 {
+			if err := setTOS(fd, dscp<<2, ipv6); err != nil {
+				return err
+			}
+		}
+		if cliFlags.DontFragment {
+			return setDontFragment(fd)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestSetupIPOptions(t *testing.T) {
+	defer func(ttl int, dscp string) { cliFlags.TTL, cliFlags.DSCP = ttl, dscp }(cliFlags.TTL, cliFlags.DSCP)
+
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	for _, ttl := range []int{0, 1, 8, 255} {
+		cliFlags.TTL = ttl
+		assert.Nil(t, setupIPOptions(conn))
+	}
+	for _, ttl := range []int{-1, 256} {
+		cliFlags.TTL = ttl
+		assert.NotNil(t, setupIPOptions(conn))
+	}
+
+	cliFlags.TTL = 0
+	for _, dscp := range []string{"", "ef", "10"} {
+		cliFlags.DSCP = dscp
+		assert.Nil(t, setupIPOptions(conn))
+	}
+	cliFlags.DSCP = "64"
+	assert.NotNil(t, setupIPOptions(conn))
+}
+
+func TestParseDSCP(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		dscp int
+	}{
+		{"", 0},
+		{"46", 46},
+		{"EF", 46},
+		{"cs6", 48},
+		{"af41", 34},
+		{"63", 63},
+	} {
+		dscp, err := parseDSCP(tc.s)
+		assert.Nil(t, err, tc.s)
+		assert.Equal(t, tc.dscp, dscp, tc.s)
+	}
+	for _, s := range []string{"-1", "64", "af44", "voice"} {
+		_, err := parseDSCP(s)
+		assert.NotNil(t, err, s)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os/exec"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// On macOS the keyring is the login keychain, which security talks to.
+
+// keyringGet returns the secret filed as `name`.
+func keyringGet(name string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", name, "-w").Output()
+	if err != nil {
+		return "", toolError(err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// keyringSet files `secret` as `name`, replacing any previous one. security
+// only takes the secret as an argument, so it is briefly visible to ps.
+func keyringSet(name, secret string) error {
+	_, err := exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", name, "-w", secret).Output()
+	return toolError(err)
+}
+
+// keyringDel removes the secret filed as `name`.
+func keyringDel(name string) error {
+	_, err := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", name).Output()
+	return toolError(err)
+}
@@ -0,0 +1,36 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os/exec"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// On Linux the keyring is the Secret Service (GNOME Keyring, KWallet, ...),
+// which secret-tool talks to.
+
+// keyringGet returns the secret filed as `name`.
+func keyringGet(name string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "name", name).Output()
+	if err != nil {
+		return "", toolError(err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// keyringSet files `secret` as `name`, replacing any previous one.
+func keyringSet(name, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", keyringService+" "+name, "service", keyringService, "name", name)
+	cmd.Stdin = strings.NewReader(secret)
+	_, err := cmd.Output()
+	return toolError(err)
+}
+
+// keyringDel removes the secret filed as `name`.
+func keyringDel(name string) error {
+	_, err := exec.Command("secret-tool", "clear", "service", keyringService, "name", name).Output()
+	return toolError(err)
+}
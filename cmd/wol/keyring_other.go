@@ -0,0 +1,29 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+var errNoKeyring = errors.New("the keyring is only supported on Linux and macOS, use env: or prompt")
+
+// keyringGet returns the secret filed as `name`.
+func keyringGet(name string) (string, error) {
+	return "", errNoKeyring
+}
+
+// keyringSet files `secret` as `name`, replacing any previous one.
+func keyringSet(name, secret string) error {
+	return errNoKeyring
+}
+
+// keyringDel removes the secret filed as `name`.
+func keyringDel(name string) error {
+	return errNoKeyring
+}
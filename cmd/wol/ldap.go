@@ -0,0 +1,482 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Active Directory (and other LDAP directories) can be an `--ipam` too: a
+// target is looked up as a computer object, whose MAC address is read from
+// an attribute the site registers it in, and whose DNS host name is used to
+// find its current address. Only what that takes of LDAPv3 is implemented:
+// simple binds, StartTLS and searches.
+
+const (
+	ldapPort  = "389"
+	ldapsPort = "636"
+
+	// ldapStartTLS is the OID of the StartTLS extended operation.
+	ldapStartTLS = "1.3.6.1.4.1.1466.20037"
+
+	// The result codes which are not failures.
+	ldapSuccess           = 0
+	ldapSizeLimitExceeded = 4
+
+	// ldapMaxMessage is the largest response accepted.
+	ldapMaxMessage = 1 << 20
+
+	// Search scopes.
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+
+	// The attribute MAC addresses are read from and the object class of
+	// computers, unless the URL names others.
+	ldapDefaultAttribute  = "macAddress"
+	ldapDefaultClass      = "computer"
+	ldapHostNameAttribute = "dNSHostName"
+)
+
+// BER identifiers of the LDAP protocol elements used.
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchEntry      = 0x64
+	ldapSearchDone       = 0x65
+	ldapExtendedRequest  = 0x77
+	ldapExtendedResponse = 0x78
+	ldapSimpleAuth       = 0x80
+	ldapExtendedName     = 0x80
+	ldapFilterAnd        = 0xa0
+	ldapFilterOr         = 0xa1
+	ldapFilterEquality   = 0xa3
+	ldapFilterPresent    = 0x87
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ber encodes the element with the identifier `id` and `content`.
+func ber(id byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := []byte{id}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+// berInt encodes the (small, non-negative) integer `v`.
+func berInt(id byte, v int) []byte {
+	var b []byte
+	for ; v > 0x7f; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return ber(id, append([]byte{byte(v)}, b...))
+}
+
+// berString encodes the string `s`.
+func berString(id byte, s string) []byte {
+	return ber(id, []byte(s))
+}
+
+// berElement is a decoded BER element.
+type berElement struct {
+	id      byte
+	content []byte
+}
+
+// readBER reads the next element from `r`.
+func readBER(r io.Reader) (berElement, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return berElement{}, err
+	}
+	n := int(hdr[1])
+	if n >= 0x80 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return berElement{}, errors.New("ldap: unsupported BER length")
+		}
+		lb := make([]byte, size)
+		if _, err := io.ReadFull(r, lb); err != nil {
+			return berElement{}, err
+		}
+		n = 0
+		for _, b := range lb {
+			n = n<<8 | int(b)
+		}
+	}
+	if n > ldapMaxMessage {
+		return berElement{}, errors.New("ldap: response too large")
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return berElement{}, err
+	}
+	return berElement{hdr[0], content}, nil
+}
+
+// children decodes the elements of the constructed element `e`.
+func (e berElement) children() ([]berElement, error) {
+	var elems []berElement
+	r := strings.NewReader(string(e.content))
+	for r.Len() > 0 {
+		child, err := readBER(r)
+		if err != nil {
+			return nil, errors.New("ldap: malformed response")
+		}
+		elems = append(elems, child)
+	}
+	return elems, nil
+}
+
+// int decodes the integer (or enumeration) `e`.
+func (e berElement) int() int {
+	v := 0
+	for _, b := range e.content {
+		v = v<<8 | int(b)
+	}
+	return v
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ldapConn is a connection to an LDAP server.
+type ldapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	id   int
+}
+
+// ldapEntry is an entry found by a search, with its attributes by lower case
+// name.
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+// dialLDAP connects to the server at `u`, over TLS for `ldaps://`, and
+// binds as its user. Over `ldap://`, StartTLS is used before a password is
+// sent.
+func dialLDAP(ctx context.Context, u *url.URL) (*ldapConn, error) {
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = ldapPort
+		if u.Scheme == "ldaps" {
+			port = ldapsPort
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if u.Scheme == "ldaps" {
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+	c := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+
+	var dn, password string
+	if u.User != nil {
+		dn = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if u.Scheme == "ldap" && password != "" {
+		if err := c.startTLS(host); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+	if dn != "" {
+		if err := c.bind(dn, password); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close unbinds and closes the connection.
+func (c *ldapConn) Close() error {
+	c.send(ber(ldapUnbindRequest))
+	return c.conn.Close()
+}
+
+// send sends the request `op`, and returns its message ID.
+func (c *ldapConn) send(op []byte) (int, error) {
+	c.id++
+	_, err := c.conn.Write(ber(berSequence, berInt(berInteger, c.id), op))
+	return c.id, err
+}
+
+// receive returns the protocol operation of the next response to the
+// request `id`.
+func (c *ldapConn) receive(id int) (berElement, error) {
+	for {
+		msg, err := readBER(c.r)
+		if err != nil {
+			return berElement{}, err
+		}
+		elems, err := msg.children()
+		if err != nil {
+			return berElement{}, err
+		}
+		if msg.id != berSequence || len(elems) < 2 {
+			return berElement{}, errors.New("ldap: malformed response")
+		}
+		// Unsolicited notifications (ID 0) mean the server is about to
+		// disconnect, which the next read reports.
+		if elems[0].int() == id {
+			return elems[1], nil
+		}
+	}
+}
+
+// result returns the error the LDAPResult `op` reports, if any.
+func ldapResult(op berElement) error {
+	elems, err := op.children()
+	if err != nil {
+		return err
+	}
+	if len(elems) < 3 || elems[0].id != berEnumerated {
+		return errors.New("ldap: malformed result")
+	}
+	code := elems[0].int()
+	if code == ldapSuccess || code == ldapSizeLimitExceeded {
+		return nil
+	}
+	msg := strings.TrimRight(string(elems[2].content), "\x00")
+	if msg == "" {
+		msg = "request failed"
+	}
+	return fmt.Errorf("ldap: %s (result code %d)", msg, code)
+}
+
+// startTLS upgrades the connection to TLS, checking the certificate of
+// `host`.
+func (c *ldapConn) startTLS(host string) error {
+	id, err := c.send(ber(ldapExtendedRequest, berString(ldapExtendedName, ldapStartTLS)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.id != ldapExtendedResponse {
+		return errors.New("ldap: unexpected response to StartTLS")
+	}
+	if err := ldapResult(op); err != nil {
+		return err
+	}
+	conn := tls.Client(c.conn, &tls.Config{ServerName: host})
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// bind authenticates as `dn` (or, with Active Directory, a user principal
+// name such as wol@corp.example.com) with `password`.
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(ber(ldapBindRequest,
+		berInt(berInteger, 3), berString(berOctetString, dn), berString(ldapSimpleAuth, password)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.id != ldapBindResponse {
+		return errors.New("ldap: unexpected response to bind")
+	}
+	return ldapResult(op)
+}
+
+// search returns the entries under `base` which match the encoded `filter`,
+// with the attributes `attrs`. Referrals to other servers are not followed.
+func (c *ldapConn) search(base string, scope int, filter []byte, attrs ...string) ([]ldapEntry, error) {
+	var attrList []byte
+	for _, a := range attrs {
+		attrList = append(attrList, berString(berOctetString, a)...)
+	}
+	id, err := c.send(ber(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, scope),
+		berInt(berEnumerated, 0), // never dereference aliases
+		berInt(berInteger, 10),   // size limit
+		berInt(berInteger, 0),    // time limit
+		ber(berBoolean, []byte{0}),
+		filter,
+		ber(berSequence, attrList)))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.id {
+		case ldapSearchDone:
+			return entries, ldapResult(op)
+		case ldapSearchEntry:
+			entry, err := parseLDAPEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// parseLDAPEntry decodes the SearchResultEntry `op`.
+func parseLDAPEntry(op berElement) (ldapEntry, error) {
+	elems, err := op.children()
+	if err != nil || len(elems) < 2 {
+		return ldapEntry{}, errors.New("ldap: malformed entry")
+	}
+	entry := ldapEntry{dn: string(elems[0].content), attrs: map[string][]string{}}
+	attrs, err := elems[1].children()
+	if err != nil {
+		return ldapEntry{}, err
+	}
+	for _, attr := range attrs {
+		parts, err := attr.children()
+		if err != nil || len(parts) < 2 {
+			return ldapEntry{}, errors.New("ldap: malformed attribute")
+		}
+		vals, err := parts[1].children()
+		if err != nil {
+			return ldapEntry{}, err
+		}
+		name := strings.ToLower(string(parts[0].content))
+		for _, v := range vals {
+			entry.attrs[name] = append(entry.attrs[name], string(v.content))
+		}
+	}
+	return entry, nil
+}
+
+// ldapEquals encodes the filter (attr=value).
+func ldapEquals(attr, value string) []byte {
+	return ber(ldapFilterEquality, berString(berOctetString, attr), berString(berOctetString, value))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ldapMAC returns the MAC address in the attribute value `v`, which may be
+// written out or, in attributes of binary syntax, its 6 bytes.
+func ldapMAC(v string) (string, bool) {
+	switch len(v) {
+	case 6:
+		return net.HardwareAddr(v).String(), true
+	case 12:
+		if _, err := hex.DecodeString(v); err == nil {
+			return canonicalMAC(v[0:2] + ":" + v[2:4] + ":" + v[4:6] + ":" + v[6:8] + ":" + v[8:10] + ":" + v[10:12])
+		}
+	}
+	return canonicalMAC(v)
+}
+
+// ldapLookup looks the computer `name` up in the directory at
+// `ldap[s]://[bind DN or UPN:password@]host[:port][/base DN][?mac=attribute&class=objectClass]`.
+// Computers are matched by common name, account name or DNS host name, and
+// their MAC address is read from the `mac` attribute (macAddress, which
+// Active Directory has from Windows Server 2003 R2 on, by default). Without a
+// base DN, the directory's default naming context is searched.
+func ldapLookup(ctx context.Context, client *http.Client, u *url.URL, name string) (ipamHost, bool, error) {
+	q := u.Query()
+	macAttr, class := q.Get("mac"), q.Get("class")
+	if macAttr == "" {
+		macAttr = ldapDefaultAttribute
+	}
+	if class == "" {
+		class = ldapDefaultClass
+	}
+
+	c, err := dialLDAP(ctx, u)
+	if err != nil {
+		return ipamHost{}, false, err
+	}
+	defer c.Close()
+
+	base := strings.TrimPrefix(u.Path, "/")
+	if base == "" {
+		root, err := c.search("", ldapScopeBase, berString(ldapFilterPresent, "objectClass"), "defaultNamingContext")
+		if err != nil {
+			return ipamHost{}, false, err
+		}
+		if len(root) == 0 || len(root[0].attrs["defaultnamingcontext"]) == 0 {
+			return ipamHost{}, false, errors.New("ldap: the directory has no default naming context, give the base DN")
+		}
+		base = root[0].attrs["defaultnamingcontext"][0]
+	}
+
+	filter := ber(ldapFilterAnd,
+		ldapEquals("objectClass", class),
+		ber(ldapFilterOr,
+			ldapEquals("cn", name),
+			ldapEquals("sAMAccountName", name+"$"),
+			ldapEquals(ldapHostNameAttribute, name)))
+	entries, err := c.search(base, ldapScopeSubtree, filter, macAttr, ldapHostNameAttribute)
+	if err != nil {
+		return ipamHost{}, false, err
+	}
+	if len(entries) == 0 {
+		return ipamHost{}, false, nil
+	}
+
+	e := entries[0]
+	for _, v := range e.attrs[strings.ToLower(macAttr)] {
+		if mac, ok := ldapMAC(v); ok {
+			h := ipamHost{MAC: mac}
+			if hosts := e.attrs[strings.ToLower(ldapHostNameAttribute)]; len(hosts) > 0 {
+				h.Host = hosts[0]
+			}
+			return h, true, nil
+		}
+	}
+	return ipamHost{}, false, fmt.Errorf("%s has no MAC address in its %s attribute", e.dn, macAttr)
+}
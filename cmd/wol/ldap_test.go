@@ -0,0 +1,127 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// fakeLDAP serves anonymous searches from `entries`, by base DN, until the
+// listener is closed, and records the filters it is sent.
+func fakeLDAP(t *testing.T, entries map[string][]byte, filters *[][]byte) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					msg, err := readBER(r)
+					if err != nil {
+						return
+					}
+					elems, _ := msg.children()
+					id := berInt(berInteger, elems[0].int())
+					if elems[1].id != ldapSearchRequest {
+						continue
+					}
+					req, _ := elems[1].children()
+					*filters = append(*filters, req[6].content)
+					if entry, ok := entries[string(req[0].content)]; ok {
+						conn.Write(ber(berSequence, id, entry))
+					}
+					conn.Write(ber(berSequence, id, ber(ldapSearchDone,
+						berInt(berEnumerated, ldapSuccess), berString(berOctetString, ""), berString(berOctetString, ""))))
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+// ldapTestEntry encodes a SearchResultEntry.
+func ldapTestEntry(dn string, attrs ...string) []byte {
+	var list []byte
+	for i := 0; i+1 < len(attrs); i += 2 {
+		list = append(list, ber(berSequence,
+			berString(berOctetString, attrs[i]),
+			ber(berSet, berString(berOctetString, attrs[i+1])))...)
+	}
+	return ber(ldapSearchEntry, berString(berOctetString, dn), ber(berSequence, list))
+}
+
+func TestBER(t *testing.T) {
+	for _, n := range []int{0, 0x7f, 0x80, 0xff, 0x100, 0x10000} {
+		b := ber(berOctetString, make([]byte, n))
+		e, err := readBER(bytes.NewReader(b))
+		assert.Nil(t, err)
+		assert.Equal(t, byte(berOctetString), e.id)
+		assert.Equal(t, n, len(e.content))
+	}
+	for _, v := range []int{0, 3, 0x80, 0xff, 0x8000, 1 << 20} {
+		e, err := readBER(bytes.NewReader(berInt(berInteger, v)))
+		assert.Nil(t, err)
+		assert.Equal(t, v, e.int())
+		assert.True(t, e.content[0] < 0x80, v)
+	}
+}
+
+func TestLDAPMAC(t *testing.T) {
+	for v, mac := range map[string]string{
+		"\x00\x11\x22\x33\x44\x55": "00:11:22:33:44:55",
+		"001122334455":             "00:11:22:33:44:55",
+		"00-11-22-33-44-55":        "00:11:22:33:44:55",
+	} {
+		got, ok := ldapMAC(v)
+		assert.True(t, ok, v)
+		assert.Equal(t, mac, got)
+	}
+	_, ok := ldapMAC("unknown")
+	assert.False(t, ok)
+}
+
+func TestLDAPLookup(t *testing.T) {
+	var filters [][]byte
+	l := fakeLDAP(t, map[string][]byte{
+		"": ldapTestEntry("", "defaultNamingContext", "DC=corp,DC=example,DC=com"),
+		"DC=corp,DC=example,DC=com": ldapTestEntry("CN=BUILD-07,OU=Servers,DC=corp,DC=example,DC=com",
+			"macAddress", "\x00\x11\x22\x33\x44\x55", "dNSHostName", "build-07.corp.example.com"),
+		"OU=Printers,DC=corp,DC=example,DC=com": ldapTestEntry("CN=PRN-1,OU=Printers,DC=corp,DC=example,DC=com"),
+	}, &filters)
+	defer l.Close()
+
+	u, _ := url.Parse("ldap://" + l.Addr().String())
+	h, ok, err := ldapLookup(context.Background(), nil, u, "build-07")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ipamHost{MAC: "00:11:22:33:44:55", Host: "build-07.corp.example.com"}, h)
+	assert.Equal(t, 2, len(filters))
+	assert.True(t, bytes.Contains(filters[1], []byte("build-07$")))
+	assert.True(t, bytes.Contains(filters[1], []byte("computer")))
+
+	// The base DN and object class can be given.
+	u, _ = url.Parse("ldap://" + l.Addr().String() + "/OU=Servers,DC=corp,DC=example,DC=com?class=device")
+	_, ok, err = ldapLookup(context.Background(), nil, u, "build-08")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 3, len(filters))
+	assert.True(t, bytes.Contains(filters[2], []byte("device")))
+
+	u, _ = url.Parse("ldap://" + l.Addr().String() + "/OU=Printers,DC=corp,DC=example,DC=com")
+	_, _, err = ldapLookup(context.Background(), nil, u, "prn-1")
+	assert.NotNil(t, err)
+}
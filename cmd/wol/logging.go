@@ -0,0 +1,104 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// journalWriter writes each log message as an entry to the systemd journal
+// using its native protocol.
+type journalWriter struct {
+	conn *net.UnixConn
+}
+
+// newJournalWriter connects to the local journald.
+func newJournalWriter() (*journalWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalWriter{conn}, nil
+}
+
+// journalEntry encodes `msg` as a journal entry. The message is written in
+// the length-prefixed form, so that it may contain newlines.
+func journalEntry(identifier string, msg []byte) []byte {
+	msg = bytes.TrimRight(msg, "\n")
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "SYSLOG_IDENTIFIER=%s\nPRIORITY=6\nMESSAGE\n", identifier)
+	binary.Write(buf, binary.LittleEndian, uint64(len(msg)))
+	buf.Write(msg)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// Write sends `p` to the journal as a single entry.
+func (jw *journalWriter) Write(p []byte) (int, error) {
+	if _, err := jw.conn.Write(journalEntry("wol", p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection to journald.
+func (jw *journalWriter) Close() error {
+	return jw.conn.Close()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// setupLogging points the log output of the daemon modes at `--log-target`.
+// The returned closer, if any, should be closed on exit.
+func setupLogging() (io.Closer, error) {
+	var w io.WriteCloser
+	var err error
+	switch cliFlags.LogTarget {
+	case "", "stderr":
+		return nil, nil
+
+	case "file":
+		if cliFlags.LogFile == "" {
+			return nil, errors.New("--log-target file requires --log-file")
+		}
+		w, err = os.OpenFile(cliFlags.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return nil, err
+		}
+		log.SetOutput(w)
+		return w, nil
+
+	case "syslog":
+		w, err = newSyslogWriter("wol")
+
+	case "journald":
+		w, err = newJournalWriter()
+
+	default:
+		return nil, fmt.Errorf("unknown log target %q", cliFlags.LogTarget)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot log to %s: %v", cliFlags.LogTarget, err)
+	}
+
+	// syslog and the journal timestamp entries themselves.
+	log.SetFlags(0)
+	log.SetOutput(w)
+	return w, nil
+}
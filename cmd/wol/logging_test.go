@@ -0,0 +1,18 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestJournalEntry(t *testing.T) {
+	entry := journalEntry("wol", []byte("Woke nas\nfor 10.0.0.2\n"))
+	assert.Equal(t, "SYSLOG_IDENTIFIER=wol\nPRIORITY=6\nMESSAGE\n"+
+		"\x15\x00\x00\x00\x00\x00\x00\x00"+
+		"Woke nas\nfor 10.0.0.2\n", string(entry))
+}
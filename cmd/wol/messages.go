@@ -0,0 +1,79 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// A catalog maps message keys to their text (a fmt format) in one language.
+type catalog map[string]string
+
+// catalogs holds the available translations by locale, either a language
+// ("de") or a language and territory ("pt_BR"). To add a translation, add a
+// messages_<locale>.go with a catalog of the keys in messagesEN, which
+// fills in any that are missing.
+var catalogs = map[string]catalog{
+	"en": messagesEN,
+	"de": messagesDE,
+}
+
+// locale is the locale messages are printed in.
+var locale = detectLocale(os.Getenv)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// detectLocale returns the catalog locale to use given the LC_ALL,
+// LC_MESSAGES and LANG environment variables (in that order of precedence),
+// falling back to English.
+func detectLocale(getenv func(string) string) string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+
+		// Drop the codeset and modifier, as in de_DE.UTF-8@euro.
+		if idx := strings.IndexAny(value, ".@"); idx >= 0 {
+			value = value[:idx]
+		}
+		if _, ok := catalogs[value]; ok {
+			return value
+		}
+		lang := strings.SplitN(value, "_", 2)[0]
+		if _, ok := catalogs[lang]; ok {
+			return lang
+		}
+		return "en"
+	}
+	return "en"
+}
+
+// msg returns the message `key` in the current locale (or in English if it
+// has not been translated), formatted with `args`.
+func msg(key string, args ...interface{}) string {
+	format, ok := catalogs[locale][key]
+	if !ok {
+		if format, ok = messagesEN[key]; !ok {
+			format = key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// msgDefault returns the message `key` in the current locale, or `def` if it
+// has not been translated. It is used for text which is defined alongside
+// what it describes, such as option descriptions.
+func msgDefault(key, def string) string {
+	if text, ok := catalogs[locale][key]; ok {
+		return text
+	}
+	return def
+}
@@ -0,0 +1,38 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+// messagesDE is the German message catalog.
+var messagesDE = catalog{
+	"usage":          "Aufruf:",
+	"usage.commands": "Befehle:",
+	"usage.options":  "Optionen:",
+	"usage.version":  "Version:",
+	"usage.default":  "(Standard: %s)",
+	"usage.wake":     "Um einen Rechner aufzuwecken:",
+	"usage.wake-via": "Um einen Rechner über ssh von einem Gateway in seinem LAN aus aufzuwecken:",
+	"usage.list":     "Um Aliase anzuzeigen:",
+	"usage.alias":    "Um einen Alias zu speichern:",
+	"usage.remove":   "Um Aliase zu löschen:",
+	"usage.probe":    "Um zu prüfen, ob ein Rechner aufgeweckt werden kann:",
+	"usage.history":  "Um den Verlauf der Weckvorgänge anzuzeigen (oder zu exportieren):",
+	"usage.stats":    "Um zu sehen, wie zuverlässig jeder Alias aufwacht:",
+	"usage.serve":    "Um die HTTP-API bereitzustellen:",
+
+	"cmd.wake":    "weckt einen Rechner per MAC-Adresse oder Alias auf",
+	"cmd.list":    "listet alle MAC-Adressen und ihre Aliase auf",
+	"cmd.alias":   "speichert einen Alias für eine MAC-Adresse",
+	"cmd.remove":  "entfernt einen Alias oder eine MAC-Adresse",
+	"cmd.probe":   "prüft, ob die Netzwerkkarte eines Rechners aufgeweckt werden kann",
+	"cmd.history": "zeigt den Verlauf der Weckvorgänge an (oder exportiert ihn)",
+	"cmd.stats":   "zeigt, wie oft das Aufwecken jedes Alias gelingt",
+	"cmd.serve":   "stellt eine HTTP-API zum Auflisten und Aufwecken von Aliasen bereit",
+
+	"opt.version":   "gibt die Version aus",
+	"opt.help":      "gibt diese Hilfe aus",
+	"opt.port":      "UDP-Port, an den das Paket gesendet wird",
+	"opt.interface": "ausgehende Schnittstelle (Name, IP oder Subnetz)",
+
+	"error.fatal":      "Schwerwiegender Fehler: %s\n",
+	"error.no-command": "Kein Befehl angegeben, siehe Aufruf:\n",
+}
@@ -0,0 +1,91 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+// messagesEN is the English message catalog, which every other catalog falls
+// back to. Keys are grouped by prefix: `cmd.` describe commands, `usage.`
+// make up the usage text and the rest are messages printed while running.
+// Options are described by their `description` tag, which catalogs can
+// translate with `opt.<long name>` keys.
+var messagesEN = catalog{
+	"usage":                  "Usage:",
+	"usage.commands":         "Commands:",
+	"usage.options":          "Options:",
+	"usage.version":          "Version:",
+	"usage.default":          "(default %s)",
+	"usage.help-hint":        "    Run `wol help <command>` for the usage and options of a command.",
+	"usage.global-hint":      "    Run `wol help` for the options which apply to every command.",
+	"usage.wake":             "To wake up a machine:",
+	"usage.wake-via":         "To wake up a machine from a gateway inside its LAN, over ssh:",
+	"usage.wake-oob":         "To power on through the management controller if the magic packet fails, or instead:",
+	"usage.list":             "To view aliases:",
+	"usage.alias":            "To store an alias:",
+	"usage.remove":           "To delete aliases:",
+	"usage.prune":            "To find aliases which have not been seen on the network for a while:",
+	"usage.probe":            "To check whether a machine is ready to be woken:",
+	"usage.export-ansible":   "To export aliases as an Ansible inventory:",
+	"usage.export-canonical": "To export aliases for sharing:",
+	"usage.import":           "To import an export elsewhere:",
+	"usage.import-nmap":      "To create aliases from an nmap scan:",
+	"usage.import-router":    "To create aliases from the clients of a router:",
+	"usage.help":             "To show the usage of a command:",
+	"usage.history":          "To view (or export) the history of wakes:",
+	"usage.history-prune":    "To trim the history of wakes:",
+	"usage.stats":            "To see how reliably each alias wakes up:",
+	"usage.scan":             "To find the hosts (and their MAC addresses) in a subnet:",
+	"usage.scan-passive":     "To find them from the traffic on the link, without probing:",
+	"usage.serve":            "To serve the HTTP API:",
+	"usage.relay":            "To relay magic packets from one network to another:",
+	"usage.coap":             "To accept wake requests over CoAP:",
+	"usage.gpio":             "To wake aliases with buttons wired to gpio lines:",
+	"usage.token":            "To manage HTTP API access tokens:",
+	"usage.group":            "To manage groups of aliases:",
+	"usage.oob":              "To power on aliases through their management controller (AMT, IPMI, Redfish):",
+	"usage.oob-vm":           "To start aliases which are VMs through their hypervisor (libvirt, Proxmox VE):",
+	"usage.shutdown":         "To shut down (or power off) an alias through its management controller, or over ssh:",
+	"usage.secret":           "To store (or remove) a password in the OS keyring, to refer to as keyring:<name>:",
+	"usage.power":            "To power an alias on or off, or query it, however it is reached:",
+	"usage.sync":             "To merge aliases with another instance:",
+	"usage.notes": `    Any option can also be set with the environment variable WOL_<OPTION>,
+    its long name in capitals with dashes as underscores (e.g. WOL_BCAST,
+    WOL_SEND_TIMEOUT), and in the config file. Options given on the command
+    line take precedence, then the environment, then the config file.
+
+    The following MAC addresses are valid and will match:
+    01-23-45-56-67-89, 89:AB:CD:EF:00:12, 89:ab:cd:ef:00:12
+
+    The following MAC addresses are not (yet) valid:
+    1-2-3-4-5-6, 01 23 45 56 67 89
+
+    A MAC address can be given as a target spec with its wake parameters:
+    <mac>[@<host>[:<port>]][?iface=<interface>&pw=<password>]
+`,
+
+	"cmd.wake":     "wakes up a machine by mac address or alias, or a group of them",
+	"cmd.list":     "lists all mac addresses and their aliases",
+	"cmd.alias":    "stores an alias to a mac address",
+	"cmd.remove":   "removes an alias or a mac address",
+	"cmd.prune":    "lists (or removes) aliases not seen on the network lately",
+	"cmd.probe":    "checks whether a machine's NIC is ready to be woken",
+	"cmd.export":   "exports all aliases in a machine-readable format",
+	"cmd.import":   "imports aliases from a canonical export",
+	"cmd.help":     "shows the usage of the application or of a command",
+	"cmd.history":  "shows (or exports) the history of wakes",
+	"cmd.stats":    "shows how often wakes of each alias succeed",
+	"cmd.scan":     "lists the hosts (and their MACs) which respond in a subnet",
+	"cmd.serve":    "serves an HTTP API to list aliases and wake them",
+	"cmd.relay":    "re-broadcasts magic packets received on a udp port",
+	"cmd.coap":     "serves a CoAP wake resource for constrained devices",
+	"cmd.gpio":     "wakes aliases when buttons on gpio lines are pressed",
+	"cmd.token":    "adds, lists or removes HTTP API access tokens",
+	"cmd.group":    "adds, lists, shows or removes groups of aliases",
+	"cmd.oob":      "stores management controllers of aliases, powers them on and queries them",
+	"cmd.shutdown": "shuts an alias down through its management controller or over ssh",
+	"cmd.secret":   "stores passwords in the OS keyring, or removes them",
+	"cmd.power":    "powers an alias on or off, or shows its power state",
+	"cmd.sync":     "merges aliases with a remote wol serve instance",
+
+	"error.fatal":           "Fatal error: %s\n",
+	"error.no-command":      "No command specified, see usage:\n",
+	"error.unknown-command": "unknown command %q",
+}
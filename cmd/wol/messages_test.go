@@ -0,0 +1,63 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestDetectLocale(t *testing.T) {
+	for _, tc := range []struct {
+		env    map[string]string
+		locale string
+	}{
+		{map[string]string{}, "en"},
+		{map[string]string{"LANG": "de_DE.UTF-8"}, "de"},
+		{map[string]string{"LANG": "de_AT@euro"}, "de"},
+		{map[string]string{"LANG": "fr_FR.UTF-8"}, "en"},
+		{map[string]string{"LANG": "de_DE.UTF-8", "LC_MESSAGES": "C"}, "en"},
+		{map[string]string{"LC_MESSAGES": "C", "LC_ALL": "de"}, "de"},
+	} {
+		getenv := func(name string) string { return tc.env[name] }
+		assert.Equal(t, tc.locale, detectLocale(getenv), "%v", tc.env)
+	}
+}
+
+func TestMsg(t *testing.T) {
+	defer func(l string) { locale = l }(locale)
+
+	locale = "de"
+	assert.Equal(t, "Schwerwiegender Fehler: boom\n", msg("error.fatal", "boom"))
+	// Untranslated messages fall back to English.
+	assert.Equal(t, messagesEN["cmd.gpio"], msg("cmd.gpio"))
+	assert.Equal(t, "no.such.key", msg("no.such.key"))
+}
+
+func TestCatalogsComplete(t *testing.T) {
+	for name, c := range cmdMap {
+		assert.NotEmpty(t, messagesEN["cmd."+name], name)
+		for _, ex := range c.examples {
+			assert.NotEmpty(t, messagesEN[ex.caption], ex.caption)
+		}
+	}
+	known := map[string]bool{}
+	for key := range messagesEN {
+		known[key] = true
+	}
+	for _, name := range append(commandNames(), "") {
+		for _, o := range getOptions(name) {
+			known["opt."+o.long] = true
+		}
+	}
+
+	// Translations may not have keys English lacks, as those are typos.
+	for locale, cat := range catalogs {
+		for key := range cat {
+			assert.True(t, known[key], "%s: unknown key %s", locale, key)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Verification of minisign (https://jedisct1.github.io/minisign/) signatures,
+// with which canonical exports are signed:
+//
+//     minisign -Sm aliases.jsonl
+//     wol import aliases.jsonl --verify-key minisign.pub
+
+const (
+	minisignKeyIDSize = 8
+
+	minisignPureAlg     = "Ed" // Ed25519 over the file itself
+	minisignPrehashAlg  = "ED" // Ed25519 over the BLAKE2b-512 of the file
+	minisignTrustedNote = "trusted comment: "
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// minisignKey is a minisign public key.
+type minisignKey struct {
+	id  [minisignKeyIDSize]byte
+	pub ed25519.PublicKey
+}
+
+// minisignLines returns the lines of a minisign key or signature file which
+// are not untrusted comments.
+func minisignLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		// Trusted comments are signed as they are.
+		if !strings.HasPrefix(line, minisignTrustedNote) {
+			line = strings.TrimSpace(line)
+		}
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseMinisignKey parses a minisign public key, either the contents of its
+// file or the key itself (as printed by `minisign -G`).
+func parseMinisignKey(text string) (*minisignKey, error) {
+	lines := minisignLines(text)
+	if len(lines) != 1 {
+		return nil, errors.New("not a minisign public key")
+	}
+	bs, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(bs) != 2+minisignKeyIDSize+ed25519.PublicKeySize || string(bs[:2]) != minisignPureAlg {
+		return nil, errors.New("not a minisign public key")
+	}
+
+	var key minisignKey
+	copy(key.id[:], bs[2:])
+	key.pub = ed25519.PublicKey(bs[2+minisignKeyIDSize:])
+	return &key, nil
+}
+
+// loadMinisignKey reads the minisign public key in the file `spec`, or else
+// parses `spec` as the key itself.
+func loadMinisignKey(spec string) (*minisignKey, error) {
+	bs, err := ioutil.ReadFile(spec)
+	if os.IsNotExist(err) {
+		if key, kerr := parseMinisignKey(spec); kerr == nil {
+			return key, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseMinisignKey(string(bs))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", spec, err)
+	}
+	return key, nil
+}
+
+// verify checks that the minisign signature file `sig` is a valid signature
+// of `data` by the key, including its trusted comment, which is returned.
+func (key *minisignKey) verify(data []byte, sig string) (string, error) {
+	lines := minisignLines(sig)
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], minisignTrustedNote) {
+		return "", errors.New("not a minisign signature")
+	}
+	bs, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(bs) != 2+minisignKeyIDSize+ed25519.SignatureSize {
+		return "", errors.New("not a minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", errors.New("not a minisign signature")
+	}
+
+	alg, id, signature := string(bs[:2]), bs[2:2+minisignKeyIDSize], bs[2+minisignKeyIDSize:]
+	if !bytes.Equal(id, key.id[:]) {
+		return "", fmt.Errorf("signed by key %X, not by the verification key %X", reverse(id), reverse(key.id[:]))
+	}
+	switch alg {
+	case minisignPureAlg:
+	case minisignPrehashAlg:
+		data = blake2b(data, 64)
+	default:
+		return "", fmt.Errorf("unsupported minisign signature algorithm %q", alg)
+	}
+	if !ed25519.Verify(key.pub, data, signature) {
+		return "", errors.New("signature verification failed")
+	}
+
+	// The trusted comment is signed along with the signature.
+	comment := strings.TrimPrefix(lines[1], minisignTrustedNote)
+	if !ed25519.Verify(key.pub, append(append([]byte(nil), signature...), comment...), global) {
+		return "", errors.New("signature verification of the trusted comment failed")
+	}
+	return comment, nil
+}
+
+// reverse returns a reversed copy of `bs`; minisign prints key IDs as little
+// endian numbers.
+func reverse(bs []byte) []byte {
+	out := make([]byte, len(bs))
+	for i, b := range bs {
+		out[len(bs)-1-i] = b
+	}
+	return out
+}
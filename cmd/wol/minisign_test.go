@@ -0,0 +1,113 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Returns a new minisign public key (as in its file) and the private key.
+func testMinisignKey(t *testing.T) (string, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	id := make([]byte, minisignKeyIDSize)
+	rand.Read(id)
+	key := append(append([]byte(minisignPureAlg), id...), pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(key) + "\n", priv
+}
+
+// Signs `data` as `minisign -S` does (with `alg` "ED", or "Ed" for -l).
+func testMinisign(t *testing.T, pubFile string, priv ed25519.PrivateKey, alg, comment string, data []byte) string {
+	key, err := parseMinisignKey(pubFile)
+	assert.Nil(t, err)
+	if alg == minisignPrehashAlg {
+		data = blake2b(data, 64)
+	}
+	sig := ed25519.Sign(priv, data)
+	global := ed25519.Sign(priv, append(append([]byte(nil), sig...), comment...))
+	line := append(append([]byte(alg), key.id[:]...), sig...)
+	return "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(line) + "\n" +
+		minisignTrustedNote + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+}
+
+func TestBlake2b(t *testing.T) {
+	block := make([]byte, 512)
+	for i := range block {
+		block[i] = byte(i)
+	}
+	for _, tc := range []struct {
+		data     []byte
+		size     int
+		expected string
+	}{
+		{nil, 64, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{[]byte("abc"), 64, "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{make([]byte, 128), 64, "865939e120e6805438478841afb739ae4250cf372653078a065cdcfffca4caf798e6d462b65d658fc165782640eded70963449ae1500fb0f24981d7727e22c41"},
+		{block, 32, "540b20132d8aeae54057cb69c24f95d26a1c472cc700dd450defe9bb796d4f14"},
+	} {
+		assert.Equal(t, tc.expected, hex.EncodeToString(blake2b(tc.data, tc.size)))
+	}
+}
+
+func TestMinisignVerify(t *testing.T) {
+	pubFile, priv := testMinisignKey(t)
+	otherFile, otherPriv := testMinisignKey(t)
+	data := []byte("aliases\n")
+
+	key, err := parseMinisignKey(pubFile)
+	assert.Nil(t, err)
+	for _, alg := range []string{minisignPrehashAlg, minisignPureAlg} {
+		comment, err := key.verify(data, testMinisign(t, pubFile, priv, alg, "timestamp:1500000000", data))
+		assert.Nil(t, err, alg)
+		assert.Equal(t, "timestamp:1500000000", comment)
+	}
+
+	sig := testMinisign(t, pubFile, priv, minisignPrehashAlg, "ok", data)
+	_, err = key.verify([]byte("aliases!\n"), sig)
+	assert.EqualError(t, err, "signature verification failed")
+	_, err = key.verify(data, strings.Replace(sig, minisignTrustedNote+"ok", minisignTrustedNote+"ko", 1))
+	assert.EqualError(t, err, "signature verification of the trusted comment failed")
+	_, err = key.verify(data, testMinisign(t, otherFile, otherPriv, minisignPrehashAlg, "ok", data))
+	assert.NotNil(t, err)
+	_, err = key.verify(data, "untrusted comment: nope\n")
+	assert.NotNil(t, err)
+
+	_, err = parseMinisignKey("RWQ")
+	assert.NotNil(t, err)
+}
+
+func TestVerifyImport(t *testing.T) {
+	defer func(key, sig string) { cliFlags.VerifyKey, cliFlags.Signature = key, sig }(cliFlags.VerifyKey, cliFlags.Signature)
+	pubFile, priv := testMinisignKey(t)
+	data := []byte("aliases\n")
+
+	dir, err := ioutil.TempDir("", "TestVerifyImport")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	export := dir + "/aliases.jsonl"
+
+	// The key may be given as such, rather than as a file.
+	cliFlags.VerifyKey, cliFlags.Signature = strings.Split(pubFile, "\n")[1], ""
+	assert.NotNil(t, verifyImport(export, data))
+	assert.Nil(t, ioutil.WriteFile(export+".minisig", []byte(testMinisign(t, pubFile, priv, minisignPrehashAlg, "ok", data)), 0600))
+	assert.Nil(t, verifyImport(export, data))
+
+	assert.Nil(t, ioutil.WriteFile(dir+"/minisign.pub", []byte(pubFile), 0600))
+	cliFlags.VerifyKey, cliFlags.Signature = dir+"/minisign.pub", dir+"/other.minisig"
+	assert.NotNil(t, verifyImport(export, data))
+	assert.Nil(t, os.Rename(export+".minisig", cliFlags.Signature))
+	assert.Nil(t, verifyImport(export, data))
+}
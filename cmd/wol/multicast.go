@@ -0,0 +1,46 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"net"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// controlConn runs `fn` on the file descriptor underlying `conn`.
+func controlConn(conn *net.UDPConn, fn func(fd uintptr) error) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ferr error
+	if err := rc.Control(func(fd uintptr) {
+		ferr = fn(fd)
+	}); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// setupMulticast prepares `conn` for sending to a multicast group. Unlike
+// broadcasts, multicast packets are routed, so the TTL (`--multicast-ttl`)
+// limits how many routers the packet may cross. The egress interface is the
+// one owning `localAddr`, if given, rather than whichever one the routing
+// table picks.
+func setupMulticast(conn *net.UDPConn, localAddr *net.UDPAddr) error {
+	ttl := cliFlags.MulticastTTL
+	if ttl < 1 || ttl > 255 {
+		return fmt.Errorf("invalid multicast TTL %d (expected 1-255)", ttl)
+	}
+
+	var ifaceIP net.IP
+	if localAddr != nil {
+		ifaceIP = localAddr.IP.To4()
+	}
+	return controlConn(conn, func(fd uintptr) error {
+		return setMulticastOptions(fd, ttl, ifaceIP)
+	})
+}
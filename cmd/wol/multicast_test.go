@@ -0,0 +1,30 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestSetupMulticast(t *testing.T) {
+	defer func(ttl int) { cliFlags.MulticastTTL = ttl }(cliFlags.MulticastTTL)
+
+	localAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	conn, err := net.DialUDP("udp4", localAddr, &net.UDPAddr{IP: net.IPv4(239, 255, 0, 9), Port: 9})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	cliFlags.MulticastTTL = 4
+	assert.Nil(t, setupMulticast(conn, nil))
+	assert.Nil(t, setupMulticast(conn, localAddr))
+
+	for _, ttl := range []int{0, 256} {
+		cliFlags.MulticastTTL = ttl
+		assert.NotNil(t, setupMulticast(conn, nil))
+	}
+}
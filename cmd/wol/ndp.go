@@ -0,0 +1,96 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+
+	ndpOptSourceLinkLayerAddr = 1
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// solicitedNodeMulticast returns the solicited-node multicast address
+// (ff02::1:ffXX:XXXX) for the IPv6 address `ip`.
+func solicitedNodeMulticast(ip net.IP) net.IP {
+	snm := net.ParseIP("ff02::1:ff00:0")
+	copy(snm[13:], ip.To16()[13:])
+	return snm
+}
+
+// buildNeighborSolicitation returns an ICMPv6 Neighbor Solicitation for
+// `target`. If `mac` is non-nil it is advertised as the source link-layer
+// address, which multicast solicitations must carry for many hosts to answer
+// them (RFC 4861 §4.3). The checksum is left zero as the kernel fills it in
+// for raw ICMPv6 sockets.
+func buildNeighborSolicitation(target net.IP, mac net.HardwareAddr) []byte {
+	msg := make([]byte, 24, 32)
+	msg[0] = icmpv6NeighborSolicitation
+	copy(msg[8:24], target.To16())
+
+	if len(mac) == 6 {
+		msg = append(msg, ndpOptSourceLinkLayerAddr, 1)
+		msg = append(msg, mac...)
+	}
+	return msg
+}
+
+// isNeighborAdvertisement returns true if `msg` is an ICMPv6 Neighbor
+// Advertisement for `target`.
+func isNeighborAdvertisement(msg []byte, target net.IP) bool {
+	if len(msg) < 24 || msg[0] != icmpv6NeighborAdvertisement || msg[1] != 0 {
+		return false
+	}
+	return bytes.Equal(msg[8:24], target.To16())
+}
+
+// resolveNDPTarget parses `host` (optionally with a `%zone`) as an IPv6
+// address, and returns the hardware address of the zone's interface (or the
+// one `--interface` selects by name, address or subnet, or else the interface
+// on the target's subnet) to advertise in solicitations.
+func resolveNDPTarget(host string) (*net.IPAddr, net.HardwareAddr, error) {
+	addr, err := net.ResolveIPAddr("ip6", host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if addr.IP.To4() != nil {
+		return nil, nil, fmt.Errorf("ndp verification requires an IPv6 address, got %s", host)
+	}
+
+	iface := addr.Zone
+	if iface == "" && cliFlags.BroadcastInterface != "" {
+		if iface, err = interfaceName(cliFlags.BroadcastInterface); err != nil {
+			return nil, nil, err
+		}
+	}
+	if iface == "" && !addr.IP.IsLinkLocalUnicast() {
+		snap, err := interfaces.get()
+		if err != nil {
+			return nil, nil, err
+		}
+		iface = snap.onLink(addr.IP)
+	}
+	if iface == "" && addr.IP.IsLinkLocalUnicast() {
+		return nil, nil, fmt.Errorf("link-local address %s needs a %%zone or an --interface", host)
+	}
+
+	var mac net.HardwareAddr
+	if iface != "" {
+		ief, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, nil, err
+		}
+		mac = ief.HardwareAddr
+		addr.Zone = ief.Name
+	}
+	return addr, mac, nil
+}
@@ -0,0 +1,10 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The syscall package leaves out the RFC 3542 socket options on macOS, whose
+// values (from <netinet6/in6.h>) are those of the other BSDs.
+const (
+	ipv6RecvHopLimit = 0x25
+	ipv6HopLimit     = 0x2f
+)
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// probeNDP is not supported here: Windows does not allow raw ICMPv6 sockets
+// to send Neighbor Solicitations, and other systems cannot read the hop limit
+// of the advertisements.
+func probeNDP(host string, deadline time.Time) (bool, error) {
+	return false, fmt.Errorf("ndp verification is not supported on %s", runtime.GOOS)
+}
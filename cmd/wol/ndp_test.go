@@ -0,0 +1,76 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestSolicitedNodeMulticast(t *testing.T) {
+	for _, tc := range []struct {
+		ip, expected string
+	}{
+		{"fe80::1", "ff02::1:ff00:1"},
+		{"2001:db8::aabb:ccdd", "ff02::1:ffbb:ccdd"},
+	} {
+		snm := solicitedNodeMulticast(net.ParseIP(tc.ip))
+		assert.Equal(t, tc.expected, snm.String())
+	}
+}
+
+func TestBuildNeighborSolicitation(t *testing.T) {
+	target := net.ParseIP("fe80::1234")
+
+	msg := buildNeighborSolicitation(target, nil)
+	assert.Equal(t, 24, len(msg))
+	assert.Equal(t, byte(icmpv6NeighborSolicitation), msg[0])
+	assert.Equal(t, []byte(target), msg[8:24])
+
+	mac, err := net.ParseMAC("00:11:22:33:44:55")
+	assert.Nil(t, err)
+	msg = buildNeighborSolicitation(target, mac)
+	assert.Equal(t, 32, len(msg))
+	assert.Equal(t, byte(ndpOptSourceLinkLayerAddr), msg[24])
+	assert.Equal(t, byte(1), msg[25])
+	assert.Equal(t, []byte(mac), msg[26:32])
+}
+
+func TestIsNeighborAdvertisement(t *testing.T) {
+	target := net.ParseIP("fe80::1234")
+
+	na := make([]byte, 24)
+	na[0] = icmpv6NeighborAdvertisement
+	copy(na[8:], target)
+	assert.True(t, isNeighborAdvertisement(na, target))
+	assert.False(t, isNeighborAdvertisement(na, net.ParseIP("fe80::1")))
+	assert.False(t, isNeighborAdvertisement(na[:20], target))
+
+	ns := buildNeighborSolicitation(target, nil)
+	assert.False(t, isNeighborAdvertisement(ns, target))
+}
+
+func TestResolveNDPTargetNegative(t *testing.T) {
+	defer func(iface string) { cliFlags.BroadcastInterface = iface }(cliFlags.BroadcastInterface)
+	cliFlags.BroadcastInterface = ""
+
+	// Link-local addresses are on every link, so which one must be given.
+	for _, host := range []string{"10.0.0.1", "not-an-ip", "fe80::1%fake-interface-0", "fe80::1"} {
+		_, _, err := resolveNDPTarget(host)
+		assert.NotNil(t, err)
+	}
+
+	// The interface may be selected by address or subnet, as when sending.
+	cliFlags.BroadcastInterface = "2001:db8:ffff::/48"
+	_, _, err := resolveNDPTarget("fe80::1")
+	assert.EqualError(t, err, "no interface has an address matching 2001:db8:ffff::/48")
+	cliFlags.BroadcastInterface = "127.0.0.0/8"
+	addr, _, err := resolveNDPTarget("fe80::1")
+	assert.Nil(t, err)
+	lo, _ := interfaceName("127.0.0.1")
+	assert.Equal(t, lo, addr.Zone)
+}
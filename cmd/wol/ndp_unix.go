@@ -0,0 +1,98 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// probeNDP sends a Neighbor Solicitation for `host` to its solicited-node
+// multicast group and waits for a matching Neighbor Advertisement. Unlike
+// ICMPv6 echo, ND is mandatory on IPv6 links and cannot be firewalled off.
+// Raw ICMPv6 sockets usually require root (or CAP_NET_RAW).
+func probeNDP(host string, deadline time.Time) (bool, error) {
+	target, mac, err := resolveNDPTarget(host)
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := net.ListenIP("ip6:ipv6-icmp", &net.IPAddr{IP: net.IPv6unspecified, Zone: target.Zone})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// RFC 4861 requires ND messages to be sent, and received, with a hop
+	// limit of 255, which shows they did not come from off the link.
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255)
+		if serr == nil {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, 255)
+		}
+		if serr == nil {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6RecvHopLimit, 1)
+		}
+	}); err != nil {
+		return false, err
+	}
+	if serr != nil {
+		return false, serr
+	}
+
+	dst := &net.IPAddr{IP: solicitedNodeMulticast(target.IP), Zone: target.Zone}
+	if _, err := conn.WriteTo(buildNeighborSolicitation(target.IP, mac), dst); err != nil {
+		return false, err
+	}
+
+	// Wait up to one probe interval (bounded by the overall deadline) for the
+	// advertisement.
+	wait := time.Now().Add(verifyInterval)
+	if wait.After(deadline) {
+		wait = deadline
+	}
+	if err := conn.SetReadDeadline(wait); err != nil {
+		return false, err
+	}
+
+	buf, oob := make([]byte, 1500), make([]byte, syscall.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := conn.ReadMsgIP(buf, oob)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		if hopLimit(oob[:oobn]) == 255 && isNeighborAdvertisement(buf[:n], target.IP) {
+			return true, nil
+		}
+	}
+}
+
+// hopLimit returns the hop limit a packet was received with, from its control
+// messages, or -1 if they do not say.
+func hopLimit(oob []byte) int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == ipv6HopLimit && len(msg.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&msg.Data[0])))
+		}
+	}
+	return -1
+}
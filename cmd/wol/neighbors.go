@@ -0,0 +1,115 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/sabhiram/go-wol/wolpacket"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+var (
+	// Matches MAC addresses as printed by `arp`, which on BSD / macOS drops
+	// leading zeros from each octet (e.g. `0:11:2:33:44:55`).
+	reARPMac = regexp.MustCompile(`\b[0-9a-fA-F]{1,2}([:-][0-9a-fA-F]{1,2}){5}\b`)
+
+	// Matches the IPv4 address of an `arp` output line.
+	reARPIP = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// canonicalMAC normalizes a MAC address which may use either delimiter and
+// may have dropped leading zeros into the lower case, colon delimited form.
+func canonicalMAC(mac string) (string, bool) {
+	// Most tables already hold full addresses, which need no splitting.
+	if len(mac) == 17 {
+		addr, err := wolpacket.ParseMAC(mac)
+		if err != nil {
+			return "", false
+		}
+		return addr.String(), true
+	}
+
+	parts := strings.FieldsFunc(mac, func(r rune) bool { return r == ':' || r == '-' })
+	if len(parts) != 6 {
+		return "", false
+	}
+	for idx, p := range parts {
+		if len(p) == 1 {
+			parts[idx] = "0" + p
+		}
+	}
+	addr, err := wolpacket.ParseMAC(strings.Join(parts, ":"))
+	if err != nil {
+		return "", false
+	}
+	return addr.String(), true
+}
+
+// parseProcNetARP parses the linux `/proc/net/arp` table into a map of
+// canonical MAC address -> IP address. Incomplete entries are skipped.
+func parseProcNetARP(r io.Reader) map[string]net.IP {
+	table := map[string]net.IP{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] == "0x0" {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		mac, ok := canonicalMAC(fields[3])
+		if ip == nil || !ok || mac == "00:00:00:00:00:00" {
+			continue
+		}
+		table[mac] = ip
+	}
+	return table
+}
+
+// parseARPOutput parses the output of `arp -a` (BSD, macOS and Windows
+// flavors) into a map of canonical MAC address -> IP address.
+func parseARPOutput(r io.Reader) map[string]net.IP {
+	table := map[string]net.IP{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		ip := net.ParseIP(reARPIP.FindString(line))
+		mac, ok := canonicalMAC(reARPMac.FindString(line))
+		if ip == nil || !ok || mac == "ff:ff:ff:ff:ff:ff" {
+			continue
+		}
+		table[mac] = ip
+	}
+	return table
+}
+
+// neighborTable returns the operating system's IPv4 neighbor (ARP) cache as
+// a map of canonical MAC address -> IP address.
+func neighborTable() (map[string]net.IP, error) {
+	if runtime.GOOS == "linux" {
+		f, err := os.Open("/proc/net/arp")
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseProcNetARP(f), nil
+	}
+
+	out, err := exec.Command("arp", "-a").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseARPOutput(bytes.NewReader(out)), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestCanonicalMAC(t *testing.T) {
+	for _, tc := range []struct {
+		mac, expected string
+		ok            bool
+	}{
+		{"00:11:22:AA:BB:CC", "00:11:22:aa:bb:cc", true},
+		{"00-11-22-aa-bb-cc", "00:11:22:aa:bb:cc", true},
+		{"0:11:2:aa:b:cc", "00:11:02:aa:0b:cc", true},
+		{"0:11:2:aa:b", "", false},
+		{"zz:11:22:aa:bb:cc", "", false},
+		{"", "", false},
+	} {
+		mac, ok := canonicalMAC(tc.mac)
+		assert.Equal(t, tc.ok, ok)
+		assert.Equal(t, tc.expected, mac)
+	}
+}
+
+func TestParseProcNetARP(t *testing.T) {
+	table := parseProcNetARP(strings.NewReader(
+		`IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         00:11:22:aa:bb:cc     *        eth0
+192.168.1.7      0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.1.9      0x1         0x2         00:11:22:AA:BB:DD     *        eth0
+`))
+	assert.Equal(t, 2, len(table))
+	assert.Equal(t, "192.168.1.1", table["00:11:22:aa:bb:cc"].String())
+	assert.Equal(t, "192.168.1.9", table["00:11:22:aa:bb:dd"].String())
+}
+
+func TestParseARPOutput(t *testing.T) {
+	// macOS / BSD.
+	table := parseARPOutput(strings.NewReader(
+		`? (192.168.1.1) at 0:11:22:aa:bb:cc on en0 ifscope [ethernet]
+? (192.168.1.5) at (incomplete) on en0 ifscope [ethernet]
+? (192.168.1.255) at ff:ff:ff:ff:ff:ff on en0 ifscope [ethernet]
+`))
+	assert.Equal(t, 1, len(table))
+	assert.Equal(t, "192.168.1.1", table["00:11:22:aa:bb:cc"].String())
+
+	// Windows.
+	table = parseARPOutput(strings.NewReader(
+		`Interface: 192.168.1.20 --- 0xb
+  Internet Address      Physical Address      Type
+  192.168.1.1           00-11-22-aa-bb-cc     dynamic
+  192.168.1.255         ff-ff-ff-ff-ff-ff     static
+`))
+	assert.Equal(t, 1, len(table))
+	assert.Equal(t, "192.168.1.1", table["00:11:22:aa:bb:cc"].String())
+}
+
+// Parses a neighbor table of a /24, as scans do every poll interval.
+func BenchmarkParseProcNetARP(b *testing.B) {
+	table := "IP address       HW type     Flags       HW address            Mask     Device\n"
+	for idx := 1; idx < 255; idx++ {
+		table += fmt.Sprintf("192.168.1.%d      0x1         0x2         00:11:22:33:44:%02x     *        eth0\n", idx, idx)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parseProcNetARP(strings.NewReader(table))
+	}
+}
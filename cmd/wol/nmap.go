@@ -0,0 +1,79 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// nmapRun is the part of nmap's XML output (`nmap -oX`) that is imported.
+type nmapRun struct {
+	Hosts []struct {
+		Status struct {
+			State string `xml:"state,attr"`
+		} `xml:"status"`
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+			Vendor   string `xml:"vendor,attr"`
+		} `xml:"address"`
+		Hostnames []struct {
+			Name string `xml:"name,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"hostnames>hostname"`
+	} `xml:"host"`
+}
+
+// parseNmap reads the hosts which are up and have a MAC address (nmap only
+// sees those for hosts on a local link) from nmap XML output.
+func parseNmap(r io.Reader) ([]inventoryHost, error) {
+	var run nmapRun
+	if err := xml.NewDecoder(r).Decode(&run); err != nil {
+		return nil, fmt.Errorf("invalid nmap XML: %v", err)
+	}
+
+	var hosts []inventoryHost
+	for _, h := range run.Hosts {
+		if h.Status.State != "" && h.Status.State != "up" {
+			continue
+		}
+
+		var host inventoryHost
+		for _, addr := range h.Addresses {
+			switch addr.AddrType {
+			case "ipv4", "ipv6":
+				if host.IP == "" {
+					host.IP = addr.Addr
+				}
+			case "mac":
+				host.MAC, _ = canonicalMAC(addr.Addr)
+				host.Vendor = addr.Vendor
+			}
+		}
+		if host.MAC == "" {
+			continue
+		}
+
+		// Names given by the user win over reverse DNS.
+		for _, hn := range h.Hostnames {
+			if hn.Type == "user" || host.Name == "" {
+				host.Name = hn.Name
+			}
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// importNmap adds aliases for the hosts in the nmap XML output `r`.
+func importNmap(r io.Reader, aliases *Aliases) error {
+	hosts, err := parseNmap(r)
+	if err != nil {
+		return err
+	}
+	return importHosts(hosts, aliases)
+}
@@ -0,0 +1,72 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const testNmapXML = `<?xml version="1.0" encoding="UTF-8"?>
+<nmaprun scanner="nmap" args="nmap -sn -oX scan.xml 192.168.1.0/24">
+<host><status state="up" reason="arp-response"/>
+<address addr="192.168.1.2" addrtype="ipv4"/>
+<address addr="00:11:22:AA:BB:CC" addrtype="mac" vendor="Super Micro Computer"/>
+<hostnames><hostname name="nas.lan" type="PTR"/></hostnames>
+</host>
+<host><status state="up" reason="arp-response"/>
+<address addr="192.168.1.3" addrtype="ipv4"/>
+<address addr="00:11:22:AA:BB:DD" addrtype="mac"/>
+<hostnames>
+<hostname name="desktop-4711.lan" type="PTR"/>
+<hostname name="gamer" type="user"/>
+</hostnames>
+</host>
+<host><status state="up" reason="arp-response"/>
+<address addr="192.168.1.4" addrtype="ipv4"/>
+<address addr="00:11:22:AA:BB:EE" addrtype="mac"/>
+</host>
+<host><status state="down" reason="no-response"/>
+<address addr="192.168.1.5" addrtype="ipv4"/>
+<address addr="00:11:22:AA:BB:FF" addrtype="mac"/>
+</host>
+<host><status state="up" reason="localhost-response"/>
+<address addr="192.168.1.6" addrtype="ipv4"/>
+</host>
+</nmaprun>
+`
+
+func TestParseNmap(t *testing.T) {
+	hosts, err := parseNmap(strings.NewReader(testNmapXML))
+	assert.Nil(t, err)
+	assert.Equal(t, []inventoryHost{
+		{"nas.lan", "192.168.1.2", "00:11:22:aa:bb:cc", "Super Micro Computer"},
+		{"gamer", "192.168.1.3", "00:11:22:aa:bb:dd", ""},
+		{"", "192.168.1.4", "00:11:22:aa:bb:ee", ""},
+	}, hosts)
+
+	_, err = parseNmap(strings.NewReader("<nmaprun><host>"))
+	assert.NotNil(t, err)
+}
+
+func TestImportNmap(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestImportNmap.db")
+	defer cleanup()
+
+	// The MAC of `gamer` has an alias, and the name `nas` is taken.
+	assert.Nil(t, aliases.Add("desktop", "00-11-22-AA-BB-DD", ""))
+	assert.Nil(t, aliases.Add("nas", "00:11:22:00:00:01", ""))
+
+	assert.Nil(t, importNmap(strings.NewReader(testNmapXML), aliases))
+	list, err := aliases.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, "192.168.1.4", list[0].Name)
+	snap, _ := interfaces.get()
+	assert.Equal(t, MacIface{Mac: "00:11:22:aa:bb:ee", IP: hostCIDR(snap, net.ParseIP("192.168.1.4"))}, list[0].MacIface)
+}
@@ -0,0 +1,342 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The events notifiers can be told about, which are derived from the history
+// entries of wakes.
+const (
+	eventWake         = "wake"          // a magic packet was sent, or a machine powered on
+	eventWakeFailed   = "wake-failed"   // sending it (or powering on) failed
+	eventUp           = "up"            // a woken machine answered its verification
+	eventVerifyFailed = "verify-failed" // a woken machine did not come up
+)
+
+// defaultNotifyEvents are what notifiers are told about unless their
+// `--notify` names the events.
+var defaultNotifyEvents = []string{eventWakeFailed, eventVerifyFailed}
+
+// notifyBackends deliver a notification to the service at `u`.
+var notifyBackends = map[string]func(ctx context.Context, client *http.Client, u *url.URL, n notification) error{
+	"gotify":   gotifyNotify,
+	"ntfy":     ntfyNotify,
+	"pushover": pushoverNotify,
+	"smtp":     smtpNotify,
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// notification is what a notifier is told about a wake.
+type notification struct {
+	Event   string
+	Title   string
+	Message string
+}
+
+// failed returns true if the notification is about a failure, which
+// services may show more prominently.
+func (n notification) failed() bool {
+	return n.Event == eventWakeFailed || n.Event == eventVerifyFailed
+}
+
+// newNotification returns the notification about the history entry `e`, and
+// false if it is not about anything to notify of.
+func newNotification(e HistoryEntry) (notification, bool) {
+	what := e.Target
+	if e.Mac != "" && e.Mac != e.Target {
+		what = fmt.Sprintf("%s (%s)", e.Target, e.Mac)
+	}
+	switch {
+	case e.Coalesced:
+		return notification{}, false
+	case e.Verify != "" && e.Error != "":
+		return notification{eventVerifyFailed, e.Target + " did not come up",
+			fmt.Sprintf("%s did not come up after being woken: %s", what, e.Error)}, true
+	case e.Verify != "":
+		return notification{eventUp, e.Target + " is up",
+			fmt.Sprintf("%s answered %s after %s", what, e.Verify, e.UpAfter.Round(time.Second))}, true
+	case e.Error != "":
+		return notification{eventWakeFailed, e.Target + " failed to wake",
+			fmt.Sprintf("Waking %s for %s failed: %s", what, e.By, e.Error)}, true
+	}
+	return notification{eventWake, e.Target + " was woken",
+		fmt.Sprintf("Woke %s for %s", what, e.By)}, true
+}
+
+// notifyTarget is a service to notify, and the events it is told about.
+type notifyTarget struct {
+	events map[string]bool
+	url    *url.URL
+}
+
+// parseNotifyTarget parses a `--notify` value, `[event,...=]URL`, whose URL
+// may also be given as a secret reference (env:<NAME> or keyring:<name>).
+func parseNotifyTarget(spec string) (notifyTarget, error) {
+	t := notifyTarget{events: map[string]bool{}}
+	events := defaultNotifyEvents
+	if i := strings.Index(spec, "="); i >= 0 && !strings.Contains(spec[:i], ":") {
+		events, spec = strings.Split(spec[:i], ","), spec[i+1:]
+	}
+	for _, event := range events {
+		switch event {
+		case "all":
+			for _, e := range []string{eventWake, eventWakeFailed, eventUp, eventVerifyFailed} {
+				t.events[e] = true
+			}
+		case eventWake, eventWakeFailed, eventUp, eventVerifyFailed:
+			t.events[event] = true
+		default:
+			return t, fmt.Errorf("unknown --notify event %q (expected %s, %s, %s, %s or all)",
+				event, eventWake, eventWakeFailed, eventUp, eventVerifyFailed)
+		}
+	}
+
+	if isSecretRef(spec) {
+		secret, err := resolveSecret(spec, "Notification URL: ")
+		if err != nil {
+			return t, err
+		}
+		spec = secret
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return t, fmt.Errorf("invalid --notify URL %q", redactURL(spec))
+	}
+	if _, ok := notifyBackends[u.Scheme]; !ok {
+		return t, fmt.Errorf("unknown --notify service %q (expected %s)", u.Scheme, notifyBackendNames())
+	}
+	t.url = u
+	return t, nil
+}
+
+// notifyBackendNames returns the names of the notification services, sorted.
+func notifyBackendNames() string {
+	var names []string
+	for name := range notifyBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// redactURL returns `s` without the credentials it may carry, for messages.
+func redactURL(s string) string {
+	if i := strings.Index(s, "://"); i >= 0 {
+		if j := strings.LastIndex(s, "@"); j > i {
+			return s[:i+3] + "..." + s[j:]
+		}
+	}
+	return s
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// notifier delivers notifications to the `--notify` services in the
+// background, or is nil if there are none.
+var notifier *notifyDispatcher
+
+// notifyDispatcher sends each notification to the targets which want it.
+type notifyDispatcher struct {
+	targets []notifyTarget
+	client  *http.Client
+	wg      sync.WaitGroup
+}
+
+// Close waits for the notifications being sent.
+func (d *notifyDispatcher) Close() error {
+	d.wg.Wait()
+	return nil
+}
+
+// setupNotify parses the `--notify` targets, if any. The returned closer, if
+// any, should be closed on exit, so that pending notifications are sent.
+func setupNotify() (io.Closer, error) {
+	if len(cliFlags.Notify) == 0 {
+		return nil, nil
+	}
+	d := &notifyDispatcher{client: &http.Client{}}
+	for _, spec := range cliFlags.Notify {
+		t, err := parseNotifyTarget(spec)
+		if err != nil {
+			return nil, err
+		}
+		d.targets = append(d.targets, t)
+	}
+	notifier = d
+	return d, nil
+}
+
+// notify tells the targets which want it about the history entry `e`.
+func (d *notifyDispatcher) notify(e HistoryEntry) {
+	n, ok := newNotification(e)
+	if !ok {
+		return
+	}
+	for _, t := range d.targets {
+		if !t.events[n.Event] {
+			continue
+		}
+		d.wg.Add(1)
+		go func(t notifyTarget) {
+			defer d.wg.Done()
+			ctx, cancel := withTimeout(context.Background(), opAPI)
+			defer cancel()
+			if err := notifyBackends[t.url.Scheme](ctx, d.client, t.url, n); err != nil {
+				log.Printf("Failed to notify %s of %s: %v\n", t.url.Scheme, n.Title, err)
+			}
+		}(t)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// notifyPost sends `req` to a notification service and fails unless it is
+// accepted. Errors do not repeat the credentials or query of the URL.
+func notifyPost(ctx context.Context, client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			uerr.URL = redactURL(strings.SplitN(uerr.URL, "?", 2)[0])
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// serviceURL returns the https:// URL of `path` on the service at `u`, or the
+// http:// one with `?scheme=http`.
+func serviceURL(u *url.URL, path string) string {
+	scheme := "https"
+	if u.Query().Get("scheme") == "http" {
+		scheme = "http"
+	}
+	return scheme + "://" + u.Host + path
+}
+
+// ntfyNotify publishes to the ntfy topic at `ntfy://[user:password@]host/topic`
+// (or `ntfy://:token@host/topic`).
+func ntfyNotify(ctx context.Context, client *http.Client, u *url.URL, n notification) error {
+	req, err := http.NewRequest("POST", serviceURL(u, u.Path), strings.NewReader(n.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", n.Title)
+	req.Header.Set("Tags", n.Event)
+	if n.failed() {
+		req.Header.Set("Priority", "high")
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		if u.User.Username() == "" {
+			req.Header.Set("Authorization", "Bearer "+password)
+		} else {
+			req.SetBasicAuth(u.User.Username(), password)
+		}
+	}
+	return notifyPost(ctx, client, req)
+}
+
+// gotifyNotify sends a message with the application token at
+// `gotify://host/token`.
+func gotifyNotify(ctx context.Context, client *http.Client, u *url.URL, n notification) error {
+	token := strings.Trim(u.Path, "/")
+	priority := 5
+	if n.failed() {
+		priority = 8
+	}
+	body, err := json.Marshal(map[string]interface{}{"title": n.Title, "message": n.Message, "priority": priority})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", serviceURL(u, "/message"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", token)
+	return notifyPost(ctx, client, req)
+}
+
+// pushoverEndpoint is where Pushover messages are sent.
+var pushoverEndpoint = "https://api.pushover.net/1/messages.json"
+
+// pushoverNotify sends a message with the application token to the user (or
+// group) key of `pushover://token@userkey`.
+func pushoverNotify(ctx context.Context, client *http.Client, u *url.URL, n notification) error {
+	if u.User == nil {
+		return fmt.Errorf("pushover needs pushover://<app token>@<user key>")
+	}
+	form := url.Values{
+		"token":   {u.User.Username()},
+		"user":    {u.Host},
+		"title":   {n.Title},
+		"message": {n.Message},
+	}
+	if n.failed() {
+		form.Set("priority", "1")
+	}
+	req, err := http.NewRequest("POST", pushoverEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return notifyPost(ctx, client, req)
+}
+
+// smtpNotify mails the notification through the server at
+// `smtp://[user:password@]host[:port]/?from=address&to=address`, which is
+// sent to every `to`. STARTTLS is used if the server offers it, and is
+// required to log in unless the server is on this machine.
+func smtpNotify(ctx context.Context, client *http.Client, u *url.URL, n notification) error {
+	q := u.Query()
+	from, to := q.Get("from"), q["to"]
+	if from == "" || len(to) == 0 {
+		return fmt.Errorf("smtp needs ?from=<address>&to=<address>")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "587")
+	}
+	var auth smtp.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		from, strings.Join(to, ", "), n.Title, time.Now().Format(time.RFC1123Z), n.Message)
+
+	// net/smtp takes no context, so the send is abandoned when it is done.
+	errs := make(chan error, 1)
+	go func() { errs <- smtp.SendMail(addr, auth, from, to, []byte(msg)) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestNewNotification(t *testing.T) {
+	base := HistoryEntry{Target: "nas", Mac: "00:11:22:33:44:55", By: "cli:alice"}
+
+	n, ok := newNotification(base)
+	assert.True(t, ok)
+	assert.Equal(t, notification{eventWake, "nas was woken", "Woke nas (00:11:22:33:44:55) for cli:alice"}, n)
+
+	e := base
+	e.Error = "network is unreachable"
+	n, _ = newNotification(e)
+	assert.Equal(t, eventWakeFailed, n.Event)
+	assert.True(t, n.failed())
+
+	e = base
+	e.Verify, e.UpAfter = "ssh", 44600*time.Millisecond
+	n, _ = newNotification(e)
+	assert.Equal(t, notification{eventUp, "nas is up", "nas (00:11:22:33:44:55) answered ssh after 45s"}, n)
+
+	e.Error = "timed out"
+	n, _ = newNotification(e)
+	assert.Equal(t, eventVerifyFailed, n.Event)
+	assert.Equal(t, "nas did not come up", n.Title)
+
+	e = base
+	e.Coalesced = true
+	_, ok = newNotification(e)
+	assert.False(t, ok)
+}
+
+func TestParseNotifyTarget(t *testing.T) {
+	nt, err := parseNotifyTarget("ntfy://ntfy.sh/wol")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{eventWakeFailed: true, eventVerifyFailed: true}, nt.events)
+	assert.Equal(t, "ntfy.sh", nt.url.Host)
+
+	nt, err = parseNotifyTarget("up,verify-failed=smtp://mail.lan/?from=wol@lan&to=me@lan")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{eventUp: true, eventVerifyFailed: true}, nt.events)
+	assert.Equal(t, []string{"me@lan"}, nt.url.Query()["to"])
+
+	nt, err = parseNotifyTarget("all=gotify://gotify.lan/token")
+	assert.Nil(t, err)
+	assert.Len(t, nt.events, 4)
+
+	defer os.Unsetenv("WOL_TEST_NOTIFY")
+	os.Setenv("WOL_TEST_NOTIFY", "pushover://apptoken@userkey")
+	nt, err = parseNotifyTarget("wake=env:WOL_TEST_NOTIFY")
+	assert.Nil(t, err)
+	assert.Equal(t, "pushover", nt.url.Scheme)
+
+	for _, spec := range []string{"slack://hooks", "ntfy:topic", "booted=ntfy://ntfy.sh/wol", "env:WOL_TEST_UNSET"} {
+		_, err := parseNotifyTarget(spec)
+		assert.NotNil(t, err, spec)
+	}
+	_, err = parseNotifyTarget("gotify://user:secret@/x")
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestNotifyBackends(t *testing.T) {
+	var got *http.Request
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(bs)
+		if r.URL.Path == "/denied" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	n := notification{eventVerifyFailed, "nas did not come up", "nas (00:11:22:33:44:55) did not come up"}
+
+	notify := func(raw string) error {
+		u, err := url.Parse(raw)
+		assert.Nil(t, err)
+		return notifyBackends[u.Scheme](context.Background(), http.DefaultClient, u, n)
+	}
+
+	assert.Nil(t, notify("ntfy://:tk_secret@"+host+"/wol?scheme=http"))
+	assert.Equal(t, "/wol", got.URL.Path)
+	assert.Equal(t, "nas did not come up", got.Header.Get("Title"))
+	assert.Equal(t, "high", got.Header.Get("Priority"))
+	assert.Equal(t, "Bearer tk_secret", got.Header.Get("Authorization"))
+	assert.Equal(t, n.Message, body)
+
+	assert.Nil(t, notify("gotify://"+host+"/apptoken?scheme=http"))
+	assert.Equal(t, "/message", got.URL.Path)
+	assert.Equal(t, "", got.URL.RawQuery)
+	assert.Equal(t, "apptoken", got.Header.Get("X-Gotify-Key"))
+	var msg map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &msg))
+	assert.Equal(t, n.Title, msg["title"])
+	assert.Equal(t, float64(8), msg["priority"])
+
+	defer func(endpoint string) { pushoverEndpoint = endpoint }(pushoverEndpoint)
+	pushoverEndpoint = ts.URL + "/1/messages.json"
+	assert.Nil(t, notify("pushover://apptoken@userkey"))
+	form, _ := url.ParseQuery(body)
+	assert.Equal(t, "apptoken", form.Get("token"))
+	assert.Equal(t, "userkey", form.Get("user"))
+	assert.Equal(t, "1", form.Get("priority"))
+	assert.NotNil(t, notify("pushover://userkey"))
+
+	err := notify("ntfy://" + host + "/denied?scheme=http")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "401")
+
+	assert.NotNil(t, notify("smtp://mail.lan/?from=wol@lan"))
+
+	// Failed requests do not give away credentials.
+	ts.Close()
+	err = notify("ntfy://wol:s3cret@" + host + "/wol?scheme=http")
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "s3cret")
+	req, _ := http.NewRequest("POST", ts.URL+"/message?token=apptoken", nil)
+	err = notifyPost(context.Background(), http.DefaultClient, req)
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "apptoken")
+}
+
+func TestNotifyDispatcher(t *testing.T) {
+	requests := make(chan string, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.Path + " " + r.Header.Get("Tags")
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	defer func(specs []string) { cliFlags.Notify, notifier = specs, nil }(cliFlags.Notify)
+	cliFlags.Notify = []string{"ntfy://" + host + "/failures?scheme=http", "all=ntfy://" + host + "/all?scheme=http"}
+	closer, err := setupNotify()
+	assert.Nil(t, err)
+
+	aliases, cleanup := openTestAliases(t, "./TestNotifyDispatcher.db")
+	defer cleanup()
+	recordWake(aliases, HistoryEntry{Time: time.Now(), Target: "nas", Mac: "00:11:22:33:44:55"})
+	recordWake(aliases, HistoryEntry{Time: time.Now(), Target: "nas", Mac: "00:11:22:33:44:55", Error: "no route"})
+	closer.Close()
+	close(requests)
+
+	var got []string
+	for r := range requests {
+		got = append(got, r)
+	}
+	sort.Strings(got)
+	assert.Equal(t, []string{"/all wake", "/all wake-failed", "/failures wake-failed"}, got)
+}
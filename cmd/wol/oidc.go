@@ -0,0 +1,534 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	oidcSessionCookie = "wol_session"
+	oidcStateCookie   = "wol_oidc_state"
+
+	// oidcSessionLifetime is how long a dashboard login lasts.
+	oidcSessionLifetime = 12 * time.Hour
+
+	// oidcLoginLifetime is how long the identity provider may take to send
+	// the user back.
+	oidcLoginLifetime = 10 * time.Minute
+)
+
+// userContextKey is the request context key of the logged in user's name.
+type userContextKey struct{}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// oidcProvider logs dashboard users in with an OpenID Connect identity
+// provider (Authentik, Keycloak, ...), through the authorization code flow.
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	groupsClaim  string
+
+	authURL  string
+	tokenURL string
+	jwksURL  string
+	client   *http.Client
+
+	mtx  sync.Mutex
+	keys map[string]crypto.PublicKey // by key id, from the provider's JWKS
+
+	// sessionKey signs session cookies. It is random, so that restarting
+	// serve logs everyone out.
+	sessionKey []byte
+}
+
+// oidcSession is what a session cookie holds about the logged in user.
+type oidcSession struct {
+	User    string   `json:"user"`
+	Groups  []string `json:"groups"`
+	Expires int64    `json:"exp"`
+}
+
+// newOIDCProvider returns the provider configured by the `--oidc-*` options
+// of serve, or nil if OIDC is not configured. It fetches the provider's
+// discovery document.
+func newOIDCProvider(ctx context.Context) (*oidcProvider, error) {
+	if cliFlags.OIDCIssuer == "" {
+		return nil, nil
+	}
+	if cliFlags.OIDCClientID == "" || cliFlags.OIDCRedirectURL == "" {
+		return nil, errors.New("--oidc-issuer requires --oidc-client-id and --oidc-redirect-url")
+	}
+	secret := cliFlags.OIDCClientSecret
+	if isSecretRef(secret) {
+		var err error
+		if secret, err = resolveSecret(secret, "OIDC client secret: "); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &oidcProvider{
+		issuer:       strings.TrimRight(cliFlags.OIDCIssuer, "/"),
+		clientID:     cliFlags.OIDCClientID,
+		clientSecret: secret,
+		redirectURL:  cliFlags.OIDCRedirectURL,
+		groupsClaim:  cliFlags.OIDCGroupsClaim,
+		client:       &http.Client{Timeout: timeoutFor(opAPI)},
+		keys:         map[string]crypto.PublicKey{},
+		sessionKey:   make([]byte, 32),
+	}
+	if p.groupsClaim == "" {
+		p.groupsClaim = "groups"
+	}
+	if _, err := rand.Read(p.sessionKey); err != nil {
+		return nil, err
+	}
+
+	var discovery struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("OIDC discovery returned the issuer %s, not %s", discovery.Issuer, p.issuer)
+	}
+	p.authURL, p.tokenURL, p.jwksURL = discovery.AuthURL, discovery.TokenURL, discovery.JWKSURL
+	return p, nil
+}
+
+// getJSON decodes the JSON at `url` into `v`.
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	body, err := readOIDCResponse(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// readOIDCResponse reads (and closes) the body of the provider's `resp`.
+func readOIDCResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity provider returned %s", resp.Status)
+	}
+	return body, nil
+}
+
+// randomString returns a random, URL safe string.
+func randomString() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// exchange trades the authorization `code` for the user's ID token.
+func (p *oidcProvider) exchange(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.redirectURL},
+	}
+	req, err := http.NewRequest("POST", p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	body, err := readOIDCResponse(resp)
+	if err != nil {
+		return "", err
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return "", errors.New("the OIDC token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// publicKey returns the provider's key `kid`, fetching its JWKS again if the
+// key is not known (yet), as providers rotate keys.
+func (p *oidcProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mtx.Lock()
+	key, ok := p.keys[kid]
+	p.mtx.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURL, &jwks); err != nil {
+		return nil, fmt.Errorf("fetching the OIDC keys failed: %v", err)
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, k := range jwks.Keys {
+		if pub, err := k.publicKey(); err == nil {
+			p.keys[k.Kid] = pub
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown OIDC key %q", kid)
+}
+
+// jwk is a JSON Web Key, as found in a JWKS.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA or P-256 public key `k` describes.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch {
+	case k.Kty == "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// verifyIDToken checks the signature (RS256 or ES256) and claims of the ID
+// token `raw`, and returns the session of the user it identifies.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, raw, nonce string) (oidcSession, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return oidcSession{}, errors.New("malformed ID token")
+	}
+	b64 := base64.RawURLEncoding
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if data, err := b64.DecodeString(parts[0]); err != nil || json.Unmarshal(data, &header) != nil {
+		return oidcSession{}, errors.New("malformed ID token header")
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return oidcSession{}, errors.New("malformed ID token signature")
+	}
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return oidcSession{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return oidcSession{}, errors.New("invalid ID token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return oidcSession{}, errors.New("invalid ID token signature")
+		}
+	default:
+		return oidcSession{}, errors.New("invalid ID token signature")
+	}
+
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return oidcSession{}, errors.New("malformed ID token claims")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return oidcSession{}, errors.New("malformed ID token claims")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != p.issuer {
+		return oidcSession{}, fmt.Errorf("ID token issued by %q", iss)
+	}
+	if !containsString(claimStrings(claims["aud"]), p.clientID) {
+		return oidcSession{}, errors.New("ID token issued for another client")
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().Unix() > int64(exp) {
+		return oidcSession{}, errors.New("ID token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return oidcSession{}, errors.New("ID token nonce mismatch")
+	}
+
+	sess := oidcSession{
+		Groups:  claimStrings(claims[p.groupsClaim]),
+		Expires: time.Now().Add(oidcSessionLifetime).Unix(),
+	}
+	for _, claim := range []string{"preferred_username", "email", "sub"} {
+		if user, _ := claims[claim].(string); user != "" {
+			sess.User = user
+			break
+		}
+	}
+	return sess, nil
+}
+
+// claimStrings returns the strings of a claim which is a string or an array
+// of them.
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// containsString returns true if `list` contains `s`.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// signed returns `value` followed by its HMAC under the session key.
+func (p *oidcProvider) signed(value string) string {
+	mac := hmac.New(sha256.New, p.sessionKey)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unsigned returns the value of `signed`, if its HMAC is valid.
+func (p *oidcProvider) unsigned(signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 || !hmac.Equal([]byte(p.signed(signed[:i])), []byte(signed)) {
+		return "", false
+	}
+	return signed[:i], true
+}
+
+// session returns the session of the request's cookie, if it is valid.
+func (p *oidcProvider) session(r *http.Request) (oidcSession, bool) {
+	cookie, err := r.Cookie(oidcSessionCookie)
+	if err != nil {
+		return oidcSession{}, false
+	}
+	value, ok := p.unsigned(cookie.Value)
+	if !ok {
+		return oidcSession{}, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	var sess oidcSession
+	if err != nil || json.Unmarshal(data, &sess) != nil || time.Now().Unix() > sess.Expires {
+		return oidcSession{}, false
+	}
+	return sess, true
+}
+
+// setCookie sets the cookie `name` to `value` for `maxAge` seconds (or
+// removes it if negative). Cookies are only sent back with same-site
+// requests, so that other sites cannot wake machines with them.
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) {
+	cookie := &http.Cookie{Name: name, Value: value, Path: "/", MaxAge: maxAge, HttpOnly: true, Secure: r.TLS != nil}
+	w.Header().Add("Set-Cookie", cookie.String()+"; SameSite=Lax")
+}
+
+// sessionToken returns the token whose groups the user of `sess` is in, the
+// first by token name should there be several.
+func sessionToken(sess oidcSession, tokens map[string]Token) *Token {
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tok := tokens[name]
+		for _, group := range tok.Groups {
+			if containsString(sess.Groups, group) {
+				return &tok
+			}
+		}
+	}
+	return nil
+}
+
+// tokensHaveGroups returns true if any token in `aliases` is mapped to a
+// group, which OIDC users need to be in to use the API.
+func tokensHaveGroups(aliases *Aliases) bool {
+	tokens, err := aliases.Tokens()
+	if err != nil {
+		return false
+	}
+	for _, tok := range tokens {
+		if len(tok.Groups) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// enableOIDC lets dashboard users log in with `p`.
+func (s *server) enableOIDC(p *oidcProvider) {
+	s.oidc = p
+	s.mux.HandleFunc("/oidc/login", s.handleOIDCLogin)
+	s.mux.HandleFunc("/oidc/callback", s.handleOIDCCallback)
+	s.mux.HandleFunc("/oidc/logout", s.handleOIDCLogout)
+}
+
+// handleOIDCLogin serves `GET /oidc/login`, which sends the user to the
+// identity provider.
+func (s *server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := randomString()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	p := s.oidc
+	setCookie(w, r, oidcStateCookie, p.signed(state+":"+nonce), int(oidcLoginLifetime.Seconds()))
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.redirectURL},
+		"scope":         {"openid profile email"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.authURL+sep+query.Encode(), http.StatusFound)
+}
+
+// handleOIDCCallback serves `GET /oidc/callback`, where the identity
+// provider sends the user back to, and logs them in.
+func (s *server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	p := s.oidc
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("login expired, try again"))
+		return
+	}
+	value, ok := p.unsigned(cookie.Value)
+	parts := strings.SplitN(value, ":", 2)
+	if !ok || len(parts) != 2 || r.URL.Query().Get("state") != parts[0] {
+		writeError(w, http.StatusBadRequest, errors.New("invalid login state"))
+		return
+	}
+	setCookie(w, r, oidcStateCookie, "", -1)
+	if e := r.URL.Query().Get("error"); e != "" {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("login failed: %s", e))
+		return
+	}
+
+	idToken, err := p.exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	sess, err := p.verifyIDToken(r.Context(), idToken, parts[1])
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	data, err := json.Marshal(sess)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	setCookie(w, r, oidcSessionCookie, p.signed(base64.RawURLEncoding.EncodeToString(data)), int(oidcSessionLifetime.Seconds()))
+	log.Printf("%s logged in from %s\n", sess.User, r.RemoteAddr)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// handleOIDCLogout serves `GET /oidc/logout`.
+func (s *server) handleOIDCLogout(w http.ResponseWriter, r *http.Request) {
+	setCookie(w, r, oidcSessionCookie, "", -1)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,244 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// fakeIdP is an OpenID Connect provider which issues ID tokens for whatever
+// code it is given.
+type fakeIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	idp := &fakeIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   b64.EncodeToString(key.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, secret, _ := r.BasicAuth(); user != "wol" || secret != "s3cret" || r.FormValue("code") != "abc" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id_token": idp.sign(t, idp.claims)})
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// sign returns an RS256 ID token carrying `claims`.
+func (idp *fakeIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	b64 := base64.RawURLEncoding
+	payload, err := json.Marshal(claims)
+	assert.Nil(t, err)
+	signed := b64.EncodeToString([]byte(`{"alg":"RS256","kid":"k1"}`)) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	assert.Nil(t, err)
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+// newTestOIDCProvider returns a provider using `idp`.
+func newTestOIDCProvider(t *testing.T, idp *fakeIdP) *oidcProvider {
+	defer func(f serveFlags) { cliFlags.serveFlags = f }(cliFlags.serveFlags)
+	cliFlags.OIDCIssuer = idp.URL
+	cliFlags.OIDCClientID = "wol"
+	cliFlags.OIDCClientSecret = "s3cret"
+	cliFlags.OIDCRedirectURL = "https://wol.example.com/oidc/callback"
+	cliFlags.OIDCGroupsClaim = "groups"
+
+	p, err := newOIDCProvider(context.Background())
+	assert.Nil(t, err)
+	return p
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.Close()
+	p := newTestOIDCProvider(t, idp)
+
+	claims := func(change map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":                idp.URL,
+			"aud":                []string{"other", "wol"},
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"nonce":              "n1",
+			"sub":                "1234",
+			"preferred_username": "alice",
+			"groups":             []string{"admins", "wol-lab"},
+		}
+		for k, v := range change {
+			c[k] = v
+		}
+		return c
+	}
+
+	sess, err := p.verifyIDToken(context.Background(), idp.sign(t, claims(nil)), "n1")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", sess.User)
+	assert.Equal(t, []string{"admins", "wol-lab"}, sess.Groups)
+
+	for _, tc := range []struct {
+		change map[string]interface{}
+		err    string
+	}{
+		{map[string]interface{}{"iss": "https://evil.example.com"}, `ID token issued by "https://evil.example.com"`},
+		{map[string]interface{}{"aud": "other"}, "ID token issued for another client"},
+		{map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}, "ID token expired"},
+		{map[string]interface{}{"nonce": "n2"}, "ID token nonce mismatch"},
+	} {
+		_, err := p.verifyIDToken(context.Background(), idp.sign(t, claims(tc.change)), "n1")
+		assert.EqualError(t, err, tc.err)
+	}
+
+	// Tokens must be signed by the provider's key.
+	raw := idp.sign(t, claims(nil))
+	tampered := raw[:strings.Index(raw, ".")+1] +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"`+idp.URL+`","aud":"wol","groups":["admins"]}`)) +
+		raw[strings.LastIndex(raw, "."):]
+	_, err = p.verifyIDToken(context.Background(), tampered, "n1")
+	assert.EqualError(t, err, "invalid ID token signature")
+}
+
+func TestSessionToken(t *testing.T) {
+	tokens := map[string]Token{
+		"b-ops": {Groups: []string{"ops"}},
+		"a-lab": {Groups: []string{"wol-lab", "ops"}},
+		"other": {},
+	}
+	for name, tok := range tokens {
+		tok.name = name
+		tokens[name] = tok
+	}
+	assert.Equal(t, "a-lab", sessionToken(oidcSession{Groups: []string{"ops"}}, tokens).name)
+	assert.Nil(t, sessionToken(oidcSession{Groups: []string{"guests"}}, tokens))
+}
+
+// Validates that OIDC login closes the API even without tokens.
+func (suite *ServerTests) TestOIDCWithoutTokens() {
+	t := suite.T()
+	idp := newFakeIdP(t)
+	defer idp.Close()
+	assert.Equal(t, http.StatusOK, suite.do("GET", "/aliases", nil).Code)
+
+	suite.server.enableOIDC(newTestOIDCProvider(t, idp))
+	assert.False(t, tokensHaveGroups(suite.aliases))
+	rec := suite.do("GET", "/aliases", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "/oidc/login", rec.Header().Get("X-Login"))
+	assert.Equal(t, http.StatusUnauthorized, suite.do("POST", "/wake/one", nil).Code)
+}
+
+// Validates logging in to the dashboard through OIDC.
+func (suite *ServerTests) TestOIDCLogin() {
+	t := suite.T()
+	idp := newFakeIdP(t)
+	defer idp.Close()
+	suite.server.enableOIDC(newTestOIDCProvider(t, idp))
+	assert.Nil(t, suite.aliases.AddToken("lab", Token{
+		Hash:   hashTokenSecret("lab"),
+		Tags:   []string{"lab"},
+		Groups: []string{"wol-lab"},
+	}))
+
+	rec := suite.do("GET", "/aliases", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "/oidc/login", rec.Header().Get("X-Login"))
+
+	// The login sends the user to the provider, remembering the state.
+	rec = suite.do("GET", "/oidc/login", nil)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, idp.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	query := location.Query()
+	assert.Equal(t, "wol", query.Get("client_id"))
+	stateCookie := rec.Result().Cookies()[0]
+	assert.Equal(t, oidcStateCookie, stateCookie.Name)
+
+	// Coming back with another state fails.
+	rec = suite.do("GET", "/oidc/callback?code=abc&state=forged", map[string]string{
+		"Cookie": stateCookie.Name + "=" + stateCookie.Value,
+	})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	idp.claims = map[string]interface{}{
+		"iss":    idp.URL,
+		"aud":    "wol",
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"nonce":  query.Get("nonce"),
+		"email":  "alice@example.com",
+		"groups": []string{"wol-lab"},
+	}
+	rec = suite.do("GET", "/oidc/callback?code=abc&state="+query.Get("state"), map[string]string{
+		"Cookie": stateCookie.Name + "=" + stateCookie.Value,
+	})
+	assert.Equal(t, http.StatusFound, rec.Code)
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcSessionCookie {
+			session = c
+		}
+	}
+	if !assert.NotNil(t, session) {
+		return
+	}
+	assert.True(t, session.HttpOnly)
+
+	// The session acts as the token mapped to the user's group.
+	cookie := map[string]string{"Cookie": session.Name + "=" + session.Value}
+	var page aliasPage
+	rec = suite.do("GET", "/aliases", cookie)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, 1, page.Total)
+
+	// Other sites cannot use the session to wake machines.
+	cookie["Origin"] = "https://evil.example.com"
+	rec = suite.do("POST", "/wake/one", cookie)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = suite.do("GET", "/aliases", map[string]string{"Cookie": session.Name + "=" + session.Value + "x"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
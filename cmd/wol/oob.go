@@ -0,0 +1,626 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	oobBucketName = "OOB"
+
+	amtPowerServiceURI = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_PowerManagementService"
+
+	// amtPowerStateRequest asks Intel AMT to change the power state of the
+	// managed system. It is formatted with a message id and the state.
+	amtPowerStateRequest = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:p="` + amtPowerServiceURI + `">
+<s:Header>
+<a:Action s:mustUnderstand="true">` + amtPowerServiceURI + `/RequestPowerStateChange</a:Action>
+<a:To s:mustUnderstand="true">/wsman</a:To>
+<w:ResourceURI s:mustUnderstand="true">` + amtPowerServiceURI + `</w:ResourceURI>
+<a:MessageID s:mustUnderstand="true">uuid:%s</a:MessageID>
+<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>
+<w:OperationTimeout>PT60S</w:OperationTimeout>
+</s:Header>
+<s:Body>
+<p:RequestPowerStateChange_INPUT>
+<p:PowerState>%d</p:PowerState>
+<p:ManagedElement>
+<a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address>
+<a:ReferenceParameters>
+<w:ResourceURI>http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ComputerSystem</w:ResourceURI>
+<w:SelectorSet>
+<w:Selector Name="CreationClassName">CIM_ComputerSystem</w:Selector>
+<w:Selector Name="Name">ManagedSystem</w:Selector>
+</w:SelectorSet>
+</a:ReferenceParameters>
+</p:ManagedElement>
+</p:RequestPowerStateChange_INPUT>
+</s:Body>
+</s:Envelope>`
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Power actions of management controllers.
+const (
+	powerOn      = "on"
+	powerOff     = "off" // gracefully, through the operating system
+	powerOffHard = "off-hard"
+)
+
+// OOB holds how to power the machine of an alias on (or off) out-of-band,
+// through its management controller: the Kind of controller (amt, ipmi or
+// redfish, or the libvirt or proxmox hypervisor of a VM), its Address and the
+// credentials to log in with. The password is kept outside the alias db,
+// where Secret refers to, unless storing it in the clear was allowed.
+type OOB struct {
+	Kind     string
+	Address  string
+	User     string
+	Password string
+	Insecure bool   // do not verify the controller's TLS certificate
+	Default  bool   // wake the alias through the controller, not a magic packet
+	VM       string // the VM (libvirt domain, Proxmox VMID or name) on a hypervisor
+	Secret   string // env:<NAME>, keyring:<name> or prompt, if Password is not stored
+}
+
+// oobBackends carry out a power action on a machine through the management
+// controller `oob`.
+var oobBackends = map[string]func(ctx context.Context, client *http.Client, oob OOB, action string) error{
+	"amt":     amtPower,
+	"ipmi":    ipmiPower,
+	"libvirt": libvirtPower,
+	"proxmox": proxmoxPower,
+	"redfish": redfishPower,
+}
+
+// oobStatusBackends return the power state of a machine through the
+// management controller `oob`, for the kinds which can report it.
+var oobStatusBackends = map[string]func(ctx context.Context, client *http.Client, oob OOB) (string, error){
+	"ipmi":    ipmiStatus,
+	"libvirt": libvirtStatus,
+	"proxmox": proxmoxStatus,
+	"redfish": redfishStatus,
+}
+
+// oobBackendNames returns the names of the OOB backends, sorted.
+func oobBackendNames() string {
+	var names []string
+	for name := range oobBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// baseURL returns the address of the controller as a URL without a trailing
+// slash, using `scheme` and `port` if the address does not give them.
+func (oob OOB) baseURL(scheme, port string) string {
+	addr := strings.TrimRight(oob.Address, "/")
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil && port != "" {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	return scheme + "://" + addr
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SetOOB stores (or replaces) the management controller of `alias`.
+func (a *Aliases) SetOOB(alias string, oob OOB) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(oob); err != nil {
+		return err
+	}
+	return a.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucketName)).Get([]byte(alias)) == nil {
+			return fmt.Errorf("alias (%s) not found in db", alias)
+		}
+		return tx.Bucket([]byte(oobBucketName)).Put([]byte(alias), buf.Bytes())
+	})
+}
+
+// DelOOB removes the management controller of `alias`.
+func (a *Aliases) DelOOB(alias string) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(oobBucketName)).Delete([]byte(alias))
+	})
+}
+
+// GetOOB returns the management controller of `alias`, and whether it has one.
+func (a *Aliases) GetOOB(alias string) (OOB, bool, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var oob OOB
+	var ok bool
+	err := a.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(oobBucketName)).Get([]byte(alias))
+		if value == nil {
+			return nil
+		}
+		ok = true
+		return gob.NewDecoder(bytes.NewBuffer(value)).Decode(&oob)
+	})
+	return oob, ok, err
+}
+
+// OOBs returns the aliases with a management controller, sorted.
+func (a *Aliases) OOBs() ([]string, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	names := []string{}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(oobBucketName)).ForEach(func(k, v []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	return names, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// oobRequest sends `req`, with basic authentication if `user` is set, and
+// returns the body of the controller's (successful) response.
+func oobRequest(client *http.Client, req *http.Request, user, password string) ([]byte, error) {
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readOOBResponse(resp)
+}
+
+// readOOBResponse reads (and closes) the body of the controller's `resp`.
+func readOOBResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("management controller returned %s", resp.Status)
+	}
+	return body, nil
+}
+
+// redfishSystem returns the URL of the first system of the Redfish service
+// `oob`.
+func redfishSystem(ctx context.Context, client *http.Client, oob OOB) (string, error) {
+	base := oob.baseURL("https", "")
+	req, err := http.NewRequest("GET", base+"/redfish/v1/Systems", nil)
+	if err != nil {
+		return "", err
+	}
+	body, err := oobRequest(client, req.WithContext(ctx), oob.User, oob.Password)
+	if err != nil {
+		return "", err
+	}
+	var systems struct {
+		Members []struct {
+			ID string `json:"@odata.id"`
+		}
+	}
+	if err := json.Unmarshal(body, &systems); err != nil {
+		return "", fmt.Errorf("invalid Redfish response: %v", err)
+	}
+	if len(systems.Members) == 0 || systems.Members[0].ID == "" {
+		return "", errors.New("the Redfish service manages no systems")
+	}
+	return base + systems.Members[0].ID, nil
+}
+
+// redfishPower resets the first system of a Redfish service (iDRAC, iLO,
+// XClarity, OpenBMC, ...) to on or off.
+func redfishPower(ctx context.Context, client *http.Client, oob OOB, action string) error {
+	resetType, ok := map[string]string{
+		powerOn:      "On",
+		powerOff:     "GracefulShutdown",
+		powerOffHard: "ForceOff",
+	}[action]
+	if !ok {
+		return fmt.Errorf("redfish cannot power %s", action)
+	}
+
+	system, err := redfishSystem(ctx, client, oob)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", system+"/Actions/ComputerSystem.Reset", strings.NewReader(`{"ResetType":"`+resetType+`"}`))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = oobRequest(client, req.WithContext(ctx), oob.User, oob.Password)
+	return err
+}
+
+// redfishStatus returns the power state of the first system of a Redfish
+// service: on, off, powering on or powering off.
+func redfishStatus(ctx context.Context, client *http.Client, oob OOB) (string, error) {
+	system, err := redfishSystem(ctx, client, oob)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", system, nil)
+	if err != nil {
+		return "", err
+	}
+	body, err := oobRequest(client, req.WithContext(ctx), oob.User, oob.Password)
+	if err != nil {
+		return "", err
+	}
+	var state struct {
+		PowerState string
+	}
+	if err := json.Unmarshal(body, &state); err != nil {
+		return "", fmt.Errorf("invalid Redfish response: %v", err)
+	}
+	switch state.PowerState {
+	case "On", "Off":
+		return strings.ToLower(state.PowerState), nil
+	case "PoweringOn":
+		return "powering on", nil
+	case "PoweringOff":
+		return "powering off", nil
+	}
+	return "", fmt.Errorf("the Redfish service reports an unknown power state %q", state.PowerState)
+}
+
+// amtPower asks Intel AMT to power its machine on or off through
+// WS-Management, which requires digest authentication.
+func amtPower(ctx context.Context, client *http.Client, oob OOB, action string) error {
+	state, ok := map[string]int{
+		powerOn:      2,
+		powerOff:     12,
+		powerOffHard: 8,
+	}[action]
+	if !ok {
+		return fmt.Errorf("amt cannot power %s", action)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	messageID := fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+	body := fmt.Sprintf(amtPowerStateRequest, messageID, state)
+	url := oob.baseURL("http", "16992") + "/wsman"
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+		return req.WithContext(ctx), nil
+	}
+	resp, err := digestDo(client, newRequest, oob.User, oob.Password)
+	if err != nil {
+		return err
+	}
+	out, err := readOOBResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	var envelope struct {
+		ReturnValue *int `xml:"Body>RequestPowerStateChange_OUTPUT>ReturnValue"`
+	}
+	if err := xml.Unmarshal(out, &envelope); err != nil || envelope.ReturnValue == nil {
+		return errors.New("invalid AMT response: missing return value")
+	}
+	if *envelope.ReturnValue != 0 {
+		return fmt.Errorf("AMT refused to power %s (return value %d)", action, *envelope.ReturnValue)
+	}
+	return nil
+}
+
+// powerOnOOB powers on the machine of `alias` through its management
+// controller, within the API timeout. The attempt is added to the history.
+func powerOnOOB(alias string, oob OOB, aliases *Aliases) error {
+	entry := HistoryEntry{
+		Time:   time.Now(),
+		Target: alias,
+		By:     cliUser(),
+		OOB:    oob.Kind,
+	}
+	if mi, err := aliases.Get(alias); err == nil {
+		entry.Mac = mi.Mac
+	}
+
+	err := runOOBBackend(oob, powerOn)
+	if err != nil {
+		err = fmt.Errorf("powering on %s through %s failed: %v", alias, oob.Kind, err)
+		entry.Error = err.Error()
+	}
+	recordWake(aliases, entry)
+	if err != nil {
+		return err
+	}
+	statusf("%s was powered on through %s\n", alias, oob.Kind)
+	return nil
+}
+
+// runOOBBackend carries out the power `action` on the machine behind `oob`
+// with its backend.
+func runOOBBackend(oob OOB, action string) error {
+	backend, ok := oobBackends[oob.Kind]
+	if !ok {
+		return fmt.Errorf("unknown management controller %q (expected one of %s)", oob.Kind, oobBackendNames())
+	}
+	return withOOB(oob, func(ctx context.Context, client *http.Client, oob OOB) error {
+		return backend(ctx, client, oob, action)
+	})
+}
+
+// oobStatus returns the power state of the machine behind `oob`.
+func oobStatus(oob OOB) (string, error) {
+	backend, ok := oobStatusBackends[oob.Kind]
+	if !ok {
+		return "", fmt.Errorf("%s cannot report the power state", oob.Kind)
+	}
+	var state string
+	err := withOOB(oob, func(ctx context.Context, client *http.Client, oob OOB) error {
+		var err error
+		state, err = backend(ctx, client, oob)
+		return err
+	})
+	return state, err
+}
+
+// withOOB calls `fn` with an HTTP client for the controller `oob` and a
+// context bound by the API timeout, looking up (or prompting for) the
+// password first if it is not stored.
+func withOOB(oob OOB, fn func(ctx context.Context, client *http.Client, oob OOB) error) error {
+	secret := oob.Secret
+	if secret == "" && oob.User != "" && oob.Password == "" {
+		secret = "prompt"
+	}
+	if secret != "" {
+		var err error
+		prompt := fmt.Sprintf("Password of %s on %s: ", oob.User, oob.Address)
+		if oob.Password, err = resolveSecret(secret, prompt); err != nil {
+			return err
+		}
+	}
+
+	client := &http.Client{}
+	if oob.Insecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	ctx, cancel := withTimeout(context.Background(), opAPI)
+	defer cancel()
+	return fn(ctx, client, oob)
+}
+
+// keepSecret records in `oob` where its password is kept, `secret`. A
+// password given with it is filed in the keyring if the secret is kept there;
+// otherwise it is only stored (in the clear) with --allow-plaintext.
+func keepSecret(oob *OOB, secret string) error {
+	if secret != "" && !isSecretRef(secret) {
+		return fmt.Errorf("invalid --secret %q (%v)", secret, errSecretRef)
+	}
+	oob.Secret = secret
+	switch {
+	case oob.Password == "":
+		return nil
+	case strings.HasPrefix(secret, "keyring:"):
+		if err := keyringSet(strings.TrimPrefix(secret, "keyring:"), oob.Password); err != nil {
+			return fmt.Errorf("storing the password in the keyring failed: %v", err)
+		}
+		oob.Password = ""
+		return nil
+	case secret != "":
+		return fmt.Errorf("a password cannot be given with --secret %s", secret)
+	case !cliFlags.AllowPlaintext:
+		return errors.New("refusing to store the password in the alias db in the clear: give --secret (env:<NAME>, keyring:<name> or prompt), or --allow-plaintext")
+	}
+	return nil
+}
+
+// defaultOOB returns the management controller of `alias` if the alias is
+// woken through it rather than with a magic packet.
+func defaultOOB(alias string, aliases *Aliases) (OOB, bool) {
+	oob, ok, err := aliases.GetOOB(alias)
+	if err != nil || !ok || !oob.Default {
+		return OOB{}, false
+	}
+	return oob, true
+}
+
+// wakeOOB powers on the machine of `alias` through its management
+// controller, which must be of the kind `method`.
+func wakeOOB(alias, method string, aliases *Aliases) error {
+	if _, ok := oobBackends[method]; !ok {
+		return fmt.Errorf("unknown wake method %q (expected wol or one of %s)", method, oobBackendNames())
+	}
+	oob, ok, err := aliases.GetOOB(alias)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("alias %s has no management controller", alias)
+	}
+	if oob.Kind != method {
+		return fmt.Errorf("the management controller of %s is %s, not %s", alias, oob.Kind, method)
+	}
+
+	progressf("Powering on %s through %s at %s\n", alias, oob.Kind, oob.Address)
+	return powerOnOOB(alias, oob, aliases)
+}
+
+// oobFallback powers on the machine of `alias` out-of-band after its magic
+// packet failed with `cause`, if `--oob` was given and the alias has a
+// management controller. Otherwise it returns `cause`.
+func oobFallback(alias string, aliases *Aliases, cause error) error {
+	if !cliFlags.OOB {
+		return cause
+	}
+	oob, ok, err := aliases.GetOOB(alias)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%v (and %s has no management controller to fall back to)", cause, alias)
+	}
+
+	progressf("Waking %s failed (%v), powering it on through %s at %s\n", alias, cause, oob.Kind, oob.Address)
+	return powerOnOOB(alias, oob, aliases)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Run the oob command.
+func oobCmd(args []string, aliases *Aliases) error {
+	if len(args) == 0 {
+		return errors.New("oob command requires one of: set <alias> <kind> <address>, list, on <alias>, status <alias>, remove <alias>")
+	}
+
+	switch sub, args := strings.ToLower(args[0]), args[1:]; sub {
+	case "set":
+		if len(args) != 3 {
+			return errors.New("oob set requires an <alias>, a <kind> and an <address>")
+		}
+		oob := OOB{
+			Kind:     strings.ToLower(args[1]),
+			Address:  args[2],
+			Insecure: cliFlags.OOBInsecure,
+			Default:  cliFlags.OOBDefault,
+		}
+		if _, ok := oobBackends[oob.Kind]; !ok {
+			return fmt.Errorf("unknown management controller %q (expected one of %s)", oob.Kind, oobBackendNames())
+		}
+		// VMs are woken through their hypervisor, by default by alias name.
+		if hypervisorKinds[oob.Kind] {
+			oob.Default, oob.VM = true, cliFlags.OOBVM
+			if oob.VM == "" {
+				oob.VM = args[0]
+			}
+		}
+		// The password is never given on the command line: it is read from
+		// --secret, or prompted for when needed, unless it is to be stored
+		// in the clear, when it is prompted for now.
+		oob.User = cliFlags.OOBUser
+		if oob.User != "" && cliFlags.OOBSecret == "" && cliFlags.AllowPlaintext {
+			password, err := promptPassword(fmt.Sprintf("Password of %s at %s: ", oob.User, oob.Address))
+			if err != nil {
+				return err
+			}
+			oob.Password = password
+		}
+		if err := keepSecret(&oob, cliFlags.OOBSecret); err != nil {
+			return err
+		}
+		return aliases.SetOOB(args[0], oob)
+
+	case "list":
+		names, err := aliases.OOBs()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			progressf("No management controllers found! Add one with \"wol oob set <alias> <kind> <address>\"\n")
+			return nil
+		}
+		for _, name := range names {
+			oob, _, err := aliases.GetOOB(name)
+			if err != nil {
+				return err
+			}
+			user := oob.User
+			switch {
+			case user == "":
+				user = "no user"
+			case oob.Secret != "" && oob.Secret != "prompt":
+				user += ", password from " + oob.Secret
+			case oob.Password == "":
+				user += ", password prompted"
+			default:
+				user += ", password stored in the clear"
+			}
+			if oob.Default {
+				user += ", wakes through it"
+			}
+			kind := oob.Kind
+			if oob.VM != "" {
+				kind += " vm " + oob.VM
+			}
+			fmt.Printf("    %s - %s at %s (%s)\n", name, kind, oob.Address, user)
+		}
+		return nil
+
+	case "on":
+		if len(args) != 1 {
+			return errors.New("oob on requires an <alias>")
+		}
+		oob, ok, err := aliases.GetOOB(args[0])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("alias %s has no management controller", args[0])
+		}
+		return powerOnOOB(args[0], oob, aliases)
+
+	case "status":
+		if len(args) != 1 {
+			return errors.New("oob status requires an <alias>")
+		}
+		oob, ok, err := aliases.GetOOB(args[0])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("alias %s has no management controller", args[0])
+		}
+		state, err := oobStatus(oob)
+		if err != nil {
+			return fmt.Errorf("querying %s through %s failed: %v", args[0], oob.Kind, err)
+		}
+		fmt.Printf("%s is %s (%s)\n", args[0], state, oob.Kind)
+		return nil
+
+	case "remove":
+		if len(args) != 1 {
+			return errors.New("oob remove requires an <alias>")
+		}
+		return aliases.DelOOB(args[0])
+	}
+	return fmt.Errorf("unknown oob command %q", args[0])
+}
@@ -0,0 +1,145 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestOOBStore(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestOOBStore.db")
+	defer cleanup()
+
+	oob := OOB{Kind: "redfish", Address: "idrac.lan", User: "root", Password: "calvin"}
+	assert.EqualError(t, aliases.SetOOB("nas", oob), "alias (nas) not found in db")
+
+	assert.Nil(t, aliases.Add("nas", "00:11:22:aa:bb:cc", ""))
+	assert.Nil(t, aliases.SetOOB("nas", oob))
+	got, ok, err := aliases.GetOOB("nas")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, oob, got)
+	names, err := aliases.OOBs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nas"}, names)
+
+	// Removing the alias removes its management controller.
+	assert.Nil(t, aliases.Del("nas"))
+	_, ok, err = aliases.GetOOB("nas")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestOOBBaseURL(t *testing.T) {
+	assert.Equal(t, "http://amt.lan:16992", OOB{Address: "amt.lan"}.baseURL("http", "16992"))
+	assert.Equal(t, "http://amt.lan:8080", OOB{Address: "amt.lan:8080"}.baseURL("http", "16992"))
+	assert.Equal(t, "https://amt.lan:16993", OOB{Address: "https://amt.lan:16993/"}.baseURL("http", "16992"))
+	assert.Equal(t, "https://idrac.lan", OOB{Address: "idrac.lan"}.baseURL("https", ""))
+}
+
+func TestRedfishPower(t *testing.T) {
+	var reset string
+	state := "Off"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "root" || password != "calvin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/redfish/v1/Systems":
+			w.Write([]byte(`{"Members": [{"@odata.id": "/redfish/v1/Systems/System.Embedded.1"}]}`))
+		case r.Method == "GET" && r.URL.Path == "/redfish/v1/Systems/System.Embedded.1":
+			w.Write([]byte(`{"Id": "System.Embedded.1", "PowerState": "` + state + `"}`))
+		case r.Method == "POST" && r.URL.Path == "/redfish/v1/Systems/System.Embedded.1/Actions/ComputerSystem.Reset":
+			body, _ := ioutil.ReadAll(r.Body)
+			reset = string(body)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	oob := OOB{Kind: "redfish", Address: server.URL, User: "root", Password: "calvin"}
+	assert.Nil(t, redfishPower(context.Background(), server.Client(), oob, powerOn))
+	assert.Equal(t, `{"ResetType":"On"}`, reset)
+	assert.Nil(t, redfishPower(context.Background(), server.Client(), oob, powerOffHard))
+	assert.Equal(t, `{"ResetType":"ForceOff"}`, reset)
+
+	for reported, expected := range map[string]string{"Off": "off", "On": "on", "PoweringOn": "powering on"} {
+		state = reported
+		got, err := redfishStatus(context.Background(), server.Client(), oob)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, got)
+	}
+	state = "Paused"
+	_, err := redfishStatus(context.Background(), server.Client(), oob)
+	assert.EqualError(t, err, `the Redfish service reports an unknown power state "Paused"`)
+
+	oob.Password = "wrong"
+	err = redfishPower(context.Background(), server.Client(), oob, powerOn)
+	assert.EqualError(t, err, "management controller returned 401 Unauthorized")
+}
+
+func TestAMTPowerOn(t *testing.T) {
+	returnValue := "0"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		auth := r.Header.Get("Authorization")
+		if r.URL.Path != "/wsman" || auth == "" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="Digest:A3829B3827DE4D33D4449B366831FD01", nonce="Q1w2", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		cnonce := auth[strings.Index(auth, `cnonce="`)+8:]
+		cnonce = cnonce[:strings.Index(cnonce, `"`)]
+		ha1 := md5Hex("admin", "Digest:A3829B3827DE4D33D4449B366831FD01", "P@ssw0rd")
+		expected := md5Hex(ha1, "Q1w2", "00000001", cnonce, "auth", md5Hex("POST", "/wsman"))
+		if !strings.Contains(auth, `response="`+expected+`"`) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.Contains(string(body), "<p:PowerState>2</p:PowerState>") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<a:Envelope xmlns:a="http://www.w3.org/2003/05/soap-envelope" xmlns:g="` + amtPowerServiceURI + `"><a:Body>
+<g:RequestPowerStateChange_OUTPUT><g:ReturnValue>` + returnValue + `</g:ReturnValue></g:RequestPowerStateChange_OUTPUT>
+</a:Body></a:Envelope>`))
+	}))
+	defer server.Close()
+
+	oob := OOB{Kind: "amt", Address: server.URL, User: "admin", Password: "P@ssw0rd"}
+	assert.Nil(t, amtPower(context.Background(), server.Client(), oob, powerOn))
+
+	returnValue = "2"
+	err := amtPower(context.Background(), server.Client(), oob, powerOn)
+	assert.EqualError(t, err, "AMT refused to power on (return value 2)")
+
+	oob.Password = "wrong"
+	assert.NotNil(t, amtPower(context.Background(), server.Client(), oob, powerOn))
+}
+
+func TestOOBFallback(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestOOBFallback.db")
+	defer cleanup()
+	defer func(oob bool) { cliFlags.OOB = oob }(cliFlags.OOB)
+
+	cause := context.DeadlineExceeded
+	cliFlags.OOB = false
+	assert.Equal(t, cause, oobFallback("nas", aliases, cause))
+
+	cliFlags.OOB = true
+	err := oobFallback("nas", aliases, cause)
+	assert.EqualError(t, err, "context deadline exceeded (and nas has no management controller to fall back to)")
+}
@@ -0,0 +1,40 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"os"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Commands print what they produce (listings, exports, reports) to stdout
+// with fmt.Printf, so that it can be piped, and everything else through the
+// helpers below. Errors and warnings always go to stderr. What is printed
+// while progress is shown has to clear its line first.
+
+// progressf prints what a command is doing, and hints such as an empty
+// listing, to stderr unless `--quiet` is set.
+func progressf(format string, args ...interface{}) {
+	if !cliFlags.Quiet {
+		clearProgress()
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// statusf prints the outcome of an action, such as a magic packet having
+// been sent, to stdout unless `--quiet` is set. The exit code tells it then.
+func statusf(format string, args ...interface{}) {
+	if !cliFlags.Quiet {
+		clearProgress()
+		fmt.Printf(format, args...)
+	}
+}
+
+// errorf prints a failure which does not stop the command, such as one
+// machine of a group failing to wake, to stderr.
+func errorf(format string, args ...interface{}) {
+	clearProgress()
+	fmt.Fprintf(os.Stderr, format, args...)
+}
@@ -0,0 +1,284 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// EtherTypes of the frames passive scans look into.
+	etherTypeARP  = 0x0806
+	etherTypeIPv4 = 0x0800
+	etherTypeVLAN = 0x8100
+
+	// Ports of the traffic passive scans learn hosts from.
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+	mdnsPort       = 5353
+
+	// dhcpMagicCookie starts the options of a DHCP message.
+	dhcpMagicCookie = 0x63825363
+
+	// DHCP options passive scans read.
+	dhcpOptRequestedIP = 50
+	dhcpOptMessageType = 53
+	dhcpOptEnd         = 255
+
+	// dhcpAck is the DHCP message type which assigns an address.
+	dhcpAck = 5
+
+	// How often frame captures check whether they should stop.
+	capturePollInterval = 200 * time.Millisecond
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// parseFrame returns the host whose address and MAC an Ethernet frame gives
+// away, if any: the sender of an ARP packet, the client of a DHCP exchange
+// or the responder of an mDNS query.
+func parseFrame(frame []byte) (scanHost, bool) {
+	if len(frame) < 14 {
+		return scanHost{}, false
+	}
+	src := net.HardwareAddr(frame[6:12])
+	// Frames captured on a trunk may carry the 802.1Q tag of their VLAN.
+	if binary.BigEndian.Uint16(frame[12:14]) == etherTypeVLAN {
+		if len(frame) < 18 {
+			return scanHost{}, false
+		}
+		frame = append(frame[:12:12], frame[16:]...)
+	}
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case etherTypeARP:
+		return parseARP(frame[14:])
+	case etherTypeIPv4:
+		return parseIPv4(src, frame[14:])
+	}
+	return scanHost{}, false
+}
+
+// parseARP returns the sender of an ARP packet on an IPv4 Ethernet.
+func parseARP(pkt []byte) (scanHost, bool) {
+	if len(pkt) < 28 || binary.BigEndian.Uint16(pkt[0:2]) != 1 ||
+		binary.BigEndian.Uint16(pkt[2:4]) != etherTypeIPv4 || pkt[4] != 6 || pkt[5] != 4 {
+		return scanHost{}, false
+	}
+	// Probes (RFC 5227) have no sender address yet.
+	return newPassiveHost(pkt[14:18], pkt[8:14])
+}
+
+// parseIPv4 returns the host a DHCP or mDNS datagram from the MAC address
+// `src` gives away.
+func parseIPv4(src net.HardwareAddr, pkt []byte) (scanHost, bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != 17 {
+		return scanHost{}, false
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	if ihl < 20 || len(pkt) < ihl+8 {
+		return scanHost{}, false
+	}
+	srcPort := binary.BigEndian.Uint16(pkt[ihl : ihl+2])
+	dstPort := binary.BigEndian.Uint16(pkt[ihl+2 : ihl+4])
+	payload := pkt[ihl+8:]
+
+	switch {
+	case srcPort == dhcpClientPort && dstPort == dhcpServerPort,
+		srcPort == dhcpServerPort && dstPort == dhcpClientPort:
+		return parseDHCP(payload)
+	case srcPort == mdnsPort:
+		return newPassiveHost(pkt[12:16], src)
+	}
+	return scanHost{}, false
+}
+
+// parseDHCP returns the client of a DHCP message: the address it asks for
+// (or has) in requests, or the address it is given in acknowledgements.
+func parseDHCP(msg []byte) (scanHost, bool) {
+	if len(msg) < 240 || msg[1] != 1 || msg[2] != 6 ||
+		binary.BigEndian.Uint32(msg[236:240]) != dhcpMagicCookie {
+		return scanHost{}, false
+	}
+	mac := msg[28:34]
+	ciaddr, yiaddr := msg[12:16], msg[16:20]
+
+	var requested []byte
+	var msgType byte
+	for opts := msg[240:]; len(opts) > 0 && opts[0] != dhcpOptEnd; {
+		if opts[0] == 0 {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			break
+		}
+		value := opts[2 : 2+int(opts[1])]
+		switch {
+		case opts[0] == dhcpOptRequestedIP && len(value) == 4:
+			requested = value
+		case opts[0] == dhcpOptMessageType && len(value) == 1:
+			msgType = value[0]
+		}
+		opts = opts[2+len(value):]
+	}
+
+	if msg[0] == 2 {
+		if msgType != dhcpAck {
+			return scanHost{}, false
+		}
+		return newPassiveHost(yiaddr, mac)
+	}
+	if host, ok := newPassiveHost(ciaddr, mac); ok {
+		return host, true
+	}
+	if requested != nil {
+		return newPassiveHost(requested, mac)
+	}
+	return scanHost{}, false
+}
+
+// newPassiveHost returns the host with the IPv4 address `ip` and the MAC
+// address `mac`, unless either is unset.
+func newPassiveHost(ip, mac []byte) (scanHost, bool) {
+	addr := net.IPv4(ip[0], ip[1], ip[2], ip[3])
+	if addr.IsUnspecified() || addr.Equal(net.IPv4bcast) {
+		return scanHost{}, false
+	}
+	hw := net.HardwareAddr(mac)
+	if isZeroMAC(hw) || hw[0]&1 != 0 {
+		return scanHost{}, false
+	}
+	return scanHost{addr, hw.String()}, true
+}
+
+// isZeroMAC returns true if `mac` is all zeros.
+func isZeroMAC(mac net.HardwareAddr) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// passiveScanner learns the hosts of a subnet from the traffic on a link
+// (ARP, DHCP and mDNS), without sending anything, for networks where probing
+// every address is not allowed.
+type passiveScanner struct {
+	subnet  *net.IPNet
+	exclude []*net.IPNet
+	capture func(context.Context, func([]byte)) error
+	report  func(scanHost)
+
+	mtx   sync.Mutex
+	found map[string]scanHost
+}
+
+// newPassiveScanner returns a scanner which captures frames on the interface
+// `iface` and reports the hosts of `subnet` (but not those in `exclude`) to
+// `report`.
+func newPassiveScanner(iface string, subnet *net.IPNet, exclude []*net.IPNet, report func(scanHost)) *passiveScanner {
+	return &passiveScanner{
+		subnet:  subnet,
+		exclude: exclude,
+		capture: func(ctx context.Context, frame func([]byte)) error {
+			return captureFrames(ctx, iface, frame)
+		},
+		report: report,
+	}
+}
+
+// add records (and reports) the host a frame gave away, if it is new or has
+// another MAC address than before.
+func (s *passiveScanner) add(frame []byte) {
+	host, ok := parseFrame(frame)
+	if !ok || !s.subnet.Contains(host.IP) || excluded(host.IP, s.exclude) {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	key := host.IP.String()
+	if known, ok := s.found[key]; ok && known.MAC == host.MAC {
+		return
+	}
+	s.found[key] = host
+	s.report(host)
+}
+
+// run listens until `ctx` is done and returns the hosts seen, sorted by
+// address.
+func (s *passiveScanner) run(ctx context.Context) ([]scanHost, error) {
+	s.found = map[string]scanHost{}
+	if err := s.capture(ctx, s.add); err != nil {
+		return nil, err
+	}
+
+	hosts := make([]scanHost, 0, len(s.found))
+	for _, host := range s.found {
+		hosts = append(hosts, host)
+	}
+	sortHosts(hosts)
+	return hosts, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// passiveInterface returns the name of the interface a passive scan of
+// `subnet` listens on: the --interface (by name, address or subnet), or else
+// the one with an address in `subnet`.
+func passiveInterface(subnet *net.IPNet) (string, error) {
+	snap, err := interfaces.get()
+	if err != nil {
+		return "", err
+	}
+	spec, match := subnet.String(), subnet.Contains
+	if iface := cliFlags.BroadcastInterface; iface != "" {
+		if _, ok := snap.byName(iface); ok {
+			return iface, nil
+		}
+		var ok bool
+		if match, ok = addrMatcher(iface); !ok {
+			return "", fmt.Errorf("no such network interface %s", iface)
+		}
+		spec = iface
+	}
+
+	for _, iface := range snap.ifaces {
+		for _, addr := range snap.addrs[iface.Name] {
+			if ip, ok := addr.(*net.IPNet); ok && ip.IP.To4() != nil && match(ip.IP) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface has an address matching %s", spec)
+}
+
+// passiveScan listens for the hosts of `subnet` for `--duration` seconds,
+// reporting them to `report` as they are seen.
+func passiveScan(subnet *net.IPNet, exclude []*net.IPNet, report func(scanHost)) ([]scanHost, error) {
+	if cliFlags.Duration <= 0 {
+		return nil, fmt.Errorf("invalid duration %d", cliFlags.Duration)
+	}
+	iface, err := passiveInterface(subnet)
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Duration(cliFlags.Duration) * time.Second
+
+	progressf("Listening for hosts in %s on %s for %s\n", subnet, iface, duration)
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	p := newTimedProgress("listening on "+iface, duration)
+	defer p.finish()
+	return newPassiveScanner(iface, subnet, exclude, report).run(ctx)
+}
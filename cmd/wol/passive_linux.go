@@ -0,0 +1,62 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// htons converts `v` to network byte order.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+// captureFrames calls `frame` with every Ethernet frame the interface `iface`
+// receives until `ctx` is done. It needs root (or CAP_NET_RAW).
+func captureFrames(ctx context.Context, iface string, frame func([]byte)) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err == syscall.EPERM {
+		return fmt.Errorf("listening on %s needs root (or CAP_NET_RAW)", iface)
+	} else if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	sa := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: ifi.Index}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return err
+	}
+	tv := syscall.NsecToTimeval(capturePollInterval.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1<<16)
+	for ctx.Err() == nil {
+		size, from, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return err
+		}
+		// Frames this machine sent are looped back to packet sockets.
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && ll.Pkttype == syscall.PACKET_OUTGOING {
+			continue
+		}
+		frame(buf[:size])
+	}
+	return nil
+}
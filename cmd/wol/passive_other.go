@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"errors"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// captureFrames is only implemented on linux.
+func captureFrames(ctx context.Context, iface string, frame func([]byte)) error {
+	return errors.New("passive scans are only supported on linux")
+}
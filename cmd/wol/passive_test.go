@@ -0,0 +1,122 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ethernetFrame returns a frame from `src` carrying `payload`.
+func ethernetFrame(src string, etherType uint16, payload []byte) []byte {
+	mac, _ := net.ParseMAC(src)
+	frame := append([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, mac...)
+	frame = append(frame, byte(etherType>>8), byte(etherType))
+	return append(frame, payload...)
+}
+
+// arpPacket returns an ARP request from `mac` at `ip`.
+func arpPacket(mac, ip string) []byte {
+	hw, _ := net.ParseMAC(mac)
+	pkt := []byte{0, 1, 8, 0, 6, 4, 0, 1}
+	pkt = append(pkt, hw...)
+	pkt = append(pkt, net.ParseIP(ip).To4()...)
+	return append(pkt, make([]byte, 10)...)
+}
+
+// udpPacket returns an IPv4 datagram from `src`:`srcPort` to `dstPort`.
+func udpPacket(src string, srcPort, dstPort uint16, payload []byte) []byte {
+	pkt := make([]byte, 28)
+	pkt[0], pkt[9] = 0x45, 17
+	copy(pkt[12:16], net.ParseIP(src).To4())
+	copy(pkt[16:20], net.IPv4bcast.To4())
+	binary.BigEndian.PutUint16(pkt[20:], srcPort)
+	binary.BigEndian.PutUint16(pkt[22:], dstPort)
+	return append(pkt, payload...)
+}
+
+// dhcpMessage returns a DHCP message of the client `mac` with the options
+// `opts`.
+func dhcpMessage(op byte, mac, ciaddr, yiaddr string, opts ...byte) []byte {
+	msg := make([]byte, 240)
+	msg[0], msg[1], msg[2] = op, 1, 6
+	copy(msg[12:16], net.ParseIP(ciaddr).To4())
+	copy(msg[16:20], net.ParseIP(yiaddr).To4())
+	hw, _ := net.ParseMAC(mac)
+	copy(msg[28:], hw)
+	binary.BigEndian.PutUint32(msg[236:], dhcpMagicCookie)
+	return append(append(msg, opts...), dhcpOptEnd)
+}
+
+func TestParseFrame(t *testing.T) {
+	const mac = "00:11:22:aa:bb:cc"
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		ip    string
+	}{
+		{"arp", ethernetFrame(mac, etherTypeARP, arpPacket(mac, "192.168.1.7")), "192.168.1.7"},
+		{"tagged arp", append(append(ethernetFrame(mac, 0x8100, nil)[:12], 0x81, 0x00, 0x00, 0x0a, 0x08, 0x06),
+			arpPacket(mac, "192.168.1.7")...), "192.168.1.7"},
+		{"arp probe", ethernetFrame(mac, etherTypeARP, arpPacket(mac, "0.0.0.0")), ""},
+		{"dhcp request", ethernetFrame(mac, etherTypeIPv4, udpPacket("0.0.0.0", 68, 67,
+			dhcpMessage(1, mac, "0.0.0.0", "0.0.0.0", dhcpOptMessageType, 1, 3, dhcpOptRequestedIP, 4, 192, 168, 1, 8))), "192.168.1.8"},
+		{"dhcp renewal", ethernetFrame(mac, etherTypeIPv4, udpPacket("192.168.1.9", 68, 67,
+			dhcpMessage(1, mac, "192.168.1.9", "0.0.0.0", dhcpOptMessageType, 1, 3))), "192.168.1.9"},
+		{"dhcp ack", ethernetFrame("00:11:22:00:00:01", etherTypeIPv4, udpPacket("192.168.1.1", 67, 68,
+			dhcpMessage(2, mac, "0.0.0.0", "192.168.1.10", 0, dhcpOptMessageType, 1, dhcpAck))), "192.168.1.10"},
+		{"dhcp offer", ethernetFrame("00:11:22:00:00:01", etherTypeIPv4, udpPacket("192.168.1.1", 67, 68,
+			dhcpMessage(2, mac, "0.0.0.0", "192.168.1.10", dhcpOptMessageType, 1, 2))), ""},
+		{"dhcp truncated", ethernetFrame(mac, etherTypeIPv4, udpPacket("0.0.0.0", 68, 67,
+			dhcpMessage(1, mac, "0.0.0.0", "0.0.0.0", dhcpOptRequestedIP, 9, 192)[:242])), ""},
+		{"mdns", ethernetFrame(mac, etherTypeIPv4, udpPacket("192.168.1.11", 5353, 5353, nil)), "192.168.1.11"},
+		{"other udp", ethernetFrame(mac, etherTypeIPv4, udpPacket("192.168.1.12", 53, 5353, nil)), ""},
+		{"ipv6", ethernetFrame(mac, 0x86dd, make([]byte, 40)), ""},
+		{"runt", []byte{1, 2, 3}, ""},
+	} {
+		host, ok := parseFrame(tc.frame)
+		if tc.ip == "" {
+			assert.False(t, ok, tc.name)
+			continue
+		}
+		if assert.True(t, ok, tc.name) {
+			assert.Equal(t, tc.ip, host.IP.String(), tc.name)
+			assert.Equal(t, mac, host.MAC, tc.name)
+		}
+	}
+}
+
+func TestPassiveScanner(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/24")
+	exclude, _ := parseExcludes("192.168.1.1")
+	var reported []scanHost
+	s := newPassiveScanner("eth0", subnet, exclude, func(host scanHost) {
+		reported = append(reported, host)
+	})
+	s.capture = func(ctx context.Context, frame func([]byte)) error {
+		for _, f := range [][]byte{
+			ethernetFrame("00:00:00:00:00:09", etherTypeARP, arpPacket("00:00:00:00:00:09", "192.168.1.9")),
+			ethernetFrame("00:00:00:00:00:02", etherTypeARP, arpPacket("00:00:00:00:00:02", "192.168.1.2")),
+			ethernetFrame("00:00:00:00:00:09", etherTypeARP, arpPacket("00:00:00:00:00:09", "192.168.1.9")),
+			ethernetFrame("00:00:00:00:00:01", etherTypeARP, arpPacket("00:00:00:00:00:01", "192.168.1.1")),
+			ethernetFrame("00:00:00:00:00:03", etherTypeARP, arpPacket("00:00:00:00:00:03", "10.0.0.3")),
+		} {
+			frame(f)
+		}
+		return nil
+	}
+
+	hosts, err := s.run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []scanHost{
+		{net.ParseIP("192.168.1.2"), "00:00:00:00:00:02"},
+		{net.ParseIP("192.168.1.9"), "00:00:00:00:00:09"},
+	}, hosts)
+	assert.Equal(t, 2, len(reported))
+}
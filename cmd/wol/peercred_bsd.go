@@ -0,0 +1,77 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// peerCredsSupported is true as the kernel tells who is at the other end of
+// a unix socket (LOCAL_PEERCRED), so that anyone may connect to the control
+// socket and is then authorized by who they are.
+const peerCredsSupported = true
+
+const (
+	solLocal      = 0
+	localPeerCred = 1
+	xucredVersion = 0
+)
+
+// xucred is the struct xucred LOCAL_PEERCRED returns, with room for the
+// process ID FreeBSD adds.
+type xucred struct {
+	version uint32
+	uid     uint32
+	ngroups int16
+	groups  [16]uint32
+	_       [2]uint64
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// peerCredentials returns the user and (effective) group of the process at
+// the other end of the unix socket `conn`.
+func peerCredentials(conn net.Conn) (int, int, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, 0, errors.New("not a unix socket")
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var cred xucred
+	var gerr error
+	if err := rc.Control(func(fd uintptr) {
+		n := uint32(unsafe.Sizeof(cred))
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, solLocal, localPeerCred,
+			uintptr(unsafe.Pointer(&cred)), uintptr(unsafe.Pointer(&n)), 0)
+		if errno != 0 {
+			gerr = errno
+		}
+	}); err != nil {
+		return 0, 0, err
+	}
+	if gerr != nil {
+		return 0, 0, gerr
+	}
+	if cred.version != xucredVersion || cred.ngroups < 1 {
+		return 0, 0, errors.New("unexpected LOCAL_PEERCRED credentials")
+	}
+	return int(cred.uid), int(cred.groups[0]), nil
+}
+
+// restrictSocket lets anyone connect to the socket at `path`.
+func restrictSocket(path string) error {
+	return os.Chmod(path, 0666)
+}
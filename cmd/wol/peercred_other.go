@@ -0,0 +1,32 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// peerCredsSupported is false as who is at the other end of a unix socket is
+// not known here.
+const peerCredsSupported = false
+
+// errNoPeerCreds is why the control socket is not served here.
+var errNoPeerCreds = errors.New("who connects to a unix socket cannot be told on " + runtime.GOOS)
+
+// peerCredentials fails, as the peer of `conn` cannot be told.
+func peerCredentials(conn net.Conn) (int, int, error) {
+	return 0, 0, errNoPeerCreds
+}
+
+// restrictSocket refuses to serve the socket at `path`, as no one who
+// connects could be authorized.
+func restrictSocket(path string) error {
+	return errNoPeerCreds
+}
@@ -0,0 +1,76 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// peerCredsSupported is false as who is at the other end of a unix socket is
+// not known here. Instead, the socket's DACL only lets the daemon's own user
+// connect.
+const peerCredsSupported = false
+
+const (
+	sddlRevision1                    = 1
+	daclSecurityInformation          = 0x00000004
+	protectedDaclSecurityInformation = 0x80000000
+)
+
+var (
+	advapi32                = syscall.NewLazyDLL("advapi32.dll")
+	procConvertStringSDToSD = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+	procSetFileSecurity     = advapi32.NewProc("SetFileSecurityW")
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// peerCredentials returns the daemon's own user and group, who alone can
+// connect to the control socket.
+func peerCredentials(conn net.Conn) (int, int, error) {
+	return os.Getuid(), os.Getgid(), nil
+}
+
+// restrictSocket replaces the DACL of the socket at `path` by one which only
+// grants the daemon's own user access, without inheriting any other entries.
+func restrictSocket(path string) error {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return err
+	}
+	defer token.Close()
+	tu, err := token.GetTokenUser()
+	if err != nil {
+		return err
+	}
+	sid, err := tu.User.Sid.String()
+	if err != nil {
+		return err
+	}
+
+	sddl, err := syscall.UTF16PtrFromString("D:P(A;;GA;;;" + sid + ")")
+	if err != nil {
+		return err
+	}
+	var sd uintptr
+	if r, _, err := procConvertStringSDToSD.Call(uintptr(unsafe.Pointer(sddl)), sddlRevision1,
+		uintptr(unsafe.Pointer(&sd)), 0); r == 0 {
+		return err
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	if r, _, err := procSetFileSecurity.Call(uintptr(unsafe.Pointer(p)),
+		daclSecurityInformation|protectedDaclSecurityInformation, sd); r == 0 {
+		return err
+	}
+	return nil
+}
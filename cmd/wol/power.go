@@ -0,0 +1,160 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// sshHost returns where to reach `alias` over ssh: the `--ssh` destination,
+// or else the address stored with the alias (without its prefix).
+func sshHost(alias string, aliases *Aliases) (string, error) {
+	if cliFlags.ShutdownSSH != "" {
+		return cliFlags.ShutdownSSH, nil
+	}
+	mi, err := aliases.Get(alias)
+	if err != nil {
+		return "", err
+	}
+	if mi.IP == "" {
+		return "", fmt.Errorf("alias %s has no management controller, nor an address (or --ssh host) to reach it over ssh", alias)
+	}
+	return strings.SplitN(mi.IP, "/", 2)[0], nil
+}
+
+// shutdownArgs returns the arguments to `ssh` which shut the machine `dest`
+// down, or power it off right away if `hard`.
+func shutdownArgs(dest string, hard bool) ([]string, error) {
+	args, err := sshArgs(dest)
+	if err != nil {
+		return nil, err
+	}
+	if hard {
+		return append(args, "--", "sudo", "poweroff", "-f"), nil
+	}
+	return append(args, "--", "sudo", "shutdown", "-h", "now"), nil
+}
+
+// powerOffAlias shuts the machine of `alias` down (or cuts its power if
+// `hard`) through its management controller or hypervisor if it has one, and
+// over ssh otherwise. It returns how the machine was powered off.
+func powerOffAlias(alias string, hard bool, aliases *Aliases) (string, error) {
+	action, done := powerOff, "told to shut down"
+	if hard {
+		action, done = powerOffHard, "powered off"
+	}
+
+	oob, ok, err := aliases.GetOOB(alias)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		if err := runOOBBackend(oob, action); err != nil {
+			return "", fmt.Errorf("powering off %s through %s failed: %v", alias, oob.Kind, err)
+		}
+		return done + " through " + oob.Kind, nil
+	}
+
+	host, err := sshHost(alias, aliases)
+	if err != nil {
+		return "", err
+	}
+	args, err := shutdownArgs(host, hard)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := withTimeout(context.Background(), opAPI)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("powering off %s over ssh failed: %v", alias, err)
+	}
+	return done + " over ssh", nil
+}
+
+// powerState returns whether the machine of `alias` is on or off, asking its
+// management controller or hypervisor if it can tell, and probing its ssh
+// server otherwise. It also returns where the state comes from.
+func powerState(alias string, aliases *Aliases) (string, string, error) {
+	oob, ok, err := aliases.GetOOB(alias)
+	if err != nil {
+		return "", "", err
+	}
+	if _, canTell := oobStatusBackends[oob.Kind]; ok && canTell {
+		state, err := oobStatus(oob)
+		if err != nil {
+			return "", "", fmt.Errorf("querying %s through %s failed: %v", alias, oob.Kind, err)
+		}
+		return state, oob.Kind, nil
+	}
+
+	host, err := sshHost(alias, aliases)
+	if err != nil {
+		return "", "", err
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	up, err := probeSSH(host, time.Now().Add(sshDialTimeout))
+	if err != nil {
+		return "", "", err
+	}
+	if up {
+		return powerOn, "its ssh server answers", nil
+	}
+	return powerOff, "or its ssh server does not answer", nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Run the shutdown command.
+func shutdownCmd(args []string, aliases *Aliases) error {
+	if len(args) != 1 {
+		return errors.New("shutdown command requires an <alias>")
+	}
+	how, err := powerOffAlias(args[0], cliFlags.Hard, aliases)
+	if err != nil {
+		return err
+	}
+	statusf("%s was %s\n", args[0], how)
+	return nil
+}
+
+// Run the power command. It is the one verb to power any alias on or off, or
+// to query it, whether it is woken with a magic packet, through a management
+// controller or hypervisor, or shut down over ssh.
+func powerCmd(args []string, aliases *Aliases) error {
+	if len(args) != 2 {
+		return errors.New("power command requires one of: on <alias>, off <alias>, status <alias>")
+	}
+
+	switch action, alias := strings.ToLower(args[0]), args[1]; action {
+	case "on":
+		return wakeCmd([]string{alias}, aliases)
+
+	case "off":
+		return shutdownCmd([]string{alias}, aliases)
+
+	case "status":
+		state, from, err := powerState(alias, aliases)
+		if err != nil {
+			return err
+		}
+		if usual, ok := aliases.UsualBootTime(alias); ok && state != powerOn {
+			fmt.Printf("%s is %s (%s), usually up in %s after a wake\n", alias, state, from, aboutDuration(usual))
+			return nil
+		}
+		fmt.Printf("%s is %s (%s)\n", alias, state, from)
+		return nil
+	}
+	return fmt.Errorf("unknown power action %q (expected on, off or status)", args[0])
+}
@@ -0,0 +1,61 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestShutdownArgs(t *testing.T) {
+	args, err := shutdownArgs("root@nas.lan:2222", false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-p", "2222", "root@nas.lan", "--", "sudo", "shutdown", "-h", "now"}, args)
+
+	args, err = shutdownArgs("nas.lan", true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nas.lan", "--", "sudo", "poweroff", "-f"}, args)
+
+	_, err = shutdownArgs("-oProxyCommand=x", false)
+	assert.NotNil(t, err)
+}
+
+func TestPowerState(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestPowerState.db")
+	defer cleanup()
+	defer func(ssh string) { cliFlags.ShutdownSSH = ssh }(cliFlags.ShutdownSSH)
+
+	// Without a controller or an address, there is no way to tell.
+	assert.Nil(t, aliases.Add("tv", "00:11:22:aa:bb:dd", ""))
+	_, _, err := powerState("tv", aliases)
+	assert.EqualError(t, err, "alias tv has no management controller, nor an address (or --ssh host) to reach it over ssh")
+
+	// Otherwise its ssh server is probed.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+	cliFlags.ShutdownSSH = "root@" + listener.Addr().String()
+	state, from, err := powerState("tv", aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, "on", state)
+	assert.Equal(t, "its ssh server answers", from)
+
+	listener.Close()
+	state, _, err = powerState("tv", aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, "off", state)
+}
@@ -0,0 +1,209 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// progressRedrawInterval is how often the progress line on a terminal
+	// is redrawn.
+	progressRedrawInterval = 100 * time.Millisecond
+
+	// progressJSONInterval is how often a JSON progress line is printed
+	// with `--json`.
+	progressJSONInterval = time.Second
+
+	// clearLine returns to the start of the terminal line and erases it.
+	clearLine = "\r\033[K"
+)
+
+// spinnerFrames are drawn in turn in front of the progress line.
+var spinnerFrames = []rune(`|/-\`)
+
+var (
+	// progressOut is where progress is written, stderr as for all
+	// diagnostics.
+	progressOut io.Writer = os.Stderr
+
+	// progressMtx guards the progress line on the terminal, which has to be
+	// erased before anything else is printed.
+	progressMtx       sync.Mutex
+	progressLineDrawn bool
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// progress reports how far a long operation (a scan, a group wake, waiting
+// for a host) has got: as a line with a spinner, counts and an ETA which is
+// redrawn on a terminal, or as periodic JSON lines with `--json`. Operations
+// which are bound by time rather than by work count seconds. The methods of
+// a nil progress do nothing, so that reporting is easily left out.
+type progress struct {
+	what  string
+	total int
+	timed bool
+	start time.Time
+
+	mtx  sync.Mutex
+	done int
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// progressLine is a progress report printed with `--json`.
+type progressLine struct {
+	Progress string  `json:"progress"`
+	Done     int     `json:"done"`
+	Total    int     `json:"total"`
+	Unit     string  `json:"unit,omitempty"`
+	Elapsed  float64 `json:"elapsed"`
+	ETA      float64 `json:"eta,omitempty"`
+	Finished bool    `json:"finished,omitempty"`
+}
+
+// newProgress starts reporting the progress of `what`, which takes `total`
+// steps, or returns nil if there is nowhere to report it to.
+func newProgress(what string, total int) *progress {
+	return startProgress(&progress{what: what, total: total})
+}
+
+// newTimedProgress starts reporting the progress of `what`, which runs for
+// at most `d`, or returns nil if there is nowhere to report it to.
+func newTimedProgress(what string, d time.Duration) *progress {
+	return startProgress(&progress{what: what, total: int(d / time.Second), timed: true})
+}
+
+// startProgress starts the goroutine which reports `p`, if progress is
+// reported as JSON or on a terminal (and not silenced with `--quiet`).
+func startProgress(p *progress) *progress {
+	interval := progressJSONInterval
+	if !cliFlags.JSON {
+		if cliFlags.Quiet || !isTerminal(os.Stderr) {
+			return nil
+		}
+		interval = progressRedrawInterval
+	}
+
+	p.start, p.stop = time.Now(), make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			select {
+			case <-ticker.C:
+				p.draw(time.Now(), frame, false)
+			case <-p.stop:
+				p.draw(time.Now(), frame, true)
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// add records that `n` more steps are done.
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mtx.Lock()
+	p.done += n
+	p.mtx.Unlock()
+}
+
+// finish stops reporting, erasing the progress line or printing a last JSON
+// line.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// report returns how far the operation has got at `now`.
+func (p *progress) report(now time.Time, finished bool) progressLine {
+	elapsed := now.Sub(p.start)
+	line := progressLine{Progress: p.what, Total: p.total, Elapsed: elapsed.Seconds(), Finished: finished}
+	if p.timed {
+		line.Done, line.Unit = int(elapsed/time.Second), "s"
+		if line.Done > line.Total {
+			line.Done = line.Total
+		}
+		return line
+	}
+
+	p.mtx.Lock()
+	line.Done = p.done
+	p.mtx.Unlock()
+	if line.Done > 0 && line.Done < line.Total {
+		line.ETA = line.Elapsed / float64(line.Done) * float64(line.Total-line.Done)
+	}
+	return line
+}
+
+// draw prints the progress at `now`, as the spinner `frame` on a terminal.
+func (p *progress) draw(now time.Time, frame int, finished bool) {
+	line := p.report(now, finished)
+	if cliFlags.JSON {
+		bs, _ := json.Marshal(line)
+		fmt.Fprintf(progressOut, "%s\n", bs)
+		return
+	}
+	if finished {
+		clearProgress()
+		return
+	}
+
+	progressMtx.Lock()
+	defer progressMtx.Unlock()
+	fmt.Fprintf(progressOut, "%s%c %s", clearLine, spinnerFrames[frame%len(spinnerFrames)], line)
+	progressLineDrawn = true
+}
+
+// String formats the report for the terminal, e.g. "scan 120/254 (47%), ETA
+// 3s", or "waiting for nas 12s/60s" for operations bound by time.
+func (l progressLine) String() string {
+	if l.Unit != "" {
+		return fmt.Sprintf("%s %d%s/%d%s", l.Progress, l.Done, l.Unit, l.Total, l.Unit)
+	}
+	s := fmt.Sprintf("%s %d/%d", l.Progress, l.Done, l.Total)
+	if l.Total > 0 {
+		s += fmt.Sprintf(" (%d%%)", l.Done*100/l.Total)
+	}
+	if l.ETA > 0 {
+		s += fmt.Sprintf(", ETA %s", (time.Duration(l.ETA * float64(time.Second))).Round(time.Second))
+	}
+	return s
+}
+
+// clearProgress erases the progress line from the terminal, if one is drawn,
+// so that other output does not run into it. It is redrawn on the next tick.
+func clearProgress() {
+	progressMtx.Lock()
+	defer progressMtx.Unlock()
+	if progressLineDrawn {
+		fmt.Fprint(progressOut, clearLine)
+		progressLineDrawn = false
+	}
+}
+
+// isTerminal returns true if `f` is a terminal (or console).
+func isTerminal(f *os.File) bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,58 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestProgressReport(t *testing.T) {
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := &progress{what: "scan 192.168.1.0/24", total: 254, start: start}
+	p.add(127)
+	line := p.report(start.Add(10*time.Second), false)
+	assert.Equal(t, progressLine{Progress: "scan 192.168.1.0/24", Done: 127, Total: 254, Elapsed: 10, ETA: 10}, line)
+	assert.Equal(t, "scan 192.168.1.0/24 127/254 (50%), ETA 10s", line.String())
+
+	p.add(127)
+	assert.Equal(t, "scan 192.168.1.0/24 254/254 (100%)", p.report(start.Add(20*time.Second), true).String())
+
+	p = &progress{what: "waiting for nas", total: 60, timed: true, start: start}
+	assert.Equal(t, "waiting for nas 12s/60s", p.report(start.Add(12500*time.Millisecond), false).String())
+	assert.Equal(t, 60, p.report(start.Add(time.Hour), false).Done)
+
+	var nilProgress *progress
+	nilProgress.add(1)
+	nilProgress.finish()
+}
+
+func TestProgressJSON(t *testing.T) {
+	defer func(quiet, js bool) { cliFlags.Quiet, cliFlags.JSON = quiet, js }(cliFlags.Quiet, cliFlags.JSON)
+	defer func() { progressOut = os.Stderr }()
+	buf := bytes.NewBuffer(nil)
+	progressOut = buf
+
+	cliFlags.JSON, cliFlags.Quiet = false, true
+	assert.Nil(t, newProgress("scan", 2))
+
+	cliFlags.JSON = true
+	p := newProgress("wake lab", 2)
+	p.add(2)
+	p.finish()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var last progressLine
+	assert.Nil(t, json.Unmarshal([]byte(lines[len(lines)-1]), &last))
+	assert.Equal(t, "wake lab", last.Progress)
+	assert.Equal(t, 2, last.Done)
+	assert.True(t, last.Finished)
+}
@@ -0,0 +1,34 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// promptPassword prints `prompt` to stderr and reads a password from the
+// terminal, without echoing it.
+func promptPassword(prompt string) (string, error) {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return "", errors.New("no password is stored, and there is no terminal to prompt for one")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	if err := setEcho(false); err != nil {
+		return "", fmt.Errorf("cannot read a password from the terminal: %v", err)
+	}
+	defer setEcho(true)
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
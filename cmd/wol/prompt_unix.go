@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+	"os/exec"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// setEcho turns echoing of what is typed on the terminal on or off.
+func setEcho(on bool) error {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
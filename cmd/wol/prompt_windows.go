@@ -0,0 +1,38 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+	"syscall"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	enableEchoInput = 0x0004
+)
+
+var (
+	procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// setEcho turns echoing of what is typed on the console on or off.
+func setEcho(on bool) error {
+	handle := syscall.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(handle, &mode); err != nil {
+		return err
+	}
+	if on {
+		mode |= enableEchoInput
+	} else {
+		mode &^= enableEchoInput
+	}
+	if r, _, err := procSetConsoleMode.Call(uintptr(handle), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
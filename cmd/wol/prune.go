@@ -0,0 +1,114 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// staleAlias is an alias whose MAC has not been seen for the prune window.
+type staleAlias struct {
+	alias    string
+	mac      string
+	sighting Sighting
+}
+
+// String describes why the alias is considered stale.
+func (sa staleAlias) String() string {
+	if sa.sighting.Last.IsZero() {
+		return fmt.Sprintf("%s - %s not seen since tracking began %s", sa.alias, sa.mac,
+			formatTime(sa.sighting.First, time.Now()))
+	}
+	return fmt.Sprintf("%s - %s last seen %s", sa.alias, sa.mac,
+		formatTime(sa.sighting.Last, time.Now()))
+}
+
+// isStale returns true if `s` has not been seen within `window` of `now`. A
+// MAC which has never been seen is only stale once it has been tracked for
+// longer than the window.
+func isStale(s Sighting, window time.Duration, now time.Time) bool {
+	last := s.Last
+	if last.IsZero() {
+		last = s.First
+	}
+	return now.Sub(last) > window
+}
+
+// findStaleAliases records which aliases are currently present in the
+// neighbor table and returns those not seen within `window`, sorted by name.
+func findStaleAliases(aliases *Aliases, window time.Duration, now time.Time) ([]staleAlias, error) {
+	table, err := neighborTable()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := aliases.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []staleAlias
+	for _, alias := range list {
+		mac, ok := canonicalMAC(alias.Mac)
+		if !ok {
+			continue
+		}
+
+		_, seen := table[mac]
+		sighting, err := aliases.Track(mac, seen, now)
+		if err != nil {
+			return nil, err
+		}
+		if isStale(sighting, window, now) {
+			stale = append(stale, staleAlias{alias.Name, alias.Mac, sighting})
+		}
+	}
+	return stale, nil
+}
+
+// Run the prune command.
+func pruneCmd(args []string, aliases *Aliases) error {
+	if cliFlags.PruneDays <= 0 {
+		return fmt.Errorf("invalid number of days %d", cliFlags.PruneDays)
+	}
+
+	window := time.Duration(cliFlags.PruneDays) * 24 * time.Hour
+	stale, err := findStaleAliases(aliases, window, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if len(stale) == 0 {
+		progressf("No aliases unseen for more than %d days\n", cliFlags.PruneDays)
+		return nil
+	}
+
+	fmt.Printf("Aliases unseen for more than %d days:\n", cliFlags.PruneDays)
+	for _, sa := range stale {
+		fmt.Printf("    %s\n", sa)
+	}
+	if cliFlags.PruneDelete {
+		// Aliases of the ethers file are left to whoever maintains it.
+		removed := 0
+		err := aliases.Batch(func(b *Batch) error {
+			for _, sa := range stale {
+				if _, err := b.Get(sa.alias); err != nil {
+					continue
+				}
+				if err := b.Del(sa.alias); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		statusf("Removed %d aliases\n", removed)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestIsStale(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	for _, tc := range []struct {
+		sighting Sighting
+		stale    bool
+	}{
+		// Seen recently.
+		{Sighting{now.Add(-100 * day), now.Add(-day)}, false},
+		// Seen, but too long ago.
+		{Sighting{now.Add(-100 * day), now.Add(-31 * day)}, true},
+		// Never seen, but only tracked for a little while.
+		{Sighting{now.Add(-day), time.Time{}}, false},
+		// Never seen in the whole window.
+		{Sighting{now.Add(-31 * day), time.Time{}}, true},
+	} {
+		assert.Equal(t, tc.stale, isStale(tc.sighting, 30*day, now))
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"log"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	queueBucketName = "Queue"
+
+	// queuePollInterval is how often serve looks for queued wakes which are
+	// due to be retried.
+	queuePollInterval = time.Second
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// QueuedWake is a wake request serve accepted but has not sent yet. Requests
+// are queued before the packet goes out and removed once it has, so that
+// those the daemon did not get to before it stopped are sent when it starts
+// again.
+type QueuedWake struct {
+	ID       uint64
+	Target   string
+	By       string
+	Accepted time.Time
+	Attempts int       // failed attempts so far
+	Next     time.Time // when the wake is next attempted
+	Sending  bool      // an attempt is under way
+	Error    string    // why the last attempt failed
+	Password bool      // the target has a password, see queuePassword
+}
+
+// queueKey returns the db key of the queued wake `id`.
+func queueKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// putQueuedWake stores `q` in the queue bucket of `tx`.
+func putQueuedWake(tx *bolt.Tx, q QueuedWake) error {
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(q); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(queueBucketName)).Put(queueKey(q.ID), buf.Bytes())
+}
+
+// QueueWake queues a wake of `target` made `by` the given client, as being
+// sent now. `password` is set if the target has a SecureOn password which is
+// not part of it.
+func (a *Aliases) QueueWake(target, by string, password bool) (QueuedWake, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	now := time.Now()
+	q := QueuedWake{Target: target, By: by, Accepted: now, Next: now, Sending: true, Password: password}
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		id, err := tx.Bucket([]byte(queueBucketName)).NextSequence()
+		if err != nil {
+			return err
+		}
+		q.ID = id
+		return putQueuedWake(tx, q)
+	})
+	return q, err
+}
+
+// RequeueWake stores the updated queued wake `q`.
+func (a *Aliases) RequeueWake(q QueuedWake) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.db.Update(func(tx *bolt.Tx) error {
+		return putQueuedWake(tx, q)
+	})
+}
+
+// DequeueWake removes the queued wake `id`.
+func (a *Aliases) DequeueWake(id uint64) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(queueBucketName)).Delete(queueKey(id))
+	})
+}
+
+// QueuedWakes returns the queued wakes, oldest first. Unless `all` is set,
+// only those due by `now` which are not being sent are returned, and marked
+// as being sent.
+func (a *Aliases) QueuedWakes(now time.Time, all bool) ([]QueuedWake, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var wakes []QueuedWake
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(queueBucketName)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var q QueuedWake
+			if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&q); err != nil {
+				return err
+			}
+			if !all && (q.Sending || q.Next.After(now)) {
+				continue
+			}
+			wakes = append(wakes, q)
+		}
+		if all {
+			return nil
+		}
+		for i := range wakes {
+			wakes[i].Sending = true
+			if err := putQueuedWake(tx, wakes[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wakes, err
+}
+
+// ResumeQueue marks the queued wakes left as being sent by a daemon which
+// stopped before it finished as due now, and returns how many wakes are
+// queued.
+func (a *Aliases) ResumeQueue() (int, error) {
+	wakes, err := a.QueuedWakes(time.Time{}, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, q := range wakes {
+		if q.Sending {
+			q.Sending, q.Next = false, time.Now()
+			if err := a.RequeueWake(q); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(wakes), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// retryDelay returns how long after its `attempts`th failed attempt a queued
+// wake is retried: `--queue-backoff`, doubling with each attempt.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Duration(cliFlags.QueueBackoff) * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// expired returns true if `q` was asked for more than `--queue-max-age` ago,
+// so that a wake sent now would come too late.
+func (q QueuedWake) expired(now time.Time) bool {
+	return now.Sub(q.Accepted) >= time.Duration(cliFlags.QueueMaxAge)*time.Second
+}
+
+// errPasswordLost is returned for a queued wake whose password was lost when
+// the daemon stopped.
+var errPasswordLost = errors.New("the SecureOn password of the target was lost when the daemon stopped")
+
+// queueWake queues the wake of `wt` made `by` the given client. The target is
+// queued without the SecureOn password of its spec, which is only kept in
+// memory: a wake queued before the daemon stopped cannot be sent afterwards.
+// The passwords of aliases are looked up again instead.
+func (s *server) queueWake(wt *wakeTarget, by string) (QueuedWake, error) {
+	password := wt.alias == "" && len(wt.password) > 0
+	q, err := s.aliases.QueueWake(wt.target, by, password)
+	if err == nil && password {
+		s.passwordMtx.Lock()
+		s.passwords[q.ID] = wt.password
+		s.passwordMtx.Unlock()
+	}
+	return q, err
+}
+
+// queuePassword returns the SecureOn password of the queued wake `q`, if it
+// has one which is not part of its target.
+func (s *server) queuePassword(q QueuedWake) ([]byte, error) {
+	if !q.Password {
+		return nil, nil
+	}
+	s.passwordMtx.Lock()
+	defer s.passwordMtx.Unlock()
+	password, ok := s.passwords[q.ID]
+	if !ok {
+		return nil, errPasswordLost
+	}
+	return password, nil
+}
+
+// dequeueWake removes the queued wake `q`, and its password.
+func (s *server) dequeueWake(q QueuedWake) error {
+	s.passwordMtx.Lock()
+	delete(s.passwords, q.ID)
+	s.passwordMtx.Unlock()
+	return s.aliases.DequeueWake(q.ID)
+}
+
+// sendQueued makes an attempt at the queued wake `q`, which is removed from
+// the queue unless it failed and is to be retried. The target is resolved
+// unless `wt` is given.
+func (s *server) sendQueued(ctx context.Context, q QueuedWake, wt *wakeTarget) (_ *wakeTarget, wait time.Duration, retry bool, err error) {
+	if wt == nil {
+		var password []byte
+		if password, err = s.queuePassword(q); err == nil {
+			wt, err = resolveWakeTarget(ctx, q.Target, s.origin(), s.aliases)
+		}
+		if err != nil {
+			wt = nil
+		} else if password != nil {
+			wt.password = password
+		}
+	}
+	if wt != nil {
+		wait, err = wt.wake(ctx, s.aliases, q.By)
+	}
+	if err == nil {
+		if derr := s.dequeueWake(q); derr != nil {
+			log.Printf("Failed to remove the wake of %s from the queue: %v\n", q.Target, derr)
+		}
+		return wt, wait, false, nil
+	}
+
+	// Targets which no longer resolve are not retried, nor are wakes which
+	// would arrive too long after they were asked for.
+	q.Attempts++
+	q.Error = err.Error()
+	retry = wt != nil && q.Attempts <= cliFlags.QueueRetries && !q.expired(time.Now())
+	var qerr error
+	if retry {
+		q.Sending, q.Next = false, time.Now().Add(retryDelay(q.Attempts))
+		qerr = s.aliases.RequeueWake(q)
+	} else {
+		qerr = s.dequeueWake(q)
+	}
+	if qerr != nil {
+		log.Printf("Failed to update the queued wake of %s: %v\n", q.Target, qerr)
+	}
+	return wt, 0, retry, err
+}
+
+// retryQueued attempts the queued wakes which are due, dropping those which
+// have expired, e.g. while the daemon was stopped.
+func (s *server) retryQueued() {
+	wakes, err := s.aliases.QueuedWakes(time.Now(), false)
+	if err != nil {
+		log.Printf("Failed to read the wake queue: %v\n", err)
+		return
+	}
+	for _, q := range wakes {
+		if q.expired(time.Now()) {
+			if err := s.dequeueWake(q); err != nil {
+				log.Printf("Failed to remove the wake of %s from the queue: %v\n", q.Target, err)
+				continue
+			}
+			log.Printf("Dropped the wake of %s for %s, asked for %s ago\n", q.Target, q.By, time.Since(q.Accepted).Truncate(time.Second))
+			s.events.publish(event{Type: "wake", Target: q.Target, Error: "expired in the queue"})
+			continue
+		}
+
+		ctx, sp := startSpan(context.Background(), "queued wake")
+		sp.set("wol.target", q.Target)
+		wt, wait, retry, err := s.sendQueued(ctx, q, nil)
+		sp.finish(err)
+
+		e := event{Type: "wake", Target: q.Target, Coalesced: wait > 0}
+		if wt != nil {
+			e.Mac = wt.mac
+		}
+		switch {
+		case err == nil:
+			log.Printf("Woke %s (%s) for %s from the queue\n", q.Target, e.Mac, q.By)
+		case retry:
+			log.Printf("Failed to wake %s for %s, retrying in %s: %v\n", q.Target, q.By, retryDelay(q.Attempts+1), err)
+			continue
+		default:
+			log.Printf("Gave up waking %s for %s: %v\n", q.Target, q.By, err)
+			e.Error = err.Error()
+		}
+		s.events.publish(e)
+	}
+}
+
+// runQueue sends the wakes left queued by a previous run, then retries failed
+// wakes as they fall due until `stop` is closed.
+func (s *server) runQueue(stop <-chan struct{}) {
+	if n, err := s.aliases.ResumeQueue(); err != nil {
+		log.Printf("Failed to read the wake queue: %v\n", err)
+	} else if n > 0 {
+		log.Printf("Resuming %d queued wakes\n", n)
+	}
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for {
+		s.retryQueued()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
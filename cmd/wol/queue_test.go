@@ -0,0 +1,208 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestWakeQueue(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestWakeQueue.db")
+	defer cleanup()
+
+	first, err := aliases.QueueWake("nas", "cli:bob", false)
+	assert.Nil(t, err)
+	second, err := aliases.QueueWake("tv", "cli:bob", false)
+	assert.Nil(t, err)
+	assert.True(t, second.ID > first.ID)
+
+	// Wakes being sent are not handed out again...
+	due, err := aliases.QueuedWakes(time.Now(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(due))
+
+	// ... unless the daemon sending them stopped.
+	n, err := aliases.ResumeQueue()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	due, err = aliases.QueuedWakes(time.Now(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(due))
+	assert.Equal(t, "nas", due[0].Target)
+	due, err = aliases.QueuedWakes(time.Now(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(due))
+
+	// Wakes are only due once their retry delay is over.
+	second.Sending, second.Next = false, time.Now().Add(time.Minute)
+	assert.Nil(t, aliases.RequeueWake(second))
+	assert.Nil(t, aliases.DequeueWake(first.ID))
+	due, err = aliases.QueuedWakes(time.Now(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(due))
+	due, err = aliases.QueuedWakes(time.Now().Add(2*time.Minute), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(due))
+
+	all, err := aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(all))
+	assert.Equal(t, "tv", all[0].Target)
+}
+
+func TestRetryDelay(t *testing.T) {
+	defer func(backoff int) { cliFlags.QueueBackoff = backoff }(cliFlags.QueueBackoff)
+	cliFlags.QueueBackoff = 5
+
+	assert.Equal(t, 5*time.Second, retryDelay(1))
+	assert.Equal(t, 10*time.Second, retryDelay(2))
+	assert.Equal(t, 20*time.Second, retryDelay(3))
+	assert.True(t, retryDelay(100) < 3*time.Hour)
+}
+
+func TestQueuedWakeRetries(t *testing.T) {
+	saved := cliFlags
+	defer func() { cliFlags = saved }()
+	cliFlags.QueueRetries, cliFlags.QueueBackoff, cliFlags.QueueMaxAge = 1, 0, 300
+
+	aliases, cleanup := openTestAliases(t, "./TestQueuedWakeRetries.db")
+	defer cleanup()
+	assert.Nil(t, aliases.Add("nas", "00:11:22:33:44:55", ""))
+	s := newServer(aliases)
+
+	// Nothing listens on the port, so sending over TCP fails.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	cliFlags.Transport, cliFlags.BroadcastIP, cliFlags.UDPPort = "tcp", "127.0.0.1", strconv.Itoa(port)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("POST", "/wake/nas", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	var result map[string]interface{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, true, result["queued"])
+	assert.NotEmpty(t, result["error"])
+
+	queued, err := aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(queued))
+	assert.Equal(t, 1, queued[0].Attempts)
+	assert.False(t, queued[0].Sending)
+
+	// The retry fails as well, which is the last.
+	s.retryQueued()
+	queued, err = aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(queued))
+	history, err := aliases.History(time.Time{}, "nas")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+
+	// A wake left queued by a daemon which stopped is sent once it resumes.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	cliFlags.Transport, cliFlags.UDPPort = "udp", strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+
+	_, err = aliases.QueueWake("nas", "cli:bob", false)
+	assert.Nil(t, err)
+	_, err = aliases.ResumeQueue()
+	assert.Nil(t, err)
+	s.retryQueued()
+	queued, err = aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(queued))
+	history, err = aliases.History(time.Time{}, "nas")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, "", history[2].Error)
+	assert.Equal(t, "cli:bob", history[2].By)
+
+	// ... unless it was asked for too long ago.
+	q, err := aliases.QueueWake("nas", "cli:bob", false)
+	assert.Nil(t, err)
+	q.Accepted = q.Accepted.Add(-time.Hour)
+	assert.Nil(t, aliases.RequeueWake(q))
+	_, err = aliases.ResumeQueue()
+	assert.Nil(t, err)
+	s.retryQueued()
+	queued, err = aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(queued))
+	history, err = aliases.History(time.Time{}, "nas")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(history))
+}
+
+// The password of a target spec is kept out of the response, the history and
+// the queue, and only in memory until the wake is sent.
+func TestQueuedWakePassword(t *testing.T) {
+	saved := cliFlags
+	defer func() { cliFlags = saved }()
+	cliFlags.QueueRetries, cliFlags.QueueBackoff, cliFlags.QueueMaxAge = 1, 0, 300
+
+	aliases, cleanup := openTestAliases(t, "./TestQueuedWakePassword.db")
+	defer cleanup()
+	s := newServer(aliases)
+
+	// Nothing listens on the port over TCP, so the first attempt fails.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	port := strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+	cliFlags.Transport = "tcp"
+
+	target := "00:11:22:33:44:55@127.0.0.1:" + port
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("POST", "/wake/"+target+"%3Fpw=s3cr3t", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "s3cr3t")
+	var result map[string]interface{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, target, result["target"])
+
+	queued, err := aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(queued))
+	assert.Equal(t, target, queued[0].Target)
+	assert.True(t, queued[0].Password)
+
+	// The retry still sends the password.
+	cliFlags.Transport = "udp"
+	s.retryQueued()
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cr3t", string(buf[n-6:n]))
+	queued, err = aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(queued))
+
+	// A daemon which stopped no longer has the password, and gives up.
+	_, err = aliases.QueueWake(target, "api:bob", true)
+	assert.Nil(t, err)
+	_, err = aliases.ResumeQueue()
+	assert.Nil(t, err)
+	s.retryQueued()
+	queued, err = aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(queued))
+	history, err := aliases.History(time.Time{}, "")
+	assert.Nil(t, err)
+	for _, h := range history {
+		assert.NotContains(t, h.Target, "s3cr3t")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// rateBucket is the token bucket of a single client.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a set of token buckets, keyed by client, which each hold up
+// to `burst` requests and refill at `perMinute` requests a minute.
+type rateLimiter struct {
+	mtx     sync.Mutex
+	burst   float64
+	rate    float64 // requests per second
+	buckets map[string]*rateBucket
+
+	allowed uint64
+	limited uint64
+}
+
+// newRateLimiter returns a limiter allowing `perMinute` requests a minute per
+// client, in bursts of up to as many. A zero rate disables limiting.
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		burst:   float64(perMinute),
+		rate:    float64(perMinute) / 60,
+		buckets: map[string]*rateBucket{},
+	}
+}
+
+// allow returns true if a request by `key` at `now` is within its limit.
+// Otherwise it returns how long the client should wait before retrying.
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	if rl.rate <= 0 {
+		rl.allowed++
+		return true, 0
+	}
+
+	// Drop the buckets of clients which have been idle long enough to have
+	// refilled, so the map does not grow without bound.
+	for k, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, k)
+		}
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &rateBucket{rl.burst, now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		rl.limited++
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	rl.allowed++
+	return true, 0
+}
+
+// counts returns the number of allowed and limited requests so far.
+func (rl *rateLimiter) counts() (uint64, uint64) {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	return rl.allowed, rl.limited
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// parseTrustedProxies parses the `--trusted-proxy` addresses and CIDRs.
+func parseTrustedProxies() ([]*net.IPNet, error) {
+	return parseAddrNets("--trusted-proxy", cliFlags.TrustedProxies)
+}
+
+// parseAddrNets parses the addresses and CIDRs given with the option `opt`.
+func parseAddrNets(opt string, values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range values {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q (expected an address or CIDR)", opt, s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy returns true if `ip` is one of the `--trusted-proxy`s.
+func trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	nets, _ := parseTrustedProxies()
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the client addresses the Forwarded (RFC 7239) or, if
+// there is none, the X-Forwarded-For headers of `r` list, in order.
+func forwardedFor(r *http.Request) []string {
+	var addrs []string
+	for _, h := range r.Header["Forwarded"] {
+		for _, elem := range strings.Split(h, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "for") {
+					continue
+				}
+				addr := strings.Trim(kv[1], `"`)
+				if host, _, err := net.SplitHostPort(addr); err == nil {
+					addr = host
+				}
+				addrs = append(addrs, strings.Trim(addr, "[]"))
+			}
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs
+	}
+	for _, h := range r.Header["X-Forwarded-For"] {
+		for _, addr := range strings.Split(h, ",") {
+			addrs = append(addrs, strings.TrimSpace(addr))
+		}
+	}
+	return addrs
+}
+
+// clientIP returns the IP address a request came from. Requests from a
+// `--trusted-proxy` came from the last address their forwarding headers list
+// which is not a trusted proxy itself; what clients put there is ignored.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	addrs := forwardedFor(r)
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if net.ParseIP(addrs[i]) == nil {
+			break
+		}
+		host = addrs[i]
+		if !trustedProxy(host) {
+			break
+		}
+	}
+	return host
+}
+
+// rateLimited checks the request against the per-IP and per-token limits.
+// If either is exceeded it writes a `429 Too Many Requests` and returns true.
+func (s *server) rateLimited(w http.ResponseWriter, r *http.Request) bool {
+	now := time.Now()
+	ok, wait := s.ipLimiter.allow(clientIP(r), now)
+	if ok {
+		if tok := requestToken(r); tok != nil {
+			ok, wait = s.tokenLimiter.allow(hex.EncodeToString(tok.Hash), now)
+		}
+	}
+	if ok {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded, retry in %s", wait.Round(time.Second)))
+	return true
+}
+
+// handleMetrics serves `GET /metrics` in the Prometheus text format.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP wol_wake_requests_total Wake requests by rate limit outcome.\n")
+	fmt.Fprintf(w, "# TYPE wol_wake_requests_total counter\n")
+	for _, l := range []struct {
+		scope string
+		rl    *rateLimiter
+	}{
+		{"ip", s.ipLimiter},
+		{"token", s.tokenLimiter},
+	} {
+		allowed, limited := l.rl.counts()
+		fmt.Fprintf(w, "wol_wake_requests_total{scope=%q,outcome=\"allowed\"} %d\n", l.scope, allowed)
+		fmt.Fprintf(w, "wol_wake_requests_total{scope=%q,outcome=\"limited\"} %d\n", l.scope, limited)
+	}
+}
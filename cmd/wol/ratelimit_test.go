@@ -0,0 +1,82 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(2)
+	t0 := time.Now()
+
+	// A burst of up to the limit is allowed, per client.
+	ok, _ := rl.allow("a", t0)
+	assert.True(t, ok)
+	ok, _ = rl.allow("a", t0)
+	assert.True(t, ok)
+	ok, wait := rl.allow("a", t0)
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, wait)
+	ok, _ = rl.allow("b", t0)
+	assert.True(t, ok)
+
+	// Buckets refill over time.
+	ok, _ = rl.allow("a", t0.Add(30*time.Second))
+	assert.True(t, ok)
+	ok, _ = rl.allow("a", t0.Add(30*time.Second))
+	assert.False(t, ok)
+
+	allowed, limited := rl.counts()
+	assert.Equal(t, uint64(4), allowed)
+	assert.Equal(t, uint64(2), limited)
+
+	// Idle clients are forgotten.
+	rl.allow("c", t0.Add(time.Hour))
+	assert.Equal(t, 1, len(rl.buckets))
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	rl := newRateLimiter(0)
+	for i := 0; i < 100; i++ {
+		ok, _ := rl.allow("a", time.Now())
+		assert.True(t, ok)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer func(proxies []string) { cliFlags.TrustedProxies = proxies }(cliFlags.TrustedProxies)
+	cliFlags.TrustedProxies = []string{"10.0.0.1", "172.16.0.0/12"}
+
+	for _, tc := range []struct {
+		remote, xff, forwarded, want string
+	}{
+		// Clients cannot pick their address by sending the headers.
+		{"192.0.2.7:1234", "198.51.100.1", "", "192.0.2.7"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"10.0.0.1:1234", "203.0.113.9, 198.51.100.1, 172.16.5.5", "", "198.51.100.1"},
+		{"10.0.0.1:1234", "unknown", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "198.51.100.1", `for=192.0.2.60;proto=http, for="[2001:db8::17]:4711"`, "2001:db8::17"},
+	} {
+		r := httptest.NewRequest("POST", "/wake/nas", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.forwarded != "" {
+			r.Header.Set("Forwarded", tc.forwarded)
+		}
+		assert.Equal(t, tc.want, clientIP(r), tc.xff+tc.forwarded)
+	}
+
+	cliFlags.TrustedProxies = []string{"proxy"}
+	_, err := parseTrustedProxies()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,162 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// readinessScript prints what the host knows about the NIC with the MAC
+// address `%[1]s`: its name, whether the device may wake the system, and
+// ethtool's report (whose Wake-on settings may need root to read).
+const readinessScript = `for d in /sys/class/net/*; do
+if [ "$(cat "$d/address" 2>/dev/null)" = "%[1]s" ]; then
+echo "Interface: ${d##*/}"
+echo "Device-Wakeup: $(cat "$d/device/power/wakeup" 2>/dev/null)"
+ethtool "${d##*/}" 2>/dev/null || sudo -n ethtool "${d##*/}" 2>/dev/null
+fi
+done`
+
+////////////////////////////////////////////////////////////////////////////////
+
+// readiness is what is known about whether a NIC will wake its host.
+type readiness struct {
+	IP           net.IP // from the neighbor table, nil if not in it
+	Iface        string // the NIC on the host, "" if not queried
+	DeviceWakeup string // "enabled" or "disabled", "" if unknown
+	Supports     string // ethtool's supported Wake-on modes, "" if unknown
+	WakeOn       string // ethtool's armed Wake-on modes, "" if unknown
+}
+
+// parseReadiness fills in `r` from the output of the readiness script.
+func parseReadiness(r *readiness, out string) {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch parts[0] {
+		case "Interface":
+			r.Iface = value
+		case "Device-Wakeup":
+			r.DeviceWakeup = value
+		case "Supports Wake-on":
+			r.Supports = value
+		case "Wake-on":
+			r.WakeOn = value
+		}
+	}
+}
+
+// Verdicts of a readiness probe.
+const (
+	verdictReady      = "ready"
+	verdictNotCapable = "not capable"
+	verdictNotArmed   = "not armed"
+	verdictUnknown    = "unknown"
+)
+
+// verdict sums up whether the NIC is ready to be woken by a magic packet
+// (Wake-on mode `g`), and explains why (or what to do about it).
+func (r readiness) verdict() (string, string) {
+	switch {
+	case r.Supports != "" && !strings.Contains(r.Supports, "g"):
+		return verdictNotCapable, fmt.Sprintf("%s does not support magic packets", r.Iface)
+	case r.WakeOn != "" && !strings.Contains(r.WakeOn, "g"):
+		return verdictNotArmed, fmt.Sprintf("run `ethtool -s %s wol g` on the host (and make it persistent)", r.Iface)
+	case r.DeviceWakeup == "disabled":
+		return verdictNotArmed, fmt.Sprintf("the device of %s may not wake the system (see power/wakeup in sysfs)", r.Iface)
+	case r.WakeOn != "":
+		return verdictReady, fmt.Sprintf("%s is armed for magic packets (WoL must be enabled in the BIOS too)", r.Iface)
+	case r.Iface != "":
+		return verdictUnknown, fmt.Sprintf("could not read the Wake-on settings of %s (is ethtool installed, is sudo allowed?)", r.Iface)
+	case r.IP != nil:
+		return verdictUnknown, "the host is on the network, give --ssh to check its NIC"
+	}
+	return verdictUnknown, "the host is not in the neighbor table (it may be asleep), give --ssh to check its NIC"
+}
+
+// queryReadiness runs the readiness script for `mac` on `dest` over SSH,
+// killing ssh if it has not finished once `ctx` is done.
+func queryReadiness(ctx context.Context, r *readiness, dest, mac string) error {
+	args, err := sshArgs(dest)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "ssh", append(args, "--", fmt.Sprintf(readinessScript, mac))...)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("querying %s failed: %v", dest, err)
+	}
+	if parseReadiness(r, string(out)); r.Iface == "" {
+		return fmt.Errorf("%s has no NIC with the MAC address %s", dest, mac)
+	}
+	return nil
+}
+
+// Run the probe command.
+func probeCmd(args []string, aliases *Aliases) error {
+	if len(args) != 1 {
+		return errors.New("probe command requires an <alias> or <mac address>")
+	}
+
+	target := args[0]
+	if mi, err := aliases.Get(target); err == nil {
+		target = mi.Mac
+	}
+	mac, ok := canonicalMAC(target)
+	if !ok {
+		return fmt.Errorf("%s is neither an alias nor a MAC address", args[0])
+	}
+
+	var r readiness
+	progressf("Probing %s (%s)\n", args[0], mac)
+	if table, err := neighborTable(); err == nil {
+		r.IP = table[mac]
+	}
+	if r.IP != nil {
+		fmt.Printf("    Neighbor entry:  %s\n", r.IP)
+	} else {
+		fmt.Printf("    Neighbor entry:  none\n")
+	}
+
+	if cliFlags.SSH != "" {
+		ctx, cancel := withTimeout(context.Background(), opProbe)
+		err := queryReadiness(ctx, &r, cliFlags.SSH, mac)
+		cancel()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("    Interface:       %s\n", r.Iface)
+		fmt.Printf("    Device wakeup:   %s\n", orUnknown(r.DeviceWakeup))
+		fmt.Printf("    Supports WoL:    %s\n", orUnknown(r.Supports))
+		fmt.Printf("    WoL armed:       %s\n", orUnknown(r.WakeOn))
+	}
+
+	verdict, detail := r.verdict()
+	fmt.Printf("Verdict: %s, %s\n", verdict, detail)
+	if verdict == verdictNotCapable || verdict == verdictNotArmed {
+		return fmt.Errorf("%s is %s for Wake-on-LAN", args[0], verdict)
+	}
+	return nil
+}
+
+// orUnknown returns `s`, or "unknown" if it is empty.
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const testReadinessOutput = `Interface: enp3s0
+Device-Wakeup: enabled
+Settings for enp3s0:
+	Supported ports: [ TP ]
+	Speed: 1000Mb/s
+	Supports Wake-on: pumbg
+	Wake-on: d
+	Link detected: yes
+`
+
+func TestParseReadiness(t *testing.T) {
+	var r readiness
+	parseReadiness(&r, testReadinessOutput)
+	assert.Equal(t, readiness{
+		Iface:        "enp3s0",
+		DeviceWakeup: "enabled",
+		Supports:     "pumbg",
+		WakeOn:       "d",
+	}, r)
+}
+
+func TestReadinessVerdict(t *testing.T) {
+	for _, tc := range []struct {
+		r        readiness
+		expected string
+	}{
+		{readiness{Iface: "eth0", DeviceWakeup: "enabled", Supports: "pumbg", WakeOn: "g"}, verdictReady},
+		{readiness{Iface: "eth0", Supports: "pumbg", WakeOn: "d"}, verdictNotArmed},
+		{readiness{Iface: "eth0", DeviceWakeup: "disabled", Supports: "pumbg", WakeOn: "g"}, verdictNotArmed},
+		{readiness{Iface: "eth0", Supports: "pumb", WakeOn: "d"}, verdictNotCapable},
+		{readiness{Iface: "eth0", DeviceWakeup: "enabled"}, verdictUnknown},
+		{readiness{IP: net.ParseIP("192.168.1.2")}, verdictUnknown},
+		{readiness{}, verdictUnknown},
+	} {
+		verdict, detail := tc.r.verdict()
+		assert.Equal(t, tc.expected, verdict)
+		assert.NotEmpty(t, detail)
+	}
+}
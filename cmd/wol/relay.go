@@ -0,0 +1,333 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	wol "github.com/sabhiram/go-wol"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	defaultRelayListen = ":9"
+
+	// relayRebindInterval is how often a relay retries binding its socket.
+	relayRebindInterval = time.Second
+
+	// relayEchoWindow is how long after re-broadcasting a packet the same
+	// packet arriving from one of our own addresses is taken to be its echo.
+	relayEchoWindow = time.Second
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// relaySuppressor tracks when each MAC address was last relayed so that
+// identical magic packets seen again within `window` are dropped. This keeps
+// two relays which can hear each other's broadcasts from looping forever.
+type relaySuppressor struct {
+	mtx    sync.Mutex
+	window time.Duration
+	seen   map[wol.MACAddress]time.Time
+	sent   map[wol.MACAddress]time.Time
+
+	relayed    uint64
+	suppressed uint64
+}
+
+// newRelaySuppressor returns a suppressor which drops repeats of the same MAC
+// inside of `window`. A zero window disables suppression.
+func newRelaySuppressor(window time.Duration) *relaySuppressor {
+	return &relaySuppressor{
+		window: window,
+		seen:   map[wol.MACAddress]time.Time{},
+		sent:   map[wol.MACAddress]time.Time{},
+	}
+}
+
+// allow returns true if a packet for `mac` observed at `now` should be
+// relayed, and updates the relayed / suppressed counters accordingly.
+func (rs *relaySuppressor) allow(mac wol.MACAddress, now time.Time) bool {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+
+	if last, ok := rs.seen[mac]; ok && now.Sub(last) < rs.window {
+		rs.suppressed++
+		return false
+	}
+
+	// Drop expired entries so the map does not grow without bound on a busy
+	// network.
+	for k, t := range rs.seen {
+		if now.Sub(t) >= rs.window {
+			delete(rs.seen, k)
+		}
+	}
+
+	rs.seen[mac] = now
+	rs.relayed++
+	return true
+}
+
+// markSent records that a packet for `mac` was re-broadcast at `now`.
+func (rs *relaySuppressor) markSent(mac wol.MACAddress, now time.Time) {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+
+	for k, t := range rs.sent {
+		if now.Sub(t) >= relayEchoWindow {
+			delete(rs.sent, k)
+		}
+	}
+	rs.sent[mac] = now
+}
+
+// echo returns true if a packet for `mac` from `local` (one of our own
+// addresses) at `now` is the relay hearing its own re-broadcast. Unlike
+// `allow`, this holds even with a zero window, so a relay listening on the
+// network it broadcasts to does not loop.
+func (rs *relaySuppressor) echo(mac wol.MACAddress, local bool, now time.Time) bool {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+
+	if last, ok := rs.sent[mac]; ok && local && now.Sub(last) < relayEchoWindow {
+		delete(rs.sent, mac)
+		rs.suppressed++
+		return true
+	}
+	return false
+}
+
+// counts returns the number of relayed and suppressed packets so far.
+func (rs *relaySuppressor) counts() (uint64, uint64) {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+
+	return rs.relayed, rs.suppressed
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// relayListener is the socket a relay receives packets on. As gateways often
+// renegotiate their links, it is bound again when the interfaces change, or
+// when reading from it fails, rather than giving up.
+type relayListener struct {
+	laddr *net.UDPAddr
+	stop  <-chan struct{}
+
+	mtx   sync.Mutex
+	conn  *net.UDPConn
+	stale bool // whether the socket should be bound again
+}
+
+// listenRelay binds the socket of a relay to `laddr`, until `stop` is closed.
+func listenRelay(laddr *net.UDPAddr, stop <-chan struct{}) (*relayListener, error) {
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	// Binding again must not pick another port.
+	laddr = &net.UDPAddr{IP: laddr.IP, Port: conn.LocalAddr().(*net.UDPAddr).Port, Zone: laddr.Zone}
+	l := &relayListener{laddr: laddr, stop: stop, conn: conn}
+
+	go func() {
+		<-stop
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		l.conn.SetReadDeadline(time.Now())
+	}()
+	return l, nil
+}
+
+// invalidate makes the listener bind its socket again, interrupting the
+// pending read.
+func (l *relayListener) invalidate() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.stale = true
+	l.conn.SetReadDeadline(time.Now())
+}
+
+// rebind closes the socket and binds it again, retrying every
+// relayRebindInterval until it succeeds or the relay stops.
+func (l *relayListener) rebind() {
+	l.mtx.Lock()
+	l.conn.Close()
+	l.mtx.Unlock()
+
+	for logged := false; !stopping(l.stop); logged = true {
+		conn, err := net.ListenUDP("udp", l.laddr)
+		if err == nil {
+			l.mtx.Lock()
+			l.conn, l.stale = conn, false
+			l.mtx.Unlock()
+			log.Printf("Listening on %s again\n", conn.LocalAddr())
+			return
+		}
+		if !logged {
+			log.Printf("Failed to listen on %s, retrying: %v\n", l.laddr, err)
+		}
+		select {
+		case <-time.After(relayRebindInterval):
+		case <-l.stop:
+		}
+	}
+}
+
+// read reads a packet into `buf`, binding the socket again as needed. It
+// returns false once the relay stops.
+func (l *relayListener) read(buf []byte) (int, *net.UDPAddr, bool) {
+	for {
+		l.mtx.Lock()
+		conn, stale := l.conn, l.stale
+		l.mtx.Unlock()
+		if stopping(l.stop) {
+			return 0, nil, false
+		}
+		if stale {
+			l.rebind()
+			continue
+		}
+
+		n, from, err := conn.ReadFromUDP(buf)
+		if err == nil {
+			return n, from, true
+		}
+		l.mtx.Lock()
+		if !l.stale && !stopping(l.stop) {
+			log.Printf("Failed to read from %s: %v\n", l.laddr, err)
+			l.stale = true
+		}
+		l.mtx.Unlock()
+	}
+}
+
+// reply sends `bs` back to `to` from the socket.
+func (l *relayListener) reply(bs []byte, to *net.UDPAddr) error {
+	l.mtx.Lock()
+	conn := l.conn
+	l.mtx.Unlock()
+	_, err := conn.WriteToUDP(bs, to)
+	return err
+}
+
+// close closes the socket.
+func (l *relayListener) close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.conn.Close()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// relaySourceAddr returns the address packets are re-broadcast from: that of
+// the outbound interface, if any, as overridden by `--source-ip`. It is
+// looked up for each packet, as a link which comes back up may have another
+// address.
+func relaySourceAddr() (*net.UDPAddr, error) {
+	var localAddr *net.UDPAddr
+	if cliFlags.BroadcastInterface != "" {
+		var err error
+		if localAddr, err = ipFromInterface(cliFlags.BroadcastInterface); err != nil {
+			return nil, err
+		}
+	}
+	return sourceAddr(localAddr)
+}
+
+// Run the relay command.
+func relayCmd(args []string, aliases *Aliases) error {
+	if cliFlags.DedupWindow < 0 {
+		return fmt.Errorf("invalid dedup window %d", cliFlags.DedupWindow)
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", listenAddr(defaultRelayListen))
+	if err != nil {
+		return err
+	}
+
+	// Packets are re-broadcast to the usual destination, optionally from a
+	// specific outbound interface.
+	if _, err := relaySourceAddr(); err != nil {
+		return err
+	}
+	bcastAddr := fmt.Sprintf("%s:%s", cliFlags.BroadcastIP, cliFlags.UDPPort)
+	udpAddr, err := net.ResolveUDPAddr("udp", bcastAddr)
+	if err != nil {
+		return err
+	}
+
+	stop := shutdownSignal()
+	l, err := listenRelay(laddr, stop)
+	if err != nil {
+		return err
+	}
+	defer l.close()
+
+	startHealthServer()
+	watchInterfaces(stop, l.invalidate)
+	rs := newRelaySuppressor(time.Duration(cliFlags.DedupWindow) * time.Second)
+	log.Printf("Relaying magic packets from %s to %s\n", l.laddr, bcastAddr)
+	done, err := daemonReady()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// Both buffers are reused for every packet.
+	buf := make([]byte, 1500)
+	out := make([]byte, wol.Size)
+	for {
+		n, from, ok := l.read(buf)
+		if !ok {
+			return nil
+		}
+
+		// Answer the network probes of other hosts, but not our own.
+		if isNetworkProbe(buf[:n]) && !isLocalIP(from.IP) {
+			if err := l.reply(buf[:n], from); err != nil {
+				log.Printf("Failed to answer the network probe of %s: %v\n", from, err)
+			}
+			continue
+		}
+
+		mp, err := wol.Parse(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		mac := mp.MAC()
+		if rs.echo(mac, isLocalIP(from.IP), time.Now()) {
+			continue
+		}
+		if !rs.allow(mac, time.Now()) {
+			relayed, suppressed := rs.counts()
+			log.Printf("Suppressed duplicate packet for %s from %s (relayed=%d suppressed=%d)\n",
+				mac, from, relayed, suppressed)
+			continue
+		}
+
+		n, err = mp.MarshalTo(out)
+		var localAddr *net.UDPAddr
+		if err == nil {
+			localAddr, err = relaySourceAddr()
+		}
+		if err == nil {
+			ctx, cancel := withTimeout(context.Background(), opSend)
+			err = sendMagicPacket(ctx, out[:n], localAddr, udpAddr)
+			cancel()
+		}
+		if err != nil {
+			log.Printf("Failed to relay packet for %s: %v\n", mac, err)
+			continue
+		}
+		rs.markSent(mac, time.Now())
+		log.Printf("Relayed packet for %s from %s\n", mac, from)
+	}
+}
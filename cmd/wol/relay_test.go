@@ -0,0 +1,114 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	wol "github.com/sabhiram/go-wol"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Validate that repeats of the same MAC are suppressed inside the window.
+func TestRelaySuppressor(t *testing.T) {
+	a := wol.MACAddress{0, 1, 2, 3, 4, 5}
+	b := wol.MACAddress{0, 1, 2, 3, 4, 6}
+	now := time.Now()
+
+	rs := newRelaySuppressor(5 * time.Second)
+	assert.True(t, rs.allow(a, now))
+	assert.False(t, rs.allow(a, now.Add(time.Second)))
+	assert.True(t, rs.allow(b, now.Add(time.Second)))
+	assert.False(t, rs.allow(a, now.Add(4*time.Second)))
+	assert.True(t, rs.allow(a, now.Add(5*time.Second)))
+
+	relayed, suppressed := rs.counts()
+	assert.Equal(t, uint64(3), relayed)
+	assert.Equal(t, uint64(2), suppressed)
+}
+
+// A zero window relays every packet.
+func TestRelaySuppressorDisabled(t *testing.T) {
+	a := wol.MACAddress{0, 1, 2, 3, 4, 5}
+	now := time.Now()
+
+	rs := newRelaySuppressor(0)
+	for i := 0; i < 3; i++ {
+		assert.True(t, rs.allow(a, now))
+	}
+}
+
+// The relay's own re-broadcasts are dropped, even with a zero window, but
+// only when they come from a local address shortly after being sent.
+func TestRelaySuppressorEcho(t *testing.T) {
+	a := wol.MACAddress{0, 1, 2, 3, 4, 5}
+	now := time.Now()
+
+	rs := newRelaySuppressor(0)
+	assert.False(t, rs.echo(a, true, now))
+	assert.True(t, rs.allow(a, now))
+	rs.markSent(a, now)
+	assert.False(t, rs.echo(a, false, now))
+	assert.True(t, rs.echo(a, true, now.Add(100*time.Millisecond)))
+	assert.False(t, rs.echo(a, true, now.Add(200*time.Millisecond)))
+
+	rs.markSent(a, now)
+	assert.False(t, rs.echo(a, true, now.Add(relayEchoWindow)))
+
+	_, suppressed := rs.counts()
+	assert.Equal(t, uint64(1), suppressed)
+	assert.True(t, isLocalIP(net.IPv4(127, 0, 0, 1)))
+}
+
+// Validate that the relay socket is bound again to the same address.
+func TestRelayListener(t *testing.T) {
+	stop := make(chan struct{})
+	l, err := listenRelay(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, stop)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer l.close()
+
+	send := func(payload string) {
+		conn, err := net.DialUDP("udp", nil, l.laddr)
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.Write([]byte(payload))
+	}
+	buf := make([]byte, 16)
+
+	send("one")
+	n, _, ok := l.read(buf)
+	assert.True(t, ok)
+	assert.Equal(t, "one", string(buf[:n]))
+
+	// Interface changes rebind the socket, as do failed reads.
+	l.invalidate()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		send("two")
+	}()
+	n, _, ok = l.read(buf)
+	assert.True(t, ok)
+	assert.Equal(t, "two", string(buf[:n]))
+
+	l.mtx.Lock()
+	l.conn.Close()
+	l.mtx.Unlock()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		send("three")
+	}()
+	n, _, ok = l.read(buf)
+	assert.True(t, ok)
+	assert.Equal(t, "three", string(buf[:n]))
+
+	close(stop)
+	_, _, ok = l.read(buf)
+	assert.False(t, ok)
+}
@@ -0,0 +1,201 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// routerBackend lists the clients known to the router at `baseURL`, using
+// `token` to authenticate.
+type routerBackend func(client *http.Client, baseURL, token string) ([]inventoryHost, error)
+
+// routerBackends maps the name passed to `--router` to its backend.
+var routerBackends = map[string]routerBackend{
+	"fritzbox": fritzboxClients,
+	"openwrt":  openwrtClients,
+	"unifi":    unifiClients,
+}
+
+// routerBackendNames returns a sorted, comma separated list of the supported
+// router backends.
+func routerBackendNames() string {
+	names := make([]string, 0, len(routerBackends))
+	for name := range routerBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// routerRequest performs a request against a router and returns the body of
+// its (successful) response.
+func routerRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readRouterResponse(resp)
+}
+
+// readRouterResponse reads (and closes) the body of the router's `resp`.
+func readRouterResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("router returned %s", resp.Status)
+	}
+	return body, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// unifiClients lists the clients of the default site of a UniFi OS console
+// (or UniFi Network server), authenticating with an API key.
+func unifiClients(client *http.Client, baseURL, token string) ([]inventoryHost, error) {
+	req, err := http.NewRequest("GET", baseURL+"/proxy/network/api/s/default/stat/sta", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-KEY", token)
+
+	body, err := routerRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data []struct {
+			MAC      string `json:"mac"`
+			IP       string `json:"ip"`
+			Name     string `json:"name"` // set by the user
+			Hostname string `json:"hostname"`
+			OUI      string `json:"oui"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid UniFi response: %v", err)
+	}
+
+	var hosts []inventoryHost
+	for _, c := range resp.Data {
+		mac, ok := canonicalMAC(c.MAC)
+		if !ok {
+			continue
+		}
+		name := c.Name
+		if name == "" {
+			name = c.Hostname
+		}
+		hosts = append(hosts, inventoryHost{name, c.IP, mac, c.OUI})
+	}
+	return hosts, nil
+}
+
+// openwrtClients lists the hosts known to an OpenWrt router through its ubus
+// JSON-RPC endpoint (as used by LuCI), authenticating with a ubus session id.
+func openwrtClients(client *http.Client, baseURL, token string) ([]inventoryHost, error) {
+	call, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "call",
+		"params":  []interface{}{token, "luci-rpc", "getHostHints", map[string]string{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", baseURL+"/ubus", bytes.NewReader(call))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := routerRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Result []json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid ubus response: %v", err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("ubus call failed: %s", resp.Error.Message)
+	}
+
+	// The result is the ubus status code, followed by the data if it is 0.
+	var status int
+	if len(resp.Result) == 0 || json.Unmarshal(resp.Result[0], &status) != nil {
+		return nil, errors.New("invalid ubus response: missing status")
+	}
+	if status != 0 {
+		return nil, fmt.Errorf("ubus call failed with status %d (is the session valid?)", status)
+	}
+	var hints map[string]struct {
+		Name    string   `json:"name"`
+		IPAddrs []string `json:"ipaddrs"`
+	}
+	if len(resp.Result) > 1 {
+		if err := json.Unmarshal(resp.Result[1], &hints); err != nil {
+			return nil, fmt.Errorf("invalid ubus response: %v", err)
+		}
+	}
+
+	var hosts []inventoryHost
+	for m, hint := range hints {
+		mac, ok := canonicalMAC(m)
+		if !ok {
+			continue
+		}
+		host := inventoryHost{Name: hint.Name, MAC: mac}
+		if len(hint.IPAddrs) > 0 {
+			host.IP = hint.IPAddrs[0]
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// importRouter adds aliases for the clients of the router at `baseURL`.
+func importRouter(backend, baseURL, token string, aliases *Aliases) error {
+	clients, ok := routerBackends[backend]
+	if !ok {
+		return fmt.Errorf("unknown router %q (expected one of %s)", backend, routerBackendNames())
+	}
+	if baseURL == "" {
+		return errors.New("importing from a router requires its --url")
+	}
+
+	client := &http.Client{Timeout: timeoutFor(opAPI)}
+	if cliFlags.Insecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	hosts, err := clients(client, strings.TrimRight(baseURL, "/"), token)
+	if err != nil {
+		return err
+	}
+	return importHosts(hosts, aliases)
+}
@@ -0,0 +1,133 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestDigestAuthorization(t *testing.T) {
+	// The example from RFC 2617, section 3.5.
+	challenge := `Digest realm="testrealm@host.com", qop="auth,auth-int", ` +
+		`nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`
+	auth, err := digestAuthorization(challenge, "Mufasa", "Circle Of Life", "GET", "/dir/index.html", "0a4f113b")
+	assert.Nil(t, err)
+	assert.Contains(t, auth, `response="6629fae49393a05397450978507c4ef1"`)
+	assert.Contains(t, auth, `opaque="5ccc069c403ebaf9f0171e9517f40e41"`)
+
+	for _, challenge := range []string{
+		`Basic realm="router"`,
+		`Digest realm="router", nonce="1", algorithm=SHA-256`,
+		`Digest realm="router", nonce="1", qop="auth-int"`,
+	} {
+		_, err := digestAuthorization(challenge, "user", "pass", "GET", "/", "1")
+		assert.NotNil(t, err)
+	}
+}
+
+func TestUnifiClients(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxy/network/api/s/default/stat/sta" || r.Header.Get("X-API-KEY") != "key" {
+			http.Error(w, "nope", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"meta":{"rc":"ok"},"data":[
+			{"mac":"00:11:22:aa:bb:cc","ip":"192.168.1.2","hostname":"nas","oui":"Synology"},
+			{"mac":"00:11:22:aa:bb:dd","ip":"192.168.1.3","hostname":"desktop","name":"gamer"}]}`))
+	}))
+	defer server.Close()
+
+	hosts, err := unifiClients(server.Client(), server.URL, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, []inventoryHost{
+		{"nas", "192.168.1.2", "00:11:22:aa:bb:cc", "Synology"},
+		{"gamer", "192.168.1.3", "00:11:22:aa:bb:dd", ""},
+	}, hosts)
+
+	_, err = unifiClients(server.Client(), server.URL, "wrong")
+	assert.NotNil(t, err)
+}
+
+func TestOpenwrtClients(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&call))
+		if string(call.Params[0]) != `"session"` {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[6]}`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[0,{
+			"00:11:22:AA:BB:CC":{"ipaddrs":["192.168.1.2"],"name":"nas"},
+			"not-a-mac":{"ipaddrs":["192.168.1.3"]}}]}`))
+	}))
+	defer server.Close()
+
+	hosts, err := openwrtClients(server.Client(), server.URL, "session")
+	assert.Nil(t, err)
+	assert.Equal(t, []inventoryHost{{"nas", "192.168.1.2", "00:11:22:aa:bb:cc", ""}}, hosts)
+
+	_, err = openwrtClients(server.Client(), server.URL, "expired")
+	assert.NotNil(t, err)
+}
+
+func TestFritzboxClients(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/upnp/control/hosts":
+			ioutil.ReadAll(r.Body)
+			auth := r.Header.Get("Authorization")
+			if auth == "" {
+				w.Header().Set("WWW-Authenticate", `Digest realm="HTTPS Access", nonce="A1B2", algorithm=MD5, qop="auth"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			cnonce := auth[strings.Index(auth, `cnonce="`)+8:]
+			cnonce = cnonce[:strings.Index(cnonce, `"`)]
+			ha1 := md5Hex("admin", "HTTPS Access", "secret")
+			expected := md5Hex(ha1, "A1B2", "00000001", cnonce, "auth", md5Hex("POST", "/upnp/control/hosts"))
+			if !strings.Contains(auth, `response="`+expected+`"`) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:X_AVM-DE_GetHostListPathResponse xmlns:u="urn:dslforum-org:service:Hosts:1">
+<NewX_AVM-DE_HostListPath>/devicehostlist.lua?sid=1234</NewX_AVM-DE_HostListPath>
+</u:X_AVM-DE_GetHostListPathResponse></s:Body></s:Envelope>`))
+		case "/devicehostlist.lua":
+			w.Write([]byte(`<?xml version="1.0"?><List>
+<Item><Index>1</Index><IPAddress>192.168.178.20</IPAddress><MACAddress>00:11:22:AA:BB:CC</MACAddress><Active>1</Active><HostName>nas</HostName></Item>
+<Item><Index>2</Index><IPAddress>192.168.178.21</IPAddress><MACAddress></MACAddress><Active>1</Active><HostName>vpn</HostName></Item>
+</List>`))
+		}
+	}))
+	defer server.Close()
+
+	hosts, err := fritzboxClients(server.Client(), server.URL, "admin:secret")
+	assert.Nil(t, err)
+	assert.Equal(t, []inventoryHost{{"nas", "192.168.178.20", "00:11:22:aa:bb:cc", ""}}, hosts)
+
+	_, err = fritzboxClients(server.Client(), server.URL, "admin:wrong")
+	assert.NotNil(t, err)
+	_, err = fritzboxClients(server.Client(), server.URL, "no-password")
+	assert.NotNil(t, err)
+}
+
+func TestImportRouterNegative(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestImportRouterNegative.db")
+	defer cleanup()
+
+	assert.NotNil(t, importRouter("netgear", "http://router", "", aliases))
+	assert.NotNil(t, importRouter("unifi", "", "", aliases))
+}
@@ -0,0 +1,428 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// Scanning more than a /16 at once is almost certainly a mistake.
+	maxScanHosts = 1 << 16
+
+	// How often the neighbor table is read while scanning.
+	scanPollInterval = 100 * time.Millisecond
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// scanHost is a host which responded to a scan.
+type scanHost struct {
+	IP  net.IP
+	MAC string // "" if the host is not on a local link
+}
+
+// scanHosts returns the addresses of the hosts in `subnet`, leaving out the
+// network and broadcast addresses where the subnet has them.
+func scanHosts(subnet *net.IPNet) ([]net.IP, error) {
+	base := subnet.IP.To4()
+	if base == nil {
+		return nil, errors.New("only IPv4 subnets can be scanned")
+	}
+	ones, bits := subnet.Mask.Size()
+	size := 1 << uint(bits-ones)
+	if size > maxScanHosts {
+		return nil, fmt.Errorf("subnet %s has more than %d addresses", subnet, maxScanHosts)
+	}
+
+	first, last := 0, size
+	if size > 2 {
+		first, last = 1, size-1
+	}
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+
+	// The addresses share a single backing array, in the 16 byte form of
+	// net.IPv4, as a /16 has 65534 of them.
+	ips := make([]net.IP, 0, last-first)
+	buf := make([]byte, net.IPv6len*(last-first))
+	for i := first; i < last; i++ {
+		ip := net.IP(buf[:net.IPv6len:net.IPv6len])
+		buf = buf[net.IPv6len:]
+		n := start + uint32(i)
+		ip[10], ip[11] = 0xff, 0xff
+		ip[12], ip[13], ip[14], ip[15] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// parseExcludes parses a comma separated list of addresses and subnets.
+func parseExcludes(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusion %q (expected an address or subnet)", entry)
+		}
+		nets = append(nets, subnet)
+	}
+	return nets, nil
+}
+
+// excluded returns true if `ip` is in one of `nets`.
+func excluded(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeHosts returns `ips` without the addresses in `nets`.
+func excludeHosts(ips []net.IP, nets []*net.IPNet) []net.IP {
+	if len(nets) == 0 {
+		return ips
+	}
+	kept := ips[:0]
+	for _, ip := range ips {
+		if !excluded(ip, nets) {
+			kept = append(kept, ip)
+		}
+	}
+	return kept
+}
+
+// probeUDP pokes `ip` with an empty datagram to the discard port. For a host
+// on a local link this makes the kernel resolve its MAC address (an ARP
+// request, which works without privileges), and a host which answers with
+// an ICMP port unreachable is alive wherever it is.
+func probeUDP(ip net.IP, timeout time.Duration) bool {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: 9})
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(nil); err != nil {
+		return false
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err = conn.Read(make([]byte, 1))
+	return err == nil || isConnRefused(err)
+}
+
+// isConnRefused returns true if `err` was caused by an ICMP unreachable.
+func isConnRefused(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// scanner sweeps a set of addresses with a pool of workers. MAC addresses
+// are taken from the neighbor table, which is read while the probes are in
+// flight, so hosts are reported as soon as they respond. Entries which were
+// in the table before the scan may be stale (hosts which have since gone), so
+// they only count for the addresses which answer a probe.
+type scanner struct {
+	concurrency int
+	timeout     time.Duration
+	probe       func(net.IP, time.Duration) bool
+	neighbors   func() (map[string]net.IP, error)
+	report      func(scanHost)
+	progress    *progress // of the probes, if reported
+
+	mtx   sync.Mutex
+	want  map[string]bool   // addresses being scanned
+	stale map[string]string // address -> MAC in the table before the scan
+	found map[string]scanHost
+}
+
+// newScanner returns a scanner which probes with UDP and reports to `report`.
+func newScanner(concurrency int, timeout time.Duration, report func(scanHost)) *scanner {
+	return &scanner{
+		concurrency: concurrency,
+		timeout:     timeout,
+		probe:       probeUDP,
+		neighbors:   neighborTable,
+		report:      report,
+	}
+}
+
+// add records (and reports) a responding host, unless it is known already.
+func (s *scanner) add(host scanHost) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := host.IP.String()
+	if _, ok := s.found[key]; ok || !s.want[key] {
+		return
+	}
+	s.found[key] = host
+	s.report(host)
+}
+
+// pollNeighbors adds the hosts in the neighbor table which were resolved
+// since the scan started, and returns the table.
+func (s *scanner) pollNeighbors() map[string]net.IP {
+	table, err := s.neighbors()
+	if err != nil {
+		return nil
+	}
+	for mac, ip := range table {
+		if s.stale[ip.String()] != mac {
+			s.add(scanHost{ip, mac})
+		}
+	}
+	return table
+}
+
+// run scans `ips` and returns the hosts which responded, sorted by address.
+func (s *scanner) run(ips []net.IP) []scanHost {
+	s.want = map[string]bool{}
+	s.found = map[string]scanHost{}
+	for _, ip := range ips {
+		s.want[ip.String()] = true
+	}
+	s.stale = map[string]string{}
+	if table, err := s.neighbors(); err == nil {
+		for mac, ip := range table {
+			s.stale[ip.String()] = mac
+		}
+	}
+
+	// Hosts which only answer a probe (rather than appearing in the
+	// neighbor table) are off link, have not been resolved yet, or were
+	// already in the table.
+	var mtx sync.Mutex
+	var answered []net.IP
+
+	work := make(chan net.IP)
+	var wg sync.WaitGroup
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range work {
+				if s.probe(ip, s.timeout) {
+					mtx.Lock()
+					answered = append(answered, ip)
+					mtx.Unlock()
+				}
+				s.progress.add(1)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, ip := range ips {
+			work <- ip
+		}
+		close(work)
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(scanPollInterval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-ticker.C:
+			s.pollNeighbors()
+		case <-done:
+			running = false
+		}
+	}
+
+	table := s.pollNeighbors()
+	macs := make(map[string]string, len(table))
+	for mac, ip := range table {
+		macs[ip.String()] = mac
+	}
+	for _, ip := range answered {
+		s.add(scanHost{ip, macs[ip.String()]})
+	}
+
+	hosts := make([]scanHost, 0, len(s.found))
+	for _, host := range s.found {
+		hosts = append(hosts, host)
+	}
+	sortHosts(hosts)
+	return hosts
+}
+
+// sortHosts sorts `hosts` by address.
+func sortHosts(hosts []scanHost) {
+	sort.Slice(hosts, func(i, j int) bool {
+		return bytes.Compare(hosts[i].IP.To16(), hosts[j].IP.To16()) < 0
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// scanSubnet returns the subnet to scan: the argument, or else the subnet of
+// the `--interface`.
+func scanSubnet(args []string) (*net.IPNet, error) {
+	if len(args) > 0 {
+		_, subnet, err := net.ParseCIDR(args[0])
+		return subnet, err
+	}
+	if cliFlags.BroadcastInterface == "" {
+		return nil, errors.New("scan command requires a <subnet> (or an --interface)")
+	}
+
+	local, err := ipFromInterface(cliFlags.BroadcastInterface)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := interfaces.get()
+	if err != nil {
+		return nil, err
+	}
+	for _, addrs := range snap.addrs {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(local.IP) {
+				return &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no subnet found for %s", local.IP)
+}
+
+// Run the scan command.
+func scanCmd(args []string, aliases *Aliases) error {
+	if cliFlags.Concurrency <= 0 {
+		return fmt.Errorf("invalid concurrency %d", cliFlags.Concurrency)
+	}
+	subnet, err := scanSubnet(args)
+	if err != nil {
+		return err
+	}
+	exclude, err := parseExcludes(cliFlags.Exclude)
+	if err != nil {
+		return err
+	}
+
+	// Show which hosts already have aliases.
+	list, err := aliases.List()
+	if err != nil {
+		return err
+	}
+	names := map[string][]string{}
+	for _, alias := range list {
+		if mac, ok := canonicalMAC(alias.Mac); ok {
+			names[mac] = append(names[mac], alias.Name)
+		}
+	}
+
+	printHost := func(prefix string, host scanHost) {
+		mac := host.MAC
+		if mac == "" {
+			mac = "-"
+		}
+		line := fmt.Sprintf("%s%-15s  %-17s  %s", prefix, host.IP, mac, strings.Join(names[host.MAC], ", "))
+		clearProgress()
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+
+	// The previous scan of the subnet is replaced by this one. Passive
+	// scans only see the hosts which were active, so they are kept apart.
+	key := subnet.String()
+	if cliFlags.Passive {
+		key = "passive " + key
+	}
+	last, scanned, err := aliases.LastScan(key)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	report := func(host scanHost) {
+		printHost("    ", host)
+	}
+	var hosts []scanHost
+	if cliFlags.Passive {
+		if hosts, err = passiveScan(subnet, exclude, report); err != nil {
+			return err
+		}
+	} else {
+		ips, err := scanHosts(subnet)
+		if err != nil {
+			return err
+		}
+		all := len(ips)
+		ips = excludeHosts(ips, exclude)
+		if skipped := all - len(ips); skipped > 0 {
+			progressf("Scanning %d addresses in %s (%d excluded)\n", len(ips), subnet, skipped)
+		} else {
+			progressf("Scanning %d addresses in %s\n", len(ips), subnet)
+		}
+		s := newScanner(cliFlags.Concurrency, timeoutFor(opScan), report)
+		s.progress = newProgress("scan "+subnet.String(), len(ips))
+		hosts = s.run(ips)
+		s.progress.finish()
+	}
+	progressf("Found %d hosts in %s\n", len(hosts), time.Since(start).Round(time.Millisecond))
+
+	if err := aliases.SaveScan(key, ScanSnapshot{start, hosts}); err != nil {
+		return err
+	}
+	if !cliFlags.Diff {
+		return nil
+	}
+	if !scanned {
+		progressf("No earlier scan of %s to compare with\n", subnet)
+		return nil
+	}
+
+	// Excluded hosts were not looked for, so they did not disappear.
+	var before []scanHost
+	for _, host := range last.Hosts {
+		if !excluded(host.IP, exclude) {
+			before = append(before, host)
+		}
+	}
+	appeared, disappeared := diffScans(before, hosts)
+	if len(appeared) == 0 && len(disappeared) == 0 {
+		progressf("No changes since the last scan (%s)\n", formatTime(last.Time, time.Now()))
+		return nil
+	}
+	fmt.Printf("Changes since the last scan (%s):\n", formatTime(last.Time, time.Now()))
+	for _, host := range appeared {
+		printHost("  + ", host)
+	}
+	for _, host := range disappeared {
+		printHost("  - ", host)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestScanHosts(t *testing.T) {
+	for _, tc := range []struct {
+		cidr        string
+		count       int
+		first, last string
+	}{
+		{"192.168.1.0/24", 254, "192.168.1.1", "192.168.1.254"},
+		{"192.168.1.77/24", 254, "192.168.1.1", "192.168.1.254"},
+		{"10.0.0.0/30", 2, "10.0.0.1", "10.0.0.2"},
+		{"10.0.0.4/31", 2, "10.0.0.4", "10.0.0.5"},
+		{"10.0.0.9/32", 1, "10.0.0.9", "10.0.0.9"},
+		{"10.0.0.0/16", 65534, "10.0.0.1", "10.0.255.254"},
+	} {
+		_, subnet, err := net.ParseCIDR(tc.cidr)
+		assert.Nil(t, err)
+		ips, err := scanHosts(subnet)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.count, len(ips))
+		assert.Equal(t, tc.first, ips[0].String())
+		assert.Equal(t, tc.last, ips[len(ips)-1].String())
+	}
+
+	for _, cidr := range []string{"10.0.0.0/8", "fd00::/120"} {
+		_, subnet, err := net.ParseCIDR(cidr)
+		assert.Nil(t, err)
+		_, err = scanHosts(subnet)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestExcludeHosts(t *testing.T) {
+	exclude, err := parseExcludes("192.168.1.1, 192.168.1.16/28,,fd00::1")
+	assert.Nil(t, err)
+	assert.Len(t, exclude, 3)
+
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	assert.Nil(t, err)
+	ips, err := scanHosts(subnet)
+	assert.Nil(t, err)
+	ips = excludeHosts(ips, exclude)
+	assert.Equal(t, 254-17, len(ips))
+	assert.Equal(t, "192.168.1.2", ips[0].String())
+	assert.Equal(t, "192.168.1.15", ips[13].String())
+	assert.Equal(t, "192.168.1.32", ips[14].String())
+	assert.True(t, excluded(net.ParseIP("fd00::1"), exclude))
+
+	_, err = parseExcludes("192.168.1.1,printer")
+	assert.EqualError(t, err, `invalid exclusion "printer" (expected an address or subnet)`)
+}
+
+func TestScanner(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/29")
+	ips, err := scanHosts(subnet)
+	assert.Nil(t, err)
+
+	var mtx sync.Mutex
+	var reported []scanHost
+	s := newScanner(4, 10*time.Millisecond, func(host scanHost) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		reported = append(reported, host)
+	})
+
+	// .2 is on the link (and in the neighbor table), .5 only answers probes
+	// and 10.0.0.1 is a neighbor outside of the scanned subnet.
+	s.probe = func(ip net.IP, _ time.Duration) bool {
+		return ip.Equal(net.ParseIP("192.168.1.2")) || ip.Equal(net.ParseIP("192.168.1.5"))
+	}
+	s.neighbors = func() (map[string]net.IP, error) {
+		return map[string]net.IP{
+			"00:00:00:00:00:02": net.ParseIP("192.168.1.2"),
+			"00:00:00:00:00:99": net.ParseIP("10.0.0.1"),
+		}, nil
+	}
+
+	hosts := s.run(ips)
+	assert.Equal(t, []scanHost{
+		{net.ParseIP("192.168.1.2"), "00:00:00:00:00:02"},
+		{net.ParseIP("192.168.1.5"), ""},
+	}, hosts)
+	assert.Equal(t, 2, len(reported))
+}
+
+// Entries already in the neighbor table before the scan only count for hosts
+// which answer, while entries resolved during the scan count regardless.
+func TestScannerStaleNeighbors(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/29")
+	ips, _ := scanHosts(subnet)
+
+	s := newScanner(4, 10*time.Millisecond, func(scanHost) {})
+	s.probe = func(ip net.IP, _ time.Duration) bool {
+		return ip.Equal(net.ParseIP("192.168.1.2"))
+	}
+	var mtx sync.Mutex
+	polls := 0
+	s.neighbors = func() (map[string]net.IP, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		table := map[string]net.IP{
+			"00:00:00:00:00:02": net.ParseIP("192.168.1.2"),
+			"00:00:00:00:00:03": net.ParseIP("192.168.1.3"),
+		}
+		if polls++; polls > 1 {
+			table["00:00:00:00:00:04"] = net.ParseIP("192.168.1.4")
+		}
+		return table, nil
+	}
+
+	assert.Equal(t, []scanHost{
+		{net.ParseIP("192.168.1.2"), "00:00:00:00:00:02"},
+		{net.ParseIP("192.168.1.4"), "00:00:00:00:00:04"},
+	}, s.run(ips))
+}
+
+func TestDiffScans(t *testing.T) {
+	a := scanHost{net.ParseIP("192.168.1.2"), "00:00:00:00:00:02"}
+	b := scanHost{net.ParseIP("192.168.1.3"), "00:00:00:00:00:03"}
+	c := scanHost{net.ParseIP("192.168.1.4"), ""}
+
+	// A host which got another address is the same host.
+	moved := scanHost{net.ParseIP("192.168.1.9"), "00:00:00:00:00:02"}
+
+	appeared, disappeared := diffScans([]scanHost{a, b}, []scanHost{moved, c})
+	assert.Equal(t, []scanHost{c}, appeared)
+	assert.Equal(t, []scanHost{b}, disappeared)
+
+	appeared, disappeared = diffScans([]scanHost{a}, []scanHost{a})
+	assert.Nil(t, appeared)
+	assert.Nil(t, disappeared)
+}
+
+func TestScanSnapshots(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestScanSnapshots.db")
+	defer cleanup()
+
+	_, ok, err := aliases.LastScan("192.168.1.0/24")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	snap := ScanSnapshot{
+		Time:  time.Unix(1500000000, 0),
+		Hosts: []scanHost{{net.ParseIP("192.168.1.2").To4(), "00:00:00:00:00:02"}},
+	}
+	assert.Nil(t, aliases.SaveScan("192.168.1.0/24", snap))
+
+	last, ok, err := aliases.LastScan("192.168.1.0/24")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, snap.Time.Equal(last.Time))
+	assert.Equal(t, snap.Hosts, last.Hosts)
+}
+
+func BenchmarkScanHosts(b *testing.B) {
+	_, subnet, _ := net.ParseCIDR("10.1.0.0/16")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		scanHosts(subnet)
+	}
+}
+
+// Runs a scan of a /24 with instant probes, which leaves the bookkeeping of
+// the scanner.
+func BenchmarkScanner(b *testing.B) {
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/24")
+	ips, _ := scanHosts(subnet)
+	table := map[string]net.IP{}
+	for idx, ip := range ips {
+		if idx%2 == 0 {
+			table[fmt.Sprintf("00:00:00:00:00:%02x", idx)] = ip
+		}
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := newScanner(64, 0, func(scanHost) {})
+		s.probe = func(ip net.IP, _ time.Duration) bool { return ip[15]%3 == 0 }
+		s.neighbors = func() (map[string]net.IP, error) { return table, nil }
+		s.run(ips)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	scansBucketName = "Scans"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ScanSnapshot is the result of a scan, as stored (per subnet) in the db.
+type ScanSnapshot struct {
+	Time  time.Time
+	Hosts []scanHost
+}
+
+// SaveScan stores `snap` as the latest scan of `subnet`.
+func (a *Aliases) SaveScan(subnet string, snap ScanSnapshot) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(snap); err != nil {
+		return err
+	}
+	return a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(scansBucketName)).Put([]byte(subnet), buf.Bytes())
+	})
+}
+
+// LastScan returns the latest scan of `subnet`, and false if it was never
+// scanned.
+func (a *Aliases) LastScan(subnet string) (ScanSnapshot, bool, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var snap ScanSnapshot
+	var ok bool
+	err := a.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(scansBucketName)).Get([]byte(subnet))
+		if v == nil {
+			return nil
+		}
+		ok = true
+		return gob.NewDecoder(bytes.NewBuffer(v)).Decode(&snap)
+	})
+	return snap, ok, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// key identifies a host across scans: by its MAC address where it is known,
+// as addresses are often handed out dynamically.
+func (h scanHost) key() string {
+	if h.MAC != "" {
+		return h.MAC
+	}
+	return h.IP.String()
+}
+
+// diffScans returns the hosts in `after` which are not in `before`, and
+// those in `before` which are not in `after`.
+func diffScans(before, after []scanHost) (appeared, disappeared []scanHost) {
+	index := func(hosts []scanHost) map[string]bool {
+		keys := map[string]bool{}
+		for _, h := range hosts {
+			keys[h.key()] = true
+		}
+		return keys
+	}
+
+	had, has := index(before), index(after)
+	for _, h := range after {
+		if !had[h.key()] {
+			appeared = append(appeared, h)
+		}
+	}
+	for _, h := range before {
+		if !has[h.key()] {
+			disappeared = append(disappeared, h)
+		}
+	}
+	return appeared, disappeared
+}
@@ -0,0 +1,210 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// keyringService is the service the secrets of go-wol are filed under in the
+// OS keyring.
+const keyringService = "go-wol"
+
+// errSecretRef is returned for a malformed secret reference.
+var errSecretRef = errors.New("expected env:<NAME>, keyring:<name> or prompt")
+
+////////////////////////////////////////////////////////////////////////////////
+
+// isSecretRef returns true if `s` refers to a secret kept outside the alias
+// db rather than being one: `env:<NAME>` (an environment variable),
+// `keyring:<name>` (an entry of the OS keyring) or `prompt` (asked for on the
+// terminal each time).
+func isSecretRef(s string) bool {
+	return s == "prompt" ||
+		strings.HasPrefix(s, "env:") && len(s) > len("env:") ||
+		strings.HasPrefix(s, "keyring:") && len(s) > len("keyring:")
+}
+
+// resolveSecret returns the secret `ref` refers to, printing `prompt` if it
+// must be asked for.
+func resolveSecret(ref, prompt string) (string, error) {
+	switch {
+	case ref == "prompt":
+		return promptPassword(prompt)
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		// Option variables such as WOL_TOKEN are sent wherever their
+		// option goes, so secrets are kept out of them.
+		if long, ok := optionEnvVars()[name]; ok {
+			return "", fmt.Errorf("%s is the environment variable of --%s, keep the secret in a variable of its own", name, long)
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("the environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, "keyring:"):
+		name := strings.TrimPrefix(ref, "keyring:")
+		secret, err := keyringGet(name)
+		if err != nil {
+			return "", fmt.Errorf("reading %s from the keyring failed: %v", name, err)
+		}
+		return secret, nil
+	}
+	return "", fmt.Errorf("invalid secret %q (%v)", ref, errSecretRef)
+}
+
+// toolError returns the error of a keyring tool, which is what it printed if
+// it failed.
+func toolError(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok {
+		if msg := strings.TrimSpace(string(ee.Stderr)); msg != "" {
+			return errors.New(msg)
+		}
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// specPassword returns the SecureOn password of the target `spec`, and the
+// query it was found in, if it has one.
+func specPassword(spec string) (string, url.Values, bool) {
+	i := strings.Index(spec, "?")
+	if i < 0 {
+		return "", nil, false
+	}
+	values, err := url.ParseQuery(spec[i+1:])
+	if err != nil || len(values["pw"]) == 0 {
+		return "", nil, false
+	}
+	pw := values["pw"]
+	return pw[len(pw)-1], values, true
+}
+
+// plaintextSpec returns true if the target `spec` carries its SecureOn
+// password itself, rather than a reference to it.
+func plaintextSpec(spec string) bool {
+	pw, _, ok := specPassword(spec)
+	return ok && !isSecretRef(pw)
+}
+
+// redactSpec returns the target `spec` without the SecureOn password it may
+// carry, for logs, events and traces. Secret references are kept.
+func redactSpec(spec string) string {
+	if !plaintextSpec(spec) {
+		return spec
+	}
+	_, values, _ := specPassword(spec)
+	values.Set("pw", "...")
+	return spec[:strings.Index(spec, "?")+1] + values.Encode()
+}
+
+// checkPlaintextSpec returns an error if the target `spec` of an alias
+// carries its SecureOn password itself and storing that is not allowed.
+func checkPlaintextSpec(alias, spec string) error {
+	if plaintextSpec(spec) && !cliFlags.AllowPlaintext {
+		return fmt.Errorf("refusing to store the SecureOn password of %s in the alias db in the clear: give pw=env:<NAME>, pw=keyring:<name> or pw=prompt, or --allow-plaintext", alias)
+	}
+	return nil
+}
+
+// targetOrigin is where a wake target came from, which decides whether the
+// secret references of its spec may be looked up.
+type targetOrigin int
+
+const (
+	// fromLocal targets are given on the command line or in the local
+	// config (e.g. of gpio buttons), and may refer to any secret.
+	fromLocal targetOrigin = iota
+
+	// fromClient targets are sent by clients of serve, coap or the control
+	// socket (or queued for them). Only the specs of aliases may refer to
+	// secrets, as a client could otherwise have this machine's secrets sent
+	// to an address of its choosing.
+	fromClient
+
+	// fromTenant targets are sent by the clients of a tenant of serve (or
+	// queued for them), and may not refer to secrets at all: this machine's
+	// secrets are not the tenant's, even for the tenant's own aliases.
+	fromTenant
+)
+
+// errClientSecretRef is returned for a target sent by a client which refers
+// to a secret.
+var errClientSecretRef = errors.New("SecureOn passwords sent by clients cannot refer to secrets (env:, keyring: or prompt), store the target as an alias instead")
+
+// errTenantSecretRef is returned for a target of a tenant which refers to a
+// secret.
+var errTenantSecretRef = errors.New("SecureOn passwords of tenants cannot refer to secrets (env:, keyring: or prompt)")
+
+// checkSpecSecret checks that the SecureOn password of the target `spec`, if
+// it refers to a secret, may be looked up for a target from `origin`, which
+// is the spec of an alias if `alias` is set. Daemons cannot prompt.
+func checkSpecSecret(spec string, alias bool, origin targetOrigin) error {
+	pw, _, ok := specPassword(spec)
+	if !ok || !isSecretRef(pw) || origin == fromLocal {
+		return nil
+	}
+	if origin == fromTenant {
+		return errTenantSecretRef
+	}
+	if !alias {
+		return errClientSecretRef
+	}
+	if pw == "prompt" {
+		return errors.New("the SecureOn password of the alias is to be prompted for, which a daemon cannot do")
+	}
+	return nil
+}
+
+// resolveSpecSecret returns the target `spec` with its SecureOn password
+// looked up, if it is given as a secret reference (e.g. `pw=env:NAS_PW`).
+func resolveSpecSecret(spec string) (string, error) {
+	pw, values, ok := specPassword(spec)
+	if !ok || !isSecretRef(pw) {
+		return spec, nil
+	}
+	secret, err := resolveSecret(pw, "SecureOn password: ")
+	if err != nil {
+		return "", err
+	}
+	values.Set("pw", secret)
+	return spec[:strings.Index(spec, "?")+1] + values.Encode(), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Run the secret command.
+func secretCmd(args []string, aliases *Aliases) error {
+	if len(args) != 2 {
+		return errors.New("secret command requires one of: set <name>, remove <name>")
+	}
+
+	switch sub, name := strings.ToLower(args[0]), args[1]; sub {
+	case "set":
+		secret, err := promptPassword(fmt.Sprintf("Secret to store as %s: ", name))
+		if err != nil {
+			return err
+		}
+		if err := keyringSet(name, secret); err != nil {
+			return fmt.Errorf("storing %s in the keyring failed: %v", name, err)
+		}
+		statusf("Stored %s in the keyring, refer to it as keyring:%s\n", name, name)
+		return nil
+
+	case "remove":
+		if err := keyringDel(name); err != nil {
+			return fmt.Errorf("removing %s from the keyring failed: %v", name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown secret command %q", args[0])
+}
@@ -0,0 +1,122 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestResolveSecret(t *testing.T) {
+	for _, ref := range []string{"prompt", "env:X", "keyring:nas"} {
+		assert.True(t, isSecretRef(ref), ref)
+	}
+	for _, ref := range []string{"", "env:", "keyring:", "hunter2", "00:11:22:33:44:55"} {
+		assert.False(t, isSecretRef(ref), ref)
+	}
+
+	os.Setenv("WOL_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("WOL_TEST_SECRET")
+	secret, err := resolveSecret("env:WOL_TEST_SECRET", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cret", secret)
+
+	_, err = resolveSecret("env:WOL_TEST_UNSET", "")
+	assert.EqualError(t, err, "the environment variable WOL_TEST_UNSET is not set")
+	os.Setenv("WOL_TOKEN", "s3cret")
+	defer os.Unsetenv("WOL_TOKEN")
+	_, err = resolveSecret("env:WOL_TOKEN", "")
+	assert.EqualError(t, err, "WOL_TOKEN is the environment variable of --token, keep the secret in a variable of its own")
+	_, err = resolveSecret("hunter2", "")
+	assert.EqualError(t, err, `invalid secret "hunter2" (expected env:<NAME>, keyring:<name> or prompt)`)
+}
+
+func TestCheckSpecSecret(t *testing.T) {
+	ref, plain := "00:11:22:33:44:55?pw=env:NAS_PW", "00:11:22:33:44:55?pw=1.2.3.4"
+	for _, tc := range []struct {
+		spec   string
+		alias  bool
+		origin targetOrigin
+		ok     bool
+	}{
+		{ref, false, fromLocal, true},
+		{ref, true, fromClient, true},
+		{ref, false, fromClient, false},
+		{plain, false, fromClient, true},
+		{ref, true, fromTenant, false},
+		{ref, false, fromTenant, false},
+		{plain, false, fromTenant, true},
+		{"00:11:22:33:44:55?pw=prompt", true, fromLocal, true},
+		{"00:11:22:33:44:55?pw=prompt", true, fromClient, false},
+	} {
+		err := checkSpecSecret(tc.spec, tc.alias, tc.origin)
+		assert.Equal(t, tc.ok, err == nil, "%+v", tc)
+	}
+}
+
+func TestResolveSpecSecret(t *testing.T) {
+	os.Setenv("WOL_TEST_PW", "aa:bb:cc:dd:ee:ff")
+	defer os.Unsetenv("WOL_TEST_PW")
+
+	assert.False(t, plaintextSpec("00:11:22:33:44:55"))
+	assert.False(t, plaintextSpec("00:11:22:33:44:55?pw=env:WOL_TEST_PW"))
+	assert.True(t, plaintextSpec("00:11:22:33:44:55@10.0.0.255?iface=eth0&pw=1.2.3.4"))
+
+	spec, err := resolveSpecSecret("00:11:22:33:44:55@10.0.0.255?iface=eth0&pw=env:WOL_TEST_PW")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:11:22:33:44:55@10.0.0.255?iface=eth0&pw=aa%3Abb%3Acc%3Add%3Aee%3Aff", spec)
+
+	spec, err = resolveSpecSecret("00:11:22:33:44:55?pw=1.2.3.4")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:11:22:33:44:55?pw=1.2.3.4", spec)
+}
+
+func TestKeepSecret(t *testing.T) {
+	defer func(allow bool) { cliFlags.AllowPlaintext = allow }(cliFlags.AllowPlaintext)
+	cliFlags.AllowPlaintext = false
+
+	oob := OOB{User: "root"}
+	assert.Nil(t, keepSecret(&oob, "env:IDRAC_PW"))
+	assert.Equal(t, "env:IDRAC_PW", oob.Secret)
+	assert.EqualError(t, keepSecret(&oob, "idrac"), `invalid --secret "idrac" (expected env:<NAME>, keyring:<name> or prompt)`)
+
+	oob = OOB{User: "root", Password: "calvin"}
+	assert.EqualError(t, keepSecret(&oob, "prompt"), "a password cannot be given with --secret prompt")
+	assert.NotNil(t, keepSecret(&oob, ""))
+	cliFlags.AllowPlaintext = true
+	assert.Nil(t, keepSecret(&oob, ""))
+	assert.Equal(t, "calvin", oob.Password)
+}
+
+func TestPlaintextSpecWrites(t *testing.T) {
+	defer func(allow bool) { cliFlags.AllowPlaintext = allow }(cliFlags.AllowPlaintext)
+	cliFlags.AllowPlaintext = false
+
+	aliases, cleanup := openTestAliases(t, "./TestPlaintextSpecWrites.db")
+	defer cleanup()
+
+	// Every way of writing an alias refuses passwords in the clear...
+	assert.Nil(t, aliases.Add("nas", "00:11:22:33:44:55?pw=env:NAS_PW", ""))
+	assert.NotNil(t, aliases.Add("tv", "00:11:22:33:44:66?pw=1.2.3.4", ""))
+	_, _, err := aliases.Merge(syncState{Aliases: []syncAlias{
+		{Name: "tv", Mac: "00:11:22:33:44:66?pw=1.2.3.4", Modified: time.Now()},
+	}})
+	assert.NotNil(t, err)
+	_, err = aliases.Get("tv")
+	assert.NotNil(t, err)
+
+	// ... unless allowed.
+	cliFlags.AllowPlaintext = true
+	assert.Nil(t, aliases.Add("tv", "00:11:22:33:44:66?pw=1.2.3.4", ""))
+}
+
+func TestRedactSpec(t *testing.T) {
+	assert.Equal(t, "nas", redactSpec("nas"))
+	assert.Equal(t, "00:11:22:33:44:55@192.0.2.1?pw=env:NAS_PW", redactSpec("00:11:22:33:44:55@192.0.2.1?pw=env:NAS_PW"))
+	assert.Equal(t, "00:11:22:33:44:55?iface=eth0&pw=...", redactSpec("00:11:22:33:44:55?iface=eth0&pw=s3cr3t"))
+}
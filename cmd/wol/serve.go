@@ -0,0 +1,509 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	defaultServeListen = "127.0.0.1:7788"
+
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// aliasEntry is the JSON representation of an alias in the API.
+type aliasEntry struct {
+	Name     string     `json:"name"`
+	Mac      string     `json:"mac"`
+	Iface    string     `json:"iface,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	IP       string     `json:"ip,omitempty"`
+	Host     string     `json:"host,omitempty"`
+	Cooldown int        `json:"cooldown,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+// newAliasEntry returns the JSON representation of `alias`.
+func newAliasEntry(alias Alias) aliasEntry {
+	e := aliasEntry{Name: alias.Name, Mac: alias.Mac, Iface: alias.Iface, Tags: alias.Tags, IP: alias.IP, Host: alias.Host, Cooldown: alias.Cooldown}
+	if !alias.Created.IsZero() {
+		e.Created = &alias.Created
+	}
+	if !alias.Modified.IsZero() {
+		e.Modified = &alias.Modified
+	}
+	return e
+}
+
+// aliasPage is a single page of the alias listing.
+type aliasPage struct {
+	Total   int          `json:"total"`
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+	Aliases []aliasEntry `json:"aliases"`
+}
+
+// aliasQuery holds the pagination, sorting and filtering parameters of an
+// alias listing request.
+type aliasQuery struct {
+	offset int
+	limit  int
+	sort   string
+	desc   bool
+	tags   []string
+}
+
+// aliasSortKeys maps the `sort` parameter to the field it orders by.
+var aliasSortKeys = map[string]func(aliasEntry) string{
+	"name":     func(e aliasEntry) string { return e.Name },
+	"mac":      func(e aliasEntry) string { return strings.ToLower(e.Mac) },
+	"iface":    func(e aliasEntry) string { return e.Iface },
+	"created":  func(e aliasEntry) string { return timeSortKey(e.Created) },
+	"modified": func(e aliasEntry) string { return timeSortKey(e.Modified) },
+}
+
+// timeSortKey returns a key which sorts like `t`, with unknown times first.
+func timeSortKey(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format("20060102150405.000000000")
+}
+
+// parseAliasQuery parses `?offset=&limit=&sort=[-]field&tag=...`.
+func parseAliasQuery(r *http.Request) (aliasQuery, error) {
+	q := aliasQuery{limit: defaultPageLimit, sort: "name"}
+	params := r.URL.Query()
+
+	var err error
+	if v := params.Get("offset"); v != "" {
+		if q.offset, err = strconv.Atoi(v); err != nil || q.offset < 0 {
+			return q, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit <= 0 || q.limit > maxPageLimit {
+			return q, fmt.Errorf("invalid limit %q (expected 1-%d)", v, maxPageLimit)
+		}
+	}
+	if v := params.Get("sort"); v != "" {
+		q.desc = strings.HasPrefix(v, "-")
+		q.sort = strings.TrimPrefix(v, "-")
+		if _, ok := aliasSortKeys[q.sort]; !ok {
+			return q, fmt.Errorf("invalid sort field %q", q.sort)
+		}
+	}
+	q.tags = params["tag"]
+	return q, nil
+}
+
+// queryAliases filters, sorts and paginates `list` according to `q`.
+func queryAliases(list []Alias, q aliasQuery) aliasPage {
+	entries := []aliasEntry{}
+	for _, alias := range list {
+		if alias.HasTags(q.tags...) {
+			entries = append(entries, newAliasEntry(alias))
+		}
+	}
+
+	// Ties are broken by name so that pages are stable.
+	key := aliasSortKeys[q.sort]
+	sort.Slice(entries, func(i, j int) bool {
+		ki, kj := key(entries[i]), key(entries[j])
+		if ki == kj {
+			ki, kj = entries[i].Name, entries[j].Name
+		}
+		if q.desc {
+			return ki > kj
+		}
+		return ki < kj
+	})
+
+	page := aliasPage{Total: len(entries), Offset: q.offset, Limit: q.limit}
+	if q.offset < len(entries) {
+		end := q.offset + q.limit
+		if end > len(entries) {
+			end = len(entries)
+		}
+		page.Aliases = entries[q.offset:end]
+	} else {
+		page.Aliases = []aliasEntry{}
+	}
+	return page
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// server implements the HTTP API of `wol serve`.
+type server struct {
+	aliases *Aliases
+	events  *eventBroker
+	mux     *http.ServeMux
+
+	ipLimiter    *rateLimiter
+	tokenLimiter *rateLimiter
+	signatures   *replayCache
+	idempotency  *idempotencyCache
+	oidc         *oidcProvider      // nil unless OIDC login is configured
+	tenants      map[string]*server // served under /t/<name>/
+	tenant       string             // the name of this server if a tenant's
+
+	passwordMtx sync.Mutex
+	passwords   map[uint64][]byte // SecureOn passwords of queued wakes, by ID
+}
+
+// origin returns where the wake targets sent to the server come from.
+func (s *server) origin() targetOrigin {
+	if s.tenant != "" {
+		return fromTenant
+	}
+	return fromClient
+}
+
+// newServer returns a server backed by `aliases`.
+func newServer(aliases *Aliases) *server {
+	s := &server{
+		aliases: aliases,
+		events:  newEventBroker(),
+		mux:     http.NewServeMux(),
+
+		ipLimiter:    newRateLimiter(cliFlags.RateLimitIP),
+		tokenLimiter: newRateLimiter(cliFlags.RateLimitToken),
+		signatures:   newReplayCache(),
+		idempotency:  newIdempotencyCache(),
+		tenants:      map[string]*server{},
+		passwords:    map[uint64][]byte{},
+	}
+	s.mux.HandleFunc("/aliases", s.authed(s.handleAliases))
+	s.mux.HandleFunc("/wake/", s.authed(s.handleWake))
+	s.mux.HandleFunc("/status", s.authed(s.handleStatus))
+	s.mux.HandleFunc("/events", s.authed(s.handleEvents))
+	s.mux.HandleFunc("/history", s.authed(s.handleHistory))
+	s.mux.HandleFunc("/sync", s.authed(s.handleSync))
+	s.mux.HandleFunc("/metrics", s.authed(s.handleMetrics))
+	s.mux.HandleFunc("/healthz", healthHandler)
+	s.mux.HandleFunc("/", s.handleDashboard)
+	return s
+}
+
+// ServeHTTP dispatches to the API handlers.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// errMethodNotAllowed returns the error for a request using an unsupported
+// method.
+func errMethodNotAllowed(r *http.Request) error {
+	return fmt.Errorf("method %s not allowed", r.Method)
+}
+
+// errNotFound returns the error for a request to an unknown path.
+func errNotFound(r *http.Request) error {
+	return fmt.Errorf("%s not found", r.URL.Path)
+}
+
+// writeJSON writes `v` as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// handleAliases serves `GET /aliases`, a sorted, filtered and paginated alias
+// listing. Responses carry an ETag so that clients can poll cheaply with
+// If-None-Match.
+func (s *server) handleAliases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+
+	q, err := parseAliasQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	list, err := s.visibleAliases(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	body, err := json.Marshal(queryAliases(list, q))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match == etag || match == "*" {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// handleWake serves `POST /wake/<alias or mac>`.
+func (s *server) handleWake(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+
+	target := strings.TrimPrefix(r.URL.Path, "/wake/")
+	if target == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no mac address or alias specified"))
+		return
+	}
+
+	if !s.mayWake(r, target) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("token may not wake %s", target))
+		return
+	}
+
+	w, finish, ok := s.idempotent(w, r, target)
+	if !ok {
+		return
+	}
+	defer finish()
+
+	if s.rateLimited(w, r) {
+		return
+	}
+
+	ctx, sp := startServerSpan(r, "POST /wake")
+	sp.set("wol.target", redactSpec(target))
+	wt, err := resolveWakeTarget(ctx, target, s.origin(), s.aliases)
+	if err != nil {
+		sp.finish(err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// The request is queued before the packet is sent, so that it is not
+	// lost if the daemon stops, and retried if sending fails. Only the
+	// target without its password is queued, see queuePassword.
+	target = wt.target
+	q, err := s.queueWake(wt, apiClient(r))
+	if err != nil {
+		sp.finish(err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	_, wait, retry, err := s.sendQueued(ctx, q, wt)
+	sp.finish(err)
+	if err != nil && retry {
+		log.Printf("Failed to wake %s (%s) for %s, retrying in %s: %v\n", target, wt.mac, r.RemoteAddr, retryDelay(1), err)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"target": target,
+			"mac":    wt.mac,
+			"queued": true,
+			"error":  err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		s.events.publish(event{Type: "wake", Target: target, Mac: wt.mac, Error: err.Error()})
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	s.events.publish(event{Type: "wake", Target: target, Mac: wt.mac, Coalesced: wait > 0})
+	log.Printf("Woke %s (%s) for %s\n", target, wt.mac, r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"target":    target,
+		"mac":       wt.mac,
+		"coalesced": wait > 0,
+	})
+}
+
+// handleEvents serves `GET /events`, only streaming events about targets the
+// request may wake.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	s.events.serveEvents(w, r, func(e event) bool {
+		return s.mayWake(r, e.Target)
+	})
+}
+
+// serverTLSConfig returns the TLS config serve uses with `--tls-cert` and
+// `--tls-key`, requiring client certificates issued by `--client-ca` if
+// given. It returns nil to serve plain HTTP.
+func serverTLSConfig() (*tls.Config, error) {
+	if cliFlags.TLSCert == "" && cliFlags.TLSKey == "" {
+		if cliFlags.ClientCA != "" {
+			return nil, errors.New("--client-ca requires --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cliFlags.TLSCert, cliFlags.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if cliFlags.ClientCA != "" {
+		pem, err := ioutil.ReadFile(cliFlags.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cliFlags.ClientCA)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Run the serve command.
+func serveCmd(args []string, aliases *Aliases) error {
+	if cliFlags.RateLimitIP < 0 {
+		return fmt.Errorf("invalid ip rate limit %d", cliFlags.RateLimitIP)
+	}
+	if cliFlags.RateLimitToken < 0 {
+		return fmt.Errorf("invalid token rate limit %d", cliFlags.RateLimitToken)
+	}
+	if _, err := parseTrustedProxies(); err != nil {
+		return err
+	}
+	if cliFlags.QueueRetries < 0 || cliFlags.QueueBackoff < 0 || cliFlags.QueueMaxAge < 0 {
+		return errors.New("--queue-retries, --queue-backoff and --queue-max-age may not be negative")
+	}
+
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	oidc, err := newOIDCProvider(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if oidc != nil && len(cliFlags.Tenants) > 0 {
+		return errors.New("--oidc-issuer cannot be used with --tenant")
+	}
+
+	s := newServer(aliases)
+	if oidc != nil {
+		s.enableOIDC(oidc)
+		if !tokensHaveGroups(aliases) {
+			log.Printf("WARNING: no token is mapped to a group (token add --oidc-group), so no one logging in may use the dashboard\n")
+		}
+	}
+	defer s.closeTenants()
+	if err := s.openTenants(); err != nil {
+		return err
+	}
+
+	// Each db gets the URL of its API, and its history pruned and queue
+	// worked through.
+	srv := &http.Server{Addr: listenAddr(defaultServeListen), Handler: s, TLSConfig: tlsConfig}
+	apiURL, err := daemonAPIURL(srv.Addr, tlsConfig != nil)
+	if err != nil {
+		return err
+	}
+	stop := shutdownSignal()
+	servers := map[string]*server{"": s}
+	for name, ts := range s.tenants {
+		servers[tenantPrefix+name] = ts
+	}
+	for path, ts := range servers {
+		if remove, err := advertiseDaemon(ts.aliases, apiURL+path); err != nil {
+			log.Printf("Could not leave the API URL for other wol commands: %v\n", err)
+		} else {
+			defer remove()
+		}
+		go pruneHistory(ts.aliases, stop)
+		go ts.runQueue(stop)
+	}
+	watchInterfaces(stop, nil)
+
+	// Listen before serving, so that the daemon is only reported ready once
+	// it accepts connections.
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, 2)
+	go func() {
+		if tlsConfig == nil {
+			log.Printf("Serving the HTTP API on %s\n", srv.Addr)
+			errs <- srv.Serve(ln)
+			return
+		}
+		log.Printf("Serving the HTTP API over HTTPS on %s\n", srv.Addr)
+		errs <- srv.ServeTLS(ln, "", "")
+	}()
+	for _, name := range s.tenantNames() {
+		log.Printf("Serving tenant %s on %s%s/\n", name, tenantPrefix, name)
+	}
+
+	// Other commands on this machine go through the control API on the
+	// socket rather than opening the alias db themselves.
+	var sock *http.Server
+	if l, err := listenSocket(socketPath()); err != nil {
+		log.Printf("Not serving on the socket: %v\n", err)
+	} else if l != nil {
+		pl := newPeerListener(l)
+		sock = &http.Server{Handler: s.controlHandler(pl)}
+		log.Printf("Serving the control API on %s\n", l.Addr())
+		go func() { errs <- sock.Serve(pl) }()
+	}
+
+	done, err := daemonReady()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+	}
+
+	// Stop accepting connections and wait for in-flight wakes to finish.
+	// Event streams never finish on their own, so they are ended first.
+	for _, ts := range servers {
+		ts.events.close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if sock != nil {
+		sock.Shutdown(ctx)
+	}
+	return srv.Shutdown(ctx)
+}
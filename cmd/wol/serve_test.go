@@ -0,0 +1,457 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestQueryAliases(t *testing.T) {
+	now := time.Now()
+	list := []Alias{
+		{Name: "a", MacIface: MacIface{Mac: "00:00:00:00:00:03", Iface: "eth0", Tags: []string{"lab"}}, Modified: now},
+		{Name: "b", MacIface: MacIface{Mac: "00:00:00:00:00:02", Tags: []string{"lab", "rack1"}}, Modified: now.Add(time.Hour)},
+		{Name: "c", MacIface: MacIface{Mac: "00:00:00:00:00:01"}},
+	}
+
+	names := func(page aliasPage) []string {
+		var out []string
+		for _, e := range page.Aliases {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		q        aliasQuery
+		total    int
+		expected []string
+	}{
+		{aliasQuery{limit: 10, sort: "name"}, 3, []string{"a", "b", "c"}},
+		{aliasQuery{limit: 10, sort: "name", desc: true}, 3, []string{"c", "b", "a"}},
+		{aliasQuery{limit: 10, sort: "mac"}, 3, []string{"c", "b", "a"}},
+		{aliasQuery{limit: 2, sort: "name"}, 3, []string{"a", "b"}},
+		{aliasQuery{offset: 2, limit: 2, sort: "name"}, 3, []string{"c"}},
+		{aliasQuery{offset: 5, limit: 2, sort: "name"}, 3, nil},
+		{aliasQuery{limit: 10, sort: "name", tags: []string{"lab"}}, 2, []string{"a", "b"}},
+		{aliasQuery{limit: 10, sort: "name", tags: []string{"lab", "rack1"}}, 1, []string{"b"}},
+		{aliasQuery{limit: 10, sort: "modified", desc: true}, 3, []string{"b", "a", "c"}},
+	} {
+		page := queryAliases(list, tc.q)
+		assert.Equal(t, tc.total, page.Total)
+		assert.Equal(t, tc.expected, names(page))
+	}
+}
+
+func TestParseAliasQueryNegative(t *testing.T) {
+	for _, query := range []string{
+		"offset=-1",
+		"offset=x",
+		"limit=0",
+		"limit=100000",
+		"sort=color",
+	} {
+		_, err := parseAliasQuery(httptest.NewRequest("GET", "/aliases?"+query, nil))
+		assert.NotNil(t, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type ServerTests struct {
+	suite.Suite
+	dbName  string
+	aliases *Aliases
+	server  *server
+}
+
+func (suite *ServerTests) SetupTest() {
+	var err error
+	suite.dbName = "./ServerTests.db"
+	suite.aliases, err = LoadAliases(suite.dbName)
+	assert.Nil(suite.T(), err)
+	suite.server = newServer(suite.aliases)
+
+	assert.Nil(suite.T(), suite.aliases.Add("one", "00:00:00:00:00:01", "", "lab"))
+	assert.Nil(suite.T(), suite.aliases.Add("two", "00:00:00:00:00:02", ""))
+}
+
+func (suite *ServerTests) TearDownTest() {
+	assert.Nil(suite.T(), suite.aliases.Close())
+	assert.Nil(suite.T(), os.Remove(suite.dbName))
+}
+
+// Performs a request against the server under test.
+func (suite *ServerTests) do(method, url string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	suite.server.ServeHTTP(rec, req)
+	return rec
+}
+
+// Validates listing aliases and conditional requests.
+func (suite *ServerTests) TestListAliases() {
+	rec := suite.do("GET", "/aliases?tag=lab", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	var page aliasPage
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(suite.T(), 1, page.Total)
+	assert.Equal(suite.T(), "one", page.Aliases[0].Name)
+
+	etag := rec.Header().Get("ETag")
+	assert.NotEmpty(suite.T(), etag)
+
+	// An unchanged listing is not resent.
+	rec = suite.do("GET", "/aliases?tag=lab", map[string]string{"If-None-Match": etag})
+	assert.Equal(suite.T(), http.StatusNotModified, rec.Code)
+
+	// A changed listing is.
+	assert.Nil(suite.T(), suite.aliases.Add("three", "00:00:00:00:00:03", "", "lab"))
+	rec = suite.do("GET", "/aliases?tag=lab", map[string]string{"If-None-Match": etag})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	rec = suite.do("GET", "/aliases?limit=0", nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	rec = suite.do("DELETE", "/aliases", nil)
+	assert.Equal(suite.T(), http.StatusMethodNotAllowed, rec.Code)
+}
+
+// Validates the wake endpoint's request handling.
+func (suite *ServerTests) TestWakeNegative() {
+	rec := suite.do("GET", "/wake/one", nil)
+	assert.Equal(suite.T(), http.StatusMethodNotAllowed, rec.Code)
+
+	rec = suite.do("POST", "/wake/", nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	rec = suite.do("POST", "/wake/not-an-alias", nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	// Clients cannot have secrets of the server sent where they like, nor
+	// learn whether they exist.
+	os.Setenv("WOL_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("WOL_TEST_SECRET")
+	for _, pw := range []string{"env:WOL_TEST_SECRET", "env:WOL_TEST_UNSET", "keyring:nas", "prompt"} {
+		rec = suite.do("POST", "/wake/00:11:22:33:44:55@127.0.0.1:9%3Fpw="+pw, nil)
+		assert.Equal(suite.T(), http.StatusBadRequest, rec.Code, pw)
+		assert.Contains(suite.T(), rec.Body.String(), "cannot refer to secrets", pw)
+	}
+	queued, err := suite.aliases.QueuedWakes(time.Time{}, true)
+	assert.Nil(suite.T(), err)
+	assert.Empty(suite.T(), queued)
+}
+
+// Validates the dashboard and status endpoints.
+func (suite *ServerTests) TestDashboard() {
+	rec := suite.do("GET", "/", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Contains(suite.T(), rec.Header().Get("Content-Type"), "text/html")
+
+	rec = suite.do("GET", "/nope", nil)
+	assert.Equal(suite.T(), http.StatusNotFound, rec.Code)
+
+	rec = suite.do("GET", "/status", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	var statuses map[string]aliasStatus
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &statuses))
+	assert.Equal(suite.T(), 2, len(statuses))
+	assert.Nil(suite.T(), statuses["one"].LastWake)
+
+	// The history graph asks for the history since a JavaScript timestamp.
+	rec = suite.do("GET", "/history?since=2024-01-02T23:00:00.000Z", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+}
+
+// Validates token authentication and per-alias authorization.
+func (suite *ServerTests) TestAuth() {
+	assert.Nil(suite.T(), suite.aliases.AddToken("admin", Token{Hash: hashTokenSecret("admin")}))
+	assert.Nil(suite.T(), suite.aliases.AddToken("lab", Token{
+		Hash: hashTokenSecret("lab"),
+		Tags: []string{"lab"},
+	}))
+	bearer := func(secret string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + secret}
+	}
+
+	// Requests without a valid token are rejected, except for the
+	// dashboard and health checks.
+	rec := suite.do("GET", "/aliases", nil)
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	rec = suite.do("GET", "/status", bearer("wrong"))
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+	rec = suite.do("GET", "/", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	rec = suite.do("GET", "/healthz", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+
+	// An unrestricted token sees everything.
+	var page aliasPage
+	rec = suite.do("GET", "/aliases", bearer("admin"))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(suite.T(), 2, page.Total)
+
+	// A restricted token only sees its aliases, also when given as a query
+	// parameter.
+	rec = suite.do("GET", "/aliases?token=lab", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(suite.T(), 1, page.Total)
+	assert.Equal(suite.T(), "one", page.Aliases[0].Name)
+
+	var statuses map[string]aliasStatus
+	rec = suite.do("GET", "/status", bearer("lab"))
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &statuses))
+	assert.Equal(suite.T(), 1, len(statuses))
+
+	// ... and may not wake other aliases or raw MAC addresses.
+	rec = suite.do("POST", "/wake/two", bearer("lab"))
+	assert.Equal(suite.T(), http.StatusForbidden, rec.Code)
+	rec = suite.do("POST", "/wake/00:00:00:00:00:01", bearer("lab"))
+	assert.Equal(suite.T(), http.StatusForbidden, rec.Code)
+}
+
+// Validates requests signed with an HMAC instead of carrying a token.
+func (suite *ServerTests) TestSignedRequests() {
+	assert.Nil(suite.T(), suite.aliases.AddToken("admin", Token{Hash: hashTokenSecret("admin")}))
+	assert.Nil(suite.T(), suite.aliases.AddToken("sensor", Token{
+		Hash:       hashTokenSecret("k3y"),
+		Tags:       []string{"lab"},
+		SigningKey: []byte("k3y"),
+	}))
+	signed := func(name, key, path string, ts int64) map[string]string {
+		sig := requestSignature([]byte(key), "GET", path, ts, nil)
+		return map[string]string{"Authorization": fmt.Sprintf("HMAC-SHA256 %s:%d:%x", name, ts, sig)}
+	}
+	now := time.Now().Unix()
+
+	var page aliasPage
+	rec := suite.do("GET", "/aliases", signed("sensor", "k3y", "/aliases", now))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(suite.T(), 1, page.Total)
+
+	// Each signature is accepted once, within the window, and only from
+	// tokens which may sign.
+	for _, header := range []map[string]string{
+		signed("sensor", "k3y", "/aliases", now),
+		signed("sensor", "k3y", "/aliases", now-600),
+		signed("sensor", "wrong", "/aliases", now+1),
+		signed("sensor", "k3y", "/aliases?tag=x", now+2),
+		signed("admin", "admin", "/aliases", now),
+		{"Authorization": "HMAC-SHA256 sensor"},
+	} {
+		rec = suite.do("GET", "/aliases", header)
+		assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code, header["Authorization"])
+	}
+}
+
+// Validates that verified client certificates authenticate as the token
+// their name is mapped to.
+func (suite *ServerTests) TestClientCertificates() {
+	assert.Nil(suite.T(), suite.aliases.AddToken("sensor", Token{
+		Hash:  hashTokenSecret("sensor"),
+		Tags:  []string{"lab"},
+		Certs: []string{"sensor1.lab"},
+	}))
+	withCert := func(cert *x509.Certificate) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/aliases", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		rec := httptest.NewRecorder()
+		suite.server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var page aliasPage
+	rec := withCert(&x509.Certificate{DNSNames: []string{"sensor1.lab"}})
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(suite.T(), 1, page.Total)
+
+	// Certificates mapped to no token still need one.
+	rec = withCert(&x509.Certificate{Subject: pkix.Name{CommonName: "laptop"}})
+	assert.Equal(suite.T(), http.StatusUnauthorized, rec.Code)
+}
+
+// Validates that wakes are rate limited.
+func (suite *ServerTests) TestRateLimit() {
+	suite.server.ipLimiter = newRateLimiter(1)
+
+	// The first wake uses up the limit (and then fails, as the alias is
+	// unknown), the second is rejected before the alias is looked up.
+	rec := suite.do("POST", "/wake/not-an-alias", nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	rec = suite.do("POST", "/wake/not-an-alias", nil)
+	assert.Equal(suite.T(), http.StatusTooManyRequests, rec.Code)
+	assert.Equal(suite.T(), "60", rec.Header().Get("Retry-After"))
+
+	rec = suite.do("GET", "/metrics", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Contains(suite.T(), rec.Body.String(), `wol_wake_requests_total{scope="ip",outcome="limited"} 1`)
+}
+
+// Validates the history endpoint.
+func (suite *ServerTests) TestHistory() {
+	now := time.Now()
+	assert.Nil(suite.T(), suite.aliases.AddHistory(HistoryEntry{Time: now, Target: "one", By: "cli"}))
+	assert.Nil(suite.T(), suite.aliases.AddHistory(HistoryEntry{Time: now, Target: "two", By: "cli"}))
+
+	var entries []HistoryEntry
+	rec := suite.do("GET", "/history?alias=one", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Equal(suite.T(), 1, len(entries))
+
+	rec = suite.do("GET", "/history?format=csv", nil)
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Equal(suite.T(), "text/csv", rec.Header().Get("Content-Type"))
+	assert.Equal(suite.T(), 3, strings.Count(rec.Body.String(), "\n"))
+
+	rec = suite.do("GET", "/history?since="+now.Add(time.Minute).Format(time.RFC3339), nil)
+	assert.Equal(suite.T(), "[]\n", rec.Body.String())
+
+	rec = suite.do("GET", "/history?since=tomorrow", nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	rec = suite.do("GET", "/history?format=text", nil)
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+
+	// Restricted tokens only see their aliases' history.
+	assert.Nil(suite.T(), suite.aliases.AddToken("lab", Token{Hash: hashTokenSecret("lab"), Tags: []string{"lab"}}))
+	rec = suite.do("GET", "/history?token=lab", nil)
+	assert.Nil(suite.T(), json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Equal(suite.T(), 1, len(entries))
+	assert.Equal(suite.T(), "one", entries[0].Target)
+}
+
+func TestServerSuite(t *testing.T) {
+	suite.Run(t, new(ServerTests))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// newTestCert returns a certificate (and its key) for `name`, signed by
+// `parent` (or self-signed if nil), in PEM.
+func newTestCert(t *testing.T, name string, isCA bool, parent *tls.Certificate) (tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.DNSNames, template.IPAddresses = nil, []net.IP{ip}
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.Nil(t, err)
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return cert, certPEM, keyPEM
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	defer func(cert, key, ca string) {
+		cliFlags.TLSCert, cliFlags.TLSKey, cliFlags.ClientCA = cert, key, ca
+	}(cliFlags.TLSCert, cliFlags.TLSKey, cliFlags.ClientCA)
+	dir, err := ioutil.TempDir("", "TestServerTLSConfig")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cliFlags.TLSCert, cliFlags.TLSKey, cliFlags.ClientCA = "", "", ""
+	config, err := serverTLSConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, config)
+	cliFlags.ClientCA = "ca.pem"
+	_, err = serverTLSConfig()
+	assert.EqualError(t, err, "--client-ca requires --tls-cert and --tls-key")
+
+	ca, caPEM, _ := newTestCert(t, "ca", true, nil)
+	_, serverPEM, serverKey := newTestCert(t, "127.0.0.1", false, &ca)
+	client, _, _ := newTestCert(t, "sensor1.lab", false, &ca)
+	stranger, _, _ := newTestCert(t, "sensor1.lab", false, nil)
+	cliFlags.TLSCert = filepath.Join(dir, "cert.pem")
+	cliFlags.TLSKey = filepath.Join(dir, "key.pem")
+	cliFlags.ClientCA = filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(cliFlags.TLSCert, serverPEM, 0600))
+	assert.Nil(t, ioutil.WriteFile(cliFlags.TLSKey, serverKey, 0600))
+	assert.Nil(t, ioutil.WriteFile(cliFlags.ClientCA, caPEM, 0600))
+	config, err = serverTLSConfig()
+	assert.Nil(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(certNames(r.TLS.VerifiedChains[0][0])[0]))
+	}))
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(cert tls.Certificate) (string, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Leaf)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{cert},
+		}}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+	name, err := get(client)
+	assert.Nil(t, err)
+	assert.Equal(t, "sensor1.lab", name)
+
+	// Certificates from another CA (or none) are refused in the handshake.
+	_, err = get(stranger)
+	assert.NotNil(t, err)
+	_, err = get(tls.Certificate{})
+	assert.NotNil(t, err)
+}
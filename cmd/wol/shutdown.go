@@ -0,0 +1,60 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// How long in-flight requests get to finish once a daemon is asked to
+	// stop.
+	shutdownTimeout = 30 * time.Second
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// shutdownSignal returns a channel which is closed once the process receives
+// SIGINT or SIGTERM. Daemon modes stop accepting requests when it is closed,
+// finish the ones in flight and return, so that deferred cleanup (such as
+// closing the alias db) runs.
+func shutdownSignal() <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	stop := make(chan struct{})
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		log.Printf("Received %s, shutting down\n", sig)
+		close(stop)
+	}()
+	return stop
+}
+
+// stopping returns true if `stop` has been closed.
+func stopping(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// unblockOnStop interrupts pending reads on `conn` once `stop` is closed. The
+// request being handled (if any) is unaffected, and the read loop should
+// return once stopping(stop) is true.
+func unblockOnStop(conn *net.UDPConn, stop <-chan struct{}) {
+	go func() {
+		<-stop
+		conn.SetReadDeadline(time.Now())
+	}()
+}
@@ -0,0 +1,38 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestUnblockOnStop(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	stop := make(chan struct{})
+	unblockOnStop(conn, stop)
+	assert.False(t, stopping(stop))
+
+	read := make(chan error)
+	go func() {
+		_, _, err := conn.ReadFromUDP(make([]byte, 16))
+		read <- err
+	}()
+
+	close(stop)
+	select {
+	case err := <-read:
+		assert.NotNil(t, err)
+		assert.True(t, stopping(stop))
+	case <-time.After(5 * time.Second):
+		t.Fatal("read was not interrupted")
+	}
+}
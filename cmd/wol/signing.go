@@ -0,0 +1,135 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// signatureScheme prefixes the Authorization header of a signed request:
+	// `HMAC-SHA256 <token name>:<unix time>:<hex signature>`.
+	signatureScheme = "HMAC-SHA256 "
+
+	// signatureWindow is how far the timestamp of a signed request may be
+	// from the server's clock. Signatures are remembered for this long, so
+	// that each is accepted once.
+	signatureWindow = 5 * time.Minute
+
+	// maxSignedBody caps the body read to check a signature.
+	maxSignedBody = 1 << 20
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// requestSignature returns the signature of a request under `key`: the HMAC-
+// SHA256 of its method, path, timestamp and body, one per line.
+func requestSignature(key []byte, method, path string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n", method, path, timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// parseSignature splits the Authorization header of a signed request into
+// the token name, timestamp and signature.
+func parseSignature(auth string) (string, int64, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(auth, signatureScheme), ":")
+	if len(parts) != 3 {
+		return "", 0, nil, errors.New("malformed signature (expected <token>:<timestamp>:<signature>)")
+	}
+	timestamp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("invalid signature timestamp %q", parts[1])
+	}
+	sig, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return "", 0, nil, errors.New("invalid signature (expected hex)")
+	}
+	return parts[0], timestamp, sig, nil
+}
+
+// replayCache remembers the signatures accepted within the signature window.
+type replayCache struct {
+	sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: map[string]time.Time{}}
+}
+
+// firstUse records `sig`, and returns false if it was already used.
+func (c *replayCache) firstUse(sig []byte, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, expires := range c.seen {
+		if now.After(expires) {
+			delete(c.seen, key)
+		}
+	}
+	key := string(sig)
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = now.Add(2 * signatureWindow)
+	return true
+}
+
+// verifySignature checks the signed request `r` against `tokens`, and
+// returns the token which signed it. The request body is read, and replaced
+// so that handlers can still read it.
+func (s *server) verifySignature(r *http.Request, tokens map[string]Token) (*Token, error) {
+	name, timestamp, sig, err := parseSignature(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+	tok, ok := tokens[name]
+	if !ok || len(tok.SigningKey) == 0 {
+		return nil, fmt.Errorf("token %s may not sign requests", name)
+	}
+
+	now := time.Now()
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > signatureWindow || skew < -signatureWindow {
+		return nil, errors.New("signature timestamp is too far from the server's clock")
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBody {
+		return nil, errors.New("request body too large to check its signature")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// The URI is the one the client sent, before the prefix of a tenant is
+	// stripped from the path.
+	uri := r.RequestURI
+	if !strings.HasPrefix(uri, "/") {
+		uri = r.URL.RequestURI()
+	}
+	expected := requestSignature(tok.SigningKey, r.Method, uri, timestamp, body)
+	if !hmac.Equal(sig, expected) {
+		return nil, errors.New("invalid signature")
+	}
+	if !s.signatures.firstUse(sig, now) {
+		return nil, errors.New("signature already used")
+	}
+	return &tok, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseSignature(t *testing.T) {
+	name, ts, sig, err := parseSignature("HMAC-SHA256 sensor:1700000000:0a0b")
+	assert.Nil(t, err)
+	assert.Equal(t, "sensor", name)
+	assert.Equal(t, int64(1700000000), ts)
+	assert.Equal(t, []byte{0x0a, 0x0b}, sig)
+
+	for _, auth := range []string{
+		"HMAC-SHA256 sensor",
+		"HMAC-SHA256 sensor:yesterday:0a0b",
+		"HMAC-SHA256 sensor:1700000000:xyz",
+	} {
+		_, _, _, err := parseSignature(auth)
+		assert.NotNil(t, err, auth)
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	c := newReplayCache()
+	now := time.Now()
+	assert.True(t, c.firstUse([]byte("a"), now))
+	assert.True(t, c.firstUse([]byte("b"), now))
+	assert.False(t, c.firstUse([]byte("a"), now.Add(signatureWindow)))
+
+	// Signatures are forgotten once their timestamp cannot pass any more.
+	assert.True(t, c.firstUse([]byte("a"), now.Add(3*signatureWindow)))
+	assert.Equal(t, 1, len(c.seen))
+}
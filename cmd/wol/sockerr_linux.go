@@ -0,0 +1,35 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"syscall"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// socketError returns the pending error (SO_ERROR) of `conn`, which reports
+// failures the kernel only noticed after a write returned, such as an
+// unreachable network.
+func socketError(conn *net.UDPConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var soErr int
+	var gerr error
+	if err := rc.Control(func(fd uintptr) {
+		soErr, gerr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+	}); err != nil {
+		return err
+	}
+	if gerr != nil {
+		return gerr
+	}
+	if soErr != 0 {
+		return syscall.Errno(soErr)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// socketError is only implemented on Linux.
+func socketError(conn *net.UDPConn) error {
+	return nil
+}
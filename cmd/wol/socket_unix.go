@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+// defaultSocketPath is the unix socket `serve` listens on, and other commands
+// look for it at, unless `--socket` says otherwise.
+const defaultSocketPath = "/var/run/go-wol.sock"
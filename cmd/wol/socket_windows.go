@@ -0,0 +1,7 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+// defaultSocketPath is empty, as `serve` only listens on a unix socket on
+// Windows if `--socket` gives one.
+const defaultSocketPath = ""
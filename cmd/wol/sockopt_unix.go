@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"syscall"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// setMulticastOptions sets the multicast TTL of the socket `fd`, and its
+// egress interface if `ifaceIP` is not nil.
+func setMulticastOptions(fd uintptr, ttl int, ifaceIP net.IP) error {
+	if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl); err != nil {
+		return err
+	}
+	if ifaceIP == nil {
+		return nil
+	}
+	var addr [4]byte
+	copy(addr[:], ifaceIP)
+	return syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+}
+
+// setTTL sets the IP TTL of unicast and broadcast packets sent on `fd`.
+func setTTL(fd uintptr, ttl int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// setTOS sets the TOS byte (or, for IPv6 sockets, the traffic class) of
+// packets sent on `fd`.
+func setTOS(fd uintptr, tos int, ipv6 bool) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
@@ -0,0 +1,39 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// setMulticastOptions sets the multicast TTL of the socket `fd`, and its
+// egress interface if `ifaceIP` is not nil.
+func setMulticastOptions(fd uintptr, ttl int, ifaceIP net.IP) error {
+	if err := syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl); err != nil {
+		return err
+	}
+	if ifaceIP == nil {
+		return nil
+	}
+	var addr [4]byte
+	copy(addr[:], ifaceIP)
+	return syscall.SetsockoptInet4Addr(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+}
+
+// setTTL sets the IP TTL of unicast and broadcast packets sent on `fd`.
+func setTTL(fd uintptr, ttl int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// setTOS sets the TOS byte of packets sent on `fd`. Windows only honors it
+// where a QoS policy allows, and has no equivalent for IPv6 sockets.
+func setTOS(fd uintptr, tos int, ipv6 bool) error {
+	if ipv6 {
+		return errors.New("--dscp is not supported for IPv6 on windows")
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
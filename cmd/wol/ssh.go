@@ -0,0 +1,54 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	defaultSSHPort = "22"
+
+	// sshDialTimeout caps a single connection attempt, so that an
+	// unanswered SYN does not use up the whole verification timeout.
+	sshDialTimeout = 5 * time.Second
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// sshAddr adds the default SSH port to `host` unless it has one.
+func sshAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultSSHPort)
+}
+
+// probeSSH considers `host` awake once its SSH server sends its
+// identification banner (`SSH-2.0-...`). Unlike a ping this only succeeds
+// once the machine has booted far enough to run services. `host` may carry
+// a port, which defaults to 22.
+func probeSSH(host string, deadline time.Time) (bool, error) {
+	timeout := time.Until(deadline)
+	if timeout > sshDialTimeout {
+		timeout = sshDialTimeout
+	}
+	conn, err := net.DialTimeout("tcp", sshAddr(host), timeout)
+	if err != nil {
+		// Refused and unanswered connections just mean "not yet".
+		return false, nil
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(deadline)
+	banner, err := bufio.NewReaderSize(conn, 256).ReadString('\n')
+	if err != nil {
+		return false, nil
+	}
+	return strings.HasPrefix(banner, "SSH-"), nil
+}
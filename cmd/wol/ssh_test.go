@@ -0,0 +1,58 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Serves `banner` to every connection on a local port, returning its address.
+func serveBanner(t *testing.T, banner string) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(banner))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestSSHAddr(t *testing.T) {
+	assert.Equal(t, "nas:22", sshAddr("nas"))
+	assert.Equal(t, "nas:2222", sshAddr("nas:2222"))
+	assert.Equal(t, "[fe80::1]:22", sshAddr("fe80::1"))
+	assert.Equal(t, "[fe80::1]:22", sshAddr("[fe80::1]"))
+}
+
+func TestProbeSSH(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	addr, stop := serveBanner(t, "SSH-2.0-OpenSSH_7.4\r\n")
+	up, err := probeSSH(addr, deadline)
+	assert.Nil(t, err)
+	assert.True(t, up)
+	stop()
+
+	addr, stop = serveBanner(t, "HTTP/1.1 400 Bad Request\r\n")
+	up, err = probeSSH(addr, deadline)
+	assert.Nil(t, err)
+	assert.False(t, up)
+	stop()
+
+	// Nothing listens on the port any more.
+	up, err = probeSSH(addr, deadline)
+	assert.Nil(t, err)
+	assert.False(t, up)
+}
@@ -0,0 +1,125 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// wakeStats summarizes the wake history of a single target.
+type wakeStats struct {
+	Target    string
+	Attempts  int // magic packets sent (or which failed to send)
+	Failed    int // attempts which failed to send
+	Coalesced int // wakes skipped within the cooldown
+	Verified  int // verifications which saw the host come up
+	Timeouts  int // verifications which did not
+
+	upTotal time.Duration
+}
+
+// successRate returns the share of verified wakes which saw the host come
+// up, and false if no wake of the target was ever verified.
+func (s *wakeStats) successRate() (float64, bool) {
+	n := s.Verified + s.Timeouts
+	if n == 0 {
+		return 0, false
+	}
+	return float64(s.Verified) / float64(n), true
+}
+
+// averageUp returns how long the target took to come up on average.
+func (s *wakeStats) averageUp() time.Duration {
+	if s.Verified == 0 {
+		return 0
+	}
+	return s.upTotal / time.Duration(s.Verified)
+}
+
+// computeStats groups history `entries` by target and returns their
+// statistics, sorted by target.
+func computeStats(entries []HistoryEntry) []*wakeStats {
+	byTarget := map[string]*wakeStats{}
+	var stats []*wakeStats
+	for _, e := range entries {
+		s, ok := byTarget[e.Target]
+		if !ok {
+			s = &wakeStats{Target: e.Target}
+			byTarget[e.Target] = s
+			stats = append(stats, s)
+		}
+
+		switch {
+		case e.Verify != "" && e.Error != "":
+			s.Timeouts++
+		case e.Verify != "":
+			s.Verified++
+			s.upTotal += e.UpAfter
+		case e.Coalesced:
+			s.Coalesced++
+		default:
+			s.Attempts++
+			if e.Error != "" {
+				s.Failed++
+			}
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Target < stats[j].Target
+	})
+	return stats
+}
+
+// String describes the statistics on a single line.
+func (s *wakeStats) String() string {
+	line := fmt.Sprintf("%s: %d attempts", s.Target, s.Attempts)
+	if s.Failed > 0 {
+		line += fmt.Sprintf(" (%d failed)", s.Failed)
+	}
+	if s.Coalesced > 0 {
+		line += fmt.Sprintf(", %d coalesced", s.Coalesced)
+	}
+	rate, ok := s.successRate()
+	if !ok {
+		return line + ", never verified"
+	}
+	line += fmt.Sprintf(", %d of %d verified up (%.0f%%)", s.Verified, s.Verified+s.Timeouts, rate*100)
+	if s.Verified > 0 {
+		line += fmt.Sprintf(", up after %s on average", s.averageUp().Round(time.Second))
+	}
+	return line
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Run the stats command.
+func statsCmd(args []string, aliases *Aliases) error {
+	since, err := parseSince(cliFlags.Since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	target := ""
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	entries, err := aliases.History(since, target)
+	if err != nil {
+		return err
+	}
+	stats := computeStats(entries)
+	if len(stats) == 0 {
+		progressf("No wakes found\n")
+		return nil
+	}
+	for _, s := range stats {
+		fmt.Printf("    %s\n", s)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestComputeStats(t *testing.T) {
+	entries := []HistoryEntry{
+		{Target: "tv"},
+		{Target: "nas"},
+		{Target: "nas", Verify: "ssh", UpAfter: 30 * time.Second},
+		{Target: "nas", Coalesced: true},
+		{Target: "nas", Error: "network is unreachable"},
+		{Target: "nas"},
+		{Target: "nas", Verify: "ssh", Error: "nas did not respond"},
+		{Target: "nas"},
+		{Target: "nas", Verify: "ssh", UpAfter: 50 * time.Second},
+	}
+
+	stats := computeStats(entries)
+	assert.Equal(t, 2, len(stats))
+
+	nas := stats[0]
+	assert.Equal(t, "nas", nas.Target)
+	assert.Equal(t, 4, nas.Attempts)
+	assert.Equal(t, 1, nas.Failed)
+	assert.Equal(t, 1, nas.Coalesced)
+	assert.Equal(t, 2, nas.Verified)
+	assert.Equal(t, 1, nas.Timeouts)
+	assert.Equal(t, 40*time.Second, nas.averageUp())
+	assert.Equal(t, "nas: 4 attempts (1 failed), 1 coalesced, 2 of 3 verified up (67%), up after 40s on average", nas.String())
+
+	tv := stats[1]
+	_, ok := tv.successRate()
+	assert.False(t, ok)
+	assert.Equal(t, "tv: 1 attempts, never verified", tv.String())
+
+	assert.Equal(t, 0, len(computeStats(nil)))
+}
@@ -0,0 +1,112 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Daemon modes run in the foreground and stop cleanly on SIGINT and SIGTERM,
+// which is what launchd, runit, s6, supervisord and systemd expect. Once one
+// is ready to handle requests it writes `--pidfile` and, with
+// `--ready-notify`, tells its supervisor:
+//
+//   systemd  sends READY=1 to $NOTIFY_SOCKET (Type=notify units)
+//   fd:<n>   writes a newline to file descriptor n and closes it (s6's
+//            notification-fd, and runit or supervisord through a wrapper)
+
+// readyNotifier tells a supervisor that the daemon is ready, and returns a
+// function which tells it that it is stopping.
+type readyNotifier func() (func(), error)
+
+// parseReadyNotify returns the notifier `--ready-notify` asks for, or nil if
+// there is none.
+func parseReadyNotify(s string) (readyNotifier, error) {
+	switch {
+	case s == "":
+		return nil, nil
+	case s == "systemd":
+		return notifySystemd, nil
+	case strings.HasPrefix(s, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(s, "fd:"))
+		if err != nil || fd < 3 {
+			return nil, fmt.Errorf("invalid --ready-notify file descriptor %q (expected 3 or more)", s)
+		}
+		return func() (func(), error) {
+			f := os.NewFile(uintptr(fd), "ready-notify")
+			defer f.Close()
+			_, err := f.Write([]byte("\n"))
+			return func() {}, err
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown --ready-notify %q (expected systemd or fd:<n>)", s)
+}
+
+// sdNotify sends `state` to the systemd notification socket, if there is one.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	if strings.HasPrefix(name, "@") {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifySystemd tells systemd that the daemon is ready.
+func notifySystemd() (func(), error) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return nil, fmt.Errorf("--ready-notify systemd needs $NOTIFY_SOCKET, which systemd sets for Type=notify units")
+	}
+	if err := sdNotify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid())); err != nil {
+		return nil, err
+	}
+	return func() { sdNotify("STOPPING=1") }, nil
+}
+
+// daemonReady is called by a daemon mode once it listens: it writes
+// `--pidfile` and notifies the supervisor as `--ready-notify` asks. The
+// returned function, to be deferred, undoes both.
+func daemonReady() (func(), error) {
+	notify, err := parseReadyNotify(cliFlags.ReadyNotify)
+	if err != nil {
+		return nil, err
+	}
+
+	if cliFlags.Pidfile != "" {
+		if err := ioutil.WriteFile(cliFlags.Pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+	removePidfile := func() {
+		if cliFlags.Pidfile != "" {
+			os.Remove(cliFlags.Pidfile)
+		}
+	}
+
+	stopping := func() {}
+	if notify != nil {
+		if stopping, err = notify(); err != nil {
+			removePidfile()
+			return nil, err
+		}
+	}
+	return func() {
+		stopping()
+		removePidfile()
+	}, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseReadyNotify(t *testing.T) {
+	notify, err := parseReadyNotify("")
+	assert.Nil(t, err)
+	assert.Nil(t, notify)
+
+	for _, s := range []string{"systemd", "fd:3", "fd:42"} {
+		notify, err := parseReadyNotify(s)
+		assert.Nil(t, err, s)
+		assert.NotNil(t, notify, s)
+	}
+	for _, s := range []string{"launchd", "fd:", "fd:x", "fd:1"} {
+		_, err := parseReadyNotify(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestDaemonReady(t *testing.T) {
+	defer func(pidfile, notify string) {
+		cliFlags.Pidfile, cliFlags.ReadyNotify = pidfile, notify
+	}(cliFlags.Pidfile, cliFlags.ReadyNotify)
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+
+	dir, err := ioutil.TempDir("", "wol-supervise-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// systemd
+	sock := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", sock)
+	cliFlags.Pidfile = filepath.Join(dir, "wol.pid")
+	cliFlags.ReadyNotify = "systemd"
+
+	done, err := daemonReady()
+	assert.Nil(t, err)
+	bs, err := ioutil.ReadFile(cliFlags.Pidfile)
+	assert.Nil(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(bs))
+
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "READY=1\nMAINPID="+strconv.Itoa(os.Getpid()), string(buf[:n]))
+
+	done()
+	n, err = conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "STOPPING=1", string(buf[:n]))
+	_, err = os.Stat(cliFlags.Pidfile)
+	assert.True(t, os.IsNotExist(err))
+
+	// Without the socket systemd sets, nothing is written.
+	os.Setenv("NOTIFY_SOCKET", "")
+	_, err = daemonReady()
+	assert.NotNil(t, err)
+	_, err = os.Stat(cliFlags.Pidfile)
+	assert.True(t, os.IsNotExist(err))
+}
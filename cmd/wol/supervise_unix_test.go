@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestDaemonReadyFD(t *testing.T) {
+	defer func(pidfile, notify string) {
+		cliFlags.Pidfile, cliFlags.ReadyNotify = pidfile, notify
+	}(cliFlags.Pidfile, cliFlags.ReadyNotify)
+
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+
+	// The notifier closes the descriptor it is given, so it gets its own.
+	fd, err := syscall.Dup(int(w.Fd()))
+	assert.Nil(t, err)
+	w.Close()
+
+	cliFlags.Pidfile, cliFlags.ReadyNotify = "", "fd:"+strconv.Itoa(fd)
+	done, err := daemonReady()
+	assert.Nil(t, err)
+	done()
+
+	bs, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "\n", string(bs))
+}
@@ -0,0 +1,318 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	createdBucketName    = "Created"
+	modifiedBucketName   = "Modified"
+	tombstonesBucketName = "Tombstones"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// syncAlias is an alias along with when it was created and last modified.
+// Peers which predate creation times send the zero time.
+type syncAlias struct {
+	Name     string    `json:"name"`
+	Mac      string    `json:"mac"`
+	Iface    string    `json:"iface,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Cooldown int       `json:"cooldown,omitempty"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+}
+
+// syncTombstone records when an alias was deleted, so that the deletion wins
+// over older copies of the alias on other instances.
+type syncTombstone struct {
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// syncState is everything exchanged to merge two alias dbs.
+type syncState struct {
+	Aliases    []syncAlias     `json:"aliases"`
+	Tombstones []syncTombstone `json:"tombstones"`
+}
+
+// getTime reads a time from `bucket`, returning the zero time if `key` is
+// not set.
+func getTime(tx *bolt.Tx, bucket, key string) (time.Time, error) {
+	var t time.Time
+	if value := tx.Bucket([]byte(bucket)).Get([]byte(key)); value != nil {
+		return t, t.UnmarshalBinary(value)
+	}
+	return t, nil
+}
+
+// putTime writes `t` to `bucket`.
+func putTime(tx *bolt.Tx, bucket, key string, t time.Time) error {
+	value, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(bucket)).Put([]byte(key), value)
+}
+
+// stampModified records that `alias` was added or changed at `t`, and that
+// it was created at `created` (or `t`, if zero) if it is new.
+func stampModified(tx *bolt.Tx, alias string, created, t time.Time) error {
+	if err := tx.Bucket([]byte(tombstonesBucketName)).Delete([]byte(alias)); err != nil {
+		return err
+	}
+	if tx.Bucket([]byte(createdBucketName)).Get([]byte(alias)) == nil {
+		if created.IsZero() {
+			created = t
+		}
+		if err := putTime(tx, createdBucketName, alias, created); err != nil {
+			return err
+		}
+	}
+	return putTime(tx, modifiedBucketName, alias, t)
+}
+
+// stampDeleted records that `alias` was deleted at `t`.
+func stampDeleted(tx *bolt.Tx, alias string, t time.Time) error {
+	for _, name := range []string{createdBucketName, modifiedBucketName} {
+		if err := tx.Bucket([]byte(name)).Delete([]byte(alias)); err != nil {
+			return err
+		}
+	}
+	return putTime(tx, tombstonesBucketName, alias, t)
+}
+
+// lastChange returns when `alias` was last modified or deleted.
+func lastChange(tx *bolt.Tx, alias string) (time.Time, error) {
+	modified, err := getTime(tx, modifiedBucketName, alias)
+	if err != nil {
+		return modified, err
+	}
+	deleted, err := getTime(tx, tombstonesBucketName, alias)
+	if err != nil || modified.After(deleted) {
+		return modified, err
+	}
+	return deleted, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SyncState returns all aliases and tombstones with their times.
+func (a *Aliases) SyncState() (syncState, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	state := syncState{Aliases: []syncAlias{}, Tombstones: []syncTombstone{}}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(bucketName)).ForEach(func(k, v []byte) error {
+			mi, err := DecodeToMacIface(bytes.NewBuffer(v))
+			if err != nil {
+				return err
+			}
+			created, err := getTime(tx, createdBucketName, string(k))
+			if err != nil {
+				return err
+			}
+			modified, err := getTime(tx, modifiedBucketName, string(k))
+			if err != nil {
+				return err
+			}
+			state.Aliases = append(state.Aliases, syncAlias{string(k), mi.Mac, mi.Iface, mi.Tags, mi.IP, mi.Host, mi.Cooldown, created, modified})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(tombstonesBucketName)).ForEach(func(k, v []byte) error {
+			var deleted time.Time
+			if err := deleted.UnmarshalBinary(v); err != nil {
+				return err
+			}
+			state.Tombstones = append(state.Tombstones, syncTombstone{string(k), deleted})
+			return nil
+		})
+	})
+	return state, err
+}
+
+// Merge applies the aliases and tombstones of `remote` which are newer than
+// the local copy (last write wins) in a single transaction, and returns how
+// many aliases were updated and deleted.
+func (a *Aliases) Merge(remote syncState) (int, int, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	updated, deleted := 0, 0
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		for _, sa := range remote.Aliases {
+			last, err := lastChange(tx, sa.Name)
+			if err != nil {
+				return err
+			}
+			// Aliases which predate syncing have no stamp, and are only taken
+			// where there is no copy (or tombstone) of them at all.
+			if sa.Modified.IsZero() {
+				if !last.IsZero() || bucket.Get([]byte(sa.Name)) != nil {
+					continue
+				}
+			} else if !sa.Modified.After(last) {
+				continue
+			}
+			if err := checkPlaintextSpec(sa.Name, sa.Mac); err != nil {
+				return err
+			}
+			buf, err := encodeMacIface(MacIface{Mac: sa.Mac, Iface: sa.Iface, Tags: sa.Tags, IP: sa.IP, Host: sa.Host, Cooldown: sa.Cooldown})
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(sa.Name), buf.Bytes()); err != nil {
+				return err
+			}
+			if !sa.Modified.IsZero() {
+				if err := stampModified(tx, sa.Name, sa.Created, sa.Modified); err != nil {
+					return err
+				}
+			}
+			updated++
+		}
+
+		for _, ts := range remote.Tombstones {
+			last, err := lastChange(tx, ts.Name)
+			if err != nil {
+				return err
+			}
+			if !ts.Deleted.After(last) {
+				continue
+			}
+			if bucket.Get([]byte(ts.Name)) != nil {
+				if err := bucket.Delete([]byte(ts.Name)); err != nil {
+					return err
+				}
+				deleted++
+			}
+			if err := stampDeleted(tx, ts.Name, ts.Deleted); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return updated, deleted, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// handleSync serves `GET /sync`, which returns the server's sync state, and
+// `POST /sync`, which merges the posted state and returns the merged one.
+// Syncing exposes and changes every alias, so it needs an unrestricted token,
+// which for a tenant must also allow MACs as synced aliases can have any.
+func (s *server) handleSync(w http.ResponseWriter, r *http.Request) {
+	tok := requestToken(r)
+	if tok != nil && !tok.Unrestricted() {
+		writeError(w, http.StatusForbidden, errors.New("syncing requires an unrestricted token"))
+		return
+	}
+	if s.tenant != "" && (tok == nil || !tok.MACs) {
+		writeError(w, http.StatusForbidden, errors.New("syncing a tenant requires a token created with --allow-macs"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var remote syncState
+		if err := json.NewDecoder(r.Body).Decode(&remote); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, _, err := s.aliases.Merge(remote); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed(r))
+		return
+	}
+
+	state, err := s.aliases.SyncState()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// syncWithPeer posts the local state to the `wol serve` instance at `peer`
+// and merges the state it returns, unless `ctx` is done first.
+func syncWithPeer(ctx context.Context, peer, token string, aliases *Aliases) (int, int, error) {
+	local, err := aliases.SyncState()
+	if err != nil {
+		return 0, 0, err
+	}
+	body, err := json.Marshal(local)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(peer, "/")+"/sync", bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return 0, 0, fmt.Errorf("peer returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var remote syncState
+	if err := json.NewDecoder(resp.Body).Decode(&remote); err != nil {
+		return 0, 0, err
+	}
+	return aliases.Merge(remote)
+}
+
+// Run the sync command.
+func syncCmd(args []string, aliases *Aliases) error {
+	if cliFlags.Peer == "" {
+		return errors.New("sync command requires --peer <url>")
+	}
+
+	ctx, cancel := withTimeout(context.Background(), opAPI)
+	defer cancel()
+
+	updated, deleted, err := syncWithPeer(ctx, cliFlags.Peer, cliFlags.Token, aliases)
+	if err != nil {
+		return err
+	}
+	statusf("Synced with %s: %d alias(es) updated, %d deleted\n", cliFlags.Peer, updated, deleted)
+	return nil
+}
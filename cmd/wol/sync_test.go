@@ -0,0 +1,132 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Opens a fresh alias db for a test, returning it and a cleanup function.
+func openTestAliases(t testing.TB, name string) (*Aliases, func()) {
+	os.Remove(name)
+	aliases, err := LoadAliases(name)
+	assert.Nil(t, err)
+	return aliases, func() {
+		aliases.Close()
+		os.Remove(name)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestMerge.db")
+	defer cleanup()
+
+	assert.Nil(t, aliases.Add("nas", "00:00:00:00:00:01", ""))
+	assert.Nil(t, aliases.Add("tv", "00:00:00:00:00:02", ""))
+	added := time.Now()
+	past, future := added.Add(-time.Hour), added.Add(time.Hour)
+
+	updated, deleted, err := aliases.Merge(syncState{
+		Aliases: []syncAlias{
+			// Older than the local copy, ignored.
+			{Name: "nas", Mac: "00:00:00:00:00:AA", Modified: past},
+			// Newer than the local copy, applied.
+			{Name: "tv", Mac: "00:00:00:00:00:BB", Modified: future},
+			// New, applied.
+			{Name: "pc", Mac: "00:00:00:00:00:03", Tags: []string{"lab"}, Modified: past},
+		},
+		Tombstones: []syncTombstone{
+			// Deleted after the local copy was added, applied.
+			{Name: "nas", Deleted: future},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, updated)
+	assert.Equal(t, 1, deleted)
+
+	list, err := aliases.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "pc", list[0].Name)
+	assert.Equal(t, MacIface{Mac: "00:00:00:00:00:03", Tags: []string{"lab"}}, list[0].MacIface)
+	assert.Equal(t, "tv", list[1].Name)
+	assert.Equal(t, MacIface{Mac: "00:00:00:00:00:BB"}, list[1].MacIface)
+
+	// New aliases without a creation time were created when last modified,
+	// and updates keep the local creation time.
+	assert.True(t, list[0].Created.Equal(past))
+	assert.False(t, list[1].Created.After(added))
+	assert.True(t, list[1].Modified.Equal(future))
+
+	// The tombstone is kept, and beats older copies of the alias.
+	state, err := aliases.SyncState()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(state.Tombstones))
+	updated, _, err = aliases.Merge(syncState{Aliases: []syncAlias{{Name: "nas", Modified: time.Now()}}})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, updated)
+
+	// Aliases which predate syncing are taken where there is no copy, but
+	// lose to any copy there is.
+	updated, _, err = aliases.Merge(syncState{Aliases: []syncAlias{
+		{Name: "printer", Mac: "00:00:00:00:00:04"},
+		{Name: "tv", Mac: "00:00:00:00:00:CC"},
+		{Name: "nas", Mac: "00:00:00:00:00:CC"},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, updated)
+	mi, err := aliases.Get("printer")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:00:00:00:00:04", mi.Mac)
+	mi, err = aliases.Get("tv")
+	assert.Nil(t, err)
+	assert.Equal(t, "00:00:00:00:00:BB", mi.Mac)
+
+	// Re-adding an alias clears its tombstone.
+	assert.Nil(t, aliases.Add("nas", "00:00:00:00:00:01", ""))
+	state, err = aliases.SyncState()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(state.Tombstones))
+}
+
+func TestSyncWithPeer(t *testing.T) {
+	local, cleanupLocal := openTestAliases(t, "./TestSyncWithPeerLocal.db")
+	defer cleanupLocal()
+	remote, cleanupRemote := openTestAliases(t, "./TestSyncWithPeerRemote.db")
+	defer cleanupRemote()
+
+	assert.Nil(t, local.Add("nas", "00:00:00:00:00:01", ""))
+	assert.Nil(t, local.Add("old", "00:00:00:00:00:09", ""))
+	assert.Nil(t, remote.Add("tv", "00:00:00:00:00:02", ""))
+	assert.Nil(t, local.Del("old"))
+
+	srv := httptest.NewServer(newServer(remote))
+	defer srv.Close()
+
+	updated, deleted, err := syncWithPeer(context.Background(), srv.URL, "", local)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, updated)
+	assert.Equal(t, 0, deleted)
+
+	// Both ends now hold the same aliases.
+	for _, aliases := range []*Aliases{local, remote} {
+		list, err := aliases.List()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(list))
+		assert.Equal(t, "nas", list[0].Name)
+		assert.Equal(t, "tv", list[1].Name)
+	}
+
+	// Restricted tokens may not sync.
+	assert.Nil(t, remote.AddToken("kids", Token{Hash: hashTokenSecret("kids"), Tags: []string{"kids"}}))
+	_, _, err = syncWithPeer(context.Background(), srv.URL, "kids", local)
+	assert.NotNil(t, err)
+}
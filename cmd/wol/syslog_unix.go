@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io"
+	"log/syslog"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// newSyslogWriter returns a writer to the local syslog daemon.
+func newSyslogWriter(tag string) (io.WriteCloser, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
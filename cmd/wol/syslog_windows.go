@@ -0,0 +1,15 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"errors"
+	"io"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// newSyslogWriter is not supported on Windows, which has no syslog.
+func newSyslogWriter(tag string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
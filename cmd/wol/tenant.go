@@ -0,0 +1,102 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// A serve can also serve tenants, such as the households or teams sharing a
+// gateway, which must not see each other's machines. Each tenant has an
+// alias db of its own, holding its aliases, tokens, history and queued
+// wakes, which the CLI manages with `--db` as usual, and its API (and
+// dashboard) is served under `/t/<name>/`.
+
+// tenantPrefix is the path under which the APIs of tenants are served.
+const tenantPrefix = "/t/"
+
+// reTenantName matches the names tenants may have.
+var reTenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// parseTenant parses a `--tenant` value, `name=path` of the tenant's db.
+func parseTenant(spec string) (name, dbpath string, err error) {
+	i := strings.Index(spec, "=")
+	if i < 0 || spec[i+1:] == "" {
+		return "", "", fmt.Errorf("invalid --tenant %q (expected name=path of its alias db)", spec)
+	}
+	name, dbpath = spec[:i], spec[i+1:]
+	if !reTenantName.MatchString(name) {
+		return "", "", fmt.Errorf("invalid tenant name %q (expected lower case letters, digits, - and _)", name)
+	}
+	return name, dbpath, nil
+}
+
+// openTenants opens the dbs of the `--tenant`s, none of which may be the db
+// of `s`, and serves the API of each under its name.
+func (s *server) openTenants() error {
+	dbs := map[string]string{}
+	if p, err := filepath.Abs(s.aliases.path); err == nil {
+		dbs[p] = "serve's own --db"
+	}
+	for _, spec := range cliFlags.Tenants {
+		name, dbpath, err := parseTenant(spec)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.tenants[name]; ok {
+			return fmt.Errorf("tenant %s is given twice", name)
+		}
+		p, err := filepath.Abs(dbpath)
+		if err != nil {
+			return err
+		}
+		if other, ok := dbs[p]; ok {
+			return fmt.Errorf("tenant %s has the alias db of %s", name, other)
+		}
+		dbs[p] = "tenant " + name
+
+		aliases, err := LoadAliases(dbpath)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %v", name, err)
+		}
+		ts := newServer(aliases)
+		ts.tenant = name
+		s.tenants[name] = ts
+		prefix := tenantPrefix + name
+		s.mux.Handle(prefix+"/", http.StripPrefix(prefix, ts))
+
+		// As elsewhere, an API without tokens is open, which here means to
+		// everyone who can reach the other tenants.
+		if tokens, err := aliases.Tokens(); err == nil && len(tokens) == 0 {
+			log.Printf("WARNING: tenant %s has no tokens, so anyone may use its API\n", name)
+		}
+	}
+	return nil
+}
+
+// closeTenants closes the dbs of the tenants of `s`.
+func (s *server) closeTenants() {
+	for name, ts := range s.tenants {
+		if err := ts.aliases.Close(); err != nil {
+			log.Printf("Failed to close the alias db of tenant %s: %v\n", name, err)
+		}
+	}
+}
+
+// tenantNames returns the names of the tenants of `s`, sorted.
+func (s *server) tenantNames() []string {
+	var names []string
+	for name := range s.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
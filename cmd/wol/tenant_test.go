@@ -0,0 +1,130 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseTenant(t *testing.T) {
+	name, dbpath, err := parseTenant("home=/var/lib/wol/home.db")
+	assert.Nil(t, err)
+	assert.Equal(t, "home", name)
+	assert.Equal(t, "/var/lib/wol/home.db", dbpath)
+
+	for _, spec := range []string{"home", "home=", "=home.db", "Home=home.db", "a/b=home.db", "-x=home.db"} {
+		_, _, err := parseTenant(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	saved := cliFlags
+	defer func() { cliFlags = saved }()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	cliFlags.BroadcastIP, cliFlags.UDPPort = "127.0.0.1", strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+
+	aliases, cleanup := openTestAliases(t, "./TestTenants.db")
+	defer cleanup()
+	assert.Nil(t, aliases.Add("gateway", "00:11:22:33:44:00", ""))
+
+	// The tenant's db is set up as the CLI would with --db.
+	home, cleanupHome := openTestAliases(t, "./TestTenantsHome.db")
+	assert.Nil(t, home.Add("nas", "00:11:22:33:44:55", ""))
+	assert.Nil(t, home.Add("vault", "00:11:22:33:44:56@127.0.0.1:"+cliFlags.UDPPort+"?pw=env:TENANT_TEST_PW", ""))
+	assert.Nil(t, home.AddToken("phone", Token{Hash: hashTokenSecret("s3cret")}))
+	assert.Nil(t, home.Close())
+	defer cleanupHome()
+
+	s := newServer(aliases)
+	cliFlags.Tenants = []string{"home=./TestTenantsHome.db", "home=./other.db"}
+	assert.NotNil(t, s.openTenants())
+	s.closeTenants()
+
+	s = newServer(aliases)
+	cliFlags.Tenants = []string{"home=./TestTenants.db"}
+	assert.NotNil(t, s.openTenants())
+
+	s = newServer(aliases)
+	cliFlags.Tenants = []string{"home=./TestTenantsHome.db"}
+	assert.Nil(t, s.openTenants())
+	defer s.closeTenants()
+	assert.Equal(t, []string{"home"}, s.tenantNames())
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Each tenant only sees its own aliases, and needs its own tokens.
+	var page aliasPage
+	rec := do("GET", "/aliases", "")
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, "gateway", page.Aliases[0].Name)
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/t/home/aliases", "").Code)
+	rec = do("GET", "/t/home/aliases", "s3cret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, "nas", page.Aliases[0].Name)
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/wake/nas", "").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/t/home/wake/nas", "s3cret").Code)
+
+	// Tenants cannot wake others' machines by their MAC addresses, unless
+	// their token allows it.
+	assert.Equal(t, http.StatusForbidden, do("POST", "/t/home/wake/00:11:22:33:44:00", "s3cret").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/t/home/wake/00:11:22:33:44:00@127.0.0.1", "s3cret").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/t/home/sync", "s3cret").Code)
+	assert.Nil(t, s.tenants["home"].aliases.AddToken("admin", Token{Hash: hashTokenSecret("admin"), MACs: true}))
+	assert.Equal(t, http.StatusOK, do("POST", "/t/home/wake/00:11:22:33:44:00", "admin").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/t/home/sync", "admin").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/t/work/aliases", "").Code)
+
+	// Nor can tenants have this machine's secrets sent anywhere, be it with a
+	// target spec or one of their aliases.
+	os.Setenv("TENANT_TEST_PW", "s3cr3t")
+	defer os.Unsetenv("TENANT_TEST_PW")
+	buf := make([]byte, 1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/t/home/wake/00:11:22:33:44:00@127.0.0.1:"+cliFlags.UDPPort+"%3Fpw=env:TENANT_TEST_PW", "admin").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/t/home/wake/vault", "admin").Code)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = conn.ReadFrom(buf)
+	assert.NotNil(t, err)
+
+	// ... and keeps its own history.
+	history, err := aliases.History(time.Time{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(history))
+	history, err = s.tenants["home"].aliases.History(time.Time{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, "api:phone@192.0.2.1", history[0].By)
+}
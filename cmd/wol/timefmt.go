@@ -0,0 +1,138 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// displayTime is the layout of times shown to people, in local time.
+	displayTime = "2006-01-02 15:04:05 MST"
+)
+
+// sinceLayouts are the layouts of local dates and times `--since` accepts
+// besides RFC 3339.
+var sinceLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// sinceUnits maps the units of a relative `--since` to their length.
+var sinceUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour,
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// formatTime formats `t` for people: in local time, followed by how long
+// before (or after) `now` it is, e.g. "2018-01-02 04:04:05 CET (3h ago)".
+func formatTime(t, now time.Time) string {
+	return t.Local().Format(displayTime) + " (" + relativeTime(t, now) + ")"
+}
+
+// relativeTime describes how long before `now` `t` is in its largest whole
+// unit, e.g. "3h ago", or "in 3h" for times after `now`.
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	var n time.Duration
+	var unit string
+	switch {
+	case d < time.Second:
+		return "just now"
+	case d < time.Minute:
+		n, unit = d/time.Second, "s"
+	case d < time.Hour:
+		n, unit = d/time.Minute, "m"
+	case d < 24*time.Hour:
+		n, unit = d/time.Hour, "h"
+	default:
+		n, unit = d/(24*time.Hour), "d"
+	}
+	if future {
+		return fmt.Sprintf("in %d%s", n, unit)
+	}
+	return fmt.Sprintf("%d%s ago", n, unit)
+}
+
+// parseSince parses the `since` option, which may be empty. It is an RFC 3339
+// time, a local date and time (2006-01-02 15:04, or just the date), "today"
+// or "yesterday" (since midnight), or how long before `now`, as in "3h",
+// "90m", "2d", "2 days ago" or "last week".
+func parseSince(since string, now time.Time) (time.Time, error) {
+	since = strings.TrimSpace(since)
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	for _, layout := range sinceLayouts {
+		if t, err := time.ParseInLocation(layout, since, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch strings.ToLower(since) {
+	case "now":
+		return now, nil
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+	if t, ok := parseRelative(strings.ToLower(since), now); ok {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 (e.g. 2006-01-02T15:04:05Z), "+
+		"a local date (e.g. 2006-01-02 15:04) or how long ago (e.g. 3h, 2 days ago, yesterday)", since)
+}
+
+// parseRelative parses how long before `now` a time is, such as "3h",
+// "2 days ago", "1h30m" or "last week".
+func parseRelative(s string, now time.Time) (time.Time, bool) {
+	s = strings.TrimSpace(strings.TrimSuffix(s, " ago"))
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), true
+	}
+
+	n, unit := 1, ""
+	if strings.HasPrefix(s, "last ") {
+		unit = strings.TrimSpace(s[len("last "):])
+	} else {
+		idx := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+		if idx <= 0 {
+			return time.Time{}, false
+		}
+		var err error
+		if n, err = strconv.Atoi(s[:idx]); err != nil {
+			return time.Time{}, false
+		}
+		unit = strings.TrimSpace(s[idx:])
+	}
+	length, ok := sinceUnits[unit]
+	if !ok {
+		length, ok = sinceUnits[strings.TrimSuffix(unit, "s")]
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	return now.Add(-time.Duration(n) * length), true
+}
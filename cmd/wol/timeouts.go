@@ -0,0 +1,95 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The network operations which can be given their own timeout.
+const (
+	opSend  = "send"  // sending a magic packet (including --via)
+	opProbe = "probe" // the ssh query of the probe command
+	opScan  = "scan"  // waiting for a single address to answer a scan
+	opAPI   = "api"   // HTTP calls to a peer (sync) or router (import)
+)
+
+// defaultTimeouts apply to operations when neither their own option nor
+// `--timeout` is given.
+var defaultTimeouts = map[string]time.Duration{
+	opSend:  30 * time.Second,
+	opProbe: 30 * time.Second,
+	opScan:  time.Second,
+	opAPI:   30 * time.Second,
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// timeoutOverride returns the timeout given with the option specific to
+// operation `op`, or 0 if there is none.
+func timeoutOverride(op string) time.Duration {
+	switch op {
+	case opSend:
+		return cliFlags.SendTimeout
+	case opProbe:
+		return cliFlags.ProbeTimeout
+	case opScan:
+		return cliFlags.ScanTimeout
+	case opAPI:
+		return cliFlags.APITimeout
+	}
+	return 0
+}
+
+// timeoutFor returns how long operation `op` may take: its own option if
+// set, else `--timeout` if set, else its default.
+func timeoutFor(op string) time.Duration {
+	if timeout := timeoutOverride(op); timeout > 0 {
+		return timeout
+	}
+	if cliFlags.Timeout > 0 {
+		return cliFlags.Timeout
+	}
+	return defaultTimeouts[op]
+}
+
+// withTimeout returns a context derived from `parent` which is cancelled
+// once operation `op` times out.
+func withTimeout(parent context.Context, op string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, timeoutFor(op))
+}
+
+// validateTimeouts rejects negative timeouts.
+func validateTimeouts() error {
+	if cliFlags.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %s", cliFlags.Timeout)
+	}
+	if cliFlags.DBTimeout < 0 {
+		return fmt.Errorf("invalid db timeout %s", cliFlags.DBTimeout)
+	}
+	if cliFlags.VerifyTimeout < 0 {
+		return fmt.Errorf("invalid verify timeout %s", cliFlags.VerifyTimeout)
+	}
+	for op := range defaultTimeouts {
+		if timeout := timeoutOverride(op); timeout < 0 {
+			return fmt.Errorf("invalid %s timeout %s", op, timeout)
+		}
+	}
+	return nil
+}
+
+// setDeadline applies the deadline of `ctx` (if any) to `conn`.
+func setDeadline(ctx context.Context, conn net.Conn) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		return conn.SetDeadline(deadline)
+	}
+	return nil
+}
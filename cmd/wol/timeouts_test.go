@@ -0,0 +1,47 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestTimeoutFor(t *testing.T) {
+	defer func(timeout, scan time.Duration) {
+		cliFlags.Timeout, cliFlags.ScanTimeout = timeout, scan
+	}(cliFlags.Timeout, cliFlags.ScanTimeout)
+
+	cliFlags.Timeout, cliFlags.ScanTimeout = 0, 0
+	assert.Equal(t, time.Second, timeoutFor(opScan))
+	assert.Equal(t, 30*time.Second, timeoutFor(opAPI))
+
+	// The global timeout applies to every operation...
+	cliFlags.Timeout = 2500 * time.Millisecond
+	assert.Equal(t, 2500*time.Millisecond, timeoutFor(opScan))
+	assert.Equal(t, 2500*time.Millisecond, timeoutFor(opSend))
+
+	// ...unless the operation has its own.
+	cliFlags.ScanTimeout = 200 * time.Millisecond
+	assert.Equal(t, 200*time.Millisecond, timeoutFor(opScan))
+	assert.Equal(t, 2500*time.Millisecond, timeoutFor(opSend))
+	assert.Nil(t, validateTimeouts())
+
+	cliFlags.ScanTimeout = -1
+	assert.NotNil(t, validateTimeouts())
+}
+
+func TestSendMagicPacketCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	udpAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	err := sendMagicPacket(ctx, make([]byte, 102), nil, udpAddr)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,212 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	tokensBucketName = "Tokens"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Token is an API access token. Only a hash of the secret is stored. A token
+// with no Aliases and no Tags may access every alias (and raw MAC addresses);
+// otherwise it may only access the listed aliases and aliases carrying any of
+// the listed tags. Tokens which sign their requests keep the secret as their
+// SigningKey, as checking an HMAC needs it. Clients presenting a certificate
+// with one of the names in Certs authenticate as the token without a secret,
+// as do dashboard users logged in through OIDC who are in one of its Groups.
+// The tokens of tenants only reach beyond their own aliases with MACs.
+type Token struct {
+	Hash       []byte
+	Aliases    []string
+	Tags       []string
+	SigningKey []byte
+	Certs      []string
+	Groups     []string
+	MACs       bool
+
+	name string // set when loaded, as tokens are keyed by name
+}
+
+// hashTokenSecret returns the hash stored for a token secret.
+func hashTokenSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// newTokenSecret returns a new random token secret.
+func newTokenSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Unrestricted returns true if the token may access everything.
+func (t *Token) Unrestricted() bool {
+	return len(t.Aliases) == 0 && len(t.Tags) == 0
+}
+
+// Allows returns true if the token may access the alias `name` with entry
+// `mi`.
+func (t *Token) Allows(name string, mi MacIface) bool {
+	if t.Unrestricted() {
+		return true
+	}
+	for _, alias := range t.Aliases {
+		if alias == name {
+			return true
+		}
+	}
+	for _, tag := range t.Tags {
+		if mi.HasTags(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// AddToken stores (or replaces) the token `name`.
+func (a *Aliases) AddToken(name string, tok Token) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(tok); err != nil {
+		return err
+	}
+	return a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tokensBucketName)).Put([]byte(name), buf.Bytes())
+	})
+}
+
+// DelToken removes the token `name`.
+func (a *Aliases) DelToken(name string) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tokensBucketName)).Delete([]byte(name))
+	})
+}
+
+// Tokens returns all tokens keyed by name.
+func (a *Aliases) Tokens() (map[string]Token, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	tokens := map[string]Token{}
+	err := a.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tokensBucketName)).ForEach(func(k, v []byte) error {
+			var tok Token
+			if err := gob.NewDecoder(bytes.NewBuffer(v)).Decode(&tok); err != nil {
+				return err
+			}
+			tok.name = string(k)
+			tokens[string(k)] = tok
+			return nil
+		})
+	})
+	return tokens, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Run the token command.
+func tokenCmd(args []string, aliases *Aliases) error {
+	if len(args) == 0 {
+		return errors.New("token command requires one of: add <name>, list, remove <name>")
+	}
+
+	switch sub, args := strings.ToLower(args[0]), args[1:]; sub {
+	case "add":
+		if len(args) != 1 {
+			return errors.New("token add requires a <name>")
+		}
+		secret, err := newTokenSecret()
+		if err != nil {
+			return err
+		}
+		tok := Token{
+			Hash:    hashTokenSecret(secret),
+			Aliases: cliFlags.Allow,
+			Tags:    cliFlags.Tags,
+			Certs:   cliFlags.Certs,
+			Groups:  cliFlags.OIDCGroups,
+			MACs:    cliFlags.MACs,
+		}
+		if cliFlags.Signing {
+			tok.SigningKey = []byte(secret)
+		}
+		if err := aliases.AddToken(args[0], tok); err != nil {
+			return err
+		}
+		// Only the secret goes to stdout, so that it can be captured.
+		progressf("Created token %s, it will not be shown again:\n", args[0])
+		fmt.Printf("%s\n", secret)
+		return nil
+
+	case "list":
+		tokens, err := aliases.Tokens()
+		if err != nil {
+			return err
+		}
+		if len(tokens) == 0 {
+			progressf("No tokens found, the HTTP API is unauthenticated\n")
+			return nil
+		}
+		names := make([]string, 0, len(tokens))
+		for name := range tokens {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			tok := tokens[name]
+			scope := "all aliases"
+			if !tok.Unrestricted() {
+				scope = fmt.Sprintf("aliases [%s] tags [%s]",
+					strings.Join(tok.Aliases, ", "), strings.Join(tok.Tags, ", "))
+			}
+			if len(tok.SigningKey) > 0 {
+				scope += ", may sign requests"
+			}
+			if len(tok.Certs) > 0 {
+				scope += ", certificates [" + strings.Join(tok.Certs, ", ") + "]"
+			}
+			if len(tok.Groups) > 0 {
+				scope += ", OIDC groups [" + strings.Join(tok.Groups, ", ") + "]"
+			}
+			if tok.MACs {
+				scope += ", raw MAC addresses as a tenant"
+			}
+			fmt.Printf("    %s - %s\n", name, scope)
+		}
+		return nil
+
+	case "remove":
+		if len(args) != 1 {
+			return errors.New("token remove requires a <name>")
+		}
+		return aliases.DelToken(args[0])
+	}
+	return fmt.Errorf("unknown token command %q", args[0])
+}
@@ -0,0 +1,40 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestTokenAllows(t *testing.T) {
+	nas := MacIface{Mac: "00:00:00:00:00:01", Tags: []string{"media"}}
+	xbox := MacIface{Mac: "00:00:00:00:00:02", Tags: []string{"kids"}}
+
+	all := Token{}
+	assert.True(t, all.Unrestricted())
+	assert.True(t, all.Allows("nas", nas))
+
+	byName := Token{Aliases: []string{"nas"}}
+	assert.False(t, byName.Unrestricted())
+	assert.True(t, byName.Allows("nas", nas))
+	assert.False(t, byName.Allows("xbox", xbox))
+
+	byTag := Token{Tags: []string{"kids"}}
+	assert.False(t, byTag.Allows("nas", nas))
+	assert.True(t, byTag.Allows("xbox", xbox))
+}
+
+func TestNewTokenSecret(t *testing.T) {
+	a, err := newTokenSecret()
+	assert.Nil(t, err)
+	b, err := newTokenSecret()
+	assert.Nil(t, err)
+	assert.Equal(t, 48, len(a))
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, hashTokenSecret(a), hashTokenSecret(a))
+	assert.NotEqual(t, hashTokenSecret(a), hashTokenSecret(b))
+}
@@ -0,0 +1,301 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	wol "github.com/sabhiram/go-wol"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// spanKindInternal and spanKindServer are the OTLP kinds of spans.
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	// otlpStatusError is the OTLP status code of a failed span.
+	otlpStatusError = 2
+
+	// tracingInterval is how often daemons export the spans they collected.
+	tracingInterval = 5 * time.Second
+
+	// maxPendingSpans caps the spans kept while the collector is unreachable.
+	maxPendingSpans = 4096
+)
+
+// spanContextKey is the context key of the current *span.
+type spanContextKey struct{}
+
+// tracer exports the spans of finished operations, or is nil if tracing is
+// not configured.
+var tracer *otlpExporter
+
+////////////////////////////////////////////////////////////////////////////////
+
+// span times an operation of a trace, in the manner of an OpenTelemetry span.
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for the root of a trace
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+}
+
+// disabledSpan is what startSpan returns while tracing is off, so that
+// untraced wakes do not pay for spans.
+var disabledSpan = &span{}
+
+// startSpan starts the span `name`, as a child of the span in `ctx` if any,
+// and returns a context carrying it.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, disabledSpan
+	}
+	sp := &span{name: name, kind: spanKindInternal, start: time.Now(), attrs: map[string]string{}}
+	if parent, ok := ctx.Value(spanContextKey{}).(*span); ok {
+		sp.traceID, sp.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(sp.traceID[:])
+	}
+	rand.Read(sp.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, sp), sp
+}
+
+// startServerSpan starts the span `name` for the request `r`, continuing the
+// trace of the client if it sent a W3C `traceparent` header.
+func startServerSpan(r *http.Request, name string) (context.Context, *span) {
+	ctx := r.Context()
+	if tracer == nil {
+		return ctx, disabledSpan
+	}
+	if parent, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		ctx = context.WithValue(ctx, spanContextKey{}, parent)
+	}
+	ctx, sp := startSpan(ctx, name)
+	sp.kind = spanKindServer
+	sp.set("http.method", r.Method)
+	sp.set("http.target", r.URL.Path)
+	return ctx, sp
+}
+
+// parseTraceparent returns the (remote) span a `traceparent` header
+// (`00-<trace id>-<span id>-<flags>`) names.
+func parseTraceparent(header string) (*span, bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil, false
+	}
+	var sp span
+	if _, err := hex.Decode(sp.traceID[:], []byte(parts[1])); err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(sp.spanID[:], []byte(parts[2])); err != nil {
+		return nil, false
+	}
+	if sp.traceID == [16]byte{} || sp.spanID == [8]byte{} {
+		return nil, false
+	}
+	return &sp, true
+}
+
+// set records the attribute `key` of the span.
+func (sp *span) set(key, value string) {
+	if sp != disabledSpan {
+		sp.attrs[key] = value
+	}
+}
+
+// finish ends the span, as failed if `err` is not nil, and queues it for
+// export.
+func (sp *span) finish(err error) {
+	if sp == disabledSpan {
+		return
+	}
+	sp.end, sp.err = time.Now(), err
+	tracer.add(sp)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// otlpExporter sends spans to an OpenTelemetry collector with OTLP/HTTP, in
+// its JSON encoding.
+type otlpExporter struct {
+	url     string
+	service string
+	client  *http.Client
+
+	mtx   sync.Mutex
+	spans []*span
+}
+
+// add queues `sp` for the next export.
+func (e *otlpExporter) add(sp *span) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if len(e.spans) < maxPendingSpans {
+		e.spans = append(e.spans, sp)
+	}
+}
+
+// otlpValue is an OTLP AnyValue holding a string.
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// otlpAttribute is an OTLP KeyValue.
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpAttributes returns `attrs` as OTLP KeyValues.
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	list := make([]otlpAttribute, 0, len(attrs))
+	for key, value := range attrs {
+		list = append(list, otlpAttribute{key, otlpValue{value}})
+	}
+	return list
+}
+
+// otlpRequest returns the body of an OTLP export request of `spans`.
+func (e *otlpExporter) otlpRequest(spans []*span) map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(spans))
+	for _, sp := range spans {
+		s := map[string]interface{}{
+			"traceId":           hex.EncodeToString(sp.traceID[:]),
+			"spanId":            hex.EncodeToString(sp.spanID[:]),
+			"name":              sp.name,
+			"kind":              sp.kind,
+			"startTimeUnixNano": strconv.FormatInt(sp.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(sp.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(sp.attrs),
+		}
+		if sp.parentID != [8]byte{} {
+			s["parentSpanId"] = hex.EncodeToString(sp.parentID[:])
+		}
+		if sp.err != nil {
+			s["status"] = map[string]interface{}{"code": otlpStatusError, "message": sp.err.Error()}
+		}
+		list = append(list, s)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": e.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "go-wol", "version": wol.Version},
+				"spans": list,
+			}},
+		}},
+	}
+}
+
+// flush exports the queued spans. Spans which fail to export are dropped,
+// so that an unreachable collector does not hold up wakes.
+func (e *otlpExporter) flush() error {
+	e.mtx.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mtx.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// run exports the queued spans every tracingInterval until `stop` is closed,
+// and once more then.
+func (e *otlpExporter) run(stop <-chan struct{}, done chan<- struct{}) {
+	ticker := time.NewTicker(tracingInterval)
+	defer ticker.Stop()
+	defer close(done)
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			if err := e.flush(); err != nil {
+				log.Printf("Failed to export traces: %v\n", err)
+			}
+			return
+		}
+		if err := e.flush(); err != nil {
+			log.Printf("Failed to export traces: %v\n", err)
+		}
+	}
+}
+
+// tracingCloser stops the exporter when closed.
+type tracingCloser struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Close exports the remaining spans.
+func (c tracingCloser) Close() error {
+	close(c.stop)
+	<-c.done
+	return nil
+}
+
+// setupTracing exports spans to the collector at `--otlp-endpoint` (or the
+// standard OTEL_EXPORTER_OTLP_ENDPOINT), if set. The returned closer, if any,
+// should be closed on exit.
+func setupTracing() (io.Closer, error) {
+	if cliFlags.OTLPEndpoint == "" {
+		cliFlags.OTLPEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if cliFlags.OTLPEndpoint == "" {
+		return nil, nil
+	}
+	url := strings.TrimRight(cliFlags.OTLPEndpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("invalid --otlp-endpoint %q (expected an http:// or https:// URL)", cliFlags.OTLPEndpoint)
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "go-wol"
+	}
+
+	tracer = &otlpExporter{url: url, service: service, client: &http.Client{Timeout: timeoutFor(opAPI)}}
+	c := tracingCloser{make(chan struct{}), make(chan struct{})}
+	go tracer.run(c.stop, c.done)
+	return c, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseTraceparent(t *testing.T) {
+	sp, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(sp.traceID[:]))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(sp.spanID[:]))
+
+	for _, header := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01",
+	} {
+		_, ok := parseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	_, sp := startSpan(context.Background(), "wake")
+	assert.Equal(t, disabledSpan, sp)
+
+	tracer = &otlpExporter{}
+	defer func() { tracer = nil }()
+	ctx, root := startSpan(context.Background(), "wake")
+	_, child := startSpan(ctx, "send")
+	assert.Equal(t, root.traceID, child.traceID)
+	assert.Equal(t, root.spanID, child.parentID)
+	assert.Equal(t, [8]byte{}, root.parentID)
+
+	// Requests continue the trace of their client.
+	req := httptest.NewRequest("POST", "/wake/nas", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, server := startServerSpan(req, "POST /wake")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(server.traceID[:]))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(server.parentID[:]))
+	assert.Equal(t, spanKindServer, server.kind)
+}
+
+func TestOTLPExport(t *testing.T) {
+	var body map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		data, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(data, &body))
+	}))
+	defer collector.Close()
+
+	defer func(endpoint string) { cliFlags.OTLPEndpoint = endpoint }(cliFlags.OTLPEndpoint)
+	cliFlags.OTLPEndpoint = collector.URL
+	closer, err := setupTracing()
+	assert.Nil(t, err)
+	defer func() { tracer = nil }()
+
+	ctx, root := startSpan(context.Background(), "wake")
+	_, child := startSpan(ctx, "send")
+	child.set("wol.mac", "00:11:22:33:44:55")
+	child.finish(errors.New("network is unreachable"))
+	root.finish(nil)
+	assert.Nil(t, closer.Close())
+
+	scopeSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"]
+	spans := scopeSpans.([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	send := spans[0].(map[string]interface{})
+	assert.Equal(t, "send", send["name"])
+	assert.Equal(t, hex.EncodeToString(root.spanID[:]), send["parentSpanId"])
+	assert.Equal(t, "network is unreachable", send["status"].(map[string]interface{})["message"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"key":   "wol.mac",
+		"value": map[string]interface{}{"stringValue": "00:11:22:33:44:55"},
+	}}, send["attributes"])
+	assert.Nil(t, spans[1].(map[string]interface{})["parentSpanId"])
+
+	cliFlags.OTLPEndpoint = "collector:4318"
+	_, err = setupTracing()
+	assert.EqualError(t, err, `invalid --otlp-endpoint "collector:4318" (expected an http:// or https:// URL)`)
+}
@@ -0,0 +1,91 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	wol "github.com/sabhiram/go-wol"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// udpTransport sends magic packets over UDP with the CLI's IP, multicast and
+// VPN relay options applied, and checks that they left the interface.
+type udpTransport struct {
+	localAddr *net.UDPAddr
+}
+
+// Send sends `payload` to the host:port `addr`.
+func (t udpTransport) Send(ctx context.Context, addr string, payload []byte) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	return sendMagicPacket(ctx, payload, t.localAddr, udpAddr)
+}
+
+// viaTransport has an SSH gateway send the magic packet for `mac`.
+type viaTransport struct {
+	gateway string
+	mac     string
+}
+
+// Send has the gateway send the packet to the host:port `addr`. The gateway
+// builds the packet itself, so `payload` is not used.
+func (t viaTransport) Send(ctx context.Context, addr string, payload []byte) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	return sendVia(ctx, t.gateway, t.mac, udpAddr)
+}
+
+// newTransport returns the transport named by `--transport` which sends
+// from `localAddr` (if not nil), or the one sending via `gateway` if set.
+// The raw transport needs an interface: `iface`, if it names one, or else
+// the one `localAddr` belongs to.
+func newTransport(name, gateway, mac, iface string, localAddr *net.UDPAddr) (wol.Transport, error) {
+	if cliFlags.VLAN != 0 {
+		if name != "raw" {
+			return nil, errors.New("--vlan requires --transport raw (or -i the VLAN's sub-interface, such as eth0.10)")
+		}
+		if cliFlags.VLAN < 1 || cliFlags.VLAN > 4094 {
+			return nil, fmt.Errorf("invalid VLAN %d (expected 1-4094)", cliFlags.VLAN)
+		}
+	}
+
+	switch name {
+	case "", "udp":
+		if gateway != "" {
+			return viaTransport{gateway, mac}, nil
+		}
+		return udpTransport{localAddr}, nil
+
+	case "tcp", "raw":
+		if gateway != "" {
+			return nil, fmt.Errorf("--transport %s cannot be used --via a gateway", name)
+		}
+		if name == "tcp" {
+			t := wol.TCP{}
+			if localAddr != nil {
+				t.LocalAddr = &net.TCPAddr{IP: localAddr.IP, Port: localAddr.Port}
+			}
+			return t, nil
+		}
+		if !interfaceExists(iface) {
+			iface = ""
+			if localAddr != nil {
+				iface = interfaceByIP(localAddr.IP)
+			}
+		}
+		if iface == "" {
+			return nil, errors.New("--transport raw requires an --interface to send from")
+		}
+		return wol.RawEthernet{Interface: iface, VLAN: cliFlags.VLAN}, nil
+	}
+	return nil, fmt.Errorf("unknown transport %q (expected udp, tcp or raw)", name)
+}
@@ -0,0 +1,49 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	wol "github.com/sabhiram/go-wol"
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestNewTransport(t *testing.T) {
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40009}
+
+	transport, err := newTransport("", "", "00:11:22:aa:bb:cc", "", local)
+	assert.Nil(t, err)
+	assert.Equal(t, udpTransport{local}, transport)
+
+	transport, err = newTransport("udp", "gw", "00:11:22:aa:bb:cc", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, viaTransport{"gw", "00:11:22:aa:bb:cc"}, transport)
+
+	transport, err = newTransport("tcp", "", "00:11:22:aa:bb:cc", "", local)
+	assert.Nil(t, err)
+	assert.Equal(t, wol.TCP{LocalAddr: &net.TCPAddr{IP: local.IP, Port: 40009}}, transport)
+
+	_, err = newTransport("tcp", "gw", "00:11:22:aa:bb:cc", "", nil)
+	assert.EqualError(t, err, "--transport tcp cannot be used --via a gateway")
+	_, err = newTransport("raw", "", "00:11:22:aa:bb:cc", "", nil)
+	assert.EqualError(t, err, "--transport raw requires an --interface to send from")
+	_, err = newTransport("sctp", "", "00:11:22:aa:bb:cc", "", nil)
+	assert.EqualError(t, err, `unknown transport "sctp" (expected udp, tcp or raw)`)
+
+	// Raw frames go out of a named interface, which needs no address, and
+	// may be tagged for a VLAN.
+	defer func(vlan int) { cliFlags.VLAN = vlan }(cliFlags.VLAN)
+	cliFlags.VLAN = 10
+	transport, err = newTransport("raw", "", "00:11:22:aa:bb:cc", "lo", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, wol.RawEthernet{Interface: "lo", VLAN: 10}, transport)
+	_, err = newTransport("udp", "", "00:11:22:aa:bb:cc", "", local)
+	assert.EqualError(t, err, "--vlan requires --transport raw (or -i the VLAN's sub-interface, such as eth0.10)")
+	cliFlags.VLAN = 4095
+	_, err = newTransport("raw", "", "00:11:22:aa:bb:cc", "lo", nil)
+	assert.EqualError(t, err, "invalid VLAN 4095 (expected 1-4094)")
+}
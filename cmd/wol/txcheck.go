@@ -0,0 +1,110 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	sysClassNet = "/sys/class/net"
+
+	// How long to give the kernel to hand a packet to the NIC before its tx
+	// counter is checked.
+	txCheckDelay = 50 * time.Millisecond
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// linkStatus is what Linux reports (in sysfs) about an interface's link.
+type linkStatus struct {
+	operState string // e.g. "up", "down", "unknown"
+	carrier   int    // 1 or 0, or -1 if unknown
+	txPackets uint64
+}
+
+// readLinkStatus reads the link status of the interface `name` from `sysfs`.
+// It fails where there is no sysfs, i.e. on anything but Linux.
+func readLinkStatus(sysfs, name string) (linkStatus, error) {
+	read := func(file string) (string, error) {
+		bs, err := ioutil.ReadFile(filepath.Join(sysfs, name, file))
+		return strings.TrimSpace(string(bs)), err
+	}
+
+	ls := linkStatus{carrier: -1}
+	var err error
+	if ls.operState, err = read("operstate"); err != nil {
+		return ls, err
+	}
+	// Reading the carrier of an interface which is administratively down
+	// fails, which leaves it unknown.
+	if carrier, err := read("carrier"); err == nil {
+		ls.carrier, _ = strconv.Atoi(carrier)
+	}
+	tx, err := read("statistics/tx_packets")
+	if err != nil {
+		return ls, err
+	}
+	ls.txPackets, err = strconv.ParseUint(tx, 10, 64)
+	return ls, err
+}
+
+// linkProblem describes why the interface `name` cannot send packets, or
+// returns "" if it looks fine.
+func linkProblem(name string, ls linkStatus) string {
+	switch {
+	case ls.operState == "down":
+		return fmt.Sprintf("interface %s is down", name)
+	case ls.carrier == 0:
+		return fmt.Sprintf("interface %s has no carrier (is the cable plugged in?)", name)
+	}
+	return ""
+}
+
+// interfaceByIP returns the name of the interface with the address `ip`, or
+// "" if there is none.
+func interfaceByIP(ip net.IP) string {
+	snap, err := interfaces.get()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range snap.ifaces {
+		for _, addr := range snap.addrs[iface.Name] {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+// warnIfNotSent prints a warning to stderr if the interface `name` cannot
+// have sent the packet just written: its link is down, or (with
+// `--check-tx`) its tx counter has not moved since `before`. It does nothing
+// where the link status cannot be read.
+func warnIfNotSent(name string, before linkStatus) {
+	if cliFlags.CheckTx {
+		time.Sleep(txCheckDelay)
+	}
+	after, err := readLinkStatus(sysClassNet, name)
+	if err != nil {
+		return
+	}
+
+	problem := linkProblem(name, after)
+	if problem == "" && cliFlags.CheckTx && after.txPackets == before.txPackets {
+		problem = fmt.Sprintf("interface %s did not transmit any packets", name)
+	}
+	if problem != "" {
+		fmt.Fprintf(os.Stderr, "WARNING: %s, the magic packet was probably not sent\n", problem)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Writes a fake sysfs entry for the interface `name`. Empty values are left
+// out.
+func writeFakeLink(t *testing.T, sysfs, name, operstate, carrier, tx string) {
+	dir := filepath.Join(sysfs, name, "statistics")
+	assert.Nil(t, os.MkdirAll(dir, 0755))
+	for file, value := range map[string]string{
+		"operstate":             operstate,
+		"carrier":               carrier,
+		"statistics/tx_packets": tx,
+	} {
+		if value != "" {
+			assert.Nil(t, ioutil.WriteFile(filepath.Join(sysfs, name, file), []byte(value+"\n"), 0644))
+		}
+	}
+}
+
+func TestReadLinkStatus(t *testing.T) {
+	sysfs, err := ioutil.TempDir("", "sysfs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(sysfs)
+
+	writeFakeLink(t, sysfs, "eth0", "up", "1", "1234")
+	writeFakeLink(t, sysfs, "eth1", "down", "", "0")
+	writeFakeLink(t, sysfs, "eth2", "up", "0", "7")
+
+	for _, tc := range []struct {
+		name     string
+		expected linkStatus
+		problem  bool
+	}{
+		{"eth0", linkStatus{"up", 1, 1234}, false},
+		{"eth1", linkStatus{"down", -1, 0}, true},
+		{"eth2", linkStatus{"up", 0, 7}, true},
+	} {
+		ls, err := readLinkStatus(sysfs, tc.name)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, ls)
+		assert.Equal(t, tc.problem, linkProblem(tc.name, ls) != "")
+	}
+
+	_, err = readLinkStatus(sysfs, "eth9")
+	assert.NotNil(t, err)
+}
+
+func TestInterfaceByIP(t *testing.T) {
+	assert.NotEqual(t, "", interfaceByIP(net.IPv4(127, 0, 0, 1)))
+	assert.Equal(t, "", interfaceByIP(net.ParseIP("192.0.2.123")))
+}
@@ -3,7 +3,11 @@ package main
 ////////////////////////////////////////////////////////////////////////////////
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/sabhiram/go-colorize"
 
@@ -12,77 +16,140 @@ import (
 
 ////////////////////////////////////////////////////////////////////////////////
 
-var (
-	validCommands = []struct {
-		name, description string
-	}{
-		{`wake`, `wakes up a machine by mac address or alias`},
-		{`list`, `lists all mac addresses and their aliases`},
-		{`alias`, `stores an alias to a mac address`},
-		{`remove`, `removes an alias or a mac address`},
-	}
+// option describes a command line option, as defined by its cliFlags tags.
+type option struct {
+	short, long, description, def string
+}
 
-	validOptions = []struct {
-		short, long, description string
-	}{
-		{`v`, `version`, `prints the application version`},
-		{`h`, `help`, `prints this help menu`},
-		{`p`, `port`, `udp port to send bcast packet to`},
-		{`b`, `bcast`, `broadcast IP to send packet to`},
-		{`i`, `interface`, `outbound interface to broadcast using`},
+// getOptions returns the options of command `cmd` (or the global options, if
+// `cmd` is empty) in the order they are defined.
+func getOptions(cmd string) []option {
+	groups := []interface{}{&cliFlags.globalFlags}
+	if cmd != "" {
+		groups = cmdMap[cmd].flags
 	}
 
-	usageString = `Usage:
-
-    To wake up a machine:
-        <cyan>wol</cyan> [<options>] <yellow>wake</yellow> <mac address | alias> <optional interface>
-
-    To store an alias:
-        <cyan>wol</cyan> [<options>] <yellow>alias</yellow> <alias> <mac address> <optional interface>
-
-    To view aliases:
-        <cyan>wol</cyan> [<options>] <yellow>list</yellow>
-
-    To delete aliases:
-        <cyan>wol</cyan> [<options>] <yellow>remove</yellow> <alias>
-
-    The following MAC addresses are valid and will match:
-    01-23-45-56-67-89, 89:AB:CD:EF:00:12, 89:ab:cd:ef:00:12
-
-    The following MAC addresses are not (yet) valid:
-    1-2-3-4-5-6, 01 23 45 56 67 89
-
-Commands:
-%s
-Options:
-%s
-Version:
-    <white>%s</white>
+	var options []option
+	for _, data := range groups {
+		t := reflect.TypeOf(data).Elem()
+		for idx := 0; idx < t.NumField(); idx++ {
+			tag := t.Field(idx).Tag
+			options = append(options, option{
+				short:       tag.Get("short"),
+				long:        tag.Get("long"),
+				description: tag.Get("description"),
+				def:         tag.Get("default"),
+			})
+		}
+	}
+	return options
+}
 
-`
-)
+// commandNames returns the names of the registered commands, wake first and
+// the rest sorted.
+func commandNames() []string {
+	names := []string{"wake"}
+	for name := range cmdMap {
+		if name != "wake" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
 
 ////////////////////////////////////////////////////////////////////////////////
 
-// Build a command string from the above valid ones.
+// Build a command string from the registered ones.
 func getAllCommands() string {
 	commands := ""
-	for _, c := range validCommands {
-		commands += fmt.Sprintf("    <yellow>%-16s</yellow> %s\n", c.name, c.description)
+	for _, name := range commandNames() {
+		commands += fmt.Sprintf("    <yellow>%-16s</yellow> %s\n", name, msg("cmd."+name))
 	}
 	return commands
 }
 
-// Build an option string from the above valid ones.
-func getAllOptions() string {
-	options := ""
-	for _, o := range validOptions {
-		options += fmt.Sprintf("    <yellow>-%s --%-8s</yellow>    %s\n", o.short, o.long, o.description)
+// Build an option string from the options which apply to `cmd` (or the
+// global ones, if `cmd` is empty).
+func getAllOptions(cmd string) string {
+	options := getOptions(cmd)
+	width := 0
+	for _, o := range options {
+		if len(o.long) > width {
+			width = len(o.long)
+		}
 	}
-	return options
+
+	lines := ""
+	for _, o := range options {
+		short := "  "
+		if o.short != "" {
+			short = "-" + o.short
+		}
+		description := msgDefault("opt."+o.long, o.description)
+		if o.def != "" && o.def != "0" {
+			description += " " + msg("usage.default", o.def)
+		}
+		lines += fmt.Sprintf("    <yellow>%s --%-*s</yellow>    %s\n", short, width, o.long, description)
+	}
+	return lines
+}
+
+// Build the examples of how to invoke command `name`.
+func getExamples(name string) string {
+	examples := ""
+	for _, ex := range cmdMap[name].examples {
+		examples += "    " + msg(ex.caption) + "\n"
+		for _, args := range ex.args {
+			line := fmt.Sprintf("<cyan>wol</cyan> [<options>] <yellow>%s</yellow> %s", name, args)
+			examples += "        " + strings.TrimSpace(line) + "\n"
+		}
+		examples += "\n"
+	}
+	return examples
 }
 
 // Returns the Usage string for this application.
 func getAppUsageString() string {
-	return colorize.Colorize(fmt.Sprintf(usageString, getAllCommands(), getAllOptions(), wol.Version))
+	usage := msg("usage") + "\n\n"
+	for _, name := range commandNames() {
+		usage += getExamples(name)
+	}
+	usage += msg("usage.notes") + "\n" +
+		msg("usage.commands") + "\n" + getAllCommands() + "\n" +
+		msg("usage.options") + "\n" + getAllOptions("") + "\n" +
+		msg("usage.help-hint") + "\n\n" +
+		msg("usage.version") + "\n    <white>" + wol.Version + "</white>\n\n"
+	return colorize.Colorize(usage)
+}
+
+// Returns the Usage string of command `name`.
+func getCommandUsageString(name string) (string, error) {
+	if _, ok := cmdMap[name]; !ok {
+		return "", errors.New(msg("error.unknown-command", name))
+	}
+
+	usage := msg("usage") + "\n\n" +
+		getExamples(name) +
+		fmt.Sprintf("    <yellow>%s</yellow>: %s\n\n", name, msg("cmd."+name))
+	if options := getAllOptions(name); options != "" {
+		usage += msg("usage.options") + "\n" + options + "\n"
+	}
+	usage += msg("usage.global-hint") + "\n\n"
+	return colorize.Colorize(usage), nil
+}
+
+// helpCmd prints the usage of the command named in `args`, or of the whole
+// application if there is none, and returns the exit code.
+func helpCmd(args []string) int {
+	if len(args) == 0 {
+		return printUsageGetExitCode("", 0)
+	}
+
+	usage, err := getCommandUsageString(strings.ToLower(args[0]))
+	if err != nil {
+		return printUsageGetExitCode(err.Error()+"\n", 1)
+	}
+	fmt.Print(usage)
+	return 0
 }
@@ -0,0 +1,49 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestOptionsDescribed(t *testing.T) {
+	for _, name := range append(commandNames(), "") {
+		for _, o := range getOptions(name) {
+			assert.NotEmpty(t, o.long)
+			assert.NotEmpty(t, o.description, o.long)
+		}
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	assert.Equal(t, "wake", commandNames()[0])
+	assert.Equal(t, len(cmdMap), len(commandNames()))
+
+	usage, err := getCommandUsageString("scan")
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(usage, "--concurrency"))
+	assert.True(t, strings.Contains(usage, "(default 128)"))
+	assert.False(t, strings.Contains(usage, "--via"))
+
+	// Options several commands take are listed for each of them.
+	usage, err = getCommandUsageString("relay")
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(usage, "--listen"))
+	assert.True(t, strings.Contains(usage, "--dscp"))
+	assert.False(t, strings.Contains(usage, "--tls-cert"))
+
+	// Global options are only listed in the application's usage.
+	assert.False(t, strings.Contains(usage, "--bcast"))
+	usage = getAppUsageString()
+	assert.True(t, strings.Contains(usage, "--bcast"))
+	assert.False(t, strings.Contains(usage, "addresses scan probes at once"))
+	assert.False(t, strings.Contains(usage, "--listen"))
+
+	_, err = getCommandUsageString("frobnicate")
+	assert.NotNil(t, err)
+}
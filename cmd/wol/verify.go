@@ -0,0 +1,72 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// verifyInterval is the pause between successive verification probes.
+	verifyInterval = time.Second
+)
+
+// probeFn runs a single probe against `host` and returns true if the host
+// appears to be awake. Probes should not block past `deadline`.
+type probeFn func(host string, deadline time.Time) (bool, error)
+
+// verifyMethods maps the name passed to `--verify` to its probe.
+var verifyMethods = map[string]probeFn{
+	"ndp": probeNDP,
+	"ssh": probeSSH,
+}
+
+// verifyMethodNames returns a sorted, comma separated list of the supported
+// verification methods.
+func verifyMethodNames() string {
+	names := make([]string, 0, len(verifyMethods))
+	for name := range verifyMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// verifyAwake repeatedly probes `host` using `method` until it answers or
+// `timeout` elapses.
+func verifyAwake(method, host string, timeout time.Duration) error {
+	probe, ok := verifyMethods[method]
+	if !ok {
+		return fmt.Errorf("unknown verify method %q (expected one of: %s)", method, verifyMethodNames())
+	}
+	if host == "" {
+		return fmt.Errorf("verify method %q requires --verify-host (or an alias with --host)", method)
+	}
+
+	up, err := pollAwake(probe, host, timeout)
+	if err == nil && !up {
+		err = fmt.Errorf("%s did not respond to %s probes within %s", host, method, timeout)
+	}
+	return err
+}
+
+// pollAwake runs `probe` against `host` every verifyInterval until it
+// succeeds or `timeout` elapses, and returns whether it succeeded.
+func pollAwake(probe probeFn, host string, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		up, err := probe(host, deadline)
+		if err != nil || up {
+			return up, err
+		}
+		if time.Now().Add(verifyInterval).After(deadline) {
+			return false, nil
+		}
+		time.Sleep(verifyInterval)
+	}
+}
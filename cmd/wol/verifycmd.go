@@ -0,0 +1,106 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// verifyCmdData is what a `--verify-cmd` template can refer to.
+type verifyCmdData struct {
+	IP     string // --verify-host, or else the alias's host name
+	Target string // the alias or MAC address given to wake
+	MAC    string
+}
+
+// shellQuote quotes `s` as a single word for the system shell: host names
+// and targets can come from IPAMs, directories and imports, so none of them
+// may be taken as shell syntax.
+func shellQuote(s string) (string, error) {
+	if runtime.GOOS == "windows" {
+		// No quoting keeps cmd from expanding %VAR% and !VAR!.
+		if strings.ContainsAny(s, "\"%!^\r\n") {
+			return "", fmt.Errorf("%q cannot be passed to cmd safely", s)
+		}
+		return `"` + s + `"`, nil
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'", nil
+}
+
+// env returns the fields of `data` as the environment variables
+// WOL_VERIFY_IP, WOL_VERIFY_TARGET and WOL_VERIFY_MAC, which no option is
+// read from.
+func (data verifyCmdData) env() []string {
+	return []string{"WOL_VERIFY_IP=" + data.IP, "WOL_VERIFY_TARGET=" + data.Target, "WOL_VERIFY_MAC=" + data.MAC}
+}
+
+// renderVerifyCmd expands the `--verify-cmd` template, with every field
+// quoted for the system shell.
+func renderVerifyCmd(cmd string, data verifyCmdData) (string, error) {
+	tmpl, err := template.New("verify-cmd").Option("missingkey=error").Parse(cmd)
+	if err != nil {
+		return "", fmt.Errorf("invalid --verify-cmd: %v", err)
+	}
+	var quoted verifyCmdData
+	for dst, src := range map[*string]string{&quoted.IP: data.IP, &quoted.Target: data.Target, &quoted.MAC: data.MAC} {
+		if *dst, err = shellQuote(src); err != nil {
+			return "", err
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buf, quoted); err != nil {
+		return "", fmt.Errorf("invalid --verify-cmd: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// shellCommand returns a command which runs `line` with the system shell.
+func shellCommand(ctx context.Context, line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", line)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", line)
+}
+
+// commandProbe returns a probe which runs `line` with the system shell, with
+// `env` added to its environment, and considers the target awake once it
+// exits zero. Runs still going at the deadline are killed.
+func commandProbe(line string, env []string) probeFn {
+	return func(_ string, deadline time.Time) (bool, error) {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+
+		cmd := shellCommand(ctx, line)
+		cmd.Env = append(os.Environ(), env...)
+		err := cmd.Run()
+		if _, failed := err.(*exec.ExitError); failed || ctx.Err() != nil {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// verifyCommand runs the `--verify-cmd` template, expanded with `data`, until
+// it exits zero or `timeout` elapses.
+func verifyCommand(cmd string, data verifyCmdData, timeout time.Duration) error {
+	line, err := renderVerifyCmd(cmd, data)
+	if err != nil {
+		return err
+	}
+
+	up, err := pollAwake(commandProbe(line, data.env()), data.IP, timeout)
+	if err == nil && !up {
+		err = fmt.Errorf("`%s` did not succeed within %s", line, timeout)
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestRenderVerifyCmd(t *testing.T) {
+	data := verifyCmdData{IP: "10.0.0.5", Target: "nas", MAC: "00:11:22:33:44:55"}
+
+	line, err := renderVerifyCmd("./check.sh {{.IP}} {{.Target}} {{.MAC}}", data)
+	assert.Nil(t, err)
+	if runtime.GOOS == "windows" {
+		assert.Equal(t, `./check.sh "10.0.0.5" "nas" "00:11:22:33:44:55"`, line)
+		_, err = renderVerifyCmd("./check.sh {{.IP}}", verifyCmdData{IP: "%PATH%"})
+		assert.NotNil(t, err)
+	} else {
+		assert.Equal(t, "./check.sh '10.0.0.5' 'nas' '00:11:22:33:44:55'", line)
+		line, err = renderVerifyCmd("./check.sh {{.IP}}", verifyCmdData{IP: "x'; reboot; '"})
+		assert.Nil(t, err)
+		assert.Equal(t, `./check.sh 'x'\''; reboot; '\'''`, line)
+	}
+
+	_, err = renderVerifyCmd("./check.sh {{.IP", data)
+	assert.NotNil(t, err)
+	_, err = renderVerifyCmd("./check.sh {{.Port}}", data)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	assert.Nil(t, verifyCommand("test {{.IP}} = 10.0.0.5", verifyCmdData{IP: "10.0.0.5"}, time.Second))
+	assert.NotNil(t, verifyCommand("exit 1", verifyCmdData{}, time.Second))
+
+	// Fields are never shell syntax, and are in the environment too.
+	assert.NotNil(t, verifyCommand("test {{.IP}} = x", verifyCmdData{IP: "x; true"}, time.Second))
+	assert.Nil(t, verifyCommand(`test "$WOL_VERIFY_TARGET" = 'a b'`, verifyCmdData{Target: "a b"}, time.Second))
+
+	// Commands running past the deadline are killed.
+	start := time.Now()
+	assert.NotNil(t, verifyCommand("sleep 10", verifyCmdData{}, 500*time.Millisecond))
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
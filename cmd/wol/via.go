@@ -0,0 +1,72 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// sshArgs returns the arguments to `ssh` which connect to `dest`, given as
+// `[user@]host` with an optional `:port`.
+func sshArgs(dest string) ([]string, error) {
+	user, host := "", dest
+	if i := strings.LastIndex(dest, "@"); i >= 0 {
+		user, host = dest[:i+1], dest[i+1:]
+	}
+
+	var args []string
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid ssh port %q", port)
+		}
+		host = h
+		args = append(args, "-p", port)
+	}
+	if host == "" || strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("invalid ssh destination %q (expected [user@]host[:port])", dest)
+	}
+	return append(args, user+host), nil
+}
+
+// viaArgs builds the arguments to `ssh` which make `gateway` send the magic
+// packet for `mac` to `udpAddr`, by running the `--via-helper` (`wol` by
+// default) there.
+func viaArgs(gateway, mac string, udpAddr *net.UDPAddr) ([]string, error) {
+	args, err := sshArgs(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	// ssh joins the remote command with spaces and hands it to the remote
+	// shell, which is fine as MACs, IPs and ports need no quoting.
+	return append(args, "--",
+		cliFlags.ViaHelper, "wake", mac,
+		"-b", udpAddr.IP.String(),
+		"-p", strconv.Itoa(udpAddr.Port)), nil
+}
+
+// sendVia sends the magic packet for `mac` from the `--via` gateway, so that
+// no daemon needs to run inside the target's LAN. The ssh client's prompts
+// (host keys, passwords) and the helper's output are passed through. ssh is
+// killed if it is still running once `ctx` is done.
+func sendVia(ctx context.Context, gateway, mac string, udpAddr *net.UDPAddr) error {
+	args, err := viaArgs(gateway, mac, udpAddr)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("waking via %s failed: %v", gateway, err)
+	}
+	return nil
+}
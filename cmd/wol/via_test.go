@@ -0,0 +1,38 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestViaArgs(t *testing.T) {
+	defer func(helper string) { cliFlags.ViaHelper = helper }(cliFlags.ViaHelper)
+	cliFlags.ViaHelper = "wol"
+
+	udpAddr := &net.UDPAddr{IP: net.IPv4(255, 255, 255, 255), Port: 9}
+	remote := []string{"--", "wol", "wake", "00:11:22:aa:bb:cc", "-b", "255.255.255.255", "-p", "9"}
+	for _, tc := range []struct {
+		gateway  string
+		expected []string
+	}{
+		{"gateway", append([]string{"gateway"}, remote...)},
+		{"pi@gateway", append([]string{"pi@gateway"}, remote...)},
+		{"pi@gateway:2222", append([]string{"-p", "2222", "pi@gateway"}, remote...)},
+		{"[fd00::1]:2222", append([]string{"-p", "2222", "fd00::1"}, remote...)},
+	} {
+		args, err := viaArgs(tc.gateway, "00:11:22:aa:bb:cc", udpAddr)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, args)
+	}
+
+	for _, gateway := range []string{"", "pi@", "-oProxyCommand=x", "gateway:port"} {
+		_, err := viaArgs(gateway, "00:11:22:aa:bb:cc", udpAddr)
+		assert.NotNil(t, err)
+	}
+}
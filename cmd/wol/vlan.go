@@ -0,0 +1,81 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// procNetVLAN lists the 802.1Q sub-interfaces on Linux.
+	procNetVLAN = "/proc/net/vlan/config"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// vlanIface is an 802.1Q sub-interface of a trunk.
+type vlanIface struct {
+	id     int
+	parent string
+}
+
+// parseVLANConfig parses the linux `/proc/net/vlan/config` table into a map
+// of sub-interface name -> VLAN.
+func parseVLANConfig(r io.Reader) map[string]vlanIface {
+	vlans := map[string]vlanIface{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), "|")
+		if len(fields) != 3 {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil {
+			continue
+		}
+		vlans[strings.TrimSpace(fields[0])] = vlanIface{id, strings.TrimSpace(fields[2])}
+	}
+	return vlans
+}
+
+// vlanInterface returns the name of the sub-interface of this machine on
+// the VLAN `id`, if it has one.
+func vlanInterface(id int) (string, bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", false
+	}
+	for _, iface := range ifaces {
+		if v, ok := vlanOf(iface.Name); ok && v.id == id {
+			return iface.Name, true
+		}
+	}
+	return "", false
+}
+
+// vlanOf returns the VLAN of the sub-interface `name`, from the kernel's
+// table where there is one, or else from the usual `<parent>.<id>` naming.
+func vlanOf(name string) (vlanIface, bool) {
+	if f, err := os.Open(procNetVLAN); err == nil {
+		defer f.Close()
+		v, ok := parseVLANConfig(f)[name]
+		return v, ok
+	}
+
+	dot := strings.LastIndex(name, ".")
+	if dot <= 0 {
+		return vlanIface{}, false
+	}
+	id, err := strconv.Atoi(name[dot+1:])
+	if err != nil || id < 1 || id > 4094 {
+		return vlanIface{}, false
+	}
+	return vlanIface{id, name[:dot]}, true
+}
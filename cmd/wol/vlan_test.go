@@ -0,0 +1,25 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseVLANConfig(t *testing.T) {
+	vlans := parseVLANConfig(strings.NewReader(
+		`VLAN Dev name	 | VLAN ID
+Name-Type: VLAN_NAME_TYPE_RAW_PLUS_VID_NO_PAD
+eth0.10        | 10  | eth0
+vlan20         | 20  | bond0
+`))
+	assert.Equal(t, map[string]vlanIface{
+		"eth0.10": {10, "eth0"},
+		"vlan20":  {20, "bond0"},
+	}, vlans)
+}
@@ -0,0 +1,88 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// isTunnelFlags reports whether an interface with `flags` cannot carry
+// broadcasts: WireGuard and most other VPN tunnels are point-to-point links
+// without a broadcast address.
+func isTunnelFlags(flags net.Flags) bool {
+	if flags&net.FlagLoopback != 0 {
+		return false
+	}
+	return flags&net.FlagBroadcast == 0 || flags&net.FlagPointToPoint != 0
+}
+
+// isTunnel reports whether the interface `name` is a tunnel.
+func isTunnel(name string) bool {
+	snap, err := interfaces.get()
+	if err != nil {
+		return false
+	}
+	iface, ok := snap.byName(name)
+	return ok && isTunnelFlags(iface.Flags)
+}
+
+// looksLikeBroadcast reports whether `ip` is meant to reach a whole network:
+// the limited broadcast, a multicast group or (as a guess, since the subnet
+// is not known) a directed broadcast ending in .255.
+func looksLikeBroadcast(ip net.IP) bool {
+	if ip.IsMulticast() {
+		return true
+	}
+	ip4 := ip.To4()
+	return ip4 != nil && ip4[3] == 255
+}
+
+// vpnRelayAddr resolves `--via-vpn-relay`, whose port defaults to that of a
+// `wol relay`.
+func vpnRelayAddr(relay string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(relay); err != nil {
+		relay = net.JoinHostPort(strings.Trim(relay, "[]"), defaultRelayListen[1:])
+	}
+	return net.ResolveUDPAddr("udp", relay)
+}
+
+// sendToVPNRelay sends the magic packet `bs` straight (unicast) to the
+// `--via-vpn-relay`, which broadcasts it on the far side of the tunnel.
+func sendToVPNRelay(ctx context.Context, bs []byte) error {
+	addr, err := vpnRelayAddr(cliFlags.ViaVPNRelay)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := setDeadline(ctx, conn); err != nil {
+		return err
+	}
+	_, err = conn.Write(bs)
+	return err
+}
+
+// routedOverTunnel checks whether a broadcast to `udpAddr` leaves through the
+// tunnel interface `iface`, where it goes nowhere. If so it either warns, or
+// returns true if the packet should go to the `--via-vpn-relay` instead.
+func routedOverTunnel(iface string, udpAddr *net.UDPAddr) bool {
+	if iface == "" || !looksLikeBroadcast(udpAddr.IP) || !isTunnel(iface) {
+		return false
+	}
+	if cliFlags.ViaVPNRelay != "" {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: the route to %s is the tunnel interface %s, which cannot carry broadcasts; consider --via-vpn-relay\n", udpAddr.IP, iface)
+	return false
+}
@@ -0,0 +1,55 @@
+package main
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestIsTunnelFlags(t *testing.T) {
+	for _, tc := range []struct {
+		flags    net.Flags
+		expected bool
+	}{
+		{net.FlagUp | net.FlagBroadcast | net.FlagMulticast, false},
+		{net.FlagUp | net.FlagLoopback, false},
+		{net.FlagUp | net.FlagPointToPoint | net.FlagMulticast, true},
+		{net.FlagUp, true},
+	} {
+		assert.Equal(t, tc.expected, isTunnelFlags(tc.flags))
+	}
+}
+
+func TestLooksLikeBroadcast(t *testing.T) {
+	for _, tc := range []struct {
+		ip       string
+		expected bool
+	}{
+		{"255.255.255.255", true},
+		{"192.168.2.255", true},
+		{"239.255.0.9", true},
+		{"10.8.0.2", false},
+		{"fd00::1", false},
+	} {
+		assert.Equal(t, tc.expected, looksLikeBroadcast(net.ParseIP(tc.ip)))
+	}
+}
+
+func TestVPNRelayAddr(t *testing.T) {
+	for _, tc := range []struct {
+		relay, expected string
+	}{
+		{"10.8.0.2", "10.8.0.2:9"},
+		{"10.8.0.2:4000", "10.8.0.2:4000"},
+		{"[fd00::2]", "[fd00::2]:9"},
+	} {
+		addr, err := vpnRelayAddr(tc.relay)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, addr.String())
+	}
+}
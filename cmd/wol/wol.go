@@ -3,6 +3,7 @@ package main
 ////////////////////////////////////////////////////////////////////////////////
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -10,8 +11,7 @@ import (
 	"os/user"
 	"path"
 	"strings"
-
-	flags "github.com/jessevdk/go-flags"
+	"time"
 
 	wol "github.com/sabhiram/go-wol"
 )
@@ -22,33 +22,50 @@ const (
 	dbPath = "/.config/go-wol/bolt.db"
 )
 
-var (
-	// Define holders for the cli arguments we wish to parse.
-	cliFlags struct {
-		Version            bool   `short:"v" long:"version"`
-		Help               bool   `short:"h" long:"help"`
-		BroadcastInterface string `short:"i" long:"interface" default:""`
-		BroadcastIP        string `short:"b" long:"bcast" default:"255.255.255.255"`
-		UDPPort            string `short:"p" long:"port" default:"9"`
-	}
-)
-
 ////////////////////////////////////////////////////////////////////////////////
 
-// ipFromInterface returns a `*net.UDPAddr` from a network interface name.
+// ipFromInterface returns a `*net.UDPAddr` from a network interface name, or
+// from an IP address (192.168.1.10) or subnet (192.168.1.0/24) which selects
+// the interface with a matching address.
 func ipFromInterface(iface string) (*net.UDPAddr, error) {
-	ief, err := net.InterfaceByName(iface)
+	addr, err := lookupInterfaceIP(iface)
 	if err != nil {
-		return nil, err
+		// The interface (or address) may be newer than the cached
+		// enumeration, so look again before giving up.
+		interfaces.invalidate()
+		addr, err = lookupInterfaceIP(iface)
+	}
+	return addr, err
+}
+
+// interfaceExists returns true if there is a network interface called `name`.
+func interfaceExists(name string) bool {
+	snap, err := interfaces.get()
+	if err != nil {
+		return false
 	}
+	_, ok := snap.byName(name)
+	return ok
+}
 
-	addrs, err := ief.Addrs()
-	if err == nil && len(addrs) <= 0 {
-		err = fmt.Errorf("no address associated with interface %s", iface)
+// lookupInterfaceIP implements ipFromInterface using the interface cache.
+func lookupInterfaceIP(iface string) (*net.UDPAddr, error) {
+	if match, ok := addrMatcher(iface); ok {
+		return ipFromAddress(iface, match)
 	}
+
+	snap, err := interfaces.get()
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := snap.byName(iface); !ok {
+		return nil, fmt.Errorf("no such network interface %s", iface)
+	}
+
+	addrs := snap.addrs[iface]
+	if len(addrs) <= 0 {
+		return nil, fmt.Errorf("no address associated with interface %s", iface)
+	}
 
 	// Validate that one of the addrs is a valid network IP address.
 	for _, addr := range addrs {
@@ -64,6 +81,140 @@ func ipFromInterface(iface string) (*net.UDPAddr, error) {
 	return nil, fmt.Errorf("no address associated with interface %s", iface)
 }
 
+// addrMatcher returns a function matching the IPv4 addresses selected by
+// `spec` if it is an IP address or a subnet in CIDR notation.
+func addrMatcher(spec string) (func(net.IP) bool, bool) {
+	if ip := net.ParseIP(spec); ip != nil {
+		return ip.Equal, true
+	}
+	if _, subnet, err := net.ParseCIDR(spec); err == nil {
+		return subnet.Contains, true
+	}
+	return nil, false
+}
+
+// ipFromAddress returns a `*net.UDPAddr` for the first IPv4 address of any
+// interface which `match` accepts.
+func ipFromAddress(spec string, match func(net.IP) bool) (*net.UDPAddr, error) {
+	snap, err := interfaces.get()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, iface := range snap.ifaces {
+		for _, addr := range snap.addrs[iface.Name] {
+			if ip, ok := addr.(*net.IPNet); ok && ip.IP.To4() != nil && match(ip.IP) {
+				return &net.UDPAddr{
+					IP: ip.IP,
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no interface has an address matching %s", spec)
+}
+
+// interfaceName returns the name of the interface `spec` selects: the one
+// with an address `spec` matches (an IP or subnet, of either family), or else
+// the one called `spec`.
+func interfaceName(spec string) (string, error) {
+	match, ok := addrMatcher(spec)
+	if !ok {
+		return spec, nil
+	}
+	snap, err := interfaces.get()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range snap.ifaces {
+		for _, addr := range snap.addrs[iface.Name] {
+			if ip, ok := addr.(*net.IPNet); ok && match(ip.IP) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface has an address matching %s", spec)
+}
+
+// sourceAddr returns the local address to send from: `localAddr` (the
+// address of the outbound interface, or nil for the default), overridden by
+// `--source-ip` and `--source-port` where given. Stateful firewalls may only
+// forward magic packets from specific source addresses.
+func sourceAddr(localAddr *net.UDPAddr) (*net.UDPAddr, error) {
+	if cliFlags.SourceIP == "" && cliFlags.SourcePort == 0 {
+		return localAddr, nil
+	}
+
+	addr := &net.UDPAddr{}
+	if localAddr != nil {
+		addr.IP = localAddr.IP
+	}
+	if cliFlags.SourceIP != "" {
+		if addr.IP = net.ParseIP(cliFlags.SourceIP); addr.IP == nil {
+			return nil, fmt.Errorf("invalid source IP %q", cliFlags.SourceIP)
+		}
+	}
+	if cliFlags.SourcePort < 0 || cliFlags.SourcePort > 65535 {
+		return nil, fmt.Errorf("invalid source port %d", cliFlags.SourcePort)
+	}
+	addr.Port = cliFlags.SourcePort
+	return addr, nil
+}
+
+// listenAddr returns the address passed with `--listen`, or `def` if none was
+// given. Each long running command has its own default.
+func listenAddr(def string) string {
+	if cliFlags.Listen != "" {
+		return cliFlags.Listen
+	}
+	return def
+}
+
+// sendMagicPacket writes the serialized magic packet `bs` to `udpAddr` from
+// the optional `localAddr` (nil implies the default interface), giving up
+// once `ctx` is done.
+func sendMagicPacket(ctx context.Context, bs []byte, localAddr, udpAddr *net.UDPAddr) error {
+	// Grab a UDP connection to send our packet of bytes.
+	conn, err := net.DialUDP("udp", localAddr, udpAddr)
+	if err != nil {
+		// The address we send from may have gone away.
+		interfaces.invalidate()
+		return err
+	}
+	defer conn.Close()
+
+	if err := setDeadline(ctx, conn); err != nil {
+		return err
+	}
+	if err := setupIPOptions(conn); err != nil {
+		return err
+	}
+	if udpAddr.IP.IsMulticast() {
+		if err := setupMulticast(conn, localAddr); err != nil {
+			return err
+		}
+	}
+
+	// Note the state of the interface the packet leaves from, so that we can
+	// tell if it was actually sent.
+	iface := interfaceByIP(conn.LocalAddr().(*net.UDPAddr).IP)
+	if routedOverTunnel(iface, udpAddr) {
+		return sendToVPNRelay(ctx, bs)
+	}
+	before, _ := readLinkStatus(sysClassNet, iface)
+
+	n, err := conn.Write(bs)
+	if err == nil && n != len(bs) {
+		err = fmt.Errorf("magic packet sent was %d bytes (expected %d bytes sent)", n, len(bs))
+	}
+	if err == nil {
+		err = socketError(conn)
+	}
+	if err == nil && iface != "" {
+		warnIfNotSent(iface, before)
+	}
+	return err
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 // Run the alias command.
@@ -75,25 +226,97 @@ func aliasCmd(args []string, aliases *Aliases) error {
 		}
 		// TODO: Validate mac address
 		alias, mac := args[0], args[1]
-		return aliases.Add(alias, mac, eth)
+		if err := checkPlaintextSpec(alias, mac); err != nil {
+			return err
+		}
+		if cliFlags.IP != "" {
+			if err := checkAliasIP(cliFlags.IP); err != nil {
+				return err
+			}
+		}
+		if cliFlags.Cooldown < 0 {
+			return errors.New("--cooldown must not be negative")
+		}
+		return aliases.Put(alias, MacIface{Mac: mac, Iface: eth, Tags: cliFlags.Tags, IP: cliFlags.IP, Host: cliFlags.Host, Cooldown: cliFlags.Cooldown})
 	}
 	return errors.New("alias command requires a <name> and a <mac>")
 }
 
 // Run the list command.
 func listCmd(args []string, aliases *Aliases) error {
-	mp, err := aliases.List()
+	list, err := aliases.List()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get list of aliases: %v\n", err)
 		return err
 	}
-	if len(mp) == 0 {
-		fmt.Printf("No aliases found! Add one with \"wol alias <name> <mac>\"\n")
-	} else {
-		for alias, mi := range mp {
-			fmt.Printf("    %s - %s %s\n", alias, mi.Mac, mi.Iface)
+	printAliases(list)
+	return nil
+}
+
+// printAliases prints the alias listing of `list`.
+func printAliases(list []Alias) {
+	if len(list) == 0 {
+		progressf("No aliases found! Add one with \"wol alias <name> <mac>\"\n")
+		return
+	}
+	for _, alias := range list {
+		tags := ""
+		if len(alias.Tags) > 0 {
+			tags = " [" + strings.Join(alias.Tags, ", ") + "]"
+		}
+		times := ""
+		if cliFlags.Long {
+			times = fmt.Sprintf(" (created %s, modified %s)",
+				formatStamp(alias.Created), formatStamp(alias.Modified))
+		}
+		ip := ""
+		if alias.IP != "" {
+			ip = " " + alias.IP
+		}
+		if alias.Host != "" {
+			ip += " " + alias.Host
+		}
+		if alias.Cooldown > 0 {
+			ip += fmt.Sprintf(" cooldown %ds", alias.Cooldown)
 		}
+		fmt.Printf("    %s - %s %s%s%s%s\n", alias.Name, alias.Mac, alias.Iface, ip, tags, times)
 	}
+}
+
+// formatStamp formats a creation or modification time, which is zero if it
+// is unknown.
+func formatStamp(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return formatTime(t, time.Now())
+}
+
+// Run the export command.
+func exportCmd(args []string, aliases *Aliases) error {
+	if !cliFlags.AnsibleInventory && !cliFlags.Canonical {
+		return errors.New("export command requires a format, e.g. --ansible-inventory or --canonical")
+	}
+
+	list, err := aliases.List()
+	if err != nil {
+		return err
+	}
+
+	if cliFlags.Canonical {
+		bs, err := canonicalExport(list)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(bs))
+		return nil
+	}
+
+	bs, err := ansibleInventory(list)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", bs)
 	return nil
 }
 
@@ -106,22 +329,52 @@ func removeCmd(args []string, aliases *Aliases) error {
 	return errors.New("remove command requires a <name> of an alias")
 }
 
-// Run the wake command.
-func wakeCmd(args []string, aliases *Aliases) error {
-	if len(args) <= 0 {
-		return errors.New("No mac address specified to wake command")
-	}
+// wakeTarget holds a resolved wake request: the MAC address to wake and
+// where (and how) to send its magic packet.
+type wakeTarget struct {
+	target    string
+	alias     string // name of the alias woken, if any
+	cooldown  time.Duration
+	mac       string
+	hwAddr    wol.MACAddress
+	bcastAddr string
+	bcastFrom string // where bcastAddr came from, for the user
+	ifacePick string // interface picked for the user, and why, if any
+	localAddr *net.UDPAddr
+	udpAddr   *net.UDPAddr
+	packet    *wol.MagicPacket
+	password  []byte // SecureOn password sent after the packet, if any
+	via       string // SSH gateway to send the packet from, if any
+	host      string // host name of the alias, if it has one
+	transport wol.Transport
+	addr      string // where the transport sends the packet
+}
+
+// resolveWakeTarget resolves `target`, which is either a MAC address or an
+// alias, into a wakeTarget using the stored alias and the CLI options. The
+// `origin` of the target decides which secrets its spec may refer to.
+func resolveWakeTarget(ctx context.Context, target string, origin targetOrigin, aliases *Aliases) (wt *wakeTarget, err error) {
+	ctx, sp := startSpan(ctx, "resolve")
+	sp.set("wol.target", redactSpec(target))
+	defer func() { sp.finish(err) }()
 
 	// bcastInterface can be "eth0", "eth1", etc.. An empty string implies
 	// that we use the default interface when sending the UDP packet (nil).
 	bcastInterface := ""
-	macAddr := args[0]
+	macAddr := target
 
 	// First we need to see if this macAddr is actually an alias, if it is:
 	// we set the eth interface based on the stored item, and set the macAddr
 	// based on the alias of the entry.
-	mi, err := aliases.Get(macAddr)
-	if err == nil {
+	mi, aliasErr := aliases.Get(macAddr)
+	alias := target
+	if aliasErr != nil {
+		alias = ""
+		if ipamMI, ok := aliases.lookupIPAM(ctx, macAddr); ok {
+			mi, aliasErr = ipamMI, nil
+		}
+	}
+	if aliasErr == nil {
 		macAddr = mi.Mac
 		bcastInterface = mi.Iface
 	}
@@ -131,114 +384,528 @@ func wakeCmd(args []string, aliases *Aliases) error {
 		bcastInterface = cliFlags.BroadcastInterface
 	}
 
+	// The broadcast address is, in order of precedence, the host of a target
+	// spec, `--bcast`, the current address of the alias's host name, the
+	// directed broadcast of the alias's address and, failing those, the
+	// limited broadcast.
+	bcastIP, udpPort := cliFlags.BroadcastIP, cliFlags.UDPPort
+	bcastFrom := "--bcast"
+	if !cliFlags.bcastSet {
+		bcastFrom = "limited broadcast"
+		if ip, err := directedBroadcast(mi.IP); err == nil {
+			bcastIP, bcastFrom = ip.String(), "directed broadcast of "+mi.IP
+		}
+		if mi.Host != "" {
+			if ip, err := resolveHost(ctx, mi.Host); err == nil {
+				bcastIP, bcastFrom = ip.String(), "address of "+mi.Host
+			} else {
+				fmt.Fprintf(os.Stderr, "WARNING: %v, sending to the %s instead\n", err, bcastFrom)
+			}
+		}
+	}
+
+	// A target spec (`<mac>@<host>:<port>?iface=<iface>&pw=<password>`)
+	// carries its own wake parameters, which take precedence over the ones
+	// on the command line. The password is kept out of the history.
+	var password []byte
+	if strings.ContainsAny(macAddr, "@?") {
+		if err := checkSpecSecret(macAddr, alias != "", origin); err != nil {
+			return nil, err
+		}
+		resolved, err := resolveSpecSecret(macAddr)
+		if err != nil {
+			return nil, err
+		}
+		spec, err := wol.ParseTarget(resolved)
+		if err != nil {
+			return nil, err
+		}
+		from := "alias " + target
+		if macAddr == target {
+			target, from = spec.String(), "target spec"
+		}
+		macAddr, password = spec.MAC.String(), spec.Password
+		if spec.Host != "" {
+			bcastIP, bcastFrom = spec.Host, from
+		}
+		if spec.Port != "" {
+			udpPort = spec.Port
+		}
+		if spec.Iface != "" {
+			bcastInterface = spec.Iface
+		}
+	}
+
+	// Interfaces are those of this machine, which mean nothing to a gateway.
+	if cliFlags.Via != "" {
+		if password != nil {
+			return nil, errors.New("SecureOn passwords cannot be sent --via a gateway")
+		}
+		bcastInterface = ""
+	}
+
+	// Limited broadcasts leave from whichever interface the OS picks, which
+	// may be a container bridge or a VPN, so one is picked for them.
+	ifacePick := ""
+	if bcastInterface == "" && bcastIP == limitedBroadcast && cliFlags.Via == "" && cliFlags.SourceIP == "" {
+		if c, ok := pickInterface(); ok {
+			bcastInterface = c.name
+			ifacePick = fmt.Sprintf("%s (%s)", c.name, c.reasons())
+		}
+	}
+
 	// Populate the local address in the event that the broadcast interface has
 	// been set.
 	var localAddr *net.UDPAddr
 	if bcastInterface != "" {
 		localAddr, err = ipFromInterface(bcastInterface)
-		if err != nil {
-			return err
+		// Raw frames need no address, which trunks often do not have.
+		if err != nil && !(cliFlags.Transport == "raw" && interfaceExists(bcastInterface)) {
+			return nil, err
 		}
 	}
+	if localAddr, err = sourceAddr(localAddr); err != nil {
+		return nil, err
+	}
 
 	// The address to broadcast to is usually the default `255.255.255.255` but
 	// can be overloaded by specifying an override in the CLI arguments.
-	bcastAddr := fmt.Sprintf("%s:%s", cliFlags.BroadcastIP, cliFlags.UDPPort)
+	bcastAddr := net.JoinHostPort(bcastIP, udpPort)
 	udpAddr, err := net.ResolveUDPAddr("udp", bcastAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Build the magic packet.
-	mp, err := wol.New(macAddr)
+	mp, err := buildPacket(ctx, macAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Grab a stream of bytes to send.
-	bs, err := mp.Marshal()
+	transport, err := newTransport(cliFlags.Transport, cliFlags.Via, mp.MAC().String(), bcastInterface, localAddr)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	addr := bcastAddr
+	if raw, ok := transport.(wol.RawEthernet); ok {
+		// Frames go to the Ethernet broadcast, not to an IP address.
+		addr = ""
+		bcastAddr, bcastFrom = "Ethernet broadcast on "+raw.Interface, "--transport raw"
+		if raw.VLAN > 0 {
+			bcastAddr += fmt.Sprintf(" (VLAN %d)", raw.VLAN)
+		}
 	}
 
-	// Grab a UDP connection to send our packet of bytes.
-	conn, err := net.DialUDP("udp", localAddr, udpAddr)
+	return &wakeTarget{
+		target:    target,
+		alias:     alias,
+		cooldown:  time.Duration(mi.Cooldown) * time.Second,
+		mac:       macAddr,
+		hwAddr:    mp.MAC(),
+		bcastAddr: bcastAddr,
+		bcastFrom: bcastFrom,
+		ifacePick: ifacePick,
+		localAddr: localAddr,
+		udpAddr:   udpAddr,
+		packet:    mp,
+		password:  password,
+		via:       cliFlags.Via,
+		host:      mi.Host,
+		transport: transport,
+		addr:      addr,
+	}, nil
+}
+
+// buildPacket builds the magic packet for `macAddr`, which is marshaled when
+// it is sent.
+func buildPacket(ctx context.Context, macAddr string) (*wol.MagicPacket, error) {
+	_, sp := startSpan(ctx, "build packet")
+	mp, err := wol.New(macAddr)
+	if err == nil {
+		sp.set("wol.mac", mp.MAC().String())
+	}
+	sp.finish(err)
+	return mp, err
+}
+
+// send broadcasts the magic packet for the wake target with its transport,
+// within the send timeout.
+func (wt *wakeTarget) send(ctx context.Context) error {
+	ctx, sp := startSpan(ctx, "send")
+	sp.set("wol.mac", wt.mac)
+	sp.set("wol.broadcast", wt.bcastAddr)
+	sp.set("wol.transport", cliFlags.Transport)
+	if wt.via != "" {
+		sp.set("wol.via", wt.via)
+	}
+	err := wol.SendPacket(ctx, wt.addr, wt.packet, wt.password, wol.WithTransport(wt.transport), wol.WithTimeout(timeoutFor(opSend)))
+	sp.finish(err)
+	return err
+}
+
+// wake sends the magic packet unless the alias was already woken within its
+// cooldown (and `--force` was not given), in which case the
+// request is coalesced with the earlier one and the time left in the window
+// is returned instead. Successful wakes are recorded in the alias db, and
+// every attempt is added to the history as made `by` the given client.
+func (wt *wakeTarget) wake(ctx context.Context, aliases *Aliases, by string) (time.Duration, error) {
+	wait, err := wt.wakeOnce(ctx, aliases)
+	entry := HistoryEntry{
+		Time:      time.Now(),
+		Target:    wt.target,
+		Mac:       wt.mac,
+		By:        by,
+		Coalesced: wait > 0,
+	}
 	if err != nil {
-		return err
+		entry.Error = err.Error()
 	}
-	defer conn.Close()
+	recordWake(aliases, entry)
+	return wait, err
+}
 
-	fmt.Printf("Attempting to send a magic packet to MAC %s\n", macAddr)
-	fmt.Printf("... Broadcasting to: %s\n", bcastAddr)
-	n, err := conn.Write(bs)
-	if err == nil && n != 102 {
-		err = fmt.Errorf("magic packet sent was %d bytes (expected 102 bytes sent)", n)
+// lastWakeKey returns the key the last wake of the target is recorded under:
+// the name of its alias, or its MAC if it has none.
+func (wt *wakeTarget) lastWakeKey() string {
+	if wt.alias != "" {
+		return wt.alias
 	}
+	return wt.hwAddr.String()
+}
+
+// wakeOnce applies the cooldown of the alias and sends the magic packet.
+func (wt *wakeTarget) wakeOnce(ctx context.Context, aliases *Aliases) (time.Duration, error) {
+	key := wt.lastWakeKey()
+	if wt.cooldown > 0 && !cliFlags.Force {
+		last, err := aliases.LastWake(key)
+		if err != nil {
+			return 0, err
+		}
+		if since := time.Since(last); since < wt.cooldown {
+			return wt.cooldown - since, nil
+		}
+	}
+
+	if err := wt.send(ctx); err != nil {
+		return 0, err
+	}
+	return 0, aliases.SetLastWake(key, time.Now())
+}
+
+// cliUser describes the user running the CLI in the wake history.
+func cliUser() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// Run the wake command.
+func wakeCmd(args []string, aliases *Aliases) error {
+	if len(args) <= 0 {
+		return errors.New("No mac address specified to wake command")
+	}
+
+	// A group wakes every machine it contains.
+	wakeMethod := cliFlags.Method
+	if _, err := aliases.Groups().Members(args[0]); err == nil {
+		if wakeMethod != "" && wakeMethod != "wol" {
+			return fmt.Errorf("--method applies to a single alias, not to group %s", args[0])
+		}
+		return wakeGroup(args[0], aliases)
+	}
+
+	// Machines may also be powered on through their management controller,
+	// which is how some aliases are woken unless told otherwise.
+	if oob, ok := defaultOOB(args[0], aliases); ok && wakeMethod == "" {
+		wakeMethod = oob.Kind
+	}
+	if wakeMethod != "" && wakeMethod != "wol" {
+		return wakeOOB(args[0], wakeMethod, aliases)
+	}
+
+	ctx, sp := startSpan(context.Background(), "wake")
+	sp.set("wol.target", redactSpec(args[0]))
+	err := wakeSingle(ctx, args[0], aliases)
+	sp.finish(err)
+	return err
+}
+
+// wakeSingle wakes the single machine `target` with a magic packet, and
+// verifies that it came up if asked to.
+func wakeSingle(ctx context.Context, target string, aliases *Aliases) error {
+	wt, err := resolveWakeTarget(ctx, target, fromLocal, aliases)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Magic packet sent successfully to %s\n", macAddr)
+	progressf("Attempting to send a magic packet to MAC %s\n", wt.mac)
+	progressf("... Broadcasting to: %s (%s)\n", wt.bcastAddr, wt.bcastFrom)
+	if wt.ifacePick != "" {
+		progressf("... From interface: %s, use -i to choose another\n", wt.ifacePick)
+	}
+	wait, err := wt.wake(ctx, aliases, cliUser())
+	if err != nil {
+		return oobFallback(target, aliases, err)
+	}
+	if wait > 0 {
+		statusf("Skipped, %s was woken less than %s ago (retry in %s or use --force)\n",
+			wt.target, wt.cooldown, wait.Round(time.Second))
+		return nil
+	}
+
+	statusf("Magic packet sent successfully to %s\n", wt.mac)
+
+	// Optionally wait for the target to come up. The outcome is added to the
+	// history, which is what `wol stats` computes success rates from. Aliases
+	// with a host name are verified at it, which the probes resolve afresh.
+	verifyHost := cliFlags.VerifyHost
+	if verifyHost == "" {
+		verifyHost = wt.host
+	}
+	// Unless --verify-timeout is given, the wait adapts to how long the
+	// machine usually takes to come up.
+	var usual time.Duration
+	note := ""
+	if cliFlags.Verify != "" || cliFlags.VerifyCmd != "" {
+		if d, ok := aliases.UsualBootTime(wt.target); ok {
+			usual, note = d, fmt.Sprintf(" (usually up in %s)", aboutDuration(d))
+		}
+	}
+	timeout := cliFlags.VerifyTimeout
+	if timeout <= 0 {
+		timeout = verifyTimeout(usual)
+	}
+	start := time.Now()
+	_, sp := startSpan(ctx, "verify")
+	var method, host string
+	switch {
+	case cliFlags.VerifyCmd != "":
+		progressf("... Waiting up to %s for the verify command to succeed%s\n", timeout, note)
+		data := verifyCmdData{IP: verifyHost, Target: wt.target, MAC: wt.mac}
+		method, host = "cmd", wt.target
+		p := newTimedProgress("waiting for "+host, timeout)
+		err = verifyCommand(cliFlags.VerifyCmd, data, timeout)
+		p.finish()
+
+	case cliFlags.Verify != "":
+		progressf("... Waiting up to %s for %s to answer %s probes%s\n", timeout, verifyHost, cliFlags.Verify, note)
+		method, host = cliFlags.Verify, verifyHost
+		p := newTimedProgress("waiting for "+host, timeout)
+		err = verifyAwake(cliFlags.Verify, verifyHost, timeout)
+		p.finish()
+
+	default:
+		return nil
+	}
+	sp.set("wol.verify", method)
+	sp.finish(err)
+
+	entry := HistoryEntry{
+		Time:   time.Now(),
+		Target: wt.target,
+		Mac:    wt.mac,
+		By:     cliUser(),
+		Verify: method,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.UpAfter = time.Since(start)
+	}
+	recordWake(aliases, entry)
+	if err != nil {
+		return oobFallback(target, aliases, err)
+	}
+	statusf("%s is awake\n", host)
 	return nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-type cmdFnType func([]string, *Aliases) error
-
-var cmdMap = map[string]cmdFnType{
-	"alias":  aliasCmd,
-	"list":   listCmd,
-	"remove": removeCmd,
-	"wake":   wakeCmd,
+// offlineCmds are the commands which only touch the alias db and never send
+// anything on the network.
+var offlineCmds = map[string]bool{
+	"alias":   true,
+	"export":  true,
+	"group":   true,
+	"history": true,
+	"import":  true,
+	"list":    true,
+	"prune":   true,
+	"remove":  true,
+	"stats":   true,
+	"sync":    true,
+	"token":   true,
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
 // Helper function to dump the usage and print an error if specified,
 // it also returns the exit code requested to the function (saves me a line).
+// Usage asked for goes to stdout, usage after a mistake to stderr.
 func printUsageGetExitCode(s string, e int) int {
+	w := os.Stdout
+	if e != 0 {
+		w = os.Stderr
+	}
 	if len(s) > 0 {
-		fmt.Printf(s)
+		fmt.Fprint(w, s)
 	}
-	fmt.Printf(getAppUsageString())
+	fmt.Fprint(w, getAppUsageString())
 	return e
 }
 
 func fatalOnError(err error) {
 	if err != nil {
-		fmt.Printf("Fatal error: %s\n", err.Error())
+		fmt.Fprint(os.Stderr, msg("error.fatal", err.Error()))
 		os.Exit(1)
 	}
 }
 
-// Main entry point for binary.
-func main() {
-	var args []string
+// aliasDBPath returns the path of the alias db, which is `--db` if set and
+// otherwise lives under the current user's home directory.
+func aliasDBPath() (string, error) {
+	if cliFlags.DBPath != "" {
+		return cliFlags.DBPath, nil
+	}
 
 	// Detect the current user to figure out what their ~ is.
 	usr, err := user.Current()
-	fatalOnError(err)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(usr.HomeDir, dbPath), nil
+}
 
-	// Load the list of aliases from the file at dbPath.
-	aliases, err := LoadAliases(path.Join(usr.HomeDir, dbPath))
-	fatalOnError(err)
+// runCommand loads the alias db and runs the command named by `args[0]`,
+// treating anything that is not a known command as a target to wake.
+func runCommand(args []string) error {
+	if err := validateTimeouts(); err != nil {
+		return err
+	}
+
+	logCloser, err := setupLogging()
+	if err != nil {
+		return err
+	}
+	if logCloser != nil {
+		defer logCloser.Close()
+	}
+
+	traceCloser, err := setupTracing()
+	if err != nil {
+		return err
+	}
+	if traceCloser != nil {
+		defer traceCloser.Close()
+	}
+
+	notifyCloser, err := setupNotify()
+	if err != nil {
+		return err
+	}
+	if notifyCloser != nil {
+		defer notifyCloser.Close()
+	}
+
+	p, err := aliasDBPath()
+	if err != nil {
+		return err
+	}
+
+	cmd, cmdArgs := strings.ToLower(args[0]), args[1:]
+
+	// Commands which a daemon serves go through the one answering on the
+	// socket, if any, unless they need what only this process can do or are
+	// given a db of their own.
+	viaDaemon := daemonCmd(args)
+	if viaDaemon != nil && !localWakeOptions() && (cliFlags.DBPath == "" || cliFlags.Socket != "") {
+		if d := dialDaemon(socketPath()); d != nil {
+			return viaDaemon(d)
+		}
+	}
+
+	// Load the list of aliases from the file at dbPath. While a daemon has it
+	// open, the commands it serves go through `wol serve` (if that is the
+	// daemon) and commands which only read it read a snapshot.
+	aliases, err := LoadAliases(p)
+	if _, ok := err.(*dbLockedError); ok {
+		if apiURL := daemonURL(p); apiURL != "" && viaDaemon != nil {
+			progressf("The alias db is in use, going through wol serve at %s\n", apiURL)
+			return viaDaemon(newDaemon(apiURL))
+		}
+		if readOnlyCmds[cmd] {
+			fmt.Fprintf(os.Stderr, "WARNING: %s is in use by another wol process, reading a snapshot of it\n", p)
+			aliases, err = loadSnapshot(p)
+		}
+	}
+	if err != nil {
+		return err
+	}
 	defer aliases.Close()
+	if cliFlags.Ethers != "" {
+		if err := aliases.LoadEthers(cliFlags.Ethers); err != nil {
+			return err
+		}
+	}
+	if cliFlags.IPAM != "" {
+		if err := aliases.UseIPAM(cliFlags.IPAM); err != nil {
+			return err
+		}
+	}
 
-	// Parse arguments which might get passed to "wol".
-	parser := flags.NewParser(&cliFlags, flags.Default & ^flags.HelpFlag)
-	args, err = parser.Parse()
+	// Commands which send packets get a warning if they cannot reach the LAN
+	// from inside a container, and can probe for that up front.
+	if !offlineCmds[cmd] {
+		warnIfBridged()
+		if cliFlags.NetworkProbe {
+			if err := runNetworkProbe(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c, ok := cmdMap[cmd]; ok && c.run != nil {
+		return c.run(cmdArgs, aliases)
+	}
+	return wakeCmd(args, aliases)
+}
+
+// Main entry point for binary.
+func main() {
+	// Parse arguments which might get passed to "wol". Options which are not
+	// given on the command line fall back to their WOL_* environment variable,
+	// then to the config file (and selected profile) and then to their
+	// default. Each command's own options are only accepted after its name.
+	parser := newParser()
+	cmdline := implicitWake(parser, os.Args[1:])
+	args, err := parser.ParseArgs(cmdline)
+	if err == nil {
+		args, err = loadConfig(parser, cmdline, args)
+		fatalOnError(err)
+	}
+	if err == nil && parser.Active != nil {
+		args = append([]string{parser.Active.Name}, args...)
+	}
+	cliFlags.bcastSet = optionSet(parser, "bcast")
 
 	ec := 0
 	switch {
 
-	// Parse Error, print usage.
+	// Parse Error, print usage (of the command, if it is known).
+	case err != nil && parser.Active != nil:
+		if usage, err := getCommandUsageString(parser.Active.Name); err == nil {
+			fmt.Fprint(os.Stderr, usage)
+		}
+		ec = 1
 	case err != nil:
 		ec = printUsageGetExitCode("", 1)
 
 	// No arguments, or help requested, print usage.
-	case len(os.Args) == 1 || cliFlags.Help:
+	case len(os.Args) == 1 || cliFlags.Help && len(args) == 0:
 		ec = printUsageGetExitCode("", 0)
+	case cliFlags.Help:
+		ec = helpCmd(args[:1])
 
 	// "--version" requested.
 	case cliFlags.Version:
@@ -246,17 +913,15 @@ func main() {
 
 	// Make sure we are being asked to run a something.
 	case len(args) == 0:
-		ec = printUsageGetExitCode("No command specified, see usage:\n", 1)
+		ec = printUsageGetExitCode(msg("error.no-command"), 1)
+
+	// "help <command>" requested, which needs no alias db.
+	case args[0] == "help":
+		ec = helpCmd(args[1:])
 
 	// All other cases go here.
 	case true:
-		cmd, cmdArgs := strings.ToLower(args[0]), args[1:]
-		if fn, ok := cmdMap[cmd]; ok {
-			err = fn(cmdArgs, aliases)
-		} else {
-			err = wakeCmd(args, aliases)
-		}
-		fatalOnError(err)
+		fatalOnError(runCommand(args))
 	}
 	os.Exit(ec)
 }
@@ -3,8 +3,10 @@ package main
 ////////////////////////////////////////////////////////////////////////////////
 
 import (
+	"context"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -27,6 +29,34 @@ func TestIPFromInterface(t *testing.T) {
 	}
 }
 
+func TestIPFromInterfaceAddress(t *testing.T) {
+	// The loopback interface can be selected by its address or subnet.
+	for _, spec := range []string{"127.0.0.1", "127.0.0.0/8"} {
+		addr, err := ipFromInterface(spec)
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1", addr.IP.String())
+	}
+
+	for _, spec := range []string{"203.0.113.123", "203.0.113.0/24"} {
+		addr, err := ipFromInterface(spec)
+		assert.Nil(t, addr)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestInterfaceName(t *testing.T) {
+	lo, err := interfaceName("127.0.0.1")
+	assert.Nil(t, err)
+	for _, spec := range []string{"127.0.0.0/8", lo} {
+		name, err := interfaceName(spec)
+		assert.Nil(t, err)
+		assert.Equal(t, lo, name)
+	}
+
+	_, err = interfaceName("2001:db8:ffff::/48")
+	assert.NotNil(t, err)
+}
+
 func TestIPFromInterfaceNegative(t *testing.T) {
 	// Test some fake interfaces.
 	var NegativeTestCases = []struct {
@@ -42,3 +72,144 @@ func TestIPFromInterfaceNegative(t *testing.T) {
 		assert.NotNil(t, err)
 	}
 }
+
+func TestSourceAddr(t *testing.T) {
+	defer func(ip string, port int) {
+		cliFlags.SourceIP, cliFlags.SourcePort = ip, port
+	}(cliFlags.SourceIP, cliFlags.SourcePort)
+
+	iface := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2)}
+	for _, tc := range []struct {
+		ip       string
+		port     int
+		local    *net.UDPAddr
+		expected string
+	}{
+		{"", 0, iface, "10.0.0.2:0"},
+		{"", 40009, iface, "10.0.0.2:40009"},
+		{"", 40009, nil, ":40009"},
+		{"10.0.1.5", 0, iface, "10.0.1.5:0"},
+		{"10.0.1.5", 40009, nil, "10.0.1.5:40009"},
+	} {
+		cliFlags.SourceIP, cliFlags.SourcePort = tc.ip, tc.port
+		addr, err := sourceAddr(tc.local)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, addr.String())
+	}
+
+	cliFlags.SourceIP, cliFlags.SourcePort = "", 0
+	addr, err := sourceAddr(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, addr)
+
+	for _, tc := range []struct {
+		ip   string
+		port int
+	}{
+		{"not-an-ip", 0},
+		{"", -1},
+		{"", 65536},
+	} {
+		cliFlags.SourceIP, cliFlags.SourcePort = tc.ip, tc.port
+		_, err := sourceAddr(nil)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestResolveWakeTargetSpec(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestResolveWakeTargetSpec.db")
+	defer cleanup()
+
+	wt, err := resolveWakeTarget(context.Background(), "00:11:22:AA:BB:CC@10.0.0.255:7?pw=192.168.1.1", fromLocal, aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, "00:11:22:aa:bb:cc@10.0.0.255:7", wt.target)
+	assert.Equal(t, "10.0.0.255:7", wt.udpAddr.String())
+	assert.Equal(t, "00:11:22:aa:bb:cc", wt.packet.MAC().String())
+	assert.Equal(t, []byte{192, 168, 1, 1}, wt.password)
+
+	_, err = resolveWakeTarget(context.Background(), "00:11:22:aa:bb:cc?color=red", fromLocal, aliases)
+	assert.NotNil(t, err)
+}
+
+func TestWakeCooldown(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestWakeCooldown.db")
+	defer cleanup()
+	defer func(force bool) { cliFlags.Force = force }(cliFlags.Force)
+	cliFlags.Force = false
+
+	// The cooldown is that of the alias, and wakes of it are recorded under
+	// its name rather than the MAC, which other aliases may share.
+	assert.Nil(t, aliases.Put("nas", MacIface{Mac: "00:11:22:aa:bb:cc", Cooldown: 60}))
+	assert.Nil(t, aliases.Put("nas-too", MacIface{Mac: "00:11:22:aa:bb:cc"}))
+	wt, err := resolveWakeTarget(context.Background(), "nas", fromLocal, aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, wt.cooldown)
+	assert.Equal(t, "nas", wt.lastWakeKey())
+
+	assert.Nil(t, aliases.SetLastWake("nas", time.Now().Add(-10*time.Second)))
+	wait, err := wt.wakeOnce(context.Background(), aliases)
+	assert.Nil(t, err)
+	assert.True(t, wait > 45*time.Second && wait <= 50*time.Second, wait)
+
+	wt, err = resolveWakeTarget(context.Background(), "nas-too", fromLocal, aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), wt.cooldown)
+
+	wt, err = resolveWakeTarget(context.Background(), "00:11:22:AA:BB:CC", fromLocal, aliases)
+	assert.Nil(t, err)
+	assert.Equal(t, "00:11:22:aa:bb:cc", wt.lastWakeKey())
+}
+
+// Resolves an alias into its packet and transport, as every wake does.
+func BenchmarkResolveWakeTarget(b *testing.B) {
+	aliases, cleanup := openTestAliases(b, "./BenchmarkResolveWakeTarget.db")
+	defer cleanup()
+	if err := aliases.Add("nas", "00:11:22:aa:bb:cc", ""); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := resolveWakeTarget(context.Background(), "nas", fromLocal, aliases); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestResolveWakeTargetBroadcast(t *testing.T) {
+	aliases, cleanup := openTestAliases(t, "./TestResolveWakeTargetBroadcast.db")
+	defer cleanup()
+	defer func(ip, port string, set bool) {
+		cliFlags.BroadcastIP, cliFlags.UDPPort, cliFlags.bcastSet = ip, port, set
+	}(cliFlags.BroadcastIP, cliFlags.UDPPort, cliFlags.bcastSet)
+	cliFlags.BroadcastIP, cliFlags.UDPPort = limitedBroadcast, "9"
+
+	assert.Nil(t, aliases.Put("nas", MacIface{Mac: "00:11:22:aa:bb:cc", IP: "192.168.1.20/24"}))
+	assert.Nil(t, aliases.Put("tv", MacIface{Mac: "00:11:22:aa:bb:dd@10.0.0.255", IP: "192.168.1.21/24"}))
+	assert.Nil(t, aliases.Add("pc", "00:11:22:aa:bb:ee", ""))
+	assert.Nil(t, aliases.Put("box", MacIface{Mac: "00:11:22:aa:bb:ff", IP: "192.168.1.22/24", Host: "localhost"}))
+
+	// An empty `bcast` leaves --bcast at its default.
+	for _, tc := range []struct {
+		target, bcast, addr, from string
+	}{
+		{"nas", "", "192.168.1.255:9", "directed broadcast of 192.168.1.20/24"},
+		{"nas", "10.1.1.255", "10.1.1.255:9", "--bcast"},
+		{"nas", limitedBroadcast, "255.255.255.255:9", "--bcast"},
+		{"tv", "", "10.0.0.255:9", "alias tv"},
+		{"pc", "", "255.255.255.255:9", "limited broadcast"},
+		{"box", "", "127.0.0.1:9", "address of localhost"},
+		{"box", "10.1.1.255", "10.1.1.255:9", "--bcast"},
+		{"00:11:22:aa:bb:ee@10.0.2.255", "", "10.0.2.255:9", "target spec"},
+	} {
+		cliFlags.BroadcastIP, cliFlags.bcastSet = tc.bcast, tc.bcast != ""
+		if tc.bcast == "" {
+			cliFlags.BroadcastIP = limitedBroadcast
+		}
+		wt, err := resolveWakeTarget(context.Background(), tc.target, fromLocal, aliases)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.addr, wt.bcastAddr, tc.target)
+		assert.Equal(t, tc.from, wt.bcastFrom, tc.target)
+	}
+}
@@ -0,0 +1,59 @@
+//go:build go1.18
+// +build go1.18
+
+package wol
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func FuzzParseTarget(f *testing.F) {
+	for _, seed := range []string{
+		"00:11:22:aa:bb:cc",
+		"00:11:22:aa:bb:cc@10.0.0.255:7?iface=eth1",
+		"00:11:22:aa:bb:cc@[fe80::1]:9?iface=en0&pw=1.2.3.4",
+		"00:11:22:aa:bb:cc@::1?pw=00:11:22:33:44:55",
+		"00:11:22:aa:bb:cc@?iface=",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, spec string) {
+		target, err := ParseTarget(spec)
+		if err != nil {
+			return
+		}
+
+		// Specs round trip, less the password.
+		again, err := ParseTarget(target.String())
+		if err != nil {
+			t.Fatalf("%q parsed as %q, which fails to parse: %v", spec, target, err)
+		}
+		again.Password = target.Password
+		if !equalTargets(again, target) {
+			t.Fatalf("%q parsed as %+v, which parses as %+v", spec, target, again)
+		}
+	})
+}
+
+// equalTargets returns true if `a` and `b` are the same target.
+func equalTargets(a, b *Target) bool {
+	return a.MAC == b.MAC && a.Host == b.Host && a.Port == b.Port && a.Iface == b.Iface &&
+		bytes.Equal(a.Password, b.Password)
+}
+
+func FuzzParsePassword(f *testing.F) {
+	for _, seed := range []string{"1.2.3.4", "00:11:22:33:44:55", "::ffff:1.2.3.4", "1.2.3", "secret"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, pw string) {
+		password, err := ParsePassword(pw)
+		if err == nil && len(password) != 4 && len(password) != 6 {
+			t.Fatalf("%q parsed as a %d byte password", pw, len(password))
+		}
+	})
+}
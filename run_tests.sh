@@ -11,6 +11,10 @@ go test -v . -covermode=count -coverprofile=magic_packet.out || fail=1
 cat magic_packet.out | tail -n +2 >> coverage.out
 rm magic_packet.out
 
+go test -v ./wolpacket -covermode=count -coverprofile=wolpacket.out || fail=1
+cat wolpacket.out | tail -n +2 >> coverage.out
+rm wolpacket.out
+
 go test -v ./cmd/wol -covermode=count -coverprofile=wol.out || fail=1
 cat wol.out | tail -n +2 >> coverage.out
 rm wol.out
@@ -0,0 +1,177 @@
+package wol
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DefaultSendTimeout bounds a Send which is not given a timeout.
+const DefaultSendTimeout = 30 * time.Second
+
+const (
+	// etherTypeWOL is the EtherType of Wake-on-LAN frames.
+	etherTypeWOL = 0x0842
+
+	// etherTypeVLAN is the EtherType (TPID) of 802.1Q tagged frames.
+	etherTypeVLAN = 0x8100
+)
+
+// maxPasswordSize is the size of the longest SecureOn password.
+const maxPasswordSize = 6
+
+// packetBufs are the buffers SendPacket marshals packets into.
+var packetBufs = sync.Pool{
+	New: func() interface{} { return new([Size + maxPasswordSize]byte) },
+}
+
+// A Transport delivers a payload, typically a marshaled MagicPacket, to an
+// address whose form depends on the transport. Implement it to wake machines
+// in ways this package does not know of, e.g. through a vendor's cloud API.
+// Transports must not keep the payload once Send returns.
+type Transport interface {
+	Send(ctx context.Context, addr string, payload []byte) error
+}
+
+// TransportFunc adapts a function to a Transport.
+type TransportFunc func(ctx context.Context, addr string, payload []byte) error
+
+// Send calls f.
+func (f TransportFunc) Send(ctx context.Context, addr string, payload []byte) error {
+	return f(ctx, addr, payload)
+}
+
+// UDP sends the payload as a datagram to a host:port, which is what network
+// cards and most relays listen for.
+type UDP struct {
+	LocalAddr *net.UDPAddr // address to send from, if not the default
+}
+
+// Send sends `payload` to `addr`.
+func (t UDP) Send(ctx context.Context, addr string, payload []byte) error {
+	var local net.Addr
+	if t.LocalAddr != nil {
+		local = t.LocalAddr
+	}
+	return dialAndWrite(ctx, "udp", local, addr, payload)
+}
+
+// TCP connects to a host:port and writes the payload, which some commercial
+// relays expect instead of a datagram.
+type TCP struct {
+	LocalAddr *net.TCPAddr // address to connect from, if not the default
+}
+
+// Send connects to `addr` and writes `payload`.
+func (t TCP) Send(ctx context.Context, addr string, payload []byte) error {
+	var local net.Addr
+	if t.LocalAddr != nil {
+		local = t.LocalAddr
+	}
+	return dialAndWrite(ctx, "tcp", local, addr, payload)
+}
+
+// RawEthernet sends the payload as an Ethernet frame of EtherType 0x0842
+// out of an interface, without IP. The address is the destination MAC
+// address, or empty for the Ethernet broadcast. Frames sent out of a trunk
+// can carry an 802.1Q tag, so that they reach a VLAN without a sub-interface
+// for it. It is only supported on Linux, where it needs CAP_NET_RAW.
+type RawEthernet struct {
+	Interface string // name of the interface to send from
+	VLAN      int    // 802.1Q VLAN ID (1-4094) to tag frames with, or 0
+}
+
+// dialAndWrite writes `payload` to `addr` over a connection on `network`
+// from the optional `local` address, within the deadline of `ctx`.
+func dialAndWrite(ctx context.Context, network string, local net.Addr, addr string, payload []byte) error {
+	dialer := net.Dialer{LocalAddr: local}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	n, err := conn.Write(payload)
+	if err == nil && n != len(payload) {
+		err = fmt.Errorf("magic packet sent was %d bytes (expected %d bytes sent)", n, len(payload))
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// A SendOption configures Send.
+type SendOption func(*sendConfig)
+
+type sendConfig struct {
+	transport Transport
+	timeout   time.Duration
+}
+
+// WithTransport selects how Send delivers the packet. The default is UDP{}.
+func WithTransport(transport Transport) SendOption {
+	return func(c *sendConfig) { c.transport = transport }
+}
+
+// WithTimeout bounds how long sending may take.
+func WithTimeout(timeout time.Duration) SendOption {
+	return func(c *sendConfig) { c.timeout = timeout }
+}
+
+// Send sends `payload`, typically a marshaled MagicPacket, to `addr` and
+// returns once it has been handed off.
+func Send(ctx context.Context, addr string, payload []byte, opts ...SendOption) error {
+	c := sendConfig{timeout: DefaultSendTimeout}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.transport == nil {
+		c.transport = UDP{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.transport.Send(ctx, addr, payload)
+}
+
+// SendPacket sends the magic packet `mp`, followed by the SecureOn `password`
+// (if any), as Send does. The packet is marshaled into a reused buffer, so
+// sending many packets does not allocate one for each of them.
+func SendPacket(ctx context.Context, addr string, mp *MagicPacket, password []byte, opts ...SendOption) error {
+	if len(password) > maxPasswordSize {
+		return fmt.Errorf("SecureOn password of %d bytes is too long (at most %d bytes)", len(password), maxPasswordSize)
+	}
+	buf := packetBufs.Get().(*[Size + maxPasswordSize]byte)
+	defer packetBufs.Put(buf)
+
+	n, err := mp.MarshalTo(buf[:])
+	if err != nil {
+		return err
+	}
+	n += copy(buf[n:], password)
+	return Send(ctx, addr, buf[:n], opts...)
+}
+
+// vlanFrame returns an Ethernet frame from `src` to `dst` which carries
+// `payload` in the VLAN `vlan`.
+func vlanFrame(dst, src net.HardwareAddr, vlan int, payload []byte) []byte {
+	frame := make([]byte, 18, 18+len(payload))
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeVLAN)
+	binary.BigEndian.PutUint16(frame[14:16], uint16(vlan))
+	binary.BigEndian.PutUint16(frame[16:18], etherTypeWOL)
+	return append(frame, payload...)
+}
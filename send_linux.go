@@ -0,0 +1,80 @@
+package wol
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// htons converts `v` to network byte order.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+// Send sends `payload` in a frame to the MAC address `addr`, or to the
+// Ethernet broadcast if it is empty.
+func (t RawEthernet) Send(ctx context.Context, addr string, payload []byte) error {
+	iface, err := net.InterfaceByName(t.Interface)
+	if err != nil {
+		return err
+	}
+	dst := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if addr != "" {
+		if dst, err = net.ParseMAC(addr); err != nil {
+			return err
+		}
+		if len(dst) != 6 {
+			return fmt.Errorf("%s is not an Ethernet address", addr)
+		}
+	}
+
+	if t.VLAN < 0 || t.VLAN > 4094 {
+		return fmt.Errorf("invalid VLAN %d (expected 1-4094)", t.VLAN)
+	}
+
+	// Tagged frames are built whole, as the kernel only fills in the
+	// Ethernet header of untagged ones.
+	sockType, proto := syscall.SOCK_DGRAM, htons(etherTypeWOL)
+	if t.VLAN > 0 {
+		if len(iface.HardwareAddr) != 6 {
+			return fmt.Errorf("%s is not an Ethernet interface", t.Interface)
+		}
+		sockType, proto = syscall.SOCK_RAW, htons(etherTypeVLAN)
+		payload = vlanFrame(dst, iface.HardwareAddr, t.VLAN, payload)
+	}
+	// Nothing is received, so the socket is bound to no EtherType.
+	fd, err := syscall.Socket(syscall.AF_PACKET, sockType, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return context.DeadlineExceeded
+		}
+		tv := syscall.NsecToTimeval(left.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_SNDTIMEO, &tv); err != nil {
+			return err
+		}
+	}
+
+	sa := &syscall.SockaddrLinklayer{
+		Protocol: proto,
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(sa.Addr[:], dst)
+	return syscall.Sendto(fd, payload, 0, sa)
+}
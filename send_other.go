@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package wol
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"errors"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Send is only implemented on Linux.
+func (t RawEthernet) Send(ctx context.Context, addr string, payload []byte) error {
+	return errors.New("raw Ethernet is only supported on Linux")
+}
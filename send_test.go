@@ -0,0 +1,151 @@
+package wol
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestSendUDP(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	pkt, _ := New("00:11:22:aa:bb:cc")
+	bs, _ := pkt.Marshal()
+	assert.Nil(t, Send(context.Background(), conn.LocalAddr().String(), bs))
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, bs, buf[:n])
+}
+
+func TestSendTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		bs, _ := ioutil.ReadAll(conn)
+		received <- bs
+	}()
+
+	pkt, _ := New("00:11:22:aa:bb:cc")
+	bs, _ := pkt.Marshal()
+	err = Send(context.Background(), listener.Addr().String(), bs,
+		WithTransport(TCP{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}}), WithTimeout(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, bs, <-received)
+}
+
+func TestSendCustomTransport(t *testing.T) {
+	var got string
+	pigeon := TransportFunc(func(ctx context.Context, addr string, payload []byte) error {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		got = fmt.Sprintf("%s %d", addr, len(payload))
+		return nil
+	})
+	assert.Nil(t, Send(context.Background(), "coop 7", make([]byte, 102), WithTransport(pigeon)))
+	assert.Equal(t, "coop 7 102", got)
+
+	lost := errors.New("pigeon lost")
+	err := Send(context.Background(), "coop 7", nil, WithTransport(TransportFunc(
+		func(context.Context, string, []byte) error { return lost })))
+	assert.Equal(t, lost, err)
+}
+
+func TestSendNegative(t *testing.T) {
+	// Nothing listens on the port of a closed listener.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	assert.NotNil(t, Send(context.Background(), addr, []byte{1}, WithTransport(TCP{})))
+
+	err = Send(context.Background(), "00:11:22:aa:bb:cc", []byte{1}, WithTransport(RawEthernet{Interface: "no-such-if0"}))
+	assert.NotNil(t, err)
+}
+
+func TestVLANFrame(t *testing.T) {
+	dst := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	src := net.HardwareAddr{0x00, 0x11, 0x22, 0xaa, 0xbb, 0xcc}
+	frame := vlanFrame(dst, src, 10, []byte{1, 2, 3})
+	assert.Equal(t, []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x11, 0x22, 0xaa, 0xbb, 0xcc,
+		0x81, 0x00, 0x00, 0x0a,
+		0x08, 0x42,
+		1, 2, 3,
+	}, frame)
+}
+
+func TestSendPacket(t *testing.T) {
+	var got [][]byte
+	record := TransportFunc(func(ctx context.Context, addr string, payload []byte) error {
+		got = append(got, append([]byte(nil), payload...))
+		return nil
+	})
+
+	pkt, _ := New("00:11:22:aa:bb:cc")
+	bs, _ := pkt.Marshal()
+	assert.Nil(t, SendPacket(context.Background(), "", pkt, nil, WithTransport(record)))
+	assert.Nil(t, SendPacket(context.Background(), "", pkt, []byte{1, 2, 3, 4}, WithTransport(record)))
+	assert.Equal(t, [][]byte{bs, append(append([]byte(nil), bs...), 1, 2, 3, 4)}, got)
+
+	err := SendPacket(context.Background(), "", pkt, make([]byte, 7), WithTransport(record))
+	assert.EqualError(t, err, "SecureOn password of 7 bytes is too long (at most 6 bytes)")
+}
+
+// Sends the packets of a batch of machines over loopback UDP, as a group
+// wake does.
+func BenchmarkSendBatch(b *testing.B) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	var pkts []*MagicPacket
+	for idx := 0; idx < 64; idx++ {
+		pkt, _ := New(fmt.Sprintf("00:11:22:33:44:%02x", idx))
+		pkts = append(pkts, pkt)
+	}
+	addr := conn.LocalAddr().String()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, pkt := range pkts {
+			if err := SendPacket(context.Background(), addr, pkt, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
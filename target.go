@@ -0,0 +1,144 @@
+package wol
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sabhiram/go-wol/wolpacket"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// maxIfaceLen bounds the interface name of a target spec. Interface names
+// are far shorter on every OS (Windows "friendly" names included).
+const maxIfaceLen = 256
+
+// Target holds everything needed to wake a machine, as encoded in a target
+// spec (see ParseTarget).
+type Target struct {
+	MAC      MACAddress
+	Host     string // broadcast address to send to, "" for the default
+	Port     string // UDP port to send to, "" for the default
+	Iface    string // outbound interface, "" for the default
+	Password []byte // SecureOn password, nil if none
+}
+
+// ParseTarget parses a target spec, which encodes full wake parameters in a
+// single string:
+//
+//	<mac>[@<host>[:<port>]][?iface=<iface>&pw=<password>]
+//
+// for example `00:11:22:aa:bb:cc@10.0.0.255:7?iface=eth1&pw=secret`. A plain
+// MAC address is a valid spec. The SecureOn password is written as for
+// ParsePassword.
+func ParseTarget(spec string) (*Target, error) {
+	rest, query := spec, ""
+	if i := strings.Index(spec, "?"); i >= 0 {
+		rest, query = spec[:i], spec[i+1:]
+	}
+	mac, addr := rest, ""
+	if i := strings.Index(rest, "@"); i >= 0 {
+		mac, addr = rest[:i], rest[i+1:]
+		if addr == "" {
+			return nil, fmt.Errorf("missing address after @ in target %s", spec)
+		}
+	}
+
+	var t Target
+	var err error
+	if t.MAC, err = wolpacket.ParseMAC(mac); err != nil {
+		return nil, err
+	}
+
+	if addr != "" {
+		t.Host = addr
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			t.Host, t.Port = host, port
+		} else if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+			t.Host = addr[1 : len(addr)-1] // an IPv6 address without a port
+		}
+		if net.ParseIP(t.Host) == nil {
+			return nil, fmt.Errorf("invalid address %q in target %s", addr, spec)
+		}
+		if t.Port != "" {
+			if _, err := strconv.ParseUint(t.Port, 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid port %q in target %s", t.Port, spec)
+			}
+		}
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options in target %s: %v", spec, err)
+	}
+	for key, vs := range values {
+		switch key {
+		case "iface":
+			if t.Iface = vs[len(vs)-1]; !validIface(t.Iface) {
+				return nil, fmt.Errorf("invalid interface %q in target %s", t.Iface, spec)
+			}
+		case "pw":
+			if t.Password, err = ParsePassword(vs[len(vs)-1]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q in target %s", key, spec)
+		}
+	}
+	return &t, nil
+}
+
+// validIface returns true if `iface` could name an interface: it is not
+// empty, not overly long and has no control characters.
+func validIface(iface string) bool {
+	if iface == "" || len(iface) > maxIfaceLen {
+		return false
+	}
+	for _, r := range iface {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// ParsePassword parses a SecureOn password: 6 bytes written like a MAC
+// address, 4 bytes written like an IPv4 address, or else 6 or 4 characters
+// (e.g. `secret`) which are the bytes themselves.
+func ParsePassword(pw string) ([]byte, error) {
+	if ip := net.ParseIP(pw); ip != nil && ip.To4() != nil && strings.Count(pw, ".") == 3 && !strings.Contains(pw, ":") {
+		return []byte(ip.To4()), nil
+	}
+	if mac, err := wolpacket.ParseMAC(pw); err == nil {
+		return mac[:], nil
+	}
+	if len(pw) == 6 || len(pw) == 4 {
+		return []byte(pw), nil
+	}
+	return nil, fmt.Errorf("invalid SecureOn password (expected 6 bytes like a MAC address, 4 like an IPv4 address, or 6 or 4 characters)")
+}
+
+// String formats the target as a spec, leaving out the password so that the
+// result can be logged.
+func (t *Target) String() string {
+	s := t.MAC.String()
+	if t.Host != "" {
+		host := t.Host
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		s += "@" + host
+		if t.Port != "" {
+			s += ":" + t.Port
+		}
+	}
+	if t.Iface != "" {
+		s += "?iface=" + url.QueryEscape(t.Iface)
+	}
+	return s
+}
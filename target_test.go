@@ -0,0 +1,63 @@
+package wol
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestParseTarget(t *testing.T) {
+	mac := MACAddress{0, 0x11, 0x22, 0xaa, 0xbb, 0xcc}
+	for _, tc := range []struct {
+		spec     string
+		expected Target
+		str      string
+	}{
+		{"00:11:22:AA:BB:CC", Target{MAC: mac}, "00:11:22:aa:bb:cc"},
+		{"00-11-22-aa-bb-cc@10.0.0.255", Target{MAC: mac, Host: "10.0.0.255"}, "00:11:22:aa:bb:cc@10.0.0.255"},
+		{"00:11:22:aa:bb:cc@10.0.0.255:7?iface=eth1&pw=secret",
+			Target{mac, "10.0.0.255", "7", "eth1", []byte("secret")},
+			"00:11:22:aa:bb:cc@10.0.0.255:7?iface=eth1"},
+		{"00:11:22:aa:bb:cc?pw=abcd", Target{MAC: mac, Password: []byte("abcd")}, "00:11:22:aa:bb:cc"},
+		{"00:11:22:aa:bb:cc@10.0.0.255:7?iface=eth1&pw=01:02:03:04:05:06",
+			Target{mac, "10.0.0.255", "7", "eth1", []byte{1, 2, 3, 4, 5, 6}},
+			"00:11:22:aa:bb:cc@10.0.0.255:7?iface=eth1"},
+		{"00:11:22:aa:bb:cc?pw=192.168.1.1", Target{MAC: mac, Password: []byte{192, 168, 1, 1}}, "00:11:22:aa:bb:cc"},
+		{"00:11:22:aa:bb:cc@[ff02::1]:9", Target{MAC: mac, Host: "ff02::1", Port: "9"}, "00:11:22:aa:bb:cc@[ff02::1]:9"},
+		{"00:11:22:aa:bb:cc@[ff02::1]", Target{MAC: mac, Host: "ff02::1"}, "00:11:22:aa:bb:cc@[ff02::1]"},
+	} {
+		target, err := ParseTarget(tc.spec)
+		if tc.str == "" {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, *target)
+		assert.Equal(t, tc.str, target.String())
+	}
+}
+
+func TestParseTargetNegative(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"not-a-mac",
+		"00:11:22:aa:bb:cc@",
+		"00:11:22:aa:bb:cc@host.example:9",
+		"00:11:22:aa:bb:cc@10.0.0.255:port",
+		"00:11:22:aa:bb:cc@10.0.0.255:70000",
+		"00:11:22:aa:bb:cc?color=red",
+		"00:11:22:aa:bb:cc?pw=1.2.3",
+		"00:11:22:aa:bb:cc?pw=secrets",
+		"00:11:22:aa:bb:cc?pw=::ffff:1.2.3.4",
+		"00:11:22:aa:bb:cc?iface=",
+		"00:11:22:aa:bb:cc?iface=eth0%0A",
+		"00:11:22:aa:bb:cc?%zz",
+	} {
+		_, err := ParseTarget(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build go1.18
+// +build go1.18
+
+package wolpacket
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"testing"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func FuzzParseMAC(f *testing.F) {
+	for _, seed := range []string{"00:11:22:aa:bb:cc", "00-11-22-AA-BB-CC", "00:11-22:aa:bb:cc", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, mac string) {
+		addr, err := ParseMAC(mac)
+		if err != nil {
+			return
+		}
+		again, err := ParseMAC(addr.String())
+		if err != nil || again != addr {
+			t.Fatalf("%q parsed as %s, which parses as %s (%v)", mac, addr, again, err)
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	bs, _ := NewFromMAC(MACAddress{0, 0x11, 0x22, 0xaa, 0xbb, 0xcc}).Marshal()
+	f.Add(bs)
+	f.Add(append([]byte{1, 2, 3}, bs...))
+	f.Add(bs[:Size-1])
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		mp, err := Parse(bs)
+		if err != nil {
+			return
+		}
+		out, err := mp.Marshal()
+		if err != nil || !bytes.Contains(bs, out) {
+			t.Fatalf("packet for %s parsed from %x is not part of it", mp.MAC(), bs)
+		}
+	})
+}
+
+func FuzzParseStrict(f *testing.F) {
+	bs, _ := NewFromMAC(MACAddress{0, 0x11, 0x22, 0xaa, 0xbb, 0xcc}).Marshal()
+	f.Add(bs)
+	f.Add(append(bs, 1, 2, 3, 4))
+	f.Add(append(bs, 1, 2, 3, 4, 5, 6))
+	f.Add(append(bs, 1))
+	f.Fuzz(func(t *testing.T, bs []byte) {
+		mp, password, err := ParseStrict(bs)
+		if err != nil {
+			return
+		}
+		out, err := mp.Marshal()
+		if err != nil || !bytes.Equal(append(out, password...), bs) {
+			t.Fatalf("%x parsed as %s with password %x", bs, mp.MAC(), password)
+		}
+	})
+}
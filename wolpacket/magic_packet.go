@@ -0,0 +1,203 @@
+// Package wolpacket builds and parses Wake-on-LAN magic packets. It has no
+// dependency on the net package, so it can be used on constrained targets
+// (TinyGo, embedded gateways) where networking support is limited.
+package wolpacket
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"fmt"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// Size is the length in bytes of a serialized magic packet.
+	Size = 6 + 16*6
+
+	hexDigits = "0123456789abcdef"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// MACAddress represents a 6 byte network mac address.
+type MACAddress [6]byte
+
+// A MagicPacket is constituted of 6 bytes of 0xFF followed by 16-groups of the
+// destination MAC address.
+type MagicPacket struct {
+	header  [6]byte
+	payload [16]MACAddress
+}
+
+// ParseMAC parses an IEEE 802 MAC-48 address written as six pairs of hex
+// digits separated by either ':' or '-' (but not a mix of both).
+func ParseMAC(mac string) (MACAddress, error) {
+	var addr MACAddress
+	if len(mac) != 17 || mac[2] != ':' && mac[2] != '-' {
+		return addr, fmt.Errorf("%s is not a IEEE 802 MAC-48 address", mac)
+	}
+
+	delim := mac[2]
+	for idx := range addr {
+		off := idx * 3
+		if idx > 0 && mac[off-1] != delim {
+			return addr, fmt.Errorf("%s is not a IEEE 802 MAC-48 address", mac)
+		}
+
+		hi, hok := fromHex(mac[off])
+		lo, lok := fromHex(mac[off+1])
+		if !hok || !lok {
+			return addr, fmt.Errorf("%s is not a IEEE 802 MAC-48 address", mac)
+		}
+		addr[idx] = hi<<4 | lo
+	}
+	return addr, nil
+}
+
+// fromHex returns the value of the hex digit `c`.
+func fromHex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// String formats the MAC address using the colon delimited notation.
+func (m MACAddress) String() string {
+	buf := make([]byte, 0, 17)
+	for idx, b := range m {
+		if idx > 0 {
+			buf = append(buf, ':')
+		}
+		buf = append(buf, hexDigits[b>>4], hexDigits[b&0xF])
+	}
+	return string(buf)
+}
+
+// New returns a magic packet based on a mac address string.
+func New(mac string) (*MagicPacket, error) {
+	macAddr, err := ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromMAC(macAddr), nil
+}
+
+// NewFromMAC returns a magic packet for an already parsed MAC address.
+func NewFromMAC(macAddr MACAddress) *MagicPacket {
+	var packet MagicPacket
+
+	// Setup the header which is 6 repetitions of 0xFF.
+	for idx := range packet.header {
+		packet.header[idx] = 0xFF
+	}
+
+	// Setup the payload which is 16 repetitions of the MAC addr.
+	for idx := range packet.payload {
+		packet.payload[idx] = macAddr
+	}
+
+	return &packet
+}
+
+// Parse locates a magic packet within `bs` and returns it. The 102 byte
+// payload may appear anywhere in the buffer (as it may in a broadcast), but
+// the header must be followed by 16 identical copies of the same MAC address.
+func Parse(bs []byte) (*MagicPacket, error) {
+	for off := 0; off+Size <= len(bs); off++ {
+		// Skip ahead to the next 0xFF, which any header starts with.
+		next := bytes.IndexByte(bs[off:len(bs)-Size+1], 0xFF)
+		if next < 0 {
+			break
+		}
+		off += next
+		if isHeader(bs[off:off+6]) && isPayload(bs[off+6:off+Size]) {
+			var mac MACAddress
+			copy(mac[:], bs[off+6:])
+			return NewFromMAC(mac), nil
+		}
+	}
+	return nil, fmt.Errorf("no magic packet found in %d bytes", len(bs))
+}
+
+// isPayload returns true if `bs` holds 16 copies of the same MAC address.
+func isPayload(bs []byte) bool {
+	for idx := 6; idx < len(bs); idx += 6 {
+		if !bytes.Equal(bs[idx:idx+6], bs[:6]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseStrict parses `bs` as exactly one magic packet, optionally followed
+// by a SecureOn password of 4 or 6 bytes, which it returns. Unlike Parse it
+// accepts nothing else, which suits input received from the network.
+func ParseStrict(bs []byte) (*MagicPacket, []byte, error) {
+	switch len(bs) {
+	case Size, Size + 4, Size + 6:
+	default:
+		return nil, nil, fmt.Errorf("a magic packet is %d bytes (plus a 4 or 6 byte password), not %d", Size, len(bs))
+	}
+	if !isHeader(bs[:6]) {
+		return nil, nil, fmt.Errorf("magic packet does not start with 6 bytes of 0xFF")
+	}
+
+	var packet MagicPacket
+	copy(packet.header[:], bs[:6])
+	for idx := range packet.payload {
+		copy(packet.payload[idx][:], bs[6+idx*6:])
+		if packet.payload[idx] != packet.payload[0] {
+			return nil, nil, fmt.Errorf("magic packet repetition %d differs from the first", idx+1)
+		}
+	}
+
+	var password []byte
+	if len(bs) > Size {
+		password = append(password, bs[Size:]...)
+	}
+	return &packet, password, nil
+}
+
+// isHeader returns true if all bytes in `bs` are 0xFF.
+func isHeader(bs []byte) bool {
+	for _, b := range bs {
+		if b != 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+// MAC returns the destination MAC address encoded in the magic packet.
+func (mp *MagicPacket) MAC() MACAddress {
+	return mp.payload[0]
+}
+
+// Marshal serializes the magic packet structure into a 102 byte slice.
+func (mp *MagicPacket) Marshal() ([]byte, error) {
+	bs := make([]byte, Size)
+	_, err := mp.MarshalTo(bs)
+	return bs, err
+}
+
+// MarshalTo serializes the magic packet structure into `buf`, which must hold
+// at least Size bytes, and returns the number of bytes written. Unlike
+// Marshal it does not allocate, so a buffer can be reused across packets.
+func (mp *MagicPacket) MarshalTo(buf []byte) (int, error) {
+	if len(buf) < Size {
+		return 0, fmt.Errorf("buffer of %d bytes cannot hold a magic packet (%d bytes)", len(buf), Size)
+	}
+	copy(buf, mp.header[:])
+	for idx, mac := range mp.payload {
+		copy(buf[6+idx*6:], mac[:])
+	}
+	return Size, nil
+}
@@ -0,0 +1,261 @@
+package wolpacket
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+func TestNewMagicPacket(t *testing.T) {
+	for _, tc := range []struct {
+		mac      string
+		expected MACAddress
+	}{
+		{"00:00:00:00:00:00", MACAddress{0, 0, 0, 0, 0, 0}},
+		{"00:ff:01:03:00:00", MACAddress{0, 255, 1, 3, 0, 0}},
+		{"00-ff-01-03-00-00", MACAddress{0, 255, 1, 3, 0, 0}},
+	} {
+		pkt, err := New(tc.mac)
+		for _, v := range pkt.header {
+			assert.Equal(t, int(v), 255)
+		}
+		for _, mac := range pkt.payload {
+			assert.Equal(t, tc.expected, mac)
+		}
+		assert.Equal(t, err, nil)
+	}
+}
+
+func TestNewMagicPacketNegative(t *testing.T) {
+	for _, tc := range []struct {
+		mac string
+	}{
+		{"00x00:00:00:00:00"},
+		{"00:00:Z0:00:00:00"},
+		{"01:23:45:67:89:ab:cd:ef"},
+		{"01:23:45:67:89:ab:cd:ef:00:00:01:23:45:67:89:ab:cd:ef:00:00"},
+		{"01-23-45-67-89-ab-cd-ef"},
+		{"01-23-45-67-89-ab-cd-ef-00-00-01-23-45-67-89-ab-cd-ef-00-00"},
+		{"0123.4567.89ab"},
+		{"0123.4567.89ab.cdef"},
+		{"0123.4567.89ab.cdef.0000.0123.4567.89ab.cdef.0000"},
+	} {
+		_, err := New(tc.mac)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestMagicPacketMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		mac   string
+		count int
+	}{
+		{"00:00:00:00:00:00", 102},
+		{"00:ff:01:03:00:00", 102},
+		{"00-ff-01-03-00-00", 102},
+	} {
+		pkt, err := New(tc.mac)
+		assert.Equal(t, err, nil)
+
+		bs, err := pkt.Marshal()
+		assert.Equal(t, err, nil)
+
+		assert.Equal(t, len(bs), tc.count)
+	}
+}
+
+func TestMagicPacketMarshalTo(t *testing.T) {
+	pkt, err := New("00:ff:01:03:00:00")
+	assert.Nil(t, err)
+	expected, err := pkt.Marshal()
+	assert.Nil(t, err)
+
+	buf := make([]byte, Size+10)
+	n, err := pkt.MarshalTo(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, Size, n)
+	assert.Equal(t, expected, buf[:n])
+
+	// Marshaling into a reused buffer does not allocate.
+	allocs := testing.AllocsPerRun(100, func() {
+		pkt.MarshalTo(buf)
+	})
+	assert.Equal(t, 0.0, allocs)
+
+	_, err = pkt.MarshalTo(buf[:Size-1])
+	assert.NotNil(t, err)
+}
+
+func TestParseMagicPacket(t *testing.T) {
+	for _, tc := range []struct {
+		mac    string
+		prefix []byte
+		suffix []byte
+	}{
+		{"00:00:00:00:00:00", nil, nil},
+		{"00:ff:01:03:00:00", []byte{0xFF, 0xFF, 0x01}, nil},
+		{"ff:ff:ff:ff:ff:ff", []byte{0x01}, []byte{0xDE, 0xAD, 0xBE, 0xEF, 0x00, 0x01}},
+	} {
+		pkt, err := New(tc.mac)
+		assert.Nil(t, err)
+
+		bs, err := pkt.Marshal()
+		assert.Nil(t, err)
+
+		buf := append(append(append([]byte{}, tc.prefix...), bs...), tc.suffix...)
+		parsed, err := Parse(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, pkt.MAC(), parsed.MAC())
+		assert.Equal(t, tc.mac, parsed.MAC().String())
+	}
+}
+
+func TestParseMagicPacketNegative(t *testing.T) {
+	pkt, err := New("00:11:22:33:44:55")
+	assert.Nil(t, err)
+	bs, err := pkt.Marshal()
+	assert.Nil(t, err)
+
+	// A payload with one mismatched repetition is not a magic packet.
+	corrupt := append([]byte{}, bs...)
+	corrupt[len(corrupt)-1] = 0x66
+
+	for _, tc := range [][]byte{
+		nil,
+		bs[:101],
+		corrupt,
+		make([]byte, 102),
+	} {
+		_, err := Parse(tc)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	pkt, err := New("00:11:22:33:44:55")
+	assert.Nil(t, err)
+	bs, err := pkt.Marshal()
+	assert.Nil(t, err)
+
+	parsed, password, err := ParseStrict(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, pkt.MAC(), parsed.MAC())
+	assert.Nil(t, password)
+
+	_, password, err = ParseStrict(append(bs, 1, 2, 3, 4))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4}, password)
+
+	corrupt := append([]byte{}, bs...)
+	corrupt[50] = 0x66
+	for _, tc := range []struct {
+		bs  []byte
+		err string
+	}{
+		{append([]byte{0}, bs...), "a magic packet is 102 bytes (plus a 4 or 6 byte password), not 103"},
+		{append(bs, 1, 2, 3), "a magic packet is 102 bytes (plus a 4 or 6 byte password), not 105"},
+		{make([]byte, 102), "magic packet does not start with 6 bytes of 0xFF"},
+		{corrupt, "magic packet repetition 8 differs from the first"},
+	} {
+		_, _, err := ParseStrict(tc.bs)
+		assert.EqualError(t, err, tc.err)
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	for _, tc := range []struct {
+		mac      string
+		expected MACAddress
+	}{
+		{"00:00:00:00:00:00", MACAddress{0, 0, 0, 0, 0, 0}},
+		{"89:AB:CD:EF:00:12", MACAddress{0x89, 0xAB, 0xCD, 0xEF, 0x00, 0x12}},
+		{"89-ab-cd-ef-00-12", MACAddress{0x89, 0xAB, 0xCD, 0xEF, 0x00, 0x12}},
+	} {
+		mac, err := ParseMAC(tc.mac)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, mac)
+	}
+}
+
+func TestParseMACNegative(t *testing.T) {
+	for _, mac := range []string{
+		"",
+		"00:00:00:00:00",
+		"00:00-00:00:00:00",
+		"00.00.00.00.00.00",
+		"0g:00:00:00:00:00",
+		"1-2-3-4-5-6",
+		"01 23 45 56 67 89",
+	} {
+		_, err := ParseMAC(mac)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestMACAddressString(t *testing.T) {
+	mac := MACAddress{0x89, 0xAB, 0xCD, 0xEF, 0x00, 0x12}
+	assert.Equal(t, "89:ab:cd:ef:00:12", mac.String())
+}
+
+// benchSink keeps benchmarked results alive, so that the compiler cannot
+// optimize allocations away.
+var benchSink []byte
+
+func BenchmarkMarshal(b *testing.B) {
+	pkt := NewFromMAC(MACAddress{0x89, 0xAB, 0xCD, 0xEF, 0x00, 0x12})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchSink, _ = pkt.Marshal()
+	}
+}
+
+func BenchmarkMarshalTo(b *testing.B) {
+	pkt := NewFromMAC(MACAddress{0x89, 0xAB, 0xCD, 0xEF, 0x00, 0x12})
+	buf := make([]byte, Size)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pkt.MarshalTo(buf)
+	}
+	benchSink = buf
+}
+
+func BenchmarkNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New("89:ab:cd:ef:00:12")
+	}
+}
+
+func BenchmarkParseMAC(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseMAC("89:AB:CD:EF:00:12")
+	}
+}
+
+// Parses a packet at the end of a full datagram, the worst case of a relay
+// receiving a broadcast which carries other data first.
+func BenchmarkParse(b *testing.B) {
+	bs, _ := NewFromMAC(MACAddress{0x89, 0xAB, 0xCD, 0xEF, 0x00, 0x12}).Marshal()
+	buf := make([]byte, 1500-len(bs), 1500)
+	for idx := range buf {
+		buf[idx] = byte(idx) | 0x80
+	}
+	buf = append(buf, bs...)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Parse(buf)
+	}
+}
+
+func BenchmarkParseStrict(b *testing.B) {
+	bs, _ := NewFromMAC(MACAddress{0x89, 0xAB, 0xCD, 0xEF, 0x00, 0x12}).Marshal()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseStrict(bs)
+	}
+}
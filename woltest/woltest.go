@@ -0,0 +1,337 @@
+// Package woltest provides a fake LAN to test code which wakes machines,
+// without sending anything on the network. A Network records the magic
+// packets it receives, as a wol.Transport or through a loopback UDP socket,
+// and wakes the fake hosts they address, which then become reachable after
+// their boot delay.
+package woltest
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sabhiram/go-wol/wolpacket"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ErrNotUp is returned by Probe for hosts which are not up.
+var ErrNotUp = errors.New("host is not up")
+
+// A Packet is a magic packet received by a Network.
+type Packet struct {
+	Time     time.Time
+	Addr     string               // address it was sent to
+	MAC      wolpacket.MACAddress // MAC address it wakes
+	Password []byte               // SecureOn password following it, if any
+}
+
+// A Network is a fake LAN. It is safe for concurrent use, and implements
+// wol.Transport so that it can be passed to wol.WithTransport.
+type Network struct {
+	mtx     sync.Mutex
+	packets []Packet
+	hosts   map[wolpacket.MACAddress]*Host
+	conns   []*net.UDPConn
+	notify  chan struct{} // closed (and replaced) on every packet
+}
+
+// NewNetwork returns an empty network.
+func NewNetwork() *Network {
+	return &Network{
+		hosts:  map[wolpacket.MACAddress]*Host{},
+		notify: make(chan struct{}),
+	}
+}
+
+// AddHost adds a sleeping host with the MAC address `mac` to the network,
+// which is up `bootDelay` after it is woken.
+func (n *Network) AddHost(mac string, bootDelay time.Duration) (*Host, error) {
+	addr, err := wolpacket.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if _, ok := n.hosts[addr]; ok {
+		return nil, fmt.Errorf("host %s already exists", addr)
+	}
+	h := &Host{MAC: addr, BootDelay: bootDelay, up: make(chan struct{})}
+	n.hosts[addr] = h
+	return h, nil
+}
+
+// Send receives `payload`, which must contain a magic packet, as if it was
+// sent to `addr`.
+func (n *Network) Send(ctx context.Context, addr string, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return n.receive(addr, payload)
+}
+
+// receive records the magic packet in `payload` and wakes its host.
+func (n *Network) receive(addr string, payload []byte) error {
+	mp, err := wolpacket.Parse(payload)
+	if err != nil {
+		return err
+	}
+	p := Packet{Time: time.Now(), Addr: addr, MAC: mp.MAC()}
+	if bs, err := mp.Marshal(); err == nil {
+		if i := bytes.Index(payload, bs); i >= 0 && len(payload) > i+len(bs) {
+			p.Password = append([]byte(nil), payload[i+len(bs):]...)
+		}
+	}
+
+	n.mtx.Lock()
+	n.packets = append(n.packets, p)
+	h := n.hosts[p.MAC]
+	close(n.notify)
+	n.notify = make(chan struct{})
+	n.mtx.Unlock()
+
+	if h != nil {
+		h.wake(p.Password)
+	}
+	return nil
+}
+
+// Packets returns the magic packets received so far, oldest first.
+func (n *Network) Packets() []Packet {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return append([]Packet(nil), n.packets...)
+}
+
+// Probe returns nil if the host with the MAC address `mac` is up, which is
+// what code verifying a wake would check.
+func (n *Network) Probe(mac string) error {
+	addr, err := wolpacket.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+	n.mtx.Lock()
+	h, ok := n.hosts[addr]
+	n.mtx.Unlock()
+	if !ok {
+		return fmt.Errorf("no host %s on the network", addr)
+	}
+	if !h.Up() {
+		return ErrNotUp
+	}
+	return nil
+}
+
+// WaitPackets waits until the network has received `count` magic packets, or
+// `ctx` is done, and returns the packets received.
+func (n *Network) WaitPackets(ctx context.Context, count int) ([]Packet, error) {
+	for {
+		n.mtx.Lock()
+		packets, notify := append([]Packet(nil), n.packets...), n.notify
+		n.mtx.Unlock()
+		if len(packets) >= count {
+			return packets, nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return packets, ctx.Err()
+		}
+	}
+}
+
+// ListenUDP receives magic packets on a loopback UDP socket as well, for code
+// which sends real datagrams, and returns its host:port.
+func (n *Network) ListenUDP() (string, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return "", err
+	}
+	n.mtx.Lock()
+	n.conns = append(n.conns, conn)
+	n.mtx.Unlock()
+
+	addr := conn.LocalAddr().String()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			size, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			n.receive(addr, buf[:size])
+		}
+	}()
+	return addr, nil
+}
+
+// Close closes the sockets of ListenUDP, and the listeners of the hosts.
+func (n *Network) Close() error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+	n.conns = nil
+	for _, h := range n.hosts {
+		h.close()
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// A Host is a fake machine on a Network. It sleeps until it receives a magic
+// packet (with its Password, if set), and is up BootDelay later.
+type Host struct {
+	MAC       wolpacket.MACAddress
+	BootDelay time.Duration
+	Password  []byte // SecureOn password the host requires, if any
+
+	mtx      sync.Mutex
+	wakes    int
+	woken    bool
+	up       chan struct{} // closed once the host is up
+	listener net.Listener
+	addr     string // reserved for the listener while asleep
+}
+
+// wake boots the host, unless it is awake or `password` is wrong.
+func (h *Host) wake(password []byte) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if len(h.Password) > 0 && !bytes.Equal(password, h.Password) {
+		return
+	}
+	h.wakes++
+	if h.woken {
+		return
+	}
+	h.woken = true
+	up := h.up
+	time.AfterFunc(h.BootDelay, func() {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		if h.up != up {
+			return // put back to sleep while booting
+		}
+		if h.addr != "" && h.listener == nil {
+			if l, err := net.Listen("tcp", h.addr); err == nil {
+				h.listener = l
+				go acceptAll(l)
+			}
+		}
+		close(up)
+	})
+}
+
+// Wakes returns how many magic packets woke (or would have woken) the host.
+func (h *Host) Wakes() int {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.wakes
+}
+
+// Up returns true if the host has been woken and is done booting.
+func (h *Host) Up() bool {
+	select {
+	case <-h.upChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitUp waits until the host is up, or `ctx` is done.
+func (h *Host) WaitUp(ctx context.Context) error {
+	select {
+	case <-h.upChan():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sleep puts the host back to sleep.
+func (h *Host) Sleep() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.listener != nil {
+		h.listener.Close()
+		h.listener = nil
+	}
+	if h.woken {
+		h.woken = false
+		h.up = make(chan struct{})
+	}
+}
+
+// ListenTCP returns a loopback host:port which refuses connections while the
+// host sleeps and accepts them once it is up, for code which probes whether
+// a machine came up (e.g. its ssh port).
+func (h *Host) ListenTCP() (string, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.addr != "" {
+		return h.addr, nil
+	}
+
+	// The port is reserved by listening on it briefly, and listened on for
+	// real once the host is up.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	h.addr = l.Addr().String()
+	if h.woken && h.isUp() {
+		h.listener = l
+		go acceptAll(l)
+	} else {
+		l.Close()
+	}
+	return h.addr, nil
+}
+
+// upChan returns the channel closed once the host is up.
+func (h *Host) upChan() chan struct{} {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.up
+}
+
+// isUp returns true if the host is up. The mutex must be held.
+func (h *Host) isUp() bool {
+	select {
+	case <-h.up:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes the listener of the host.
+func (h *Host) close() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.listener != nil {
+		h.listener.Close()
+		h.listener = nil
+	}
+}
+
+// acceptAll accepts (and closes) connections until `l` is closed.
+func acceptAll(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}
+}
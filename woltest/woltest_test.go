@@ -0,0 +1,104 @@
+package woltest
+
+////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	wol "github.com/sabhiram/go-wol"
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+// magicPacket returns the marshaled magic packet of `mac`.
+func magicPacket(t *testing.T, mac string) []byte {
+	mp, err := wol.New(mac)
+	assert.Nil(t, err)
+	bs, err := mp.Marshal()
+	assert.Nil(t, err)
+	return bs
+}
+
+func TestNetworkTransport(t *testing.T) {
+	lan := NewNetwork()
+	defer lan.Close()
+	nas, err := lan.AddHost("00:11:22:33:44:55", 20*time.Millisecond)
+	assert.Nil(t, err)
+	_, err = lan.AddHost("00-11-22-33-44-55", 0)
+	assert.EqualError(t, err, "host 00:11:22:33:44:55 already exists")
+
+	assert.Equal(t, ErrNotUp, lan.Probe("00:11:22:33:44:55"))
+	err = wol.Send(context.Background(), "192.168.1.255:9", magicPacket(t, "00:11:22:33:44:55"), wol.WithTransport(lan))
+	assert.Nil(t, err)
+
+	packets := lan.Packets()
+	if assert.Len(t, packets, 1) {
+		assert.Equal(t, "192.168.1.255:9", packets[0].Addr)
+		assert.Equal(t, "00:11:22:33:44:55", packets[0].MAC.String())
+	}
+
+	// The host is up once it booted.
+	assert.False(t, nas.Up())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, nas.WaitUp(ctx))
+	assert.Nil(t, lan.Probe("00:11:22:33:44:55"))
+	assert.Equal(t, 1, nas.Wakes())
+
+	nas.Sleep()
+	assert.False(t, nas.Up())
+
+	// Packets for unknown machines are recorded all the same.
+	assert.Nil(t, lan.Send(context.Background(), "", magicPacket(t, "00:11:22:33:44:66")))
+	assert.Len(t, lan.Packets(), 2)
+	assert.EqualError(t, lan.Probe("00:11:22:33:44:66"), "no host 00:11:22:33:44:66 on the network")
+	assert.NotNil(t, lan.Send(context.Background(), "", []byte("hello")))
+}
+
+func TestNetworkPassword(t *testing.T) {
+	lan := NewNetwork()
+	defer lan.Close()
+	nas, err := lan.AddHost("00:11:22:33:44:55", 0)
+	assert.Nil(t, err)
+	nas.Password = []byte{1, 2, 3, 4}
+
+	bs := magicPacket(t, "00:11:22:33:44:55")
+	assert.Nil(t, lan.Send(context.Background(), "", append(bs, 9, 9, 9, 9)))
+	assert.Equal(t, 0, nas.Wakes())
+	assert.Nil(t, lan.Send(context.Background(), "", append(bs, 1, 2, 3, 4)))
+	assert.Equal(t, 1, nas.Wakes())
+	assert.Equal(t, []byte{1, 2, 3, 4}, lan.Packets()[1].Password)
+}
+
+func TestNetworkUDP(t *testing.T) {
+	lan := NewNetwork()
+	defer lan.Close()
+	nas, err := lan.AddHost("00:11:22:33:44:55", 0)
+	assert.Nil(t, err)
+	ssh, err := nas.ListenTCP()
+	assert.Nil(t, err)
+
+	// The host refuses connections until it is up.
+	_, err = net.Dial("tcp", ssh)
+	assert.NotNil(t, err)
+
+	addr, err := lan.ListenUDP()
+	assert.Nil(t, err)
+	assert.Nil(t, wol.Send(context.Background(), addr, magicPacket(t, "00:11:22:33:44:55")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	packets, err := lan.WaitPackets(ctx, 1)
+	assert.Nil(t, err)
+	assert.Len(t, packets, 1)
+	assert.Nil(t, nas.WaitUp(ctx))
+
+	conn, err := net.Dial("tcp", ssh)
+	if assert.Nil(t, err) {
+		conn.Close()
+	}
+}